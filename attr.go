@@ -47,7 +47,11 @@ func (a *_attr) GetValue() string {
 }
 
 func (a *_attr) SetValue(newValue string) {
+  oldValue := a.value
   a.value = newValue
+  if a.ownerElement != nil {
+    a.ownerElement.updateId(a.Name(), oldValue, newValue)
+  }
 }
 
 func (a *_attr) Name() string {
@@ -68,3 +72,13 @@ func newAttr(name string, val string, owner *_elem) (*_attr) {
   node.self = Node(a)
   return a
 }
+
+// newAttrNS creates an attribute in the given namespace. The prefix of
+// qualifiedName, if any, is kept for serialization.
+func newAttrNS(namespaceURI string, qualifiedName string, val string, owner *_elem) (*_attr) {
+  prefix, local := splitQName(qualifiedName)
+  a := newAttr(local, val, owner)
+  a.n.Space = namespaceURI
+  a.pfx = prefix
+  return a
+}
@@ -0,0 +1,372 @@
+package dom
+
+/*
+ * Canonical XML
+ * http://www.w3.org/TR/xml-c14n
+ * http://www.w3.org/TR/xml-c14n11/
+ * http://www.w3.org/TR/xml-exc-c14n/
+ */
+
+import (
+	"bufio"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// A C14NMethod is a kind of canonicalization.
+type C14NMethod int
+
+const (
+	C14N10        C14NMethod = iota // Canonical XML 1.0
+	C14N11                          // Canonical XML 1.1
+	ExclusiveC14N                   // Exclusive XML Canonicalization 1.0
+)
+
+// C14NOptions choose how Canonicalize and CanonicalizeNodeSet work.
+type C14NOptions struct {
+	Method C14NMethod
+
+	// WithComments keeps comments, which are left out otherwise.
+	WithComments bool
+
+	// InclusiveNamespaces lists the prefixes that ExclusiveC14N declares
+	// as the inclusive methods do, whether they are used or not.
+	// "#default" stands for the default namespace.
+	InclusiveNamespaces []string
+}
+
+// Canonicalize writes the canonical form of n and everything below it
+// to w. n is a Document or, for a subtree, any other node. The
+// namespaces, and with the inclusive methods the xml:lang, xml:space and
+// xml:base attributes, that the subtree inherits from outside it are
+// written on its top element as the method says. A nil opts stands for
+// Canonical XML 1.0 without comments.
+//
+// As in the XPath data model, CDATA sections are written as text and
+// entity references are replaced by their children, while the XML
+// declaration, the doctype and whitespace outside the document element
+// are left out.
+func Canonicalize(w io.Writer, n Node, opts *C14NOptions) error {
+	c := newC14NWriter(w, opts, nil)
+	ctx := c14nContext{ns: map[string]string{}, rendered: map[string]string{}}
+	if p := n.ParentNode(); p != nil && p.NodeType() != DOCUMENT_NODE && n.NodeType() != ATTRIBUTE_NODE {
+		ctx.ns = inScopeNamespaces(p)
+		// the ancestors are all left out
+		var chain []Node
+		for a := nearestElement(p); a != nil && a.NodeType() == ELEMENT_NODE; a = a.ParentNode() {
+			chain = append(chain, a)
+		}
+		for i := len(chain) - 1; i >= 0; i-- {
+			ctx.xmlAttrs = appendXMLAttrs(ctx.xmlAttrs, chain[i].(*_elem))
+		}
+	}
+	c.node(n, ctx)
+	return c.Flush()
+}
+
+// CanonicalizeNodeSet writes the canonical form of the document subset
+// made of nodes to w. The nodes must belong to a single document. As the
+// DOM has no namespace nodes, the namespaces in scope at an element in
+// the set are taken to be in it as well; attributes are only in it if
+// they are listed, like the other nodes. A nil opts stands for Canonical
+// XML 1.0 without comments.
+func CanonicalizeNodeSet(w io.Writer, nodes []Node, opts *C14NOptions) error {
+	if len(nodes) == 0 {
+		return nil
+	}
+	set := make(map[Node]bool, len(nodes))
+	for _, n := range nodes {
+		set[n] = true
+	}
+	return canonicalizeSubset(w, nodes[0], func(n Node) bool { return set[n] }, opts)
+}
+
+// canonicalizeSubset writes the canonical form of the nodes inSet
+// accepts in the document of n.
+func canonicalizeSubset(w io.Writer, n Node, inSet func(Node) bool, opts *C14NOptions) error {
+	c := newC14NWriter(w, opts, inSet)
+	if a, ok := n.(Attr); ok {
+		n = a.OwnerElement()
+	}
+	for n.ParentNode() != nil {
+		n = n.ParentNode()
+	}
+	c.node(n, c14nContext{ns: map[string]string{}, rendered: map[string]string{}})
+	return c.Flush()
+}
+
+// a c14nWriter writes the canonical form of nodes
+type c14nWriter struct {
+	*bufio.Writer
+	opts  *C14NOptions
+	inSet func(Node) bool // nil if all nodes are in the set
+}
+
+func newC14NWriter(w io.Writer, opts *C14NOptions, inSet func(Node) bool) *c14nWriter {
+	if opts == nil {
+		opts = &C14NOptions{}
+	}
+	return &c14nWriter{bufio.NewWriter(w), opts, inSet}
+}
+
+func (c *c14nWriter) in(n Node) bool {
+	return c.inSet == nil || c.inSet(n)
+}
+
+// a c14nContext is what the canonical form of an element depends on
+// above it
+type c14nContext struct {
+	ns       map[string]string // the namespaces in scope, by prefix
+	rendered map[string]string // the namespaces declared by the output so far
+	xmlAttrs []*_attr          // the xml: attributes of the ancestors left out since the last one written, outermost first
+}
+
+func (c *c14nWriter) node(n Node, ctx c14nContext) {
+	switch n.NodeType() {
+	case DOCUMENT_NODE:
+		c.document(n.(*_doc), ctx)
+	case ELEMENT_NODE:
+		c.element(n.(*_elem), ctx)
+	case TEXT_NODE, CDATA_SECTION_NODE:
+		if c.in(n) {
+			c.WriteString(escapeText(n.NodeValue()))
+		}
+	case COMMENT_NODE:
+		if c.opts.WithComments && c.in(n) {
+			c.WriteString("<!--" + n.NodeValue() + "-->")
+		}
+	case PROCESSING_INSTRUCTION_NODE:
+		if c.in(n) {
+			pi := n.(ProcessingInstruction)
+			c.WriteString("<?" + pi.Target())
+			if data := pi.GetData(); data != "" {
+				c.WriteString(" " + data)
+			}
+			c.WriteString("?>")
+		}
+	case ENTITY_REFERENCE_NODE, DOCUMENT_FRAGMENT_NODE:
+		for ch := n.FirstChild(); ch != nil; ch = ch.NextSibling() {
+			c.node(ch, ctx)
+		}
+	}
+}
+
+// document writes the children of d, with a line break between the
+// document element and the comments and processing instructions around
+// it.
+func (c *c14nWriter) document(d *_doc, ctx c14nContext) {
+	before := true
+	for ch := d.FirstChild(); ch != nil; ch = ch.NextSibling() {
+		switch ch.NodeType() {
+		case ELEMENT_NODE:
+			c.element(ch.(*_elem), ctx)
+			before = false
+		case COMMENT_NODE, PROCESSING_INSTRUCTION_NODE:
+			if !c.in(ch) || ch.NodeType() == COMMENT_NODE && !c.opts.WithComments {
+				continue
+			}
+			if !before {
+				c.WriteString("\n")
+			}
+			c.node(ch, ctx)
+			if before {
+				c.WriteString("\n")
+			}
+		}
+	}
+}
+
+// a c14nAttr is an attribute as it is written
+type c14nAttr struct {
+	name, space, local, value string
+}
+
+func (c *c14nWriter) element(e *_elem, ctx c14nContext) {
+	// the namespaces in scope, with those the names of the element and
+	// its attributes use even if nothing declares them
+	ns, copied := ctx.ns, false
+	bind := func(prefix, uri string) {
+		if u, ok := ns[prefix]; ok && u == uri {
+			return
+		}
+		if !copied {
+			ns, copied = copyMap(ns), true
+		}
+		ns[prefix] = uri
+	}
+	for _, a := range e.attribs {
+		if p, ok := namespaceDecl(a.NodeName()); ok {
+			bind(p, a.value)
+		}
+	}
+	if ns[e.pfx] != e.n.Space {
+		bind(e.pfx, e.n.Space)
+	}
+	for _, a := range e.attribs {
+		if a.pfx != "" && a.pfx != "xml" && a.pfx != "xmlns" && ns[a.pfx] != a.n.Space {
+			bind(a.pfx, a.n.Space)
+		}
+	}
+
+	if !c.in(e) {
+		inner := c14nContext{ns: ns, rendered: ctx.rendered, xmlAttrs: ctx.xmlAttrs}
+		if c.opts.Method != ExclusiveC14N {
+			inner.xmlAttrs = appendXMLAttrs(append([]*_attr(nil), ctx.xmlAttrs...), e)
+		}
+		for ch := e.FirstChild(); ch != nil; ch = ch.NextSibling() {
+			c.node(ch, inner)
+		}
+		return
+	}
+
+	var attrs []c14nAttr
+	for _, a := range e.attribs {
+		if _, ok := namespaceDecl(a.NodeName()); ok || !c.in(a) {
+			continue
+		}
+		attrs = append(attrs, c14nAttr{a.NodeName(), a.n.Space, a.n.Local, a.value})
+	}
+	if c.opts.Method != ExclusiveC14N && len(ctx.xmlAttrs) > 0 {
+		attrs = c.inheritXMLAttrs(attrs, ctx.xmlAttrs)
+	}
+	sort.Slice(attrs, func(i, j int) bool {
+		if attrs[i].space != attrs[j].space {
+			return attrs[i].space < attrs[j].space
+		}
+		return attrs[i].local < attrs[j].local
+	})
+
+	// the namespaces to declare
+	var prefixes []string
+	if c.opts.Method == ExclusiveC14N {
+		used := map[string]bool{e.pfx: true}
+		for _, a := range attrs {
+			if p, _ := splitQName(a.name); p != "" && p != "xml" {
+				used[p] = true
+			}
+		}
+		for _, p := range c.opts.InclusiveNamespaces {
+			if p == "#default" {
+				p = ""
+			}
+			used[p] = true
+		}
+		for p := range used {
+			prefixes = append(prefixes, p)
+		}
+	} else {
+		for p := range ns {
+			prefixes = append(prefixes, p)
+		}
+		if _, ok := ns[""]; !ok {
+			prefixes = append(prefixes, "")
+		}
+	}
+	sort.Strings(prefixes)
+	rendered := ctx.rendered
+	var decls []string
+	for _, p := range prefixes {
+		uri := ns[p]
+		if p == "xml" || rendered[p] == uri || p != "" && uri == "" {
+			continue
+		}
+		if len(decls) == 0 {
+			rendered = copyMap(rendered)
+		}
+		rendered[p] = uri
+		if p == "" {
+			decls = append(decls, " xmlns=\""+escapeAttr(uri)+"\"")
+		} else {
+			decls = append(decls, " xmlns:"+p+"=\""+escapeAttr(uri)+"\"")
+		}
+	}
+
+	c.WriteString("<" + e.NodeName())
+	for _, d := range decls {
+		c.WriteString(d)
+	}
+	for _, a := range attrs {
+		c.WriteString(" " + a.name + "=\"" + escapeAttr(a.value) + "\"")
+	}
+	c.WriteString(">")
+	inner := c14nContext{ns: ns, rendered: rendered}
+	for ch := e.FirstChild(); ch != nil; ch = ch.NextSibling() {
+		c.node(ch, inner)
+	}
+	c.WriteString("</" + e.NodeName() + ">")
+}
+
+// inheritXMLAttrs adds the xml: attributes of the ancestors left out to
+// attrs, unless the element has its own. Canonical XML 1.1 leaves out
+// xml:id and joins xml:base values instead.
+func (c *c14nWriter) inheritXMLAttrs(attrs []c14nAttr, inherited []*_attr) []c14nAttr {
+	own := map[string]int{}
+	for i, a := range attrs {
+		if a.space == xmlURL {
+			own[a.local] = i
+		}
+	}
+	var bases []string
+	for i := len(inherited) - 1; i >= 0; i-- {
+		a := inherited[i]
+		if c.opts.Method == C14N11 {
+			if a.n.Local == "id" {
+				continue
+			}
+			if a.n.Local == "base" {
+				bases = append([]string{a.value}, bases...)
+				continue
+			}
+		}
+		if _, ok := own[a.n.Local]; !ok {
+			own[a.n.Local] = len(attrs)
+			attrs = append(attrs, c14nAttr{"xml:" + a.n.Local, xmlURL, a.n.Local, a.value})
+		}
+	}
+	if len(bases) > 0 {
+		if i, ok := own["base"]; ok {
+			attrs[i].value = joinURIs(append(bases, attrs[i].value))
+		} else {
+			attrs = append(attrs, c14nAttr{"xml:base", xmlURL, "base", joinURIs(bases)})
+		}
+	}
+	return attrs
+}
+
+// appendXMLAttrs appends the xml: attributes of e to attrs.
+func appendXMLAttrs(attrs []*_attr, e *_elem) []*_attr {
+	for _, a := range e.attribs {
+		if a.n.Space == xmlURL {
+			attrs = append(attrs, a)
+		}
+	}
+	return attrs
+}
+
+// joinURIs resolves each of uris against the ones before it.
+func joinURIs(uris []string) string {
+	base := uris[0]
+	for _, ref := range uris[1:] {
+		b, err1 := url.Parse(base)
+		r, err2 := url.Parse(ref)
+		if err1 != nil || err2 != nil {
+			base = ref
+			continue
+		}
+		base = b.ResolveReference(r).String()
+		if ref == "" || strings.HasSuffix(ref, "/") && !strings.HasSuffix(base, "/") {
+			base += "/"
+		}
+	}
+	return base
+}
+
+func copyMap(m map[string]string) map[string]string {
+	c := make(map[string]string, len(m)+1)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
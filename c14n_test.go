@@ -0,0 +1,145 @@
+package dom
+
+import (
+  "strings"
+  "testing"
+)
+
+func canonical(t *testing.T, n Node, opts *C14NOptions) string {
+  var b strings.Builder
+  if err := Canonicalize(&b, n, opts); err != nil {
+    t.Fatalf("Canonicalize failed: %v", err)
+  }
+  return b.String()
+}
+
+// the examples of http://www.w3.org/TR/xml-c14n#Examples
+func TestCanonicalizeDocument(t *testing.T) {
+  d, err := ParseString(`<?xml version="1.0"?>
+
+<?xml-stylesheet   href="doc.xsl"
+   type="text/xsl"   ?>
+
+<!DOCTYPE doc SYSTEM "doc.dtd">
+
+<doc>Hello, world!<!-- Comment 1 --></doc>
+
+<?pi-without-data     ?>
+
+<!-- Comment 2 -->
+
+<!-- Comment 3 -->`)
+  if err != nil {
+    t.Fatalf("Parse failed: %v", err)
+  }
+  want := "<?xml-stylesheet href=\"doc.xsl\"\n   type=\"text/xsl\"   ?>\n<doc>Hello, world!</doc>\n<?pi-without-data?>"
+  if s := canonical(t, d, nil); (s != want) {
+    t.Errorf("Canonicalize wrote\n%s\ninstead of\n%s", s, want)
+  }
+  want = "<?xml-stylesheet href=\"doc.xsl\"\n   type=\"text/xsl\"   ?>\n<doc>Hello, world!<!-- Comment 1 --></doc>\n<?pi-without-data?>\n<!-- Comment 2 -->\n<!-- Comment 3 -->"
+  if s := canonical(t, d, &C14NOptions{WithComments: true}); (s != want) {
+    t.Errorf("Canonicalize wrote\n%s\ninstead of\n%s", s, want)
+  }
+
+  d, _ = ParseNativeString(`<doc>
+   <e1   />
+   <e2   ></e2>
+   <e3   name = "elem3"   id="elem3"   />
+   <e5 a:attr="out" b:attr="sorted" attr2="all" attr="I'm"
+      xmlns:b="http://www.ietf.org"
+      xmlns:a="http://www.w3.org"
+      xmlns="http://example.org"/>
+   <e6 xmlns="" xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="" xmlns:a="http://www.w3.org">
+            <e9 xmlns="" xmlns:a="http://www.ietf.org"/>
+         </e8>
+      </e7>
+   </e6>
+   <t a="&#x9;&#xA;&quot;&lt;">&lt;&gt;&amp;<![CDATA[<x>]]>&#xD;</t>
+</doc>`)
+  want = `<doc>
+   <e1></e1>
+   <e2></e2>
+   <e3 id="elem3" name="elem3"></e3>
+   <e5 xmlns="http://example.org" xmlns:a="http://www.w3.org" xmlns:b="http://www.ietf.org" attr="I'm" attr2="all" b:attr="sorted" a:attr="out"></e5>
+   <e6 xmlns:a="http://www.w3.org">
+      <e7 xmlns="http://www.ietf.org">
+         <e8 xmlns="">
+            <e9 xmlns:a="http://www.ietf.org"></e9>
+         </e8>
+      </e7>
+   </e6>
+   <t a="&#x9;&#xA;&quot;&lt;">&lt;&gt;&amp;&lt;x&gt;&#xD;</t>
+</doc>`
+  if s := canonical(t, d, nil); (s != want) {
+    t.Errorf("Canonicalize wrote\n%s\ninstead of\n%s", s, want)
+  }
+}
+
+// the example of http://www.w3.org/TR/xml-exc-c14n/#sec-Enveloping
+func TestCanonicalizeSubtree(t *testing.T) {
+  d, _ := ParseString(`<n0:local xmlns:n0="foo:bar" xmlns:n3="ftp://example.org"><n1:elem2 xmlns:n1="http://example.net" xml:lang="en">
+     <n3:stuff xmlns:n3="ftp://example.org"/>
+  </n1:elem2></n0:local>`)
+  e := d.DocumentElement().FirstChild()
+  want := `<n1:elem2 xmlns:n0="foo:bar" xmlns:n1="http://example.net" xmlns:n3="ftp://example.org" xml:lang="en">
+     <n3:stuff></n3:stuff>
+  </n1:elem2>`
+  if s := canonical(t, e, nil); (s != want) {
+    t.Errorf("C14N wrote\n%s\ninstead of\n%s", s, want)
+  }
+  want = `<n1:elem2 xmlns:n1="http://example.net" xml:lang="en">
+     <n3:stuff xmlns:n3="ftp://example.org"></n3:stuff>
+  </n1:elem2>`
+  if s := canonical(t, e, &C14NOptions{Method: ExclusiveC14N}); (s != want) {
+    t.Errorf("Exclusive C14N wrote\n%s\ninstead of\n%s", s, want)
+  }
+  want = `<n1:elem2 xmlns:n1="http://example.net" xmlns:n3="ftp://example.org" xml:lang="en">
+     <n3:stuff></n3:stuff>
+  </n1:elem2>`
+  if s := canonical(t, e, &C14NOptions{Method: ExclusiveC14N, InclusiveNamespaces: []string{"n3"}}); (s != want) {
+    t.Errorf("Exclusive C14N with n3 inclusive wrote\n%s\ninstead of\n%s", s, want)
+  }
+}
+
+func TestCanonicalizeXMLAttributes(t *testing.T) {
+  d, _ := ParseString(`<a xml:lang="en" xml:id="i" xml:base="http://x/y/"><b xml:base="z/"><c/></b></a>`)
+  c := d.DocumentElement().FirstChild().FirstChild()
+  if s := canonical(t, c, nil); (s != `<c xml:base="z/" xml:id="i" xml:lang="en"></c>`) {
+    t.Errorf("C14N 1.0 wrote %s", s)
+  }
+  if s := canonical(t, c, &C14NOptions{Method: C14N11}); (s != `<c xml:base="http://x/y/z/" xml:lang="en"></c>`) {
+    t.Errorf("C14N 1.1 wrote %s", s)
+  }
+  if s := canonical(t, c, &C14NOptions{Method: ExclusiveC14N}); (s != `<c></c>`) {
+    t.Errorf("Exclusive C14N wrote %s", s)
+  }
+}
+
+func TestCanonicalizeNodeSet(t *testing.T) {
+  d, _ := ParseString(`<a xmlns="urn:a" x="1"><b y="2">t<c>u</c></b><!--c--></a>`)
+  // everything but c and its content, and the attribute of b
+  var nodes []Node
+  var add func(n Node)
+  add = func(n Node) {
+    if (n.NodeName() == "c") {
+      return
+    }
+    nodes = append(nodes, n)
+    if (n.NodeName() == "a") {
+      nodes = append(nodes, n.Attributes().Item(1))
+    }
+    for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+      add(c)
+    }
+  }
+  add(d)
+  var b strings.Builder
+  if err := CanonicalizeNodeSet(&b, nodes, &C14NOptions{WithComments: true}); err != nil {
+    t.Fatalf("CanonicalizeNodeSet failed: %v", err)
+  }
+  if s := b.String(); (s != `<a xmlns="urn:a" x="1"><b>t</b><!--c--></a>`) {
+    t.Errorf("CanonicalizeNodeSet wrote %s", s)
+  }
+}
@@ -7,20 +7,63 @@ package dom
  * types.
  *
  * Copyright (c) 2011, Robert Johnstone
- */ 
+ */
 
 const (
-  ELEMENT_NODE = iota + 1
-  ATTRIBUTE_NODE
-  TEXT_NODE
-  CDATA_SECTION_NODE
-  ENTITY_REFERENCE_NODE
-  ENTITY_NODE
-  PROCESSING_INSTRUCTION_NODE
-  COMMENT_NODE
-  DOCUMENT_NODE
-  DOCUMENT_TYPE_NODE
-  DOCUMENT_FRAGMENT_NODE
-  NOTATION_NODE
+	ELEMENT_NODE = iota + 1
+	ATTRIBUTE_NODE
+	TEXT_NODE
+	CDATA_SECTION_NODE
+	ENTITY_REFERENCE_NODE
+	ENTITY_NODE
+	PROCESSING_INSTRUCTION_NODE
+	COMMENT_NODE
+	DOCUMENT_NODE
+	DOCUMENT_TYPE_NODE
+	DOCUMENT_FRAGMENT_NODE
+	NOTATION_NODE
 )
 
+// The answers of a ParseFilter, as in DOM Level 3 Load and Save.
+const (
+	FILTER_ACCEPT = iota + 1
+	FILTER_REJECT
+	FILTER_SKIP
+	FILTER_INTERRUPT
+)
+
+// The bits of the WhatToShow of a filter, one per node type, as in DOM
+// Level 2 Traversal.
+const (
+	SHOW_ELEMENT = 1 << iota
+	SHOW_ATTRIBUTE
+	SHOW_TEXT
+	SHOW_CDATA_SECTION
+	SHOW_ENTITY_REFERENCE
+	SHOW_ENTITY
+	SHOW_PROCESSING_INSTRUCTION
+	SHOW_COMMENT
+	SHOW_DOCUMENT
+	SHOW_DOCUMENT_TYPE
+	SHOW_DOCUMENT_FRAGMENT
+	SHOW_NOTATION
+
+	SHOW_ALL = 0xFFFFFFFF
+)
+
+// The types of the results of XPath expressions, as in DOM Level 3 XPath.
+const (
+	ANY_TYPE = iota
+	NUMBER_TYPE
+	STRING_TYPE
+	BOOLEAN_TYPE
+	UNORDERED_NODE_ITERATOR_TYPE
+	ORDERED_NODE_ITERATOR_TYPE
+	UNORDERED_NODE_SNAPSHOT_TYPE
+	ORDERED_NODE_SNAPSHOT_TYPE
+	ANY_UNORDERED_NODE_TYPE
+	FIRST_ORDERED_NODE_TYPE
+)
+
+// The type of the namespace nodes of XPath, as in DOM Level 3 XPath.
+const XPATH_NAMESPACE_NODE = 13
@@ -10,6 +10,8 @@ package dom
  * Copyright (c) 2010, Jeff Schiller
  */ 
 
+import "io"
+
 // TODO: split this out into separate interfaces again eventually
 
 type (
@@ -31,11 +33,24 @@ type (
     LastChild() Node
     PreviousSibling() Node
     NextSibling() Node
+    // DOM Level 2
+    NamespaceURI() string
+    Prefix() string
+    LocalName() string
+    // DOM Level 3
+    LookupNamespaceURI(prefix string) string
+    // not part of the DOM
+    SourceRange() SourceRange
+    WriteTo(w io.Writer) (int64, error)
   
     // internal interface methods needed for implementations (not part of the DOM)
     setParent(Node)
+    setSourceRange(SourceRange)
+    setPreviousSibling(Node)
+    setNextSibling(Node)
     insertChildAt(Node, uint)
     removeChild(Node)
+    removeChildren() []Node
   }
   
   // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-745549614
@@ -51,21 +66,70 @@ type (
     OwnerDocument() Document
     GetElementsByTagName(name string) NodeList
     HasAttribute(name string) bool
+    // DOM Level 2
+    GetAttributeNS(namespaceURI string, localName string) string
+    GetAttributeNodeNS(namespaceURI string, localName string) Attr
+    SetAttributeNS(namespaceURI string, qualifiedName string, value string)
+    RemoveAttributeNS(namespaceURI string, localName string)
+    HasAttributeNS(namespaceURI string, localName string) bool
+    GetElementsByTagNameNS(namespaceURI string, localName string) NodeList
+    // DOM Standard
+    QuerySelector(selectors string) (Element, error)
+    QuerySelectorAll(selectors string) (NodeList, error)
+    Matches(selectors string) (bool, error)
+    Closest(selectors string) (Element, error)
+    // not part of the DOM
+    InnerXML() string
+    OuterXML() string
+    SetInnerXML(s string) error
+    SetOuterXML(s string) error
   }
   
   // http://www.w3.org/TR/DOM-Level-3-Core/core.html#i-Document
   Document interface {
     Node
+    Doctype() DocumentType
     DocumentElement() Element
     CreateElement(tagName string) Element
+    CreateDocumentFragment() DocumentFragment
     CreateTextNode(data string) Text
+    CreateComment(data string) Comment
+    CreateCDATASection(data string) CDATASection
+    CreateProcessingInstruction(target string, data string) ProcessingInstruction
     CreateAttribute(name string) Attr
+    CreateEntityReference(name string) EntityReference
     OwnerDocument() Document
     // DOM Level 2
+    CreateElementNS(namespaceURI string, qualifiedName string) Element
+    CreateAttributeNS(namespaceURI string, qualifiedName string) Attr
     GetElementById(id string) Element
     GetElementsByTagName(name string) NodeList
+    GetElementsByTagNameNS(namespaceURI string, localName string) NodeList
+    // DOM Level 3
+    XmlVersion() string
+    XmlEncoding() string
+    XmlStandalone() bool
+    DocumentURI() string
+    // DOM Level 3 XPath
+    CreateExpression(expression string, resolver XPathNSResolver) (*XPathExpression, error)
+    CreateNSResolver(nodeResolver Node) XPathNSResolver
+    Evaluate(expression string, contextNode Node, resolver XPathNSResolver, resultType uint) (*XPathResult, error)
+    // DOM Standard
+    QuerySelector(selectors string) (Element, error)
+    QuerySelectorAll(selectors string) (NodeList, error)
+    // not part of the DOM
+    DuplicateIds() []string
   }
   
+  // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-B63ED1A3
+  DocumentFragment interface {
+    Node
+    OwnerDocument() Document
+    // DOM Standard
+    QuerySelector(selectors string) (Element, error)
+    QuerySelectorAll(selectors string) (NodeList, error)
+  }
+
   // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-FF21A306
   CharacterData interface {
     Node
@@ -84,7 +148,38 @@ type (
   Comment interface {
     CharacterData
   }
+
+  // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-667469212
+  CDATASection interface {
+    Text
+  }
+
+  // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-11C98490
+  EntityReference interface {
+    Node
+    OwnerDocument() Document
+  }
   
+  // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-1004215813
+  ProcessingInstruction interface {
+    Node
+    OwnerDocument() Document
+    Target() string
+    GetData() string
+    SetData(string)
+  }
+
+  // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-412266927
+  DocumentType interface {
+    Node
+    OwnerDocument() Document
+    Name() string
+    // DOM Level 2
+    PublicId() string
+    SystemId() string
+    InternalSubset() string
+  }
+
   // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-637646024
   Attr interface {
     Node
@@ -96,6 +191,18 @@ type (
     OwnerElement() Element
   }
   
+  // http://www.w3.org/TR/DOM-Level-3-XPath/xpath.html#XPathNSResolver
+  XPathNSResolver interface {
+    LookupNamespaceURI(prefix string) string
+  }
+
+  // http://www.w3.org/TR/DOM-Level-3-XPath/xpath.html#XPathNamespace
+  XPathNamespace interface {
+    Node
+    OwnerDocument() Document
+    OwnerElement() Element
+  }
+
   // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-536297177
   NodeList interface {
     Length() uint
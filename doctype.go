@@ -0,0 +1,106 @@
+package dom
+
+/*
+ * DocumentType node implementation
+ */
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+type _doctype struct {
+	*_node
+	name           string
+	publicId       string
+	systemId       string
+	internalSubset string
+}
+
+func (dt *_doctype) NodeName() string {
+	return dt.name
+}
+
+func (dt *_doctype) NodeValue() string {
+	return ""
+}
+
+func (dt *_doctype) OwnerDocument() Document {
+	return ownerDocument(dt)
+}
+
+func (dt *_doctype) Name() string {
+	return dt.name
+}
+
+func (dt *_doctype) PublicId() string {
+	return dt.publicId
+}
+
+func (dt *_doctype) SystemId() string {
+	return dt.systemId
+}
+
+func (dt *_doctype) InternalSubset() string {
+	return dt.internalSubset
+}
+
+func newDoctype(name, publicId, systemId, internalSubset string) *_doctype {
+	n := newNode(DOCUMENT_TYPE_NODE)
+	dt := &_doctype{n, name, publicId, systemId, internalSubset}
+	n.self = Node(dt)
+	return dt
+}
+
+// newDoctypeFromDirective builds a DocumentType out of the text of a
+// <!DOCTYPE ...> directive as returned by xml.Decoder. It returns nil if
+// the directive is not a document type declaration.
+func newDoctypeFromDirective(dir xml.Directive) *_doctype {
+	s := bytes.TrimSpace(dir)
+	if !bytes.HasPrefix(s, []byte("DOCTYPE")) {
+		return nil
+	}
+	s = bytes.TrimLeft(s[len("DOCTYPE"):], " \t\r\n")
+
+	// the name runs up to whitespace or the internal subset
+	i := bytes.IndexAny(s, " \t\r\n[")
+	if i < 0 {
+		i = len(s)
+	}
+	name := string(s[:i])
+	s = bytes.TrimLeft(s[i:], " \t\r\n")
+
+	publicId, systemId := "", ""
+	switch {
+	case bytes.HasPrefix(s, []byte("PUBLIC")):
+		s = bytes.TrimLeft(s[len("PUBLIC"):], " \t\r\n")
+		publicId, s = quotedLiteral(s)
+		s = bytes.TrimLeft(s, " \t\r\n")
+		systemId, s = quotedLiteral(s)
+	case bytes.HasPrefix(s, []byte("SYSTEM")):
+		s = bytes.TrimLeft(s[len("SYSTEM"):], " \t\r\n")
+		systemId, s = quotedLiteral(s)
+	}
+	s = bytes.TrimSpace(s)
+
+	internalSubset := ""
+	if len(s) > 0 && s[0] == '[' {
+		if end := bytes.LastIndexByte(s, ']'); end > 0 {
+			internalSubset = string(s[1:end])
+		}
+	}
+	return newDoctype(name, publicId, systemId, internalSubset)
+}
+
+// quotedLiteral returns the contents of the single or double quoted
+// literal at the start of s along with the rest of s.
+func quotedLiteral(s []byte) (string, []byte) {
+	if len(s) == 0 || (s[0] != '"' && s[0] != '\'') {
+		return "", s
+	}
+	end := bytes.IndexByte(s[1:], s[0])
+	if end < 0 {
+		return string(s[1:]), nil
+	}
+	return string(s[1 : end+1]), s[end+2:]
+}
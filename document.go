@@ -9,11 +9,19 @@ package dom
 
 import (
 	"encoding/xml"
-	"os"
+	"sort"
+	"strings"
 )
 
 type _doc struct {
 	*_node
+	ids           map[string][]Element // elements by id attribute, in attach order
+	xmlVersion    string
+	xmlEncoding   string
+	xmlStandalone bool
+	documentURI   string
+	mutations     uint // nodes added or removed so far, for XPathResult
+	html          bool // made by ParseHTML
 }
 
 func (d *_doc) NodeValue() string {
@@ -28,15 +36,38 @@ func (d *_doc) RemoveChild(c Node) Node {
 	return removeChild(d, c)
 }
 
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-B63ED1A31
+func (d *_doc) Doctype() DocumentType {
+	for _, c := range d.c {
+		if c.NodeType() == DOCUMENT_TYPE_NODE {
+			return c.(DocumentType)
+		}
+	}
+	return nil
+}
+
+// DocumentElement returns the first Element child of the document, or
+// nil if the document has no root element yet.
 func (d *_doc) DocumentElement() Element {
-	return d.ChildNodes().Item(0).(Element)
+	for _, c := range d.c {
+		if c.NodeType() == ELEMENT_NODE {
+			return c.(Element)
+		}
+	}
+	return nil
 }
 
 func (d *_doc) OwnerDocument() Document {
 	return ownerDocument(d)
 }
 
+// CreateElement creates an element called tag. In a document made by
+// ParseHTML it is an HTML element, in the XHTML namespace, and its name is
+// put in lower case.
 func (d *_doc) CreateElement(tag string) Element {
+	if d.html {
+		return newElem(xml.StartElement{xml.Name{htmlURL, strings.ToLower(tag)}, nil})
+	}
 	return newElem(xml.StartElement{xml.Name{"", tag}, nil})
 }
 
@@ -44,30 +75,134 @@ func (d *_doc) CreateTextNode(data string) Text {
 	return newText(xml.CharData([]byte(data)))
 }
 
+func (d *_doc) CreateComment(data string) Comment {
+	return newComment(xml.Comment([]byte(data)))
+}
+
+func (d *_doc) CreateCDATASection(data string) CDATASection {
+	return newCData(xml.CharData([]byte(data)))
+}
+
+func (d *_doc) CreateEntityReference(name string) EntityReference {
+	return newEntityRef(name)
+}
+
+func (d *_doc) CreateProcessingInstruction(target string, data string) ProcessingInstruction {
+	return newProcInst(xml.ProcInst{Target: target, Inst: []byte(data)})
+}
+
 func (d *_doc) CreateAttribute(name string) Attr {
 	return newAttr(name, "", nil)
 }
 
-func (d *_doc) setRoot(r Element) Element {
-	// empty the children vector
-	if d.ChildNodes().Length() > 0 {
-		os.Exit(-1)
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-35CB04B5
+func (d *_doc) CreateDocumentFragment() DocumentFragment {
+	return newFrag(d)
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-DocCrElNS
+func (d *_doc) CreateElementNS(namespaceURI string, qualifiedName string) Element {
+	return newElemNS(namespaceURI, qualifiedName)
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-DocCrAttrNS
+func (d *_doc) CreateAttributeNS(namespaceURI string, qualifiedName string) Attr {
+	return newAttrNS(namespaceURI, qualifiedName, "", nil)
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#Document3-version
+func (d *_doc) XmlVersion() string {
+	return d.xmlVersion
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#Document3-encoding
+func (d *_doc) XmlEncoding() string {
+	return d.xmlEncoding
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#Document3-standalone
+func (d *_doc) XmlStandalone() bool {
+	return d.xmlStandalone
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#Document3-documentURI
+func (d *_doc) DocumentURI() string {
+	return d.documentURI
+}
+
+// setXMLDecl records the values of the XML declaration.
+func (d *_doc) setXMLDecl(decl XMLDecl) {
+	if decl.Version != "" {
+		d.xmlVersion = decl.Version
+	}
+	d.xmlEncoding = decl.Encoding
+	d.xmlStandalone = decl.Standalone == "yes"
+}
+
+// setRoot appends r as the document element. It fails if the document
+// already has one.
+func (d *_doc) setRoot(r Element) (Element, error) {
+	if d.DocumentElement() != nil {
+		return nil, ErrMultipleRoots
 	}
 	appendChild(d, r)
-	return r
+	return r, nil
 }
 
+// GetElementById looks the id up in the document's ID index. If the id
+// is used by more than one element the first one attached is returned;
+// use DuplicateIds() to find such ids.
 func (d *_doc) GetElementById(id string) Element {
-	return getElementById(d.DocumentElement(), id)
+	if es := d.ids[id]; len(es) > 0 {
+		return es[0]
+	}
+	return nil
+}
+
+// DuplicateIds returns, in sorted order, the ids that are used by more
+// than one element of the document.
+func (d *_doc) DuplicateIds() []string {
+	dups := []string{}
+	for id, es := range d.ids {
+		if len(es) > 1 {
+			dups = append(dups, id)
+		}
+	}
+	sort.Strings(dups)
+	return dups
+}
+
+func (d *_doc) addId(id string, e Element) {
+	d.ids[id] = append(d.ids[id], e)
+}
+
+func (d *_doc) removeId(id string, e Element) {
+	es := d.ids[id]
+	for i := range es {
+		if es[i] == e {
+			es = append(es[:i], es[i+1:]...)
+			break
+		}
+	}
+	if len(es) == 0 {
+		delete(d.ids, id)
+	} else {
+		d.ids[id] = es
+	}
 }
 
 func (d *_doc) GetElementsByTagName(tagName string) NodeList {
 	return newTagNodeList(d, tagName)
 }
 
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-getElBTNNS
+func (d *_doc) GetElementsByTagNameNS(namespaceURI string, localName string) NodeList {
+	return newTagNodeListNS(d, namespaceURI, localName)
+}
+
 func newDoc() *_doc {
 	n := newNode(DOCUMENT_NODE)
-	d := &_doc{n}
-	n.self = Node(n)
+	d := &_doc{_node: n, ids: make(map[string][]Element), xmlVersion: "1.0"}
+	n.self = Node(d)
 	return d
 }
@@ -11,12 +11,6 @@ package dom
 // FIXME: we use the empty string "" to denote a 'null' value when the data type
 // according to the DOM API is expected to be a string. Perhaps return a pointer to a string?
 
-import (
-	"encoding/xml"
-	"io"
-	"strings"
-)
-
 const (
 	DEBUG = true
 )
@@ -33,17 +27,70 @@ func appendChild(p Node, c Node) Node {
 		removeChild(c.ParentNode(), c)
 	}
 	i := p.ChildNodes().Length()
+	insertChild(p, c, i)
+	return c
+}
+
+// insertChild attaches c as the i-th child of p and registers the IDs
+// of the attached subtree with the owner document. A DocumentFragment
+// is not attached itself; its children are moved to p instead.
+func insertChild(p Node, c Node, i uint) {
+	if c.NodeType() == DOCUMENT_FRAGMENT_NODE {
+		for _, fc := range removeChildren(c) {
+			insertChild(p, fc, i)
+			i++
+		}
+		return
+	}
 	p.insertChildAt(c, i)
 	c.setParent(p)
-	return c
+	if d, ok := ownerDocument(p).(*_doc); ok {
+		d.mutations++
+		indexIds(d, c, true)
+	}
 }
 
 func removeChild(p Node, c Node) Node {
+	if d, ok := ownerDocument(p).(*_doc); ok {
+		d.mutations++
+		indexIds(d, c, false)
+	}
 	p.removeChild(c)
 	c.setParent(nil)
 	return c
 }
 
+// removeChildren detaches all the children of p and returns them.
+func removeChildren(p Node) []Node {
+	if d, ok := ownerDocument(p).(*_doc); ok {
+		d.mutations++
+		for c := p.FirstChild(); c != nil; c = c.NextSibling() {
+			indexIds(d, c, false)
+		}
+	}
+	return p.removeChildren()
+}
+
+// indexIds adds (or removes) every element with an id attribute in the
+// subtree rooted at n to (or from) the ID index of document d.
+func indexIds(d *_doc, n Node, add bool) {
+	f := func(n Node) bool {
+		if n.NodeType() == ELEMENT_NODE {
+			e := n.(Element)
+			if id := e.GetAttribute("id"); id != "" {
+				if add {
+					d.addId(id, e)
+				} else {
+					d.removeId(id, e)
+				}
+			}
+		}
+		return true
+	}
+	f(n)
+	walkTreeDepthFirst(n, f)
+}
+
 /*
 func prevSibling(n Node) Node {
   children := n.ParentNode().ChildNodes()
@@ -59,121 +106,19 @@ func prevSibling(n Node) Node {
 */
 
 func getElementById(e Element, id string) Element {
-	if e.NodeType() == ELEMENT_NODE {
-		// check for an id
-		if av := e.GetAttribute("id"); av != "" {
-			if av == id {
-				return e
-			}
-		}
-		// if not found, check the children
-		cnodes := e.ChildNodes()
-		var ix uint
-		clen := cnodes.Length()
-		for ix = 0; ix < clen; ix++ {
-			//for c := range e.c {
-			// return the first one found
-			//ce := cnodes.Item(ix).(Element).GetElementById(id)
-			cnode := cnodes.Item(ix)
-			// can't cast safely unless it's an Element for reals
-			if cnode.NodeType() == 1 {
-				ce := getElementById(cnode.(Element), id)
-				if ce != nil {
-					return ce
-				}
-			}
-		}
+	if id == "" {
+		return nil
 	}
-	return nil
-}
-
-func ParseString(s string) (doc Document, err error) {
-	doc, err = Parse(strings.NewReader(s))
-	return
-}
-
-func Parse(r io.Reader) (doc Document, err error) {
-	// Create parser and get first token
-	p := xml.NewDecoder(r)
-	t, err := p.Token()
-	if err != nil {
-		return nil, err
-	}
-
-	d := newDoc()
-	e := (Node)(nil) // e is the current parent
-	for t != nil {
-		switch token := t.(type) {
-		case xml.StartElement:
-			el := newElem(token)
-			for ar := range token.Attr {
-				el.SetAttribute(token.Attr[ar].Name.Local, token.Attr[ar].Value)
-			}
-			if e == nil {
-				// set doc root
-				// this element is a child of e, the last element we found
-				e = d.setRoot(el)
-			} else {
-				// this element is a child of e, the last element we found
-				e = e.AppendChild(el)
-			}
-		case xml.CharData:
-			if nil != e {
-				e.AppendChild(newText(token))
-			}
-		case xml.Comment:
-			if nil != e {
-				e.AppendChild(newComment(token))
-			}
-		case xml.EndElement:
-			if nil != e {
-				e = e.ParentNode()
-			}
-		default:
-			// TODO: add handling for other types (text nodes, etc)
+	var found Element
+	f := func(n Node) bool {
+		if n.NodeType() == ELEMENT_NODE && n.(Element).GetAttribute("id") == id {
+			found = n.(Element)
+			return false
 		}
-		// get the next token
-		t, err = p.Token()
+		return true
 	}
-
-	// Make sure that reading stopped on EOF
-	if err != io.EOF {
-		return nil, err
+	if f(e) {
+		walkTreeDepthFirst(e, f)
 	}
-
-	// All is good, return the document
-	return d, nil
-}
-
-// called recursively
-func toXml(n Node) string {
-	s := ""
-	switch n.NodeType() {
-	case ELEMENT_NODE: // Element Nodes
-		s += "<" + n.NodeName()
-
-		// iterate over attributes
-		for i := uint(0); i < n.Attributes().Length(); i++ {
-			a := n.Attributes().Item(i)
-			s += " " + a.NodeName() + "=\"" + a.NodeValue() + "\""
-		}
-
-		s += ">"
-
-		// iterate over children
-		for ch := uint(0); ch < n.ChildNodes().Length(); ch++ {
-			s += toXml(n.ChildNodes().Item(ch))
-		}
-
-		s += "</" + n.NodeName() + ">"
-
-	case TEXT_NODE: // Text Nodes
-		s += n.NodeValue()
-		break
-	}
-	return s
-}
-
-func ToXml(doc Document) string {
-	return toXml(doc.DocumentElement())
+	return found
 }
@@ -3,6 +3,7 @@ package dom
 import (
   "testing"
   "strconv"
+  "strings"
 )
 
 // Document.nodeName should be #document
@@ -664,6 +665,65 @@ func TestDocumentGetElementById(t *testing.T) {
   }
 }
 
+func TestDocumentGetElementByIdAfterMutation(t *testing.T) {
+  d, _ := ParseString(`<parent id="p"><child id="c"/></parent>`)
+  r := d.DocumentElement()
+  c := d.GetElementById("c")
+
+  r.RemoveChild(c)
+  if d.GetElementById("c") != nil {
+    t.Errorf("Detached element still found by id")
+  }
+
+  r.AppendChild(c)
+  if d.GetElementById("c") != c {
+    t.Errorf("Re-attached element not found by id")
+  }
+
+  c.SetAttribute("id", "c2")
+  if d.GetElementById("c") != nil || d.GetElementById("c2") != c {
+    t.Errorf("ID index not updated by Element.SetAttribute()")
+  }
+
+  c.GetAttributeNode("id").SetValue("c3")
+  if d.GetElementById("c2") != nil || d.GetElementById("c3") != c {
+    t.Errorf("ID index not updated by Attr.SetValue()")
+  }
+
+  c.RemoveAttribute("id")
+  if d.GetElementById("c3") != nil {
+    t.Errorf("ID index not updated by Element.RemoveAttribute()")
+  }
+
+  n := d.CreateElement("new")
+  n.SetAttribute("id", "n")
+  n.AppendChild(d.CreateElement("grandchild"))
+  n.FirstChild().(Element).SetAttribute("id", "g")
+  if d.GetElementById("n") != nil {
+    t.Errorf("Element not in the document found by id")
+  }
+  r.InsertBefore(n, c)
+  if d.GetElementById("n") != n || d.GetElementById("g") != n.FirstChild().(Element) {
+    t.Errorf("Subtree attached with InsertBefore() not found by id")
+  }
+}
+
+func TestDocumentDuplicateIds(t *testing.T) {
+  d, _ := ParseString(`<parent id="p"><child id="c"/><child id="c"/><child id="d"/></parent>`)
+  first := d.DocumentElement().FirstChild()
+  dups := d.DuplicateIds()
+  if len(dups) != 1 || dups[0] != "c" {
+    t.Errorf("Document.DuplicateIds() returned %v instead of [c]", dups)
+  }
+  if d.GetElementById("c") != first {
+    t.Errorf("Document.GetElementById() did not return the first element with a duplicate id")
+  }
+  d.DocumentElement().RemoveChild(first)
+  if len(d.DuplicateIds()) != 0 {
+    t.Errorf("Document.DuplicateIds() still reports a removed duplicate")
+  }
+}
+
 func TestDocumentGetElementsByTagNameLength(t *testing.T) {
   d, _ := ParseString(
   `<foo id="a">
@@ -971,6 +1031,55 @@ func TestNodeNextPrevSibling(t *testing.T) {
   }
 }
 
+func TestNodeSiblingsDetached(t *testing.T) {
+  d, _ := ParseString(`<parent><child0/><child1/><child2/></parent>`)
+  r := d.DocumentElement()
+  child0 := r.ChildNodes().Item(0)
+  child1 := r.ChildNodes().Item(1)
+  child2 := r.ChildNodes().Item(2)
+  r.RemoveChild(child1)
+  if child1.PreviousSibling() != nil || child1.NextSibling() != nil {
+    t.Errorf("Removed node still has siblings")
+  }
+  if child0.NextSibling() != child2 || child2.PreviousSibling() != child0 {
+    t.Errorf("Siblings of a removed node were not linked to each other")
+  }
+  orphan := d.CreateElement("orphan")
+  if orphan.PreviousSibling() != nil || orphan.NextSibling() != nil {
+    t.Errorf("Node without a parent has siblings")
+  }
+  if d.PreviousSibling() != nil || d.NextSibling() != nil {
+    t.Errorf("Document has siblings")
+  }
+}
+
+func TestNodeSiblingsEmptyParent(t *testing.T) {
+  d, _ := ParseString(`<parent><only/></parent>`)
+  only := d.DocumentElement().FirstChild()
+  if only.PreviousSibling() != nil || only.NextSibling() != nil {
+    t.Errorf("Only child has siblings")
+  }
+}
+
+func TestNodeInsertBeforeSiblings(t *testing.T) {
+  d, _ := ParseString(`<parent><child0/><child2/><child3/></parent>`)
+  r := d.DocumentElement()
+  child0 := r.ChildNodes().Item(0)
+  child2 := r.ChildNodes().Item(1)
+  child1 := d.CreateElement("child1")
+  r.InsertBefore(child1, child2)
+  if r.ChildNodes().Length() != 4 {
+    t.Errorf("Node.InsertBefore() inserted the node %d times", r.ChildNodes().Length()-3)
+  }
+  if child0.NextSibling() != child1.(Node) || child1.PreviousSibling() != child0 ||
+     child1.NextSibling() != child2 || child2.PreviousSibling() != child1.(Node) {
+    t.Errorf("Node.InsertBefore() did not link the new node to its siblings")
+  }
+  if child1.ParentNode() != r.(Node) {
+    t.Errorf("Node.InsertBefore() did not set the parent node")
+  }
+}
+
 func TestElementRemoveAttribute(t *testing.T) {
   d, _ := ParseString(`<parent attr="val"/>`)
   r := d.DocumentElement()
@@ -1044,3 +1153,250 @@ func TestParseComment(t *testing.T) {
     t.Errorf("Comment.nodeValue was not correct: '%s'", c.NodeValue())
   }
 }
+
+func TestParsePrologAndEpilog(t *testing.T) {
+  d, err := ParseString("<?xml version=\"1.0\"?>\n<!-- before --><?pi data?>\n<root/>\n<!-- after -->")
+  if err != nil {
+    t.Fatalf("Error parsing document with a prolog: %v", err)
+  }
+  types := []uint{TEXT_NODE, COMMENT_NODE, PROCESSING_INSTRUCTION_NODE, TEXT_NODE, ELEMENT_NODE, TEXT_NODE, COMMENT_NODE}
+  children := d.ChildNodes()
+  if children.Length() != uint(len(types)) {
+    t.Fatalf("Document had %d children instead of %d", children.Length(), len(types))
+  }
+  for i, nt := range types {
+    if children.Item(uint(i)).NodeType() != nt {
+      t.Errorf("Document child %d had node type %d instead of %d", i, children.Item(uint(i)).NodeType(), nt)
+    }
+  }
+  if d.DocumentElement() == nil || d.DocumentElement().NodeName() != "root" {
+    t.Errorf("Document.DocumentElement() did not return the root element")
+  }
+  if children.Item(6).NodeValue() != " after " {
+    t.Errorf("Epilog comment was '%s'", children.Item(6).NodeValue())
+  }
+}
+
+func TestParseProcessingInstruction(t *testing.T) {
+  d, _ := ParseString(`<root><?target some data?></root>`)
+  pi, ok := d.DocumentElement().FirstChild().(ProcessingInstruction)
+  if !ok {
+    t.Fatalf("Processing instruction was not parsed")
+  }
+  if pi.NodeType() != PROCESSING_INSTRUCTION_NODE || pi.Target() != "target" || pi.NodeName() != "target" {
+    t.Errorf("ProcessingInstruction.target was '%s'", pi.Target())
+  }
+  if pi.GetData() != "some data" || pi.NodeValue() != "some data" {
+    t.Errorf("ProcessingInstruction.data was '%s'", pi.GetData())
+  }
+}
+
+func TestParseDoctype(t *testing.T) {
+  d, _ := ParseString(`<!DOCTYPE html PUBLIC "-//W3C//DTD XHTML 1.0 Strict//EN" "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd"><html/>`)
+  dt := d.Doctype()
+  if dt == nil {
+    t.Fatalf("Document.Doctype() returned nil")
+  }
+  if dt.NodeType() != DOCUMENT_TYPE_NODE || dt.Name() != "html" ||
+     dt.PublicId() != "-//W3C//DTD XHTML 1.0 Strict//EN" ||
+     dt.SystemId() != "http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd" {
+    t.Errorf("DocumentType not parsed correctly: %s %s %s", dt.Name(), dt.PublicId(), dt.SystemId())
+  }
+}
+
+func TestDocumentElementNotFirstChild(t *testing.T) {
+  d, _ := ParseString(`<!-- comment --><root/>`)
+  r := d.DocumentElement()
+  if r == nil || r.NodeName() != "root" {
+    t.Errorf("Document.DocumentElement() did not skip the leading comment")
+  }
+}
+
+func TestDocumentElementEmptyDocument(t *testing.T) {
+  d, err := ParseString(`<!-- only a comment -->`)
+  if err != nil {
+    t.Fatalf("Error parsing document without a root: %v", err)
+  }
+  if d.DocumentElement() != nil {
+    t.Errorf("Document.DocumentElement() did not return nil on a document without a root")
+  }
+}
+
+func TestParseSecondRoot(t *testing.T) {
+  d, err := ParseString(`<root/><root/>`)
+  if err == nil || d != nil {
+    t.Errorf("Parsing a document with two root elements did not fail")
+  }
+}
+
+func TestParseByteOrderMark(t *testing.T) {
+  for _, src := range []string{"\ufeff<a>x</a>", "\ufeff<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n<a>x</a>"} {
+    for _, parse := range []func(string) (Document, error){ParseString, ParseNativeString} {
+      d, err := parse(src)
+      if err != nil {
+        t.Errorf("Parsing %q failed: %v", src, err)
+        continue
+      }
+      if d.DocumentElement().FirstChild().NodeValue() != "x" || strings.Contains(toXml(d), "\ufeff") {
+        t.Errorf("Parsing %q gave %s", src, toXml(d))
+      }
+    }
+  }
+}
+
+func TestDocumentCreateComment(t *testing.T) {
+  d, _ := ParseString(`<root/>`)
+  c := d.CreateComment("note")
+  d.DocumentElement().AppendChild(c)
+  if c.NodeType() != COMMENT_NODE || c.GetData() != "note" || d.DocumentElement().FirstChild() != c {
+    t.Errorf("Document.CreateComment() did not create a comment")
+  }
+}
+
+func TestDocumentCreateProcessingInstruction(t *testing.T) {
+  d, _ := ParseString(`<root/>`)
+  pi := d.CreateProcessingInstruction("target", "data")
+  if pi.NodeType() != PROCESSING_INSTRUCTION_NODE || pi.Target() != "target" || pi.GetData() != "data" {
+    t.Errorf("Document.CreateProcessingInstruction() did not create a processing instruction")
+  }
+}
+
+func TestNamespaces(t *testing.T) {
+  src := `<a xmlns="urn:a" xmlns:b="urn:b"><b:c b:x="1" y="2"/><d xmlns="urn:d"/></a>`
+  for _, native := range []bool{false, true} {
+    opts := NewParseOptions()
+    opts.Native = native
+    d, err := ParseWithOptions(strings.NewReader(src), opts)
+    if err != nil {
+      t.Fatalf("Error parsing namespaced document: %v", err)
+    }
+    r := d.DocumentElement()
+    if r.NamespaceURI() != "urn:a" || r.Prefix() != "" || r.LocalName() != "a" {
+      t.Errorf("Root element was {%s}%s (native %v)", r.NamespaceURI(), r.NodeName(), native)
+    }
+    c := r.FirstChild().(Element)
+    if c.NodeName() != "b:c" || c.NamespaceURI() != "urn:b" || c.Prefix() != "b" || c.LocalName() != "c" {
+      t.Errorf("Prefixed element was {%s}%s (native %v)", c.NamespaceURI(), c.NodeName(), native)
+    }
+    if c.GetAttributeNS("urn:b", "x") != "1" || c.GetAttribute("b:x") != "1" || !c.HasAttributeNS("", "y") {
+      t.Errorf("Namespaced attributes were not found (native %v)", native)
+    }
+    if c.LookupNamespaceURI("") != "urn:a" || r.LastChild().LookupNamespaceURI("") != "urn:d" {
+      t.Errorf("Node.LookupNamespaceURI() did not find the default namespace (native %v)", native)
+    }
+    if d.GetElementsByTagNameNS("urn:d", "d").Length() != 1 || d.GetElementsByTagNameNS("*", "c").Length() != 1 {
+      t.Errorf("Document.GetElementsByTagNameNS() did not find the elements (native %v)", native)
+    }
+  }
+}
+
+func TestAttributeOrder(t *testing.T) {
+  d, _ := ParseString(`<a z="1" y="2" x="3" w="4"/>`)
+  attrs := d.DocumentElement().Attributes()
+  for i, name := range []string{"z", "y", "x", "w"} {
+    if attrs.Item(uint(i)).NodeName() != name {
+      t.Errorf("Attribute %d was %s instead of %s", i, attrs.Item(uint(i)).NodeName(), name)
+    }
+  }
+}
+
+func TestManyAttributes(t *testing.T) {
+  src := `<a xmlns:p="urn:p"`
+  for i := 0; i < 20; i++ {
+    src += " a" + strconv.Itoa(i) + "=\"" + strconv.Itoa(i) + "\""
+  }
+  d, err := ParseString(src + ` p:a0="p" id="x"/>`)
+  if err != nil {
+    t.Fatalf("Parse failed: %v", err)
+  }
+  e := d.DocumentElement()
+  if (e.GetAttribute("a7") != "7" || e.GetAttribute("p:a0") != "p" || e.GetAttributeNS("urn:p", "a0") != "p" || e.GetAttributeNS("", "a0") != "0") {
+    t.Errorf("Lookups among many attributes failed")
+  }
+  e.RemoveAttribute("a3")
+  e.SetAttribute("a25", "25")
+  e.SetAttributeNS("urn:p", "q:a0", "q")
+  if (e.HasAttribute("a3") || e.GetAttribute("a4") != "4" || e.GetAttribute("a25") != "25" || e.GetAttribute("q:a0") != "q" || e.HasAttribute("p:a0")) {
+    t.Errorf("Changes among many attributes were lost")
+  }
+  na := d.CreateAttribute("a5")
+  na.SetValue("five")
+  if old := e.SetAttributeNode(na); (old == nil || old.GetValue() != "5" || e.GetAttributeNode("a5") != na) {
+    t.Errorf("SetAttributeNode among many attributes gave %v", old)
+  }
+  e.SetAttribute("id", "y")
+  if (d.GetElementById("y") != e || d.GetElementById("x") != nil || e.Attributes().Length() != 23) {
+    t.Errorf("The attributes of the element were %s", e.OuterXML())
+  }
+}
+
+func TestInnerOuterXML(t *testing.T) {
+  d, _ := ParseString(`<a xmlns="urn:a" xmlns:p="urn:p"><b p:x="1 &amp; 2">x &lt; y</b><!--c--><?pi data?></a>`)
+  b := d.DocumentElement().FirstChild().(Element)
+  if s := b.OuterXML(); s != `<b xmlns="urn:a" xmlns:p="urn:p" p:x="1 &amp; 2">x &lt; y</b>` {
+    t.Errorf("Element.OuterXML() was %s", s)
+  }
+  if s := d.DocumentElement().InnerXML(); s != `<b xmlns="urn:a" xmlns:p="urn:p" p:x="1 &amp; 2">x &lt; y</b><!--c--><?pi data?>` {
+    t.Errorf("Element.InnerXML() was %s", s)
+  }
+}
+
+func TestSetInnerXML(t *testing.T) {
+  d, _ := ParseString(`<a xmlns="urn:a" xmlns:p="urn:p"><old id="old"/><old2/></a>`)
+  r := d.DocumentElement()
+  old := r.FirstChild()
+  if err := r.SetInnerXML(`text<p:b id="new"/><c/>`); err != nil {
+    t.Fatalf("Element.SetInnerXML() failed: %v", err)
+  }
+  if r.ChildNodes().Length() != 3 || r.FirstChild().NodeValue() != "text" {
+    t.Fatalf("Element.SetInnerXML() did not replace the children")
+  }
+  b := r.ChildNodes().Item(1).(Element)
+  if b.NamespaceURI() != "urn:p" || r.LastChild().NamespaceURI() != "urn:a" {
+    t.Errorf("Element.SetInnerXML() did not use the namespaces in scope")
+  }
+  if d.GetElementById("new") != b || d.GetElementById("old") != nil {
+    t.Errorf("Element.SetInnerXML() did not update the ID index")
+  }
+  if old.ParentNode() != nil || old.NextSibling() != nil {
+    t.Errorf("Element.SetInnerXML() left the old children linked")
+  }
+  if err := r.SetInnerXML(`<unclosed>`); err == nil || r.ChildNodes().Length() != 3 {
+    t.Errorf("Element.SetInnerXML() of bad markup did not fail cleanly")
+  }
+}
+
+func TestSetOuterXML(t *testing.T) {
+  d, _ := ParseString(`<a><b/><c/></a>`)
+  r := d.DocumentElement()
+  if err := r.FirstChild().(Element).SetOuterXML(`<x/><y/>`); err != nil {
+    t.Fatalf("Element.SetOuterXML() failed: %v", err)
+  }
+  if s := ToXml(d); s != `<a><x></x><y></y><c></c></a>` {
+    t.Errorf("Element.SetOuterXML() gave %s", s)
+  }
+  if err := r.SetOuterXML(`<x/><y/>`); err != ErrMultipleRoots {
+    t.Errorf("Element.SetOuterXML() of the root with two elements gave %v", err)
+  }
+  if err := r.SetOuterXML(`<z/>`); err != nil || d.DocumentElement().NodeName() != "z" {
+    t.Errorf("Element.SetOuterXML() did not replace the root: %v", err)
+  }
+  if err := d.CreateElement("e").SetOuterXML(`<z/>`); err != ErrNoParent {
+    t.Errorf("Element.SetOuterXML() of a detached element gave %v", err)
+  }
+}
+
+func TestDocumentFragment(t *testing.T) {
+  d, _ := ParseString(`<a><z/></a>`)
+  f := d.CreateDocumentFragment()
+  f.AppendChild(d.CreateElement("x"))
+  f.AppendChild(d.CreateElement("y"))
+  r := d.DocumentElement()
+  r.InsertBefore(f, r.FirstChild())
+  if r.ChildNodes().Length() != 3 || r.FirstChild().NodeName() != "x" || f.HasChildNodes() {
+    t.Errorf("Inserting a DocumentFragment did not move its children")
+  }
+  if f.NodeName() != "#document-fragment" || f.OwnerDocument() != d {
+    t.Errorf("DocumentFragment has the wrong name or owner")
+  }
+}
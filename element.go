@@ -8,12 +8,58 @@ package dom
  */
 
 import (
+	"bytes"
 	"encoding/xml"
+	"errors"
+	"strings"
 )
 
+// ErrNoParent is returned by SetOuterXML for an element without a parent.
+var ErrNoParent = errors.New("element has no parent")
+
 type _elem struct {
 	*_node
-	attribs map[string]*_attr // attributes of the element
+	attribs []*_attr   // attributes of the element, in document order
+	index   *attrIndex // where they are in attribs, once there are many
+}
+
+// attrIndexMin is how many attributes an element has before they are
+// looked up through an attrIndex instead of one by one.
+const attrIndexMin = 8
+
+// an attrIndex maps the names of the attributes of an element to their
+// places in attribs. The first of several with a name is the one kept.
+type attrIndex struct {
+	names    map[string]int   // by qualified name
+	expanded map[xml.Name]int // by namespace URI and local name
+}
+
+// indexAttrs returns the index of the attributes, building it if need be.
+func (e *_elem) indexAttrs() *attrIndex {
+	if e.index == nil {
+		e.index = &attrIndex{make(map[string]int, len(e.attribs)), make(map[xml.Name]int, len(e.attribs))}
+		for i, a := range e.attribs {
+			e.index.add(a, i)
+		}
+	}
+	return e.index
+}
+
+func (x *attrIndex) add(a *_attr, i int) {
+	if _, ok := x.names[a.NodeName()]; !ok {
+		x.names[a.NodeName()] = i
+	}
+	if _, ok := x.expanded[a.n]; !ok {
+		x.expanded[a.n] = i
+	}
+}
+
+// addAttr appends a to the attributes.
+func (e *_elem) addAttr(a *_attr) {
+	e.attribs = append(e.attribs, a)
+	if e.index != nil {
+		e.index.add(a, len(e.attribs)-1)
+	}
 }
 
 func (e *_elem) NodeValue() string {
@@ -41,76 +87,259 @@ func (e *_elem) Attributes() NamedNodeMap {
 }
 
 func (e *_elem) GetElementById(id string) Element {
-	return getElementById(e, id).(Element)
+	return getElementById(e, id)
+}
+
+// attr returns the attribute with the given qualified name.
+func (e *_elem) attr(name string) *_attr {
+	if len(e.attribs) >= attrIndexMin {
+		if i, ok := e.indexAttrs().names[name]; ok {
+			return e.attribs[i]
+		}
+		return nil
+	}
+	for _, a := range e.attribs {
+		if a.NodeName() == name {
+			return a
+		}
+	}
+	return nil
+}
+
+// attrNS returns the attribute with the given namespace and local name.
+func (e *_elem) attrNS(namespaceURI, localName string) *_attr {
+	if len(e.attribs) >= attrIndexMin {
+		if i, ok := e.indexAttrs().expanded[xml.Name{Space: namespaceURI, Local: localName}]; ok {
+			return e.attribs[i]
+		}
+		return nil
+	}
+	for _, a := range e.attribs {
+		if a.n.Space == namespaceURI && a.n.Local == localName {
+			return a
+		}
+	}
+	return nil
 }
 
 func (e *_elem) GetAttribute(name string) string {
-	attr, ok := e.attribs[name]
-	if ok {
+	if attr := e.attr(name); attr != nil {
 		return attr.GetValue()
 	}
 	return ""
 }
 
 func (e *_elem) GetAttributeNode(attrName string) Attr {
-	attr, ok := e.attribs[attrName]
-	if ok {
+	if attr := e.attr(attrName); attr != nil {
 		return attr
 	}
 	return nil
 }
 
 func (e *_elem) SetAttribute(attrName string, attrVal string) {
-	attr, ok := e.attribs[attrName]
-	if !ok {
-		e.attribs[attrName] = newAttr(attrName, attrVal, e)
-	} else {
-		attr.value = attrVal
+	if attr := e.attr(attrName); attr != nil {
+		attr.SetValue(attrVal)
+		return
 	}
+	e.addAttr(newAttr(attrName, attrVal, e))
+	e.updateId(attrName, "", attrVal)
 }
 
 func (e *_elem) SetAttributeNode(newAttr Attr) Attr {
-	oldAttr, ok := e.attribs[newAttr.Name()]
 	// New attribute must not have an owner element.
-	if newAttr.OwnerElement() == nil {
-		var a *_attr = newAttr.(*_attr)
-		e.attribs[newAttr.Name()] = a
-		if ok {
+	if newAttr.OwnerElement() != nil {
+		return nil
+	}
+	a := newAttr.(*_attr)
+	a.ownerElement = e
+	for i, oldAttr := range e.attribs {
+		if oldAttr.NodeName() == a.NodeName() {
+			e.attribs[i] = a
+			e.index = nil
+			oldAttr.ownerElement = nil
+			e.updateId(a.Name(), oldAttr.value, a.value)
 			return oldAttr
 		}
 	}
+	e.addAttr(a)
+	e.updateId(a.Name(), "", a.value)
 	return nil
 }
 
 // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-6D6AC0F9
 func (e *_elem) RemoveAttribute(name string) {
-	delete(e.attribs, name)
+	if attr := e.attr(name); attr != nil {
+		e.RemoveAttributeNode(attr)
+	}
 }
 
 func (e *_elem) RemoveAttributeNode(oldAttr Attr) Attr {
-	for name, attr := range e.attribs {
+	for i, attr := range e.attribs {
 		if attr == oldAttr {
-			delete(e.attribs, name)
+			e.attribs = append(e.attribs[:i], e.attribs[i+1:]...)
+			e.index = nil
+			attr.ownerElement = nil
+			e.updateId(attr.Name(), attr.value, "")
 			return oldAttr
 		}
 	}
 	return nil
 }
 
+// updateId keeps the ID index of the owner document in step when the
+// value of the attribute called name changes from oldVal to newVal.
+func (e *_elem) updateId(name, oldVal, newVal string) {
+	if name != "id" || oldVal == newVal {
+		return
+	}
+	if d, ok := ownerDocument(e).(*_doc); ok {
+		if oldVal != "" {
+			d.removeId(oldVal, e)
+		}
+		if newVal != "" {
+			d.addId(newVal, e)
+		}
+	}
+}
+
 // http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-ElHasAttr
 func (e *_elem) HasAttribute(name string) bool {
-	_, has := e.attribs[name]
-	return has
+	return e.attr(name) != nil
 }
 
 func (e *_elem) GetElementsByTagName(name string) NodeList {
 	return newTagNodeList(e, name)
 }
 
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-ElGetAttrNS
+func (e *_elem) GetAttributeNS(namespaceURI string, localName string) string {
+	if attr := e.attrNS(namespaceURI, localName); attr != nil {
+		return attr.value
+	}
+	return ""
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-ElGetAtNodeNS
+func (e *_elem) GetAttributeNodeNS(namespaceURI string, localName string) Attr {
+	if attr := e.attrNS(namespaceURI, localName); attr != nil {
+		return attr
+	}
+	return nil
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-ElSetAttrNS
+func (e *_elem) SetAttributeNS(namespaceURI string, qualifiedName string, value string) {
+	prefix, local := splitQName(qualifiedName)
+	if attr := e.attrNS(namespaceURI, local); attr != nil {
+		if attr.pfx != prefix {
+			attr.pfx = prefix
+			e.index = nil
+		}
+		attr.SetValue(value)
+		return
+	}
+	e.addAttr(newAttrNS(namespaceURI, qualifiedName, value, e))
+	e.updateId(qualifiedName, "", value)
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-ElRemAtNS
+func (e *_elem) RemoveAttributeNS(namespaceURI string, localName string) {
+	if attr := e.attrNS(namespaceURI, localName); attr != nil {
+		e.RemoveAttributeNode(attr)
+	}
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-ElHasAttrNS
+func (e *_elem) HasAttributeNS(namespaceURI string, localName string) bool {
+	return e.attrNS(namespaceURI, localName) != nil
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-A6C90942
+func (e *_elem) GetElementsByTagNameNS(namespaceURI string, localName string) NodeList {
+	return newTagNodeListNS(e, namespaceURI, localName)
+}
+
+// InnerXML returns the children of the element as XML.
+func (e *_elem) InnerXML() string {
+	var b strings.Builder
+	w := newXMLWriter(&b, nil)
+	for c := e.FirstChild(); c != nil; c = c.NextSibling() {
+		w.node(c)
+	}
+	w.Flush()
+	return b.String()
+}
+
+// OuterXML returns the element and its children as XML.
+func (e *_elem) OuterXML() string {
+	return toXml(e)
+}
+
+// SetInnerXML replaces the children of the element with the nodes parsed
+// from s, which may use the namespaces in scope at the element. On error
+// the element is left as it was.
+func (e *_elem) SetInnerXML(s string) error {
+	f, err := ParseFragment(e, strings.NewReader(s))
+	if err != nil {
+		return err
+	}
+	removeChildren(e)
+	e.AppendChild(f)
+	return nil
+}
+
+// SetOuterXML replaces the element with the nodes parsed from s, which
+// may use the namespaces in scope at its parent. The document element
+// can only be replaced by markup holding at most one element.
+func (e *_elem) SetOuterXML(s string) error {
+	parent := e.ParentNode()
+	if parent == nil {
+		return ErrNoParent
+	}
+	var context Element
+	if parent.NodeType() == ELEMENT_NODE {
+		context = parent.(Element)
+	}
+	f, err := ParseFragment(context, strings.NewReader(s))
+	if err != nil {
+		return err
+	}
+	if parent.NodeType() == DOCUMENT_NODE {
+		roots := 0
+		for c := f.FirstChild(); c != nil; c = c.NextSibling() {
+			switch c.NodeType() {
+			case ELEMENT_NODE:
+				roots++
+			case TEXT_NODE:
+				if len(bytes.TrimSpace(c.(*_text).content)) > 0 {
+					return ErrTextOutsideRoot
+				}
+			case ENTITY_REFERENCE_NODE:
+				return ErrReferenceOutsideRoot
+			}
+		}
+		if roots > 1 {
+			return ErrMultipleRoots
+		}
+	}
+	parent.InsertBefore(f, e)
+	parent.RemoveChild(e)
+	return nil
+}
+
 func newElem(token xml.StartElement) *_elem {
 	n := newNode(ELEMENT_NODE)
 	n.n = token.Name
-	e := &_elem{n, make(map[string]*_attr)}
+	e := &_elem{_node: n}
 	n.self = Node(e)
 	return e
 }
+
+// newElemNS creates an element in the given namespace. The prefix of
+// qualifiedName, if any, is kept for serialization.
+func newElemNS(namespaceURI string, qualifiedName string) *_elem {
+	prefix, local := splitQName(qualifiedName)
+	e := newElem(xml.StartElement{Name: xml.Name{Space: namespaceURI, Local: local}})
+	e.pfx = prefix
+	return e
+}
@@ -0,0 +1,25 @@
+package dom
+
+/*
+ * EntityReference node implementation
+ */
+
+type _entityref struct {
+	*_node
+}
+
+func (er *_entityref) NodeValue() string {
+	return ""
+}
+
+func (er *_entityref) OwnerDocument() Document {
+	return ownerDocument(er)
+}
+
+func newEntityRef(name string) *_entityref {
+	n := newNode(ENTITY_REFERENCE_NODE)
+	n.n.Local = name
+	er := &_entityref{n}
+	n.self = Node(er)
+	return er
+}
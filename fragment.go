@@ -0,0 +1,25 @@
+package dom
+
+/*
+ * DocumentFragment implementation
+ */
+
+type _frag struct {
+	*_node
+	owner *_doc // the document the fragment was made for, if any
+}
+
+func (f *_frag) NodeValue() string {
+	return ""
+}
+
+func (f *_frag) OwnerDocument() Document {
+	return ownerDocument(f)
+}
+
+func newFrag(owner *_doc) *_frag {
+	n := newNode(DOCUMENT_FRAGMENT_NODE)
+	f := &_frag{n, owner}
+	n.self = Node(f)
+	return f
+}
@@ -0,0 +1,2266 @@
+package dom
+
+/*
+ * An HTML parser that builds the same Document, Element, Text and Comment
+ * nodes as Parse, following the tree construction rules of
+ * https://html.spec.whatwg.org/multipage/parsing.html#tree-construction
+ *
+ * Scripting is taken to be enabled, so noscript holds raw text. DOM Core
+ * has no template contents: the content of a template element becomes
+ * its children.
+ */
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+const (
+	htmlURL   = "http://www.w3.org/1999/xhtml"
+	mathMLURL = "http://www.w3.org/1998/Math/MathML"
+	svgURL    = "http://www.w3.org/2000/svg"
+	xlinkURL  = "http://www.w3.org/1999/xlink"
+)
+
+// ParseHTML parses r as an HTML document the way a web browser does: it
+// never fails on bad markup, but fixes it up. Elements are in the XHTML
+// namespace, or in the SVG and MathML namespaces for foreign content, and
+// their names are in lower case. The input has to be UTF-8. The
+// DefaultLimits apply; going beyond them returns a *LimitError.
+func ParseHTML(r io.Reader) (Document, error) {
+	limits := DefaultLimits()
+	if limits.MaxInputBytes > 0 {
+		r = io.LimitReader(r, limits.MaxInputBytes+1)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := exceeds("MaxInputBytes", limits.MaxInputBytes, int64(len(data))); err != nil {
+		return nil, err
+	}
+	d := newDoc()
+	d.xmlVersion = ""
+	d.html = true
+	p := &htmlParser{z: newHTMLTokenizer(string(data)), d: d, limits: limits, framesetOK: true}
+	if err := p.parse(); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+func ParseHTMLString(s string) (Document, error) {
+	return ParseHTML(strings.NewReader(s))
+}
+
+// the insertion modes of the tree builder
+type insertionMode int
+
+const (
+	htmlInitial insertionMode = iota
+	htmlBeforeHTML
+	htmlBeforeHead
+	htmlInHead
+	htmlAfterHead
+	htmlInBody
+	htmlText
+	htmlInTable
+	htmlInTableText
+	htmlInCaption
+	htmlInColumnGroup
+	htmlInTableBody
+	htmlInRow
+	htmlInCell
+	htmlInSelect
+	htmlInSelectInTable
+	htmlInTemplate
+	htmlAfterBody
+	htmlInFrameset
+	htmlAfterFrameset
+	htmlAfterAfterBody
+	htmlAfterAfterFrameset
+)
+
+type htmlParser struct {
+	z               *htmlTokenizer
+	tok             htmlToken // the current token
+	d               *_doc
+	oe              []*_elem // the stack of open elements
+	afe             []*_elem // the list of active formatting elements; nil is a marker
+	head, form      *_elem
+	mode            insertionMode
+	originalMode    insertionMode
+	templateModes   []insertionMode
+	framesetOK      bool
+	fosterParenting bool
+	quirks          bool
+	skipLF          bool // a newline at the start of the next token is dropped
+	tableText       strings.Builder
+	limits          Limits
+	nodes           int
+	err             error // the first limit the document went beyond
+}
+
+func (p *htmlParser) parse() error {
+	for {
+		p.z.cdata = len(p.oe) > 0 && p.top().n.Space != htmlURL
+		p.tok = p.z.next()
+		if p.skipLF {
+			p.skipLF = false
+			if p.tok.typ == htmlTextToken && strings.HasPrefix(p.tok.data, "\n") {
+				if p.tok.data = p.tok.data[1:]; p.tok.data == "" {
+					continue
+				}
+			}
+		}
+		for done := false; !done && p.err == nil; {
+			if p.inForeignContent() {
+				done = p.foreignContent()
+			} else {
+				done = p.using(p.mode)
+			}
+		}
+		if p.err != nil {
+			return p.err
+		}
+		if p.tok.typ == htmlEOFToken {
+			return nil
+		}
+	}
+}
+
+// using processes the current token by the rules of insertion mode m. It
+// returns false if the token has to be processed again.
+func (p *htmlParser) using(m insertionMode) bool {
+	switch m {
+	case htmlInitial:
+		return p.initial()
+	case htmlBeforeHTML:
+		return p.beforeHTML()
+	case htmlBeforeHead:
+		return p.beforeHead()
+	case htmlInHead:
+		return p.inHead()
+	case htmlAfterHead:
+		return p.afterHead()
+	case htmlInBody:
+		return p.inBody()
+	case htmlText:
+		return p.text()
+	case htmlInTable:
+		return p.inTable()
+	case htmlInTableText:
+		return p.inTableText()
+	case htmlInCaption:
+		return p.inCaption()
+	case htmlInColumnGroup:
+		return p.inColumnGroup()
+	case htmlInTableBody:
+		return p.inTableBody()
+	case htmlInRow:
+		return p.inRow()
+	case htmlInCell:
+		return p.inCell()
+	case htmlInSelect:
+		return p.inSelect()
+	case htmlInSelectInTable:
+		return p.inSelectInTable()
+	case htmlInTemplate:
+		return p.inTemplate()
+	case htmlAfterBody:
+		return p.afterBody()
+	case htmlInFrameset:
+		return p.inFrameset()
+	case htmlAfterFrameset:
+		return p.afterFrameset()
+	case htmlAfterAfterBody:
+		return p.afterAfterBody()
+	}
+	return p.afterAfterFrameset()
+}
+
+// ====================================
+// the stack of open elements and the list of active formatting elements
+
+func (p *htmlParser) top() *_elem {
+	if len(p.oe) == 0 {
+		return nil
+	}
+	return p.oe[len(p.oe)-1]
+}
+
+func (p *htmlParser) pop() {
+	p.oe = p.oe[:len(p.oe)-1]
+}
+
+// elemIs reports whether e is an element in namespace ns with one of the
+// given names.
+func elemIs(e *_elem, ns string, names ...string) bool {
+	if e == nil || e.n.Space != ns {
+		return false
+	}
+	for _, name := range names {
+		if e.n.Local == name {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlIs reports whether e is an HTML element with one of the names.
+func htmlIs(e *_elem, names ...string) bool {
+	return elemIs(e, htmlURL, names...)
+}
+
+func indexOf(list []*_elem, e *_elem) int {
+	for i := len(list) - 1; i >= 0; i-- {
+		if list[i] == e {
+			return i
+		}
+	}
+	return -1
+}
+
+func removeAt(list []*_elem, i int) []*_elem {
+	return append(list[:i], list[i+1:]...)
+}
+
+func insertAt(list []*_elem, i int, e *_elem) []*_elem {
+	list = append(list, nil)
+	copy(list[i+1:], list[i:])
+	list[i] = e
+	return list
+}
+
+// hasOnStack reports whether an HTML element called name is open.
+func (p *htmlParser) hasOnStack(name string) bool {
+	for _, e := range p.oe {
+		if htmlIs(e, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// the kinds of scope an element may be in
+type htmlScope int
+
+const (
+	defaultScope htmlScope = iota
+	listItemScope
+	buttonScope
+	tableScope
+	selectScope
+)
+
+// scopeBoundary reports whether e ends a scope of kind s.
+func scopeBoundary(e *_elem, s htmlScope) bool {
+	switch s {
+	case tableScope:
+		return htmlIs(e, "html", "table", "template")
+	case selectScope:
+		return !htmlIs(e, "optgroup", "option")
+	case listItemScope:
+		if htmlIs(e, "ol", "ul") {
+			return true
+		}
+	case buttonScope:
+		if htmlIs(e, "button") {
+			return true
+		}
+	}
+	return htmlIs(e, "applet", "caption", "html", "table", "td", "th", "marquee", "object", "template") ||
+		elemIs(e, mathMLURL, "mi", "mo", "mn", "ms", "mtext", "annotation-xml") ||
+		elemIs(e, svgURL, "foreignObject", "desc", "title")
+}
+
+// indexInScope returns the position on the stack of the HTML element
+// with one of the names that is in scope s, or -1 if there is none.
+func (p *htmlParser) indexInScope(s htmlScope, names ...string) int {
+	for i := len(p.oe) - 1; i >= 0; i-- {
+		if htmlIs(p.oe[i], names...) {
+			return i
+		}
+		if scopeBoundary(p.oe[i], s) {
+			return -1
+		}
+	}
+	return -1
+}
+
+func (p *htmlParser) inScope(s htmlScope, names ...string) bool {
+	return p.indexInScope(s, names...) >= 0
+}
+
+// popUntil pops the stack up to and including the element with one of
+// the names that is in scope s. It returns false if there is none.
+func (p *htmlParser) popUntil(s htmlScope, names ...string) bool {
+	i := p.indexInScope(s, names...)
+	if i < 0 {
+		return false
+	}
+	p.oe = p.oe[:i]
+	return true
+}
+
+// clearStackBackTo pops elements until the current node is one of the
+// names, html or template.
+func (p *htmlParser) clearStackBackTo(names ...string) {
+	for len(p.oe) > 0 && !htmlIs(p.top(), names...) && !htmlIs(p.top(), "html", "template") {
+		p.pop()
+	}
+}
+
+// generateImpliedEndTags closes the elements whose end tags may be left
+// out, except those called except.
+func (p *htmlParser) generateImpliedEndTags(except ...string) {
+	for len(p.oe) > 0 {
+		n := p.top()
+		if !htmlIs(n, "dd", "dt", "li", "optgroup", "option", "p", "rb", "rp", "rt", "rtc") || htmlIs(n, except...) {
+			return
+		}
+		p.pop()
+	}
+}
+
+// generateAllImpliedEndTags also closes the parts of tables.
+func (p *htmlParser) generateAllImpliedEndTags() {
+	for len(p.oe) > 0 && htmlIs(p.top(), "caption", "colgroup", "dd", "dt", "li", "optgroup", "option", "p",
+		"rb", "rp", "rt", "rtc", "tbody", "td", "tfoot", "th", "thead", "tr") {
+		p.pop()
+	}
+}
+
+func (p *htmlParser) closePElement() {
+	p.generateImpliedEndTags("p")
+	p.popUntil(buttonScope, "p")
+}
+
+func (p *htmlParser) closePInButtonScope() {
+	if p.inScope(buttonScope, "p") {
+		p.closePElement()
+	}
+}
+
+// isSpecial reports whether e is in the special category of elements.
+func isSpecial(e *_elem) bool {
+	switch e.n.Space {
+	case htmlURL:
+		return htmlSpecial[e.n.Local]
+	case mathMLURL:
+		return elemIs(e, mathMLURL, "mi", "mo", "mn", "ms", "mtext", "annotation-xml")
+	case svgURL:
+		return elemIs(e, svgURL, "foreignObject", "desc", "title")
+	}
+	return false
+}
+
+var htmlSpecial = map[string]bool{
+	"address": true, "applet": true, "area": true, "article": true, "aside": true, "base": true,
+	"basefont": true, "bgsound": true, "blockquote": true, "body": true, "br": true, "button": true,
+	"caption": true, "center": true, "col": true, "colgroup": true, "dd": true, "details": true,
+	"dir": true, "div": true, "dl": true, "dt": true, "embed": true, "fieldset": true,
+	"figcaption": true, "figure": true, "footer": true, "form": true, "frame": true, "frameset": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "head": true,
+	"header": true, "hgroup": true, "hr": true, "html": true, "iframe": true, "img": true,
+	"input": true, "keygen": true, "li": true, "link": true, "listing": true, "main": true,
+	"marquee": true, "menu": true, "meta": true, "nav": true, "noembed": true, "noframes": true,
+	"noscript": true, "object": true, "ol": true, "p": true, "param": true, "plaintext": true,
+	"pre": true, "script": true, "search": true, "section": true, "select": true, "source": true,
+	"style": true, "summary": true, "table": true, "tbody": true, "td": true, "template": true,
+	"textarea": true, "tfoot": true, "th": true, "thead": true, "title": true, "tr": true,
+	"track": true, "ul": true, "wbr": true, "xmp": true,
+}
+
+func (p *htmlParser) clearActiveFormattingElements() {
+	for len(p.afe) > 0 {
+		e := p.afe[len(p.afe)-1]
+		p.afe = p.afe[:len(p.afe)-1]
+		if e == nil {
+			return
+		}
+	}
+}
+
+// reconstructActiveFormattingElements reopens the formatting elements
+// that were closed implicitly, as in <b>1<p>2 where the b goes on in p.
+func (p *htmlParser) reconstructActiveFormattingElements() {
+	if len(p.afe) == 0 {
+		return
+	}
+	i := len(p.afe) - 1
+	if e := p.afe[i]; e == nil || indexOf(p.oe, e) >= 0 {
+		return
+	}
+	for i > 0 {
+		if e := p.afe[i-1]; e == nil || indexOf(p.oe, e) >= 0 {
+			break
+		}
+		i--
+	}
+	for ; i < len(p.afe); i++ {
+		e := p.cloneElement(p.afe[i])
+		p.insert(e)
+		p.oe = append(p.oe, e)
+		p.afe[i] = e
+	}
+}
+
+// ====================================
+// creating and inserting nodes
+
+// count counts a new node against the limits.
+func (p *htmlParser) count(data int) {
+	p.nodes++
+	p.check(exceeds("MaxNodes", int64(p.limits.MaxNodes), int64(p.nodes)))
+	p.check(exceeds("MaxTextLength", int64(p.limits.MaxTextLength), int64(data)))
+}
+
+func (p *htmlParser) check(err error) {
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+// insertionPlace returns the appropriate place for inserting a node:
+// before the node before, or at the end of parent if before is nil.
+// target overrides the current node if it is not nil.
+func (p *htmlParser) insertionPlace(target *_elem) (parent Node, before Node) {
+	if target == nil {
+		target = p.top()
+	}
+	if !p.fosterParenting || !htmlIs(target, "table", "tbody", "tfoot", "thead", "tr") {
+		return target, nil
+	}
+	// foster parenting: in front of the table
+	table, template := -1, -1
+	for i := len(p.oe) - 1; i >= 0; i-- {
+		if table < 0 && htmlIs(p.oe[i], "table") {
+			table = i
+		}
+		if template < 0 && htmlIs(p.oe[i], "template") {
+			template = i
+		}
+	}
+	switch {
+	case template >= 0 && template > table:
+		return p.oe[template], nil
+	case table < 0:
+		return p.oe[0], nil
+	case p.oe[table].ParentNode() != nil:
+		return p.oe[table].ParentNode(), p.oe[table]
+	}
+	return p.oe[table-1], nil
+}
+
+// insert puts n at the appropriate place for inserting a node.
+func (p *htmlParser) insert(n Node) {
+	p.insertAt(n, nil)
+}
+
+// insertAt puts n at the appropriate place with target as the override
+// target.
+func (p *htmlParser) insertAt(n Node, target *_elem) {
+	parent, before := p.insertionPlace(target)
+	if before == nil {
+		parent.AppendChild(n)
+	} else {
+		parent.InsertBefore(n, before)
+	}
+}
+
+// createElement creates an element in namespace ns for tag token t.
+func (p *htmlParser) createElement(t *htmlToken, ns string) *_elem {
+	e := newElem(xml.StartElement{Name: xml.Name{Space: ns, Local: t.data}})
+	for _, a := range t.attr {
+		attr := newAttr(a.name, a.value, e)
+		attr.n.Space = a.ns
+		attr.pfx = a.prefix
+		e.addAttr(attr)
+	}
+	p.count(0)
+	p.check(exceeds("MaxAttributesPerElement", int64(p.limits.MaxAttributesPerElement), int64(len(t.attr))))
+	return e
+}
+
+// cloneElement creates an element like e, without its children.
+func (p *htmlParser) cloneElement(e *_elem) *_elem {
+	t := htmlToken{typ: htmlStartTagToken, data: e.n.Local}
+	for _, a := range e.attribs {
+		t.attr = append(t.attr, htmlAttr{a.n.Space, a.pfx, a.n.Local, a.value})
+	}
+	return p.createElement(&t, e.n.Space)
+}
+
+// insertElement inserts an element in namespace ns for tag token t and
+// pushes it onto the stack.
+func (p *htmlParser) insertElement(t *htmlToken, ns string) *_elem {
+	e := p.createElement(t, ns)
+	p.insert(e)
+	p.oe = append(p.oe, e)
+	p.check(exceeds("MaxDepth", int64(p.limits.MaxDepth), int64(len(p.oe))))
+	return e
+}
+
+// addElement inserts an HTML element for the current token.
+func (p *htmlParser) addElement() *_elem {
+	return p.insertElement(&p.tok, htmlURL)
+}
+
+// addElementNamed inserts an HTML element for a start tag that is not
+// in the input.
+func (p *htmlParser) addElementNamed(name string) *_elem {
+	return p.insertElement(&htmlToken{typ: htmlStartTagToken, data: name}, htmlURL)
+}
+
+// addFormattingElement inserts an HTML element for the current token and
+// adds it to the list of active formatting elements.
+func (p *htmlParser) addFormattingElement() {
+	e := p.addElement()
+	// no more than three of the same since the last marker
+	same := 0
+	for i := len(p.afe) - 1; i >= 0 && p.afe[i] != nil; i-- {
+		if f := p.afe[i]; f.n == e.n && sameAttributes(f, e) {
+			if same++; same == 3 {
+				p.afe = removeAt(p.afe, i)
+				break
+			}
+		}
+	}
+	p.afe = append(p.afe, e)
+}
+
+func sameAttributes(a, b *_elem) bool {
+	if len(a.attribs) != len(b.attribs) {
+		return false
+	}
+	for _, attr := range a.attribs {
+		if other := b.attrNS(attr.n.Space, attr.n.Local); other == nil || other.value != attr.value {
+			return false
+		}
+	}
+	return true
+}
+
+// addMissingAttributes adds the attributes of the current token that e
+// does not have, as for a second <html> or <body> tag.
+func (p *htmlParser) addMissingAttributes(e *_elem) {
+	for _, a := range p.tok.attr {
+		if !e.HasAttribute(a.name) {
+			e.SetAttribute(a.name, a.value)
+		}
+	}
+}
+
+// addText inserts text at the appropriate place, merging it with a text
+// node that is there already.
+func (p *htmlParser) addText(s string) {
+	if s == "" {
+		return
+	}
+	parent, before := p.insertionPlace(nil)
+	if parent.NodeType() == DOCUMENT_NODE {
+		return
+	}
+	prev := parent.LastChild()
+	if before != nil {
+		prev = before.PreviousSibling()
+	}
+	if t, ok := prev.(*_text); ok {
+		t.content = append(t.content, s...)
+		p.check(exceeds("MaxTextLength", int64(p.limits.MaxTextLength), int64(len(t.content))))
+		return
+	}
+	p.count(len(s))
+	p.insert(newText(xml.CharData(s)))
+}
+
+// addComment appends a comment for the current token to parent, or
+// inserts it at the appropriate place if parent is nil.
+func (p *htmlParser) addComment(parent Node) {
+	c := newComment(xml.Comment(p.tok.data))
+	p.count(len(p.tok.data))
+	if parent != nil {
+		parent.AppendChild(c)
+		return
+	}
+	p.insert(c)
+}
+
+// parseText inserts an element for the current token whose content is
+// text, read by the tokenizer in the given state.
+func (p *htmlParser) parseText(state htmlTextState) {
+	p.addElement()
+	p.z.state = state
+	p.originalMode = p.mode
+	p.mode = htmlText
+}
+
+// leadingSpace splits s into its leading whitespace and the rest.
+func leadingSpace(s string) (string, string) {
+	i := 0
+	for i < len(s) && isHTMLSpace(s[i]) {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+// onlySpace returns the whitespace characters of s.
+func onlySpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r < 0x80 && isHTMLSpace(byte(r)) {
+			return r
+		}
+		return -1
+	}, s)
+}
+
+func isAllSpace(s string) bool {
+	_, rest := leadingSpace(s)
+	return rest == ""
+}
+
+// attrValue returns the value of the named attribute of tag token t.
+func attrValue(t *htmlToken, name string) (string, bool) {
+	for _, a := range t.attr {
+		if a.name == name {
+			return a.value, true
+		}
+	}
+	return "", false
+}
+
+// resetInsertionMode picks the insertion mode that fits the open
+// elements, as after a table or select is closed.
+func (p *htmlParser) resetInsertionMode() {
+	for i := len(p.oe) - 1; i >= 0; i-- {
+		n, last := p.oe[i], i == 0
+		if n.n.Space != htmlURL {
+			continue
+		}
+		switch n.n.Local {
+		case "select":
+			for j := i - 1; j > 0 && !last; j-- {
+				if htmlIs(p.oe[j], "template") {
+					break
+				}
+				if htmlIs(p.oe[j], "table") {
+					p.mode = htmlInSelectInTable
+					return
+				}
+			}
+			p.mode = htmlInSelect
+			return
+		case "td", "th":
+			if !last {
+				p.mode = htmlInCell
+				return
+			}
+		case "tr":
+			p.mode = htmlInRow
+			return
+		case "tbody", "thead", "tfoot":
+			p.mode = htmlInTableBody
+			return
+		case "caption":
+			p.mode = htmlInCaption
+			return
+		case "colgroup":
+			p.mode = htmlInColumnGroup
+			return
+		case "table":
+			p.mode = htmlInTable
+			return
+		case "template":
+			p.mode = p.templateModes[len(p.templateModes)-1]
+			return
+		case "head":
+			if !last {
+				p.mode = htmlInHead
+				return
+			}
+		case "body":
+			p.mode = htmlInBody
+			return
+		case "frameset":
+			p.mode = htmlInFrameset
+			return
+		case "html":
+			if p.head == nil {
+				p.mode = htmlBeforeHead
+			} else {
+				p.mode = htmlAfterHead
+			}
+			return
+		}
+	}
+	p.mode = htmlInBody
+}
+
+// ====================================
+// the insertion modes
+
+func (p *htmlParser) initial() bool {
+	switch p.tok.typ {
+	case htmlTextToken:
+		_, rest := leadingSpace(p.tok.data)
+		if rest == "" {
+			return true
+		}
+		p.tok.data = rest
+	case htmlCommentToken:
+		p.addComment(p.d)
+		return true
+	case htmlDoctypeToken:
+		p.count(0)
+		p.d.AppendChild(newDoctype(p.tok.data, p.tok.publicId, p.tok.systemId, ""))
+		p.quirks = quirksDoctype(&p.tok)
+		p.mode = htmlBeforeHTML
+		return true
+	}
+	p.quirks = true
+	p.mode = htmlBeforeHTML
+	return false
+}
+
+func (p *htmlParser) beforeHTML() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlDoctypeToken:
+		return true
+	case htmlCommentToken:
+		p.addComment(p.d)
+		return true
+	case htmlTextToken:
+		_, rest := leadingSpace(t.data)
+		if rest == "" {
+			return true
+		}
+		t.data = rest
+	case htmlStartTagToken:
+		if t.data == "html" {
+			p.addRoot(t)
+			p.mode = htmlBeforeHead
+			return true
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "head", "body", "html", "br":
+		default:
+			return true
+		}
+	}
+	p.addRoot(&htmlToken{typ: htmlStartTagToken, data: "html"})
+	p.mode = htmlBeforeHead
+	return false
+}
+
+// addRoot appends the html element to the document.
+func (p *htmlParser) addRoot(t *htmlToken) {
+	e := p.createElement(t, htmlURL)
+	p.d.AppendChild(e)
+	p.oe = append(p.oe, e)
+}
+
+func (p *htmlParser) beforeHead() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		_, rest := leadingSpace(t.data)
+		if rest == "" {
+			return true
+		}
+		t.data = rest
+	case htmlCommentToken:
+		p.addComment(nil)
+		return true
+	case htmlDoctypeToken:
+		return true
+	case htmlStartTagToken:
+		switch t.data {
+		case "html":
+			return p.inBody()
+		case "head":
+			p.head = p.addElement()
+			p.mode = htmlInHead
+			return true
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "head", "body", "html", "br":
+		default:
+			return true
+		}
+	}
+	p.head = p.addElementNamed("head")
+	p.mode = htmlInHead
+	return false
+}
+
+func (p *htmlParser) inHead() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		space, rest := leadingSpace(t.data)
+		p.addText(space)
+		if rest == "" {
+			return true
+		}
+		t.data = rest
+	case htmlCommentToken:
+		p.addComment(nil)
+		return true
+	case htmlDoctypeToken:
+		return true
+	case htmlStartTagToken:
+		switch t.data {
+		case "html":
+			return p.inBody()
+		case "base", "basefont", "bgsound", "link", "meta":
+			p.addElement()
+			p.pop()
+			return true
+		case "title":
+			p.parseText(htmlRCDATAState)
+			return true
+		case "noscript", "noframes", "style":
+			p.parseText(htmlRawTextState)
+			return true
+		case "script":
+			p.parseText(htmlScriptDataState)
+			return true
+		case "template":
+			p.addElement()
+			p.afe = append(p.afe, nil)
+			p.framesetOK = false
+			p.mode = htmlInTemplate
+			p.templateModes = append(p.templateModes, htmlInTemplate)
+			return true
+		case "head":
+			return true
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "head":
+			p.pop()
+			p.mode = htmlAfterHead
+			return true
+		case "body", "html", "br":
+		case "template":
+			if !p.hasOnStack("template") {
+				return true
+			}
+			p.generateAllImpliedEndTags()
+			for !htmlIs(p.top(), "template") {
+				p.pop()
+			}
+			p.pop()
+			p.clearActiveFormattingElements()
+			p.templateModes = p.templateModes[:len(p.templateModes)-1]
+			p.resetInsertionMode()
+			return true
+		default:
+			return true
+		}
+	}
+	p.pop()
+	p.mode = htmlAfterHead
+	return false
+}
+
+func (p *htmlParser) afterHead() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		space, rest := leadingSpace(t.data)
+		p.addText(space)
+		if rest == "" {
+			return true
+		}
+		t.data = rest
+	case htmlCommentToken:
+		p.addComment(nil)
+		return true
+	case htmlDoctypeToken:
+		return true
+	case htmlStartTagToken:
+		switch t.data {
+		case "html":
+			return p.inBody()
+		case "body":
+			p.addElement()
+			p.framesetOK = false
+			p.mode = htmlInBody
+			return true
+		case "frameset":
+			p.addElement()
+			p.mode = htmlInFrameset
+			return true
+		case "base", "basefont", "bgsound", "link", "meta", "noframes", "script", "style", "template", "title":
+			// these still go into the head
+			head := p.head
+			p.oe = append(p.oe, head)
+			done := p.inHead()
+			if i := indexOf(p.oe, head); i >= 0 {
+				p.oe = removeAt(p.oe, i)
+			}
+			return done
+		case "head":
+			return true
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "template":
+			return p.inHead()
+		case "body", "html", "br":
+		default:
+			return true
+		}
+	}
+	p.addElementNamed("body")
+	p.mode = htmlInBody
+	return false
+}
+
+func (p *htmlParser) inBody() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		if t.data == "" {
+			return true
+		}
+		p.reconstructActiveFormattingElements()
+		p.addText(t.data)
+		if !isAllSpace(t.data) {
+			p.framesetOK = false
+		}
+	case htmlCommentToken:
+		p.addComment(nil)
+	case htmlStartTagToken:
+		return p.inBodyStartTag()
+	case htmlEndTagToken:
+		return p.inBodyEndTag()
+	case htmlEOFToken:
+		if len(p.templateModes) > 0 {
+			return p.inTemplate()
+		}
+	}
+	return true
+}
+
+func (p *htmlParser) inBodyStartTag() bool {
+	t := &p.tok
+	switch t.data {
+	case "html":
+		if !p.hasOnStack("template") {
+			p.addMissingAttributes(p.oe[0])
+		}
+	case "base", "basefont", "bgsound", "link", "meta", "noframes", "script", "style", "template", "title":
+		return p.inHead()
+	case "body":
+		if len(p.oe) < 2 || !htmlIs(p.oe[1], "body") || p.hasOnStack("template") {
+			return true
+		}
+		p.framesetOK = false
+		p.addMissingAttributes(p.oe[1])
+	case "frameset":
+		if len(p.oe) < 2 || !htmlIs(p.oe[1], "body") || !p.framesetOK {
+			return true
+		}
+		body := p.oe[1]
+		if parent := body.ParentNode(); parent != nil {
+			parent.RemoveChild(body)
+		}
+		p.oe = p.oe[:1]
+		p.addElement()
+		p.mode = htmlInFrameset
+	case "address", "article", "aside", "blockquote", "center", "details", "dialog", "dir", "div", "dl",
+		"fieldset", "figcaption", "figure", "footer", "header", "hgroup", "main", "menu", "nav", "ol", "p",
+		"search", "section", "summary", "ul":
+		p.closePInButtonScope()
+		p.addElement()
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		p.closePInButtonScope()
+		if htmlIs(p.top(), "h1", "h2", "h3", "h4", "h5", "h6") {
+			p.pop()
+		}
+		p.addElement()
+	case "pre", "listing":
+		p.closePInButtonScope()
+		p.addElement()
+		p.skipLF = true
+		p.framesetOK = false
+	case "form":
+		template := p.hasOnStack("template")
+		if p.form != nil && !template {
+			return true
+		}
+		p.closePInButtonScope()
+		e := p.addElement()
+		if !template {
+			p.form = e
+		}
+	case "li", "dd", "dt":
+		p.framesetOK = false
+		closes := []string{"li"}
+		if t.data != "li" {
+			closes = []string{"dd", "dt"}
+		}
+		for i := len(p.oe) - 1; i >= 0; i-- {
+			n := p.oe[i]
+			if htmlIs(n, closes...) {
+				p.generateImpliedEndTags(n.n.Local)
+				p.oe = p.oe[:indexOf(p.oe, n)]
+				break
+			}
+			if isSpecial(n) && !htmlIs(n, "address", "div", "p") {
+				break
+			}
+		}
+		p.closePInButtonScope()
+		p.addElement()
+	case "plaintext":
+		p.closePInButtonScope()
+		p.addElement()
+		p.z.state = htmlPlainTextState
+	case "button":
+		if p.inScope(defaultScope, "button") {
+			p.generateImpliedEndTags()
+			p.popUntil(defaultScope, "button")
+		}
+		p.reconstructActiveFormattingElements()
+		p.addElement()
+		p.framesetOK = false
+	case "a":
+		for i := len(p.afe) - 1; i >= 0 && p.afe[i] != nil; i-- {
+			if a := p.afe[i]; htmlIs(a, "a") {
+				p.adoptionAgency("a")
+				if j := indexOf(p.afe, a); j >= 0 {
+					p.afe = removeAt(p.afe, j)
+				}
+				if j := indexOf(p.oe, a); j >= 0 {
+					p.oe = removeAt(p.oe, j)
+				}
+				break
+			}
+		}
+		p.reconstructActiveFormattingElements()
+		p.addFormattingElement()
+	case "b", "big", "code", "em", "font", "i", "s", "small", "strike", "strong", "tt", "u":
+		p.reconstructActiveFormattingElements()
+		p.addFormattingElement()
+	case "nobr":
+		p.reconstructActiveFormattingElements()
+		if p.inScope(defaultScope, "nobr") {
+			p.adoptionAgency("nobr")
+			p.reconstructActiveFormattingElements()
+		}
+		p.addFormattingElement()
+	case "applet", "marquee", "object":
+		p.reconstructActiveFormattingElements()
+		p.addElement()
+		p.afe = append(p.afe, nil)
+		p.framesetOK = false
+	case "table":
+		if !p.quirks {
+			p.closePInButtonScope()
+		}
+		p.addElement()
+		p.framesetOK = false
+		p.mode = htmlInTable
+	case "area", "br", "embed", "img", "keygen", "wbr":
+		p.reconstructActiveFormattingElements()
+		p.addElement()
+		p.pop()
+		p.framesetOK = false
+	case "input":
+		p.reconstructActiveFormattingElements()
+		p.addElement()
+		p.pop()
+		if typ, _ := attrValue(t, "type"); !strings.EqualFold(typ, "hidden") {
+			p.framesetOK = false
+		}
+	case "param", "source", "track":
+		p.addElement()
+		p.pop()
+	case "hr":
+		p.closePInButtonScope()
+		p.addElement()
+		p.pop()
+		p.framesetOK = false
+	case "image":
+		t.data = "img"
+		return false
+	case "textarea":
+		p.addElement()
+		p.skipLF = true
+		p.z.state = htmlRCDATAState
+		p.originalMode = p.mode
+		p.framesetOK = false
+		p.mode = htmlText
+	case "xmp":
+		p.closePInButtonScope()
+		p.reconstructActiveFormattingElements()
+		p.framesetOK = false
+		p.parseText(htmlRawTextState)
+	case "iframe":
+		p.framesetOK = false
+		p.parseText(htmlRawTextState)
+	case "noembed", "noscript":
+		p.parseText(htmlRawTextState)
+	case "select":
+		p.reconstructActiveFormattingElements()
+		p.addElement()
+		p.framesetOK = false
+		switch p.mode {
+		case htmlInTable, htmlInCaption, htmlInTableBody, htmlInRow, htmlInCell:
+			p.mode = htmlInSelectInTable
+		default:
+			p.mode = htmlInSelect
+		}
+	case "optgroup", "option":
+		if htmlIs(p.top(), "option") {
+			p.pop()
+		}
+		p.reconstructActiveFormattingElements()
+		p.addElement()
+	case "rb", "rtc":
+		if p.inScope(defaultScope, "ruby") {
+			p.generateImpliedEndTags()
+		}
+		p.addElement()
+	case "rp", "rt":
+		if p.inScope(defaultScope, "ruby") {
+			p.generateImpliedEndTags("rtc")
+		}
+		p.addElement()
+	case "math", "svg":
+		p.reconstructActiveFormattingElements()
+		ns := mathMLURL
+		if t.data == "svg" {
+			ns = svgURL
+		}
+		adjustForeignToken(t, ns)
+		p.insertElement(t, ns)
+		if t.selfClosing {
+			p.pop()
+		}
+	case "caption", "col", "colgroup", "frame", "head", "tbody", "td", "tfoot", "th", "thead", "tr":
+		// ignored
+	default:
+		p.reconstructActiveFormattingElements()
+		p.addElement()
+	}
+	return true
+}
+
+func (p *htmlParser) inBodyEndTag() bool {
+	t := &p.tok
+	switch t.data {
+	case "template":
+		return p.inHead()
+	case "body", "html":
+		if !p.inScope(defaultScope, "body") {
+			return true
+		}
+		p.mode = htmlAfterBody
+		return t.data == "body"
+	case "address", "article", "aside", "blockquote", "button", "center", "details", "dialog", "dir", "div",
+		"dl", "fieldset", "figcaption", "figure", "footer", "header", "hgroup", "listing", "main", "menu",
+		"nav", "ol", "pre", "search", "section", "summary", "ul":
+		if p.inScope(defaultScope, t.data) {
+			p.generateImpliedEndTags()
+			p.popUntil(defaultScope, t.data)
+		}
+	case "form":
+		if p.hasOnStack("template") {
+			if p.inScope(defaultScope, "form") {
+				p.generateImpliedEndTags()
+				p.popUntil(defaultScope, "form")
+			}
+			return true
+		}
+		node := p.form
+		p.form = nil
+		if i := p.indexInScope(defaultScope, "form"); node == nil || i < 0 || p.oe[i] != node {
+			return true
+		}
+		p.generateImpliedEndTags()
+		p.oe = removeAt(p.oe, indexOf(p.oe, node))
+	case "p":
+		if !p.inScope(buttonScope, "p") {
+			p.addElementNamed("p")
+		}
+		p.closePElement()
+	case "li":
+		if p.inScope(listItemScope, "li") {
+			p.generateImpliedEndTags("li")
+			p.popUntil(listItemScope, "li")
+		}
+	case "dd", "dt":
+		if p.inScope(defaultScope, t.data) {
+			p.generateImpliedEndTags(t.data)
+			p.popUntil(defaultScope, t.data)
+		}
+	case "h1", "h2", "h3", "h4", "h5", "h6":
+		if p.inScope(defaultScope, "h1", "h2", "h3", "h4", "h5", "h6") {
+			p.generateImpliedEndTags()
+			p.popUntil(defaultScope, "h1", "h2", "h3", "h4", "h5", "h6")
+		}
+	case "a", "b", "big", "code", "em", "font", "i", "nobr", "s", "small", "strike", "strong", "tt", "u":
+		if !p.adoptionAgency(t.data) {
+			p.anyOtherEndTag()
+		}
+	case "applet", "marquee", "object":
+		if p.inScope(defaultScope, t.data) {
+			p.generateImpliedEndTags()
+			p.popUntil(defaultScope, t.data)
+			p.clearActiveFormattingElements()
+		}
+	case "br":
+		// as if it were <br>
+		t.typ = htmlStartTagToken
+		t.attr = nil
+		return false
+	default:
+		p.anyOtherEndTag()
+	}
+	return true
+}
+
+// anyOtherEndTag closes the element the current end tag is for, if no
+// special element is in the way.
+func (p *htmlParser) anyOtherEndTag() {
+	for i := len(p.oe) - 1; i >= 0; i-- {
+		n := p.oe[i]
+		if htmlIs(n, p.tok.data) {
+			p.generateImpliedEndTags(p.tok.data)
+			p.oe = p.oe[:indexOf(p.oe, n)]
+			return
+		}
+		if isSpecial(n) {
+			return
+		}
+	}
+}
+
+// adoptionAgency closes the formatting element called tag, fixing up
+// misnested tags as in <b>1<p>2</b>3</p>. It returns false if the end
+// tag is to be treated like any other end tag.
+func (p *htmlParser) adoptionAgency(tag string) bool {
+	if n := p.top(); htmlIs(n, tag) && indexOf(p.afe, n) < 0 {
+		p.pop()
+		return true
+	}
+	for outer := 0; outer < 8; outer++ {
+		fi := -1
+		for i := len(p.afe) - 1; i >= 0 && p.afe[i] != nil; i-- {
+			if htmlIs(p.afe[i], tag) {
+				fi = i
+				break
+			}
+		}
+		if fi < 0 {
+			return false
+		}
+		fe := p.afe[fi]
+		si := indexOf(p.oe, fe)
+		if si < 0 {
+			p.afe = removeAt(p.afe, fi)
+			return true
+		}
+		for i := len(p.oe) - 1; i > si; i-- {
+			if scopeBoundary(p.oe[i], defaultScope) {
+				// not in scope
+				return true
+			}
+		}
+		var fb *_elem // the furthest block
+		fbi := -1
+		for i := si + 1; i < len(p.oe); i++ {
+			if isSpecial(p.oe[i]) {
+				fb, fbi = p.oe[i], i
+				break
+			}
+		}
+		if fb == nil {
+			p.oe = p.oe[:si]
+			p.afe = removeAt(p.afe, fi)
+			return true
+		}
+		common := p.oe[si-1]
+		bookmark := fi
+		node, last := fb, fb
+		for inner, ni := 1, fbi-1; ; inner, ni = inner+1, ni-1 {
+			node = p.oe[ni]
+			if node == fe {
+				break
+			}
+			ai := indexOf(p.afe, node)
+			if inner > 3 && ai >= 0 {
+				p.afe = removeAt(p.afe, ai)
+				if ai < bookmark {
+					bookmark--
+				}
+				ai = -1
+			}
+			if ai < 0 {
+				p.oe = removeAt(p.oe, ni)
+				continue
+			}
+			clone := p.cloneElement(node)
+			p.afe[ai] = clone
+			p.oe[ni] = clone
+			node = clone
+			if last == fb {
+				bookmark = ai + 1
+			}
+			node.AppendChild(last)
+			last = node
+		}
+		if parent := last.ParentNode(); parent != nil {
+			parent.RemoveChild(last)
+		}
+		p.insertAt(last, common)
+		clone := p.cloneElement(fe)
+		for c := fb.FirstChild(); c != nil; c = fb.FirstChild() {
+			clone.AppendChild(c)
+		}
+		fb.AppendChild(clone)
+
+		fi = indexOf(p.afe, fe)
+		p.afe = removeAt(p.afe, fi)
+		if fi < bookmark {
+			bookmark--
+		}
+		p.afe = insertAt(p.afe, bookmark, clone)
+		p.oe = removeAt(p.oe, indexOf(p.oe, fe))
+		p.oe = insertAt(p.oe, indexOf(p.oe, fb)+1, clone)
+	}
+	return true
+}
+
+func (p *htmlParser) text() bool {
+	switch p.tok.typ {
+	case htmlTextToken:
+		p.addText(p.tok.data)
+	case htmlEOFToken:
+		p.pop()
+		p.mode = p.originalMode
+		return false
+	case htmlEndTagToken:
+		p.pop()
+		p.mode = p.originalMode
+	}
+	return true
+}
+
+func (p *htmlParser) inTable() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		if htmlIs(p.top(), "table", "tbody", "template", "tfoot", "thead", "tr") {
+			p.tableText.Reset()
+			p.originalMode = p.mode
+			p.mode = htmlInTableText
+			return false
+		}
+	case htmlCommentToken:
+		p.addComment(nil)
+		return true
+	case htmlDoctypeToken:
+		return true
+	case htmlStartTagToken:
+		switch t.data {
+		case "caption":
+			p.clearStackBackTo("table")
+			p.afe = append(p.afe, nil)
+			p.addElement()
+			p.mode = htmlInCaption
+			return true
+		case "colgroup":
+			p.clearStackBackTo("table")
+			p.addElement()
+			p.mode = htmlInColumnGroup
+			return true
+		case "col":
+			p.clearStackBackTo("table")
+			p.addElementNamed("colgroup")
+			p.mode = htmlInColumnGroup
+			return false
+		case "tbody", "tfoot", "thead":
+			p.clearStackBackTo("table")
+			p.addElement()
+			p.mode = htmlInTableBody
+			return true
+		case "td", "th", "tr":
+			p.clearStackBackTo("table")
+			p.addElementNamed("tbody")
+			p.mode = htmlInTableBody
+			return false
+		case "table":
+			if !p.popUntil(tableScope, "table") {
+				return true
+			}
+			p.resetInsertionMode()
+			return false
+		case "style", "script", "template":
+			return p.inHead()
+		case "input":
+			if typ, _ := attrValue(t, "type"); strings.EqualFold(typ, "hidden") {
+				p.addElement()
+				p.pop()
+				return true
+			}
+		case "form":
+			if p.hasOnStack("template") || p.form != nil {
+				return true
+			}
+			p.form = p.addElement()
+			p.pop()
+			return true
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "table":
+			if p.popUntil(tableScope, "table") {
+				p.resetInsertionMode()
+			}
+			return true
+		case "body", "caption", "col", "colgroup", "html", "tbody", "td", "tfoot", "th", "thead", "tr":
+			return true
+		case "template":
+			return p.inHead()
+		}
+	case htmlEOFToken:
+		return p.inBody()
+	}
+	p.fosterParenting = true
+	done := p.inBody()
+	p.fosterParenting = false
+	return done
+}
+
+func (p *htmlParser) inTableText() bool {
+	if p.tok.typ == htmlTextToken {
+		p.tableText.WriteString(p.tok.data)
+		return true
+	}
+	if s := p.tableText.String(); !isAllSpace(s) {
+		// foster parented, as by the anything else rule of in table
+		p.fosterParenting = true
+		p.reconstructActiveFormattingElements()
+		p.addText(s)
+		p.framesetOK = false
+		p.fosterParenting = false
+	} else {
+		p.addText(s)
+	}
+	p.tableText.Reset()
+	p.mode = p.originalMode
+	return false
+}
+
+// closeCaption closes the caption, if there is one in table scope.
+func (p *htmlParser) closeCaption() bool {
+	if !p.inScope(tableScope, "caption") {
+		return false
+	}
+	p.generateImpliedEndTags()
+	p.popUntil(tableScope, "caption")
+	p.clearActiveFormattingElements()
+	p.mode = htmlInTable
+	return true
+}
+
+func (p *htmlParser) inCaption() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlStartTagToken:
+		switch t.data {
+		case "caption", "col", "colgroup", "tbody", "td", "tfoot", "th", "thead", "tr":
+			return !p.closeCaption()
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "caption":
+			p.closeCaption()
+			return true
+		case "table":
+			return !p.closeCaption()
+		case "body", "col", "colgroup", "html", "tbody", "td", "tfoot", "th", "thead", "tr":
+			return true
+		}
+	}
+	return p.inBody()
+}
+
+func (p *htmlParser) inColumnGroup() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		space, rest := leadingSpace(t.data)
+		p.addText(space)
+		if rest == "" {
+			return true
+		}
+		t.data = rest
+	case htmlCommentToken:
+		p.addComment(nil)
+		return true
+	case htmlDoctypeToken:
+		return true
+	case htmlStartTagToken:
+		switch t.data {
+		case "html":
+			return p.inBody()
+		case "col":
+			p.addElement()
+			p.pop()
+			return true
+		case "template":
+			return p.inHead()
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "colgroup":
+			if htmlIs(p.top(), "colgroup") {
+				p.pop()
+				p.mode = htmlInTable
+			}
+			return true
+		case "col":
+			return true
+		case "template":
+			return p.inHead()
+		}
+	case htmlEOFToken:
+		return p.inBody()
+	}
+	if !htmlIs(p.top(), "colgroup") {
+		return true
+	}
+	p.pop()
+	p.mode = htmlInTable
+	return false
+}
+
+func (p *htmlParser) inTableBody() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlStartTagToken:
+		switch t.data {
+		case "tr":
+			p.clearStackBackTo("tbody", "tfoot", "thead")
+			p.addElement()
+			p.mode = htmlInRow
+			return true
+		case "th", "td":
+			p.clearStackBackTo("tbody", "tfoot", "thead")
+			p.addElementNamed("tr")
+			p.mode = htmlInRow
+			return false
+		case "caption", "col", "colgroup", "tbody", "tfoot", "thead":
+			return !p.closeTableBody()
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "tbody", "tfoot", "thead":
+			if p.inScope(tableScope, t.data) {
+				p.clearStackBackTo("tbody", "tfoot", "thead")
+				p.pop()
+				p.mode = htmlInTable
+			}
+			return true
+		case "table":
+			return !p.closeTableBody()
+		case "body", "caption", "col", "colgroup", "html", "td", "th", "tr":
+			return true
+		}
+	}
+	return p.inTable()
+}
+
+// closeTableBody closes the tbody, thead or tfoot that is open, if any.
+func (p *htmlParser) closeTableBody() bool {
+	if !p.inScope(tableScope, "tbody", "thead", "tfoot") {
+		return false
+	}
+	p.clearStackBackTo("tbody", "tfoot", "thead")
+	p.pop()
+	p.mode = htmlInTable
+	return true
+}
+
+// closeRow closes the tr that is open, if any.
+func (p *htmlParser) closeRow() bool {
+	if !p.inScope(tableScope, "tr") {
+		return false
+	}
+	p.clearStackBackTo("tr")
+	p.pop()
+	p.mode = htmlInTableBody
+	return true
+}
+
+func (p *htmlParser) inRow() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlStartTagToken:
+		switch t.data {
+		case "th", "td":
+			p.clearStackBackTo("tr")
+			p.addElement()
+			p.mode = htmlInCell
+			p.afe = append(p.afe, nil)
+			return true
+		case "caption", "col", "colgroup", "tbody", "tfoot", "thead", "tr":
+			return !p.closeRow()
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "tr":
+			p.closeRow()
+			return true
+		case "table":
+			return !p.closeRow()
+		case "tbody", "tfoot", "thead":
+			if !p.inScope(tableScope, t.data) {
+				return true
+			}
+			return !p.closeRow()
+		case "body", "caption", "col", "colgroup", "html", "td", "th":
+			return true
+		}
+	}
+	return p.inTable()
+}
+
+func (p *htmlParser) closeCell() {
+	p.generateImpliedEndTags()
+	p.popUntil(tableScope, "td", "th")
+	p.clearActiveFormattingElements()
+	p.mode = htmlInRow
+}
+
+func (p *htmlParser) inCell() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlStartTagToken:
+		switch t.data {
+		case "caption", "col", "colgroup", "tbody", "td", "tfoot", "th", "thead", "tr":
+			if !p.inScope(tableScope, "td", "th") {
+				return true
+			}
+			p.closeCell()
+			return false
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "td", "th":
+			if !p.inScope(tableScope, t.data) {
+				return true
+			}
+			p.generateImpliedEndTags()
+			p.popUntil(tableScope, t.data)
+			p.clearActiveFormattingElements()
+			p.mode = htmlInRow
+			return true
+		case "body", "caption", "col", "colgroup", "html":
+			return true
+		case "table", "tbody", "tfoot", "thead", "tr":
+			if !p.inScope(tableScope, t.data) {
+				return true
+			}
+			p.closeCell()
+			return false
+		}
+	}
+	return p.inBody()
+}
+
+func (p *htmlParser) inSelect() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		p.addText(t.data)
+	case htmlCommentToken:
+		p.addComment(nil)
+	case htmlStartTagToken:
+		switch t.data {
+		case "html":
+			return p.inBody()
+		case "option":
+			if htmlIs(p.top(), "option") {
+				p.pop()
+			}
+			p.addElement()
+		case "optgroup", "hr":
+			if htmlIs(p.top(), "option") {
+				p.pop()
+			}
+			if htmlIs(p.top(), "optgroup") {
+				p.pop()
+			}
+			p.addElement()
+			if t.data == "hr" {
+				p.pop()
+			}
+		case "select":
+			if p.popUntil(selectScope, "select") {
+				p.resetInsertionMode()
+			}
+		case "input", "keygen", "textarea":
+			if !p.popUntil(selectScope, "select") {
+				return true
+			}
+			p.resetInsertionMode()
+			return false
+		case "script", "template":
+			return p.inHead()
+		}
+	case htmlEndTagToken:
+		switch t.data {
+		case "optgroup":
+			if htmlIs(p.top(), "option") && len(p.oe) > 1 && htmlIs(p.oe[len(p.oe)-2], "optgroup") {
+				p.pop()
+			}
+			if htmlIs(p.top(), "optgroup") {
+				p.pop()
+			}
+		case "option":
+			if htmlIs(p.top(), "option") {
+				p.pop()
+			}
+		case "select":
+			if p.popUntil(selectScope, "select") {
+				p.resetInsertionMode()
+			}
+		case "template":
+			return p.inHead()
+		}
+	case htmlEOFToken:
+		return p.inBody()
+	}
+	return true
+}
+
+func (p *htmlParser) inSelectInTable() bool {
+	t := &p.tok
+	if t.typ == htmlStartTagToken || t.typ == htmlEndTagToken {
+		switch t.data {
+		case "caption", "table", "tbody", "tfoot", "thead", "tr", "td", "th":
+			if t.typ == htmlEndTagToken && !p.inScope(tableScope, t.data) {
+				return true
+			}
+			p.popUntil(selectScope, "select")
+			p.resetInsertionMode()
+			return false
+		}
+	}
+	return p.inSelect()
+}
+
+// switchTemplateMode replaces the current template insertion mode.
+func (p *htmlParser) switchTemplateMode(m insertionMode) bool {
+	p.templateModes[len(p.templateModes)-1] = m
+	p.mode = m
+	return false
+}
+
+func (p *htmlParser) inTemplate() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken, htmlCommentToken, htmlDoctypeToken:
+		return p.inBody()
+	case htmlStartTagToken:
+		switch t.data {
+		case "base", "basefont", "bgsound", "link", "meta", "noframes", "script", "style", "template", "title":
+			return p.inHead()
+		case "caption", "colgroup", "tbody", "tfoot", "thead":
+			return p.switchTemplateMode(htmlInTable)
+		case "col":
+			return p.switchTemplateMode(htmlInColumnGroup)
+		case "tr":
+			return p.switchTemplateMode(htmlInTableBody)
+		case "td", "th":
+			return p.switchTemplateMode(htmlInRow)
+		}
+		return p.switchTemplateMode(htmlInBody)
+	case htmlEndTagToken:
+		if t.data == "template" {
+			return p.inHead()
+		}
+	case htmlEOFToken:
+		if !p.hasOnStack("template") {
+			return true
+		}
+		for !htmlIs(p.top(), "template") {
+			p.pop()
+		}
+		p.pop()
+		p.clearActiveFormattingElements()
+		p.templateModes = p.templateModes[:len(p.templateModes)-1]
+		p.resetInsertionMode()
+		return false
+	}
+	return true
+}
+
+func (p *htmlParser) afterBody() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		if isAllSpace(t.data) {
+			return p.inBody()
+		}
+	case htmlCommentToken:
+		p.addComment(p.oe[0])
+		return true
+	case htmlDoctypeToken:
+		return true
+	case htmlStartTagToken:
+		if t.data == "html" {
+			return p.inBody()
+		}
+	case htmlEndTagToken:
+		if t.data == "html" {
+			p.mode = htmlAfterAfterBody
+			return true
+		}
+	case htmlEOFToken:
+		return true
+	}
+	p.mode = htmlInBody
+	return false
+}
+
+func (p *htmlParser) inFrameset() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		p.addText(onlySpace(t.data))
+	case htmlCommentToken:
+		p.addComment(nil)
+	case htmlStartTagToken:
+		switch t.data {
+		case "html":
+			return p.inBody()
+		case "frameset":
+			p.addElement()
+		case "frame":
+			p.addElement()
+			p.pop()
+		case "noframes":
+			return p.inHead()
+		}
+	case htmlEndTagToken:
+		if t.data == "frameset" && !htmlIs(p.top(), "html") {
+			p.pop()
+			if !htmlIs(p.top(), "frameset") {
+				p.mode = htmlAfterFrameset
+			}
+		}
+	}
+	return true
+}
+
+func (p *htmlParser) afterFrameset() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		p.addText(onlySpace(t.data))
+	case htmlCommentToken:
+		p.addComment(nil)
+	case htmlStartTagToken:
+		switch t.data {
+		case "html":
+			return p.inBody()
+		case "noframes":
+			return p.inHead()
+		}
+	case htmlEndTagToken:
+		if t.data == "html" {
+			p.mode = htmlAfterAfterFrameset
+		}
+	}
+	return true
+}
+
+func (p *htmlParser) afterAfterBody() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlCommentToken:
+		p.addComment(p.d)
+		return true
+	case htmlDoctypeToken:
+		return p.inBody()
+	case htmlTextToken:
+		if isAllSpace(t.data) {
+			return p.inBody()
+		}
+	case htmlStartTagToken:
+		if t.data == "html" {
+			return p.inBody()
+		}
+	case htmlEOFToken:
+		return true
+	}
+	p.mode = htmlInBody
+	return false
+}
+
+func (p *htmlParser) afterAfterFrameset() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlCommentToken:
+		p.addComment(p.d)
+	case htmlDoctypeToken:
+		return p.inBody()
+	case htmlTextToken:
+		t.data = onlySpace(t.data)
+		return p.inBody()
+	case htmlStartTagToken:
+		switch t.data {
+		case "html":
+			return p.inBody()
+		case "noframes":
+			return p.inHead()
+		}
+	}
+	return true
+}
+
+// ====================================
+// foreign content: SVG and MathML
+
+// inForeignContent reports whether the current token is processed by the
+// rules for foreign content rather than by the insertion mode.
+func (p *htmlParser) inForeignContent() bool {
+	if len(p.oe) == 0 {
+		return false
+	}
+	n, t := p.top(), &p.tok
+	switch {
+	case n.n.Space == htmlURL, t.typ == htmlEOFToken:
+		return false
+	case elemIs(n, mathMLURL, "mi", "mo", "mn", "ms", "mtext"):
+		if t.typ == htmlTextToken || t.typ == htmlStartTagToken && t.data != "mglyph" && t.data != "malignmark" {
+			return false
+		}
+	case elemIs(n, mathMLURL, "annotation-xml"):
+		if t.typ == htmlStartTagToken && t.data == "svg" {
+			return false
+		}
+	}
+	if htmlIntegrationPoint(n) && (t.typ == htmlTextToken || t.typ == htmlStartTagToken) {
+		return false
+	}
+	return true
+}
+
+// htmlIntegrationPoint reports whether e is a foreign element whose
+// content is HTML.
+func htmlIntegrationPoint(e *_elem) bool {
+	if elemIs(e, mathMLURL, "annotation-xml") {
+		enc := strings.ToLower(e.GetAttribute("encoding"))
+		return enc == "text/html" || enc == "application/xhtml+xml"
+	}
+	return elemIs(e, svgURL, "foreignObject", "desc", "title")
+}
+
+// the tags that end foreign content
+var htmlBreakout = map[string]bool{
+	"b": true, "big": true, "blockquote": true, "body": true, "br": true, "center": true,
+	"code": true, "dd": true, "div": true, "dl": true, "dt": true, "em": true, "embed": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true, "head": true,
+	"hr": true, "i": true, "img": true, "li": true, "listing": true, "menu": true, "meta": true,
+	"nobr": true, "ol": true, "p": true, "pre": true, "ruby": true, "s": true, "small": true,
+	"span": true, "strong": true, "strike": true, "sub": true, "sup": true, "table": true,
+	"tt": true, "u": true, "ul": true, "var": true,
+}
+
+func (p *htmlParser) foreignContent() bool {
+	t := &p.tok
+	switch t.typ {
+	case htmlTextToken:
+		p.addText(t.data)
+		if !isAllSpace(t.data) {
+			p.framesetOK = false
+		}
+	case htmlCommentToken:
+		p.addComment(nil)
+	case htmlStartTagToken:
+		breakout := htmlBreakout[t.data]
+		if t.data == "font" {
+			for _, a := range t.attr {
+				if a.name == "color" || a.name == "face" || a.name == "size" {
+					breakout = true
+				}
+			}
+		}
+		if breakout {
+			p.popForeign()
+			return p.using(p.mode)
+		}
+		ns := p.top().n.Space
+		adjustForeignToken(t, ns)
+		p.insertElement(t, ns)
+		if t.selfClosing {
+			p.pop()
+		}
+	case htmlEndTagToken:
+		if t.data == "br" || t.data == "p" {
+			p.popForeign()
+			return p.using(p.mode)
+		}
+		for i := len(p.oe) - 1; i > 0; {
+			if strings.ToLower(p.oe[i].n.Local) == t.data {
+				p.oe = p.oe[:i]
+				return true
+			}
+			if i--; p.oe[i].n.Space == htmlURL {
+				return p.using(p.mode)
+			}
+		}
+	}
+	return true
+}
+
+// popForeign pops foreign elements until the current node is an HTML
+// element or an integration point.
+func (p *htmlParser) popForeign() {
+	for len(p.oe) > 0 {
+		n := p.top()
+		if n.n.Space == htmlURL || elemIs(n, mathMLURL, "mi", "mo", "mn", "ms", "mtext") || htmlIntegrationPoint(n) {
+			return
+		}
+		p.pop()
+	}
+}
+
+// adjustForeignToken gives the tag name and attributes of start tag t
+// for an element in namespace ns the case and namespaces they have in
+// SVG and MathML.
+func adjustForeignToken(t *htmlToken, ns string) {
+	switch ns {
+	case svgURL:
+		if name, ok := svgTagNames[t.data]; ok {
+			t.data = name
+		}
+	}
+	for i := range t.attr {
+		a := &t.attr[i]
+		switch ns {
+		case mathMLURL:
+			if a.name == "definitionurl" {
+				a.name = "definitionURL"
+			}
+		case svgURL:
+			if name, ok := svgAttributeNames[a.name]; ok {
+				a.name = name
+			}
+		}
+		prefix, local := splitQName(a.name)
+		switch {
+		case prefix == "xlink" && xlinkAttributes[local]:
+			a.ns, a.prefix, a.name = xlinkURL, prefix, local
+		case prefix == "xml" && (local == "lang" || local == "space"):
+			a.ns, a.prefix, a.name = xmlURL, prefix, local
+		case a.name == "xmlns":
+			a.ns = xmlnsURL
+		case a.name == "xmlns:xlink":
+			a.ns, a.prefix, a.name = xmlnsURL, prefix, local
+		}
+	}
+}
+
+var xlinkAttributes = map[string]bool{
+	"actuate": true, "arcrole": true, "href": true, "role": true, "show": true, "title": true, "type": true,
+}
+
+var svgTagNames = map[string]string{
+	"altglyph":            "altGlyph",
+	"altglyphdef":         "altGlyphDef",
+	"altglyphitem":        "altGlyphItem",
+	"animatecolor":        "animateColor",
+	"animatemotion":       "animateMotion",
+	"animatetransform":    "animateTransform",
+	"clippath":            "clipPath",
+	"feblend":             "feBlend",
+	"fecolormatrix":       "feColorMatrix",
+	"fecomponenttransfer": "feComponentTransfer",
+	"fecomposite":         "feComposite",
+	"feconvolvematrix":    "feConvolveMatrix",
+	"fediffuselighting":   "feDiffuseLighting",
+	"fedisplacementmap":   "feDisplacementMap",
+	"fedistantlight":      "feDistantLight",
+	"fedropshadow":        "feDropShadow",
+	"feflood":             "feFlood",
+	"fefunca":             "feFuncA",
+	"fefuncb":             "feFuncB",
+	"fefuncg":             "feFuncG",
+	"fefuncr":             "feFuncR",
+	"fegaussianblur":      "feGaussianBlur",
+	"feimage":             "feImage",
+	"femerge":             "feMerge",
+	"femergenode":         "feMergeNode",
+	"femorphology":        "feMorphology",
+	"feoffset":            "feOffset",
+	"fepointlight":        "fePointLight",
+	"fespecularlighting":  "feSpecularLighting",
+	"fespotlight":         "feSpotLight",
+	"fetile":              "feTile",
+	"feturbulence":        "feTurbulence",
+	"foreignobject":       "foreignObject",
+	"glyphref":            "glyphRef",
+	"lineargradient":      "linearGradient",
+	"radialgradient":      "radialGradient",
+	"textpath":            "textPath",
+}
+
+var svgAttributeNames = map[string]string{
+	"attributename":       "attributeName",
+	"attributetype":       "attributeType",
+	"basefrequency":       "baseFrequency",
+	"baseprofile":         "baseProfile",
+	"calcmode":            "calcMode",
+	"clippathunits":       "clipPathUnits",
+	"diffuseconstant":     "diffuseConstant",
+	"edgemode":            "edgeMode",
+	"filterunits":         "filterUnits",
+	"glyphref":            "glyphRef",
+	"gradienttransform":   "gradientTransform",
+	"gradientunits":       "gradientUnits",
+	"kernelmatrix":        "kernelMatrix",
+	"kernelunitlength":    "kernelUnitLength",
+	"keypoints":           "keyPoints",
+	"keysplines":          "keySplines",
+	"keytimes":            "keyTimes",
+	"lengthadjust":        "lengthAdjust",
+	"limitingconeangle":   "limitingConeAngle",
+	"markerheight":        "markerHeight",
+	"markerunits":         "markerUnits",
+	"markerwidth":         "markerWidth",
+	"maskcontentunits":    "maskContentUnits",
+	"maskunits":           "maskUnits",
+	"numoctaves":          "numOctaves",
+	"pathlength":          "pathLength",
+	"patterncontentunits": "patternContentUnits",
+	"patterntransform":    "patternTransform",
+	"patternunits":        "patternUnits",
+	"pointsatx":           "pointsAtX",
+	"pointsaty":           "pointsAtY",
+	"pointsatz":           "pointsAtZ",
+	"preservealpha":       "preserveAlpha",
+	"preserveaspectratio": "preserveAspectRatio",
+	"primitiveunits":      "primitiveUnits",
+	"refx":                "refX",
+	"refy":                "refY",
+	"repeatcount":         "repeatCount",
+	"repeatdur":           "repeatDur",
+	"requiredextensions":  "requiredExtensions",
+	"requiredfeatures":    "requiredFeatures",
+	"specularconstant":    "specularConstant",
+	"specularexponent":    "specularExponent",
+	"spreadmethod":        "spreadMethod",
+	"startoffset":         "startOffset",
+	"stddeviation":        "stdDeviation",
+	"stitchtiles":         "stitchTiles",
+	"surfacescale":        "surfaceScale",
+	"systemlanguage":      "systemLanguage",
+	"tablevalues":         "tableValues",
+	"targetx":             "targetX",
+	"targety":             "targetY",
+	"textlength":          "textLength",
+	"viewbox":             "viewBox",
+	"viewtarget":          "viewTarget",
+	"xchannelselector":    "xChannelSelector",
+	"ychannelselector":    "yChannelSelector",
+	"zoomandpan":          "zoomAndPan",
+}
+
+// ====================================
+// quirks mode
+
+// quirksDoctype reports whether doctype token t puts the document in
+// quirks mode, which only matters to the parser for <p><table>.
+func quirksDoctype(t *htmlToken) bool {
+	if t.forceQuirks || t.data != "html" {
+		return true
+	}
+	pub, sys := strings.ToLower(t.publicId), strings.ToLower(t.systemId)
+	switch pub {
+	case "-//w3o//dtd w3 html strict 3.0//en//", "-/w3c/dtd html 4.0 transitional/en", "html":
+		return true
+	}
+	if sys == "http://www.ibm.com/data/dtd/v11/ibmxhtml1-transitional.dtd" {
+		return true
+	}
+	for _, prefix := range quirksPublicIds {
+		if strings.HasPrefix(pub, prefix) {
+			return true
+		}
+	}
+	return !t.hasSystem && (strings.HasPrefix(pub, "-//w3c//dtd html 4.01 frameset//") ||
+		strings.HasPrefix(pub, "-//w3c//dtd html 4.01 transitional//"))
+}
+
+var quirksPublicIds = []string{
+	"+//silmaril//dtd html pro v0r11 19970101//",
+	"-//as//dtd html 3.0 aswedit + extensions//",
+	"-//advasoft ltd//dtd html 3.0 aswedit + extensions//",
+	"-//ietf//dtd html 2.0 level 1//",
+	"-//ietf//dtd html 2.0 level 2//",
+	"-//ietf//dtd html 2.0 strict level 1//",
+	"-//ietf//dtd html 2.0 strict level 2//",
+	"-//ietf//dtd html 2.0 strict//",
+	"-//ietf//dtd html 2.0//",
+	"-//ietf//dtd html 2.1e//",
+	"-//ietf//dtd html 3.0//",
+	"-//ietf//dtd html 3.2 final//",
+	"-//ietf//dtd html 3.2//",
+	"-//ietf//dtd html 3//",
+	"-//ietf//dtd html level 0//",
+	"-//ietf//dtd html level 1//",
+	"-//ietf//dtd html level 2//",
+	"-//ietf//dtd html level 3//",
+	"-//ietf//dtd html strict level 0//",
+	"-//ietf//dtd html strict level 1//",
+	"-//ietf//dtd html strict level 2//",
+	"-//ietf//dtd html strict level 3//",
+	"-//ietf//dtd html strict//",
+	"-//ietf//dtd html//",
+	"-//metrius//dtd metrius presentational//",
+	"-//microsoft//dtd internet explorer 2.0 html strict//",
+	"-//microsoft//dtd internet explorer 2.0 html//",
+	"-//microsoft//dtd internet explorer 2.0 tables//",
+	"-//microsoft//dtd internet explorer 3.0 html strict//",
+	"-//microsoft//dtd internet explorer 3.0 html//",
+	"-//microsoft//dtd internet explorer 3.0 tables//",
+	"-//netscape comm. corp.//dtd html//",
+	"-//netscape comm. corp.//dtd strict html//",
+	"-//o'reilly and associates//dtd html 2.0//",
+	"-//o'reilly and associates//dtd html extended 1.0//",
+	"-//o'reilly and associates//dtd html extended relaxed 1.0//",
+	"-//sq//dtd html 2.0 hotmetal + extensions//",
+	"-//softquad software//dtd hotmetal pro 6.0::19990601::extensions to html 4.0//",
+	"-//softquad//dtd hotmetal pro 4.0::19971010::extensions to html 4.0//",
+	"-//spyglass//dtd html 2.0 extended//",
+	"-//sun microsystems corp.//dtd hotjava html//",
+	"-//sun microsystems corp.//dtd hotjava strict html//",
+	"-//w3c//dtd html 3 1995-03-24//",
+	"-//w3c//dtd html 3.2 draft//",
+	"-//w3c//dtd html 3.2 final//",
+	"-//w3c//dtd html 3.2//",
+	"-//w3c//dtd html 3.2s draft//",
+	"-//w3c//dtd html 4.0 frameset//",
+	"-//w3c//dtd html 4.0 transitional//",
+	"-//w3c//dtd html experimental 19960712//",
+	"-//w3c//dtd html experimental 970421//",
+	"-//w3c//dtd w3 html//",
+	"-//w3o//dtd w3 html 3.0//",
+	"-//webtechs//dtd mozilla html 2.0//",
+	"-//webtechs//dtd mozilla html//",
+}
@@ -0,0 +1,187 @@
+package dom
+
+import (
+  "strings"
+  "testing"
+)
+
+// dumpHTML writes the tree under n compactly; foreign elements are
+// marked with svg: or math:.
+func dumpHTML(b *strings.Builder, n Node) {
+  switch n.NodeType() {
+  case ELEMENT_NODE:
+    e := n.(Element)
+    name := e.LocalName()
+    switch e.NamespaceURI() {
+    case svgURL:
+      name = "svg:" + name
+    case mathMLURL:
+      name = "math:" + name
+    }
+    b.WriteString("<" + name)
+    for i := uint(0); i < e.Attributes().Length(); i++ {
+      a := e.Attributes().Item(i)
+      b.WriteString(" " + a.NodeName() + "=\"" + a.NodeValue() + "\"")
+    }
+    b.WriteString(">")
+    for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+      dumpHTML(b, c)
+    }
+    b.WriteString("</" + name + ">")
+  case TEXT_NODE:
+    b.WriteString(n.NodeValue())
+  case COMMENT_NODE:
+    b.WriteString("<!--" + n.NodeValue() + "-->")
+  case DOCUMENT_TYPE_NODE:
+    b.WriteString("<!DOCTYPE " + n.NodeName() + ">")
+  default:
+    for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+      dumpHTML(b, c)
+    }
+  }
+}
+
+func TestParseHTML(t *testing.T) {
+  tests := []struct{ in, out string }{
+    // implied html, head and body
+    {"x", "<html><head></head><body>x</body></html>"},
+    {"<!DOCTYPE html><title>t</title><p>x",
+      "<!DOCTYPE html><html><head><title>t</title></head><body><p>x</p></body></html>"},
+    // implied end tags
+    {"<p>a<p>b<ul><li>c<li>d</ul>",
+      "<html><head></head><body><p>a</p><p>b</p><ul><li>c</li><li>d</li></ul></body></html>"},
+    {"<dl><dt>a<dd>b<dt>c</dl>",
+      "<html><head></head><body><dl><dt>a</dt><dd>b</dd><dt>c</dt></dl></body></html>"},
+    // misnested formatting elements
+    {"<b>1<i>2</b>3</i>",
+      "<html><head></head><body><b>1<i>2</i></b><i>3</i></body></html>"},
+    {"<b>1<p>2</b>3</p>",
+      "<html><head></head><body><b>1</b><p><b>2</b>3</p></body></html>"},
+    {"<a href=x>1<a href=y>2",
+      "<html><head></head><body><a href=\"x\">1</a><a href=\"y\">2</a></body></html>"},
+    // foster parenting
+    {"<table>x<tr><td>y</table>",
+      "<html><head></head><body>x<table><tbody><tr><td>y</td></tr></tbody></table></body></html>"},
+    {"<table><b>x<tr><td>y</td></tr></b></table>",
+      "<html><head></head><body><b>x</b><table><tbody><tr><td>y</td></tr></tbody></table></body></html>"},
+    // void and raw text elements
+    {"<p>a<br>b<img src=i.png>c<input></p>",
+      "<html><head></head><body><p>a<br></br>b<img src=\"i.png\"></img>c<input></input></p></body></html>"},
+    {"<script>if (a < b && c) {}</script><textarea>\n<b>&amp;</b></textarea>",
+      "<html><head><script>if (a < b && c) {}</script></head><body><textarea><b>&</b></textarea></body></html>"},
+    // named character references
+    {"&amp &lt;&copy;x&notit; &notin; &#x41;&#0;",
+      "<html><head></head><body>& <©x¬it; ∉ A�</body></html>"},
+    {"<a title='&amp=&ampx&lt'>",
+      "<html><head></head><body><a title=\"&amp=&ampx<\"></a></body></html>"},
+    // foreign content
+    {"<svg viewbox='0 0 1 1'><foreignobject><p>x</p></foreignobject><clippath/></svg><math><mi>y</mi></math>",
+      "<html><head></head><body><svg:svg viewBox=\"0 0 1 1\"><svg:foreignObject><p>x</p></svg:foreignObject><svg:clipPath></svg:clipPath></svg:svg><math:math><math:mi>y</math:mi></math:math></body></html>"},
+    {"<svg><title></br>x",
+      "<html><head></head><body><svg:svg><svg:title><br></br>x</svg:title></svg:svg></body></html>"},
+    {"<svg><g><p>x",
+      "<html><head></head><body><svg:svg><svg:g></svg:g></svg:svg><p>x</p></body></html>"},
+    // comments and stray tags
+    {"<!--c--><html><body></p></div>x</body></html><!--d-->",
+      "<!--c--><html><head></head><body><p></p>x</body></html><!--d-->"},
+  }
+  for _, test := range tests {
+    d, err := ParseHTMLString(test.in)
+    if err != nil {
+      t.Errorf("ParseHTMLString(%q) failed: %v", test.in, err)
+      continue
+    }
+    b := &strings.Builder{}
+    dumpHTML(b, d)
+    if (b.String() != test.out) {
+      t.Errorf("ParseHTMLString(%q) built %s instead of %s", test.in, b.String(), test.out)
+    }
+  }
+}
+
+func TestParseHTMLNodes(t *testing.T) {
+  d, _ := ParseHTMLString("<div id=a><svg xlink:href=#b xml:lang=en></svg></div>")
+  if (d.GetElementById("a") == nil) {
+    t.Errorf("Element was not found by id")
+  }
+  r := d.DocumentElement()
+  if (r.NamespaceURI() != htmlURL || r.NodeName() != "html") {
+    t.Errorf("Root element was %s in %q", r.NodeName(), r.NamespaceURI())
+  }
+  svg := d.GetElementsByTagNameNS(svgURL, "svg").Item(0).(Element)
+  if (svg.GetAttributeNS(xlinkURL, "href") != "#b" || svg.GetAttributeNS(xmlURL, "lang") != "en") {
+    t.Errorf("Foreign attributes were not put in their namespaces")
+  }
+  if (d.XmlVersion() != "") {
+    t.Errorf("HTML document had XML version %q", d.XmlVersion())
+  }
+}
+
+func TestParseHTMLLimits(t *testing.T) {
+  _, err := ParseHTMLString(strings.Repeat("<div>", 2000))
+  if le, ok := err.(*LimitError); !ok || le.Limit != "MaxDepth" {
+    t.Errorf("Deep nesting returned %v instead of a MaxDepth LimitError", err)
+  }
+}
+
+func TestToHTML(t *testing.T) {
+  in := "<!DOCTYPE html><html><head><script>if (a < b && c) {}</script></head>" +
+    "<body><p class=\"&quot;x&quot;\">a&amp;b&lt;&nbsp;<br><input checked type=\"checkbox\"></p>" +
+    "<pre>\n\nx</pre><svg viewBox=\"0 0 1 1\" xlink:href=\"#a\"><path></path></svg><!--c--></body></html>"
+  d, _ := ParseHTMLString(in)
+  want := "<!DOCTYPE html><html><head><script>if (a < b && c) {}</script></head>" +
+    "<body><p class=\"&quot;x&quot;\">a&amp;b&lt;&nbsp;<br><input checked type=\"checkbox\"></p>" +
+    "<pre>\n\nx</pre><svg viewBox=\"0 0 1 1\" xlink:href=\"#a\"><path></path></svg><!--c--></body></html>"
+  if s := ToHTML(d); (s != want) {
+    t.Errorf("ToHTML wrote\n%s\ninstead of\n%s", s, want)
+  }
+  again, _ := ParseHTMLString(ToHTML(d))
+  if (ToHTML(again) != ToHTML(d)) {
+    t.Errorf("Serialized HTML did not parse back to the same tree")
+  }
+  b := &strings.Builder{}
+  if err := WriteHTML(b, d.GetElementsByTagName("p").Item(0)); err != nil || b.String() != "<p class=\"&quot;x&quot;\">a&amp;b&lt;&nbsp;<br><input checked type=\"checkbox\"></p>" {
+    t.Errorf("WriteHTML wrote %q, %v", b.String(), err)
+  }
+  if err := WriteHTML(failingWriter{}, d); (err == nil || err.Error() != "full") {
+    t.Errorf("WriteHTML gave %v for a failing writer", err)
+  }
+}
+
+func TestToHTMLCreatedElements(t *testing.T) {
+  d, _ := ParseHTMLString("<p>x</p>")
+  p := d.GetElementsByTagName("p").Item(0)
+  br := d.CreateElement("BR")
+  script := d.CreateElement("script")
+  script.AppendChild(d.CreateTextNode("a<b"))
+  p.AppendChild(br)
+  p.AppendChild(script)
+  if (br.NamespaceURI() != htmlURL || br.NodeName() != "br") {
+    t.Errorf("CreateElement on an HTML document made {%s}%s", br.NamespaceURI(), br.NodeName())
+  }
+  if s := ToHTML(p); (s != "<p>x<br><script>a<b</script></p>") {
+    t.Errorf("ToHTML wrote %s", s)
+  }
+
+  x, _ := ParseString(`<div><br/><script>a&lt;b</script><input disabled=""/></div>`)
+  if s := ToHTML(x); (s != "<div><br><script>a<b</script><input disabled></div>") {
+    t.Errorf("ToHTML of an XML tree wrote %s", s)
+  }
+}
+
+// a chunkWriter keeps the size of the largest write
+type chunkWriter struct{ n, max int }
+
+func (w *chunkWriter) Write(p []byte) (int, error) {
+  w.n += len(p)
+  w.max = max(w.max, len(p))
+  return len(p), nil
+}
+
+func TestWriteHTMLStreams(t *testing.T) {
+  d, _ := ParseHTMLString("<ul>" + strings.Repeat("<li>item &amp; more</li>", 10000) + "</ul>")
+  w := &chunkWriter{}
+  if err := WriteHTML(w, d); (err != nil || w.n != len(ToHTML(d)) || w.max > 1<<16) {
+    t.Errorf("WriteHTML wrote %d bytes, %d at once, %v", w.n, w.max, err)
+  }
+}
@@ -0,0 +1,557 @@
+package dom
+
+/*
+ * The tokenizer of the HTML parser
+ * https://html.spec.whatwg.org/multipage/parsing.html#tokenization
+ */
+
+import (
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type htmlTokenType int
+
+const (
+	htmlEOFToken htmlTokenType = iota
+	htmlTextToken
+	htmlStartTagToken
+	htmlEndTagToken
+	htmlCommentToken
+	htmlDoctypeToken
+)
+
+// An htmlAttr is an attribute of a tag. ns and prefix are only set for
+// the attributes of foreign elements that are in a namespace.
+type htmlAttr struct {
+	ns, prefix, name, value string
+}
+
+type htmlToken struct {
+	typ         htmlTokenType
+	data        string // the tag name, the text, the comment or the doctype name
+	attr        []htmlAttr
+	selfClosing bool
+
+	// for doctype tokens
+	publicId, systemId   string
+	hasPublic, hasSystem bool
+	forceQuirks          bool
+}
+
+// the states of the tokenizer that the tree builder switches to
+type htmlTextState int
+
+const (
+	htmlDataState htmlTextState = iota
+	htmlRCDATAState
+	htmlRawTextState
+	htmlScriptDataState
+	htmlPlainTextState
+)
+
+type htmlTokenizer struct {
+	s       string // the input, with newlines normalized
+	i       int    // the position of the next byte of s
+	state   htmlTextState
+	lastTag string // the name of the last start tag, to find the end of raw text
+	cdata   bool   // CDATA sections are recognized, as in foreign content
+}
+
+func newHTMLTokenizer(s string) *htmlTokenizer {
+	s = strings.Replace(s, "\r\n", "\n", -1)
+	s = strings.Replace(s, "\r", "\n", -1)
+	return &htmlTokenizer{s: s}
+}
+
+// next returns the next token of the input.
+func (z *htmlTokenizer) next() htmlToken {
+	for z.i < len(z.s) {
+		switch z.state {
+		case htmlRCDATAState, htmlRawTextState, htmlScriptDataState:
+			if t, ok := z.rawText(); ok {
+				return t
+			}
+			continue
+		case htmlPlainTextState:
+			t := htmlToken{typ: htmlTextToken, data: replaceNUL(z.s[z.i:])}
+			z.i = len(z.s)
+			return t
+		}
+		if z.markupStarts(z.i) {
+			if t, ok := z.markup(); ok {
+				return t
+			}
+			continue
+		}
+		return z.text()
+	}
+	return htmlToken{typ: htmlEOFToken}
+}
+
+// markupStarts reports whether a tag, comment or other markup starts at
+// position i of the input.
+func (z *htmlTokenizer) markupStarts(i int) bool {
+	if z.s[i] != '<' || i+1 >= len(z.s) {
+		return false
+	}
+	switch c := z.s[i+1]; {
+	case isASCIIAlpha(c), c == '!', c == '?':
+		return true
+	case c == '/':
+		return i+2 < len(z.s)
+	}
+	return false
+}
+
+func isASCIIAlpha(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+func isASCIIAlnum(c byte) bool {
+	return isASCIIAlpha(c) || '0' <= c && c <= '9'
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f'
+}
+
+// replaceNUL replaces NUL characters with U+FFFD.
+func replaceNUL(s string) string {
+	return strings.Replace(s, "\x00", "\uFFFD", -1)
+}
+
+// text reads character data up to the next markup.
+func (z *htmlTokenizer) text() htmlToken {
+	var b strings.Builder
+	for z.i < len(z.s) && !z.markupStarts(z.i) {
+		switch c := z.s[z.i]; c {
+		case '&':
+			s, n := decodeCharRef(z.s[z.i:], false)
+			b.WriteString(s)
+			z.i += n
+		case 0:
+			// dropped, as the tree builder would
+			z.i++
+		default:
+			b.WriteByte(c)
+			z.i++
+		}
+	}
+	return htmlToken{typ: htmlTextToken, data: b.String()}
+}
+
+// rawText reads the content of an element like script, style or
+// textarea up to its end tag, which it returns on the next call. Script
+// data is read as raw text: an end tag inside an escaped <!-- --> section
+// of a script ends it.
+func (z *htmlTokenizer) rawText() (htmlToken, bool) {
+	start := z.i
+	end := len(z.s)
+	for j := z.i; ; j += 2 {
+		k := strings.Index(z.s[j:], "</")
+		if k < 0 {
+			break
+		}
+		if j += k; z.isEndTagOf(j, z.lastTag) {
+			end = j
+			break
+		}
+	}
+	z.i = end
+	if end > start {
+		data := replaceNUL(z.s[start:end])
+		if z.state == htmlRCDATAState {
+			data = decodeCharRefs(data)
+		}
+		return htmlToken{typ: htmlTextToken, data: data}, true
+	}
+	// at the end tag
+	z.state = htmlDataState
+	return z.markup()
+}
+
+// isEndTagOf reports whether an end tag for name starts at position i.
+func (z *htmlTokenizer) isEndTagOf(i int, name string) bool {
+	i += 2
+	if i+len(name) > len(z.s) || !strings.EqualFold(z.s[i:i+len(name)], name) {
+		return false
+	}
+	i += len(name)
+	return i == len(z.s) || isHTMLSpace(z.s[i]) || z.s[i] == '/' || z.s[i] == '>'
+}
+
+// markup reads the markup at the current position, which starts with <.
+// It returns false if there is nothing to return, as for </>.
+func (z *htmlTokenizer) markup() (htmlToken, bool) {
+	z.i++ // <
+	switch c := z.s[z.i]; {
+	case c == '!':
+		z.i++
+		switch {
+		case strings.HasPrefix(z.s[z.i:], "--"):
+			z.i += 2
+			return z.comment(), true
+		case len(z.s)-z.i >= 7 && strings.EqualFold(z.s[z.i:z.i+7], "DOCTYPE"):
+			z.i += 7
+			return z.doctype(), true
+		case z.cdata && strings.HasPrefix(z.s[z.i:], "[CDATA["):
+			z.i += 7
+			return htmlToken{typ: htmlTextToken, data: z.until("]]>")}, true
+		}
+		return z.bogusComment(), true
+	case c == '?':
+		return z.bogusComment(), true
+	case c == '/':
+		z.i++
+		switch {
+		case z.i < len(z.s) && z.s[z.i] == '>':
+			z.i++
+			return htmlToken{}, false
+		case z.i < len(z.s) && isASCIIAlpha(z.s[z.i]):
+			return z.tag(htmlEndTagToken)
+		}
+		return z.bogusComment(), true
+	}
+	return z.tag(htmlStartTagToken)
+}
+
+// until returns the input up to s and moves past s, or to the end of the
+// input if s is not found.
+func (z *htmlTokenizer) until(s string) string {
+	j := strings.Index(z.s[z.i:], s)
+	if j < 0 {
+		data := z.s[z.i:]
+		z.i = len(z.s)
+		return data
+	}
+	data := z.s[z.i : z.i+j]
+	z.i += j + len(s)
+	return data
+}
+
+func (z *htmlTokenizer) bogusComment() htmlToken {
+	return htmlToken{typ: htmlCommentToken, data: replaceNUL(z.until(">"))}
+}
+
+// comment reads a comment; the input is just past <!--.
+func (z *htmlTokenizer) comment() htmlToken {
+	// <!--> and <!---> are empty comments
+	for _, empty := range []string{">", "->"} {
+		if strings.HasPrefix(z.s[z.i:], empty) {
+			z.i += len(empty)
+			return htmlToken{typ: htmlCommentToken}
+		}
+	}
+	for j := z.i; j < len(z.s); j++ {
+		if z.s[j] != '-' {
+			continue
+		}
+		for _, end := range []string{"-->", "--!>"} {
+			if strings.HasPrefix(z.s[j:], end) {
+				data := z.s[z.i:j]
+				z.i = j + len(end)
+				return htmlToken{typ: htmlCommentToken, data: replaceNUL(data)}
+			}
+		}
+	}
+	data := strings.TrimSuffix(strings.TrimSuffix(z.s[z.i:], "-"), "-")
+	z.i = len(z.s)
+	return htmlToken{typ: htmlCommentToken, data: replaceNUL(data)}
+}
+
+func (z *htmlTokenizer) skipSpace() {
+	for z.i < len(z.s) && isHTMLSpace(z.s[z.i]) {
+		z.i++
+	}
+}
+
+// doctype reads a document type declaration; the input is just past
+// <!DOCTYPE.
+func (z *htmlTokenizer) doctype() htmlToken {
+	t := htmlToken{typ: htmlDoctypeToken}
+	z.skipSpace()
+	if z.i >= len(z.s) {
+		t.forceQuirks = true
+		return t
+	}
+	if z.s[z.i] == '>' {
+		z.i++
+		t.forceQuirks = true
+		return t
+	}
+	j := z.i
+	for j < len(z.s) && !isHTMLSpace(z.s[j]) && z.s[j] != '>' {
+		j++
+	}
+	t.data = strings.ToLower(replaceNUL(z.s[z.i:j]))
+	z.i = j
+	z.skipSpace()
+	if z.i >= len(z.s) {
+		t.forceQuirks = true
+		return t
+	}
+	if z.s[z.i] == '>' {
+		z.i++
+		return t
+	}
+	keyword := ""
+	if len(z.s)-z.i >= 6 {
+		keyword = strings.ToUpper(z.s[z.i : z.i+6])
+	}
+	switch keyword {
+	case "PUBLIC":
+		z.i += 6
+		z.skipSpace()
+		if t.publicId, t.hasPublic = z.quoted(); !t.hasPublic {
+			t.forceQuirks = true
+			break
+		}
+		z.skipSpace()
+		if z.i < len(z.s) && z.s[z.i] != '>' {
+			if t.systemId, t.hasSystem = z.quoted(); !t.hasSystem {
+				t.forceQuirks = true
+			}
+		}
+	case "SYSTEM":
+		z.i += 6
+		z.skipSpace()
+		if t.systemId, t.hasSystem = z.quoted(); !t.hasSystem {
+			t.forceQuirks = true
+		}
+	default:
+		t.forceQuirks = true
+	}
+	// whatever is left up to > is ignored
+	z.until(">")
+	return t
+}
+
+// quoted reads a quoted identifier of a doctype.
+func (z *htmlTokenizer) quoted() (string, bool) {
+	if z.i >= len(z.s) || z.s[z.i] != '"' && z.s[z.i] != '\'' {
+		return "", false
+	}
+	q := z.s[z.i]
+	z.i++
+	j := z.i
+	for j < len(z.s) && z.s[j] != q && z.s[j] != '>' {
+		j++
+	}
+	id := replaceNUL(z.s[z.i:j])
+	if j >= len(z.s) || z.s[j] == '>' {
+		z.i = j
+		return id, false
+	}
+	z.i = j + 1
+	return id, true
+}
+
+// tag reads a start or end tag; the input is at the first letter of its
+// name. A tag cut off by the end of the input is dropped.
+func (z *htmlTokenizer) tag(typ htmlTokenType) (htmlToken, bool) {
+	t := htmlToken{typ: typ}
+	j := z.i
+	for j < len(z.s) && !isHTMLSpace(z.s[j]) && z.s[j] != '/' && z.s[j] != '>' {
+		j++
+	}
+	t.data = strings.ToLower(replaceNUL(z.s[z.i:j]))
+	z.i = j
+	for {
+		z.skipSpace()
+		if z.i >= len(z.s) {
+			return htmlToken{}, false
+		}
+		switch z.s[z.i] {
+		case '>':
+			z.i++
+			if typ == htmlStartTagToken {
+				z.lastTag = t.data
+			} else {
+				// end tags have no attributes
+				t.attr = nil
+				t.selfClosing = false
+			}
+			return t, true
+		case '/':
+			z.i++
+			if z.i < len(z.s) && z.s[z.i] == '>' {
+				t.selfClosing = true
+			}
+			continue
+		}
+		name, value := z.attribute()
+		if name == "" {
+			continue
+		}
+		dup := false
+		for _, a := range t.attr {
+			if a.name == name {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			t.attr = append(t.attr, htmlAttr{name: name, value: value})
+		}
+	}
+}
+
+// attribute reads an attribute of a tag.
+func (z *htmlTokenizer) attribute() (string, string) {
+	j := z.i
+	if z.s[j] == '=' {
+		// an = where a name is expected is part of the name
+		j++
+	}
+	for j < len(z.s) && !isHTMLSpace(z.s[j]) && z.s[j] != '/' && z.s[j] != '>' && z.s[j] != '=' {
+		j++
+	}
+	name := strings.ToLower(replaceNUL(z.s[z.i:j]))
+	z.i = j
+	z.skipSpace()
+	if z.i >= len(z.s) || z.s[z.i] != '=' {
+		return name, ""
+	}
+	z.i++
+	z.skipSpace()
+	if z.i >= len(z.s) {
+		return name, ""
+	}
+	switch q := z.s[z.i]; q {
+	case '>':
+		return name, ""
+	case '"', '\'':
+		z.i++
+		j = strings.IndexByte(z.s[z.i:], q)
+		if j < 0 {
+			// the tag is dropped at the end of the input anyway
+			z.i = len(z.s)
+			return name, ""
+		}
+		value := z.s[z.i : z.i+j]
+		z.i += j + 1
+		return name, decodeAttrCharRefs(replaceNUL(value))
+	}
+	j = z.i
+	for j < len(z.s) && !isHTMLSpace(z.s[j]) && z.s[j] != '>' {
+		j++
+	}
+	value := z.s[z.i:j]
+	z.i = j
+	return name, decodeAttrCharRefs(replaceNUL(value))
+}
+
+// decodeCharRefs replaces the character references in s.
+func decodeCharRefs(s string) string {
+	return decodeRefs(s, false)
+}
+
+// decodeAttrCharRefs replaces the character references in the value of
+// an attribute.
+func decodeAttrCharRefs(s string) string {
+	return decodeRefs(s, true)
+}
+
+func decodeRefs(s string, inAttr bool) string {
+	if strings.IndexByte(s, '&') < 0 {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); {
+		if s[i] != '&' {
+			b.WriteByte(s[i])
+			i++
+			continue
+		}
+		r, n := decodeCharRef(s[i:], inAttr)
+		b.WriteString(r)
+		i += n
+	}
+	return b.String()
+}
+
+// the characters that numeric references to C1 controls stand for, as
+// in windows-1252
+var htmlC1Replacements = map[int]rune{
+	0x80: '€', 0x82: '‚', 0x83: 'ƒ', 0x84: '„',
+	0x85: '…', 0x86: '†', 0x87: '‡', 0x88: 'ˆ',
+	0x89: '‰', 0x8A: 'Š', 0x8B: '‹', 0x8C: 'Œ',
+	0x8E: 'Ž', 0x91: '‘', 0x92: '’', 0x93: '“',
+	0x94: '”', 0x95: '•', 0x96: '–', 0x97: '—',
+	0x98: '˜', 0x99: '™', 0x9A: 'š', 0x9B: '›',
+	0x9C: 'œ', 0x9E: 'ž', 0x9F: 'Ÿ',
+}
+
+// decodeCharRef decodes the character reference at the start of s, which
+// starts with &. It returns the replacement and the number of bytes of s
+// it stands for; if there is no reference, that is just the &.
+func decodeCharRef(s string, inAttr bool) (string, int) {
+	if len(s) < 2 {
+		return "&", 1
+	}
+	if s[1] == '#' {
+		i, base := 2, 10
+		if i < len(s) && (s[i] == 'x' || s[i] == 'X') {
+			i, base = 3, 16
+		}
+		j := i
+		for j < len(s) && (base == 10 && '0' <= s[j] && s[j] <= '9' || base == 16 && isHexDigit(s[j])) {
+			j++
+		}
+		if j == i {
+			return "&", 1
+		}
+		n := int64(utf8.MaxRune + 1)
+		if digits := strings.TrimLeft(s[i:j], "0"); len(digits) <= 8 {
+			n, _ = strconv.ParseInt("0"+digits, base, 64)
+		}
+		if j < len(s) && s[j] == ';' {
+			j++
+		}
+		return string(htmlCharRefRune(int(n))), j
+	}
+	j := 1
+	for j < len(s) && isASCIIAlnum(s[j]) {
+		j++
+	}
+	if j == 1 {
+		return "&", 1
+	}
+	if j < len(s) && s[j] == ';' {
+		if v, ok := htmlEntities[s[1:j+1]]; ok {
+			return v, j + 1
+		}
+	}
+	// the longest name that may be used without a semicolon
+	for k := min(j, 1+longestHTMLEntityWithoutSemicolon); k > 1; k-- {
+		v, ok := htmlEntities[s[1:k]]
+		if !ok {
+			continue
+		}
+		if inAttr && k < len(s) && (s[k] == '=' || isASCIIAlnum(s[k])) {
+			// kept as it is, for the sake of URLs in attributes
+			break
+		}
+		return v, k
+	}
+	return "&", 1
+}
+
+func isHexDigit(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+// htmlCharRefRune returns the character a numeric reference to n stands
+// for.
+func htmlCharRefRune(n int) rune {
+	switch {
+	case n == 0, n > utf8.MaxRune, 0xD800 <= n && n <= 0xDFFF:
+		return '\uFFFD'
+	}
+	if r, ok := htmlC1Replacements[n]; ok {
+		return r
+	}
+	return rune(n)
+}
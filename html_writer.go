@@ -0,0 +1,155 @@
+package dom
+
+/*
+ * Writes nodes with the HTML syntax, following
+ * https://html.spec.whatwg.org/multipage/parsing.html#serialising-html-fragments
+ */
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// ToHTML serializes n, which may be a Document, with the HTML syntax, so
+// that ParseHTML gives back the same tree: void elements like br have no
+// end tag, the text of script and style is written as it is, and empty
+// boolean attributes have no value.
+func ToHTML(n Node) string {
+	var b strings.Builder
+	WriteHTML(&b, n)
+	return b.String()
+}
+
+// WriteHTML writes n to w as ToHTML does.
+func WriteHTML(w io.Writer, n Node) error {
+	hw := &htmlWriter{bufio.NewWriter(w)}
+	hw.node(n)
+	return hw.Flush()
+}
+
+// an htmlWriter writes nodes with the HTML syntax. Errors writing stick
+// in the bufio.Writer and come back from Flush.
+type htmlWriter struct {
+	*bufio.Writer
+}
+
+func (w *htmlWriter) node(n Node) {
+	switch n.NodeType() {
+	case ELEMENT_NODE:
+		w.element(n.(*_elem))
+	case TEXT_NODE, CDATA_SECTION_NODE:
+		if p, ok := n.ParentNode().(*_elem); ok && htmlElement(p, htmlRawText...) {
+			w.WriteString(n.NodeValue())
+		} else {
+			htmlTextEscaper.WriteString(w, n.NodeValue())
+		}
+	case COMMENT_NODE:
+		w.WriteString("<!--" + n.NodeValue() + "-->")
+	case PROCESSING_INSTRUCTION_NODE:
+		pi := n.(ProcessingInstruction)
+		w.WriteString("<?" + pi.Target() + " " + pi.GetData() + ">")
+	case DOCUMENT_TYPE_NODE:
+		w.WriteString("<!DOCTYPE " + n.NodeName() + ">")
+	case ENTITY_REFERENCE_NODE:
+		if !n.HasChildNodes() {
+			w.WriteString("&" + n.NodeName() + ";")
+			break
+		}
+		fallthrough
+	default:
+		for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+			w.node(c)
+		}
+	}
+}
+
+func (w *htmlWriter) element(e *_elem) {
+	name := e.NodeName()
+	switch e.n.Space {
+	case htmlURL, svgURL, mathMLURL:
+		name = e.n.Local
+	}
+	w.WriteString("<" + name)
+	for _, a := range e.attribs {
+		w.WriteString(" " + htmlAttrName(a))
+		if a.value == "" && htmlElement(e) && htmlBoolean[a.NodeName()] {
+			continue
+		}
+		w.WriteString("=\"")
+		htmlAttrEscaper.WriteString(w, a.value)
+		w.WriteString("\"")
+	}
+	w.WriteString(">")
+	if htmlElement(e, htmlVoid...) {
+		return
+	}
+	// the parser drops a newline that follows these start tags
+	if htmlElement(e, "pre", "textarea", "listing") {
+		if t, ok := e.FirstChild().(*_text); ok && strings.HasPrefix(t.NodeValue(), "\n") {
+			w.WriteString("\n")
+		}
+	}
+	for c := e.FirstChild(); c != nil; c = c.NextSibling() {
+		w.node(c)
+	}
+	w.WriteString("</" + name + ">")
+}
+
+// htmlElement reports whether e is an HTML element called one of names,
+// or any HTML element if there are none. Elements in no namespace, as in
+// trees that ParseHTML did not make, count as HTML ones.
+func htmlElement(e *_elem, names ...string) bool {
+	if e.n.Space != htmlURL && e.n.Space != "" {
+		return false
+	}
+	if len(names) == 0 {
+		return true
+	}
+	for _, name := range names {
+		if e.n.Local == name {
+			return true
+		}
+	}
+	return false
+}
+
+// htmlAttrName returns the name a is written with.
+func htmlAttrName(a *_attr) string {
+	switch a.n.Space {
+	case "":
+		return a.NodeName()
+	case xmlURL:
+		return "xml:" + a.n.Local
+	case xmlnsURL:
+		if a.n.Local == "xmlns" {
+			return "xmlns"
+		}
+		return "xmlns:" + a.n.Local
+	case xlinkURL:
+		return "xlink:" + a.n.Local
+	}
+	return a.NodeName()
+}
+
+var (
+	htmlTextEscaper = strings.NewReplacer("&", "&amp;", "\u00a0", "&nbsp;", "<", "&lt;", ">", "&gt;")
+	htmlAttrEscaper = strings.NewReplacer("&", "&amp;", "\u00a0", "&nbsp;", "\"", "&quot;", "<", "&lt;", ">", "&gt;")
+)
+
+// the elements that have no end tag and no content
+var htmlVoid = []string{"area", "base", "basefont", "bgsound", "br", "col", "embed", "frame",
+	"hr", "img", "input", "keygen", "link", "meta", "param", "source", "track", "wbr"}
+
+// the elements whose text is written without escaping
+var htmlRawText = []string{"style", "script", "xmp", "iframe", "noembed", "noframes", "plaintext", "noscript"}
+
+// the attributes of HTML elements that are written without a value when
+// it is empty
+var htmlBoolean = map[string]bool{
+	"allowfullscreen": true, "async": true, "autofocus": true, "autoplay": true, "checked": true,
+	"controls": true, "default": true, "defer": true, "disabled": true, "formnovalidate": true,
+	"hidden": true, "inert": true, "ismap": true, "itemscope": true, "loop": true, "multiple": true,
+	"muted": true, "nomodule": true, "novalidate": true, "open": true, "playsinline": true,
+	"readonly": true, "required": true, "reversed": true, "selected": true,
+}
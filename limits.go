@@ -0,0 +1,81 @@
+package dom
+
+/*
+ * Bounds on what a parsed document may use, to parse input from
+ * untrusted sources safely.
+ */
+
+import (
+	"fmt"
+)
+
+// Limits bound the resources a document may use while it is parsed.
+// A limit of zero means no limit.
+type Limits struct {
+	MaxDepth                int   // nesting depth of elements
+	MaxNodes                int   // nodes created, not counting attributes
+	MaxAttributesPerElement int   // attributes on one element
+	MaxTextLength           int   // bytes in one text, CDATA, comment or PI node
+	MaxInputBytes           int64 // bytes read from the input
+	MaxEntityExpansions     int   // entity references expanded in total
+	MaxEntityDepth          int   // entity references expanded within each other
+	MaxEntityExpansionBytes int64 // bytes of replacement text expanded in total
+}
+
+// DefaultLimits returns the limits used by Parse. They are generous for
+// ordinary documents but stop runaway nesting and entity expansion, and
+// bound the memory a document can take with at most 256 MiB of input and
+// 4M nodes. Larger documents need limits of their own. ParseSAX reads
+// inputs of any size, without the bound on MaxInputBytes, and builds no
+// nodes.
+func DefaultLimits() Limits {
+	return Limits{
+		MaxDepth:                1000,
+		MaxNodes:                4 << 20,
+		MaxAttributesPerElement: 1000,
+		MaxTextLength:           64 << 20,
+		MaxInputBytes:           256 << 20,
+		MaxEntityExpansions:     10000,
+		MaxEntityDepth:          16,
+		MaxEntityExpansionBytes: 10 << 20,
+	}
+}
+
+// A LimitError reports that the input went beyond one of the Limits.
+type LimitError struct {
+	Limit string // the name of the field of Limits
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("dom: %s limit of %d exceeded", e.Limit, e.Max)
+}
+
+// exceeds returns a LimitError if n is over the limit max.
+func exceeds(limit string, max int64, n int64) error {
+	if max > 0 && n > max {
+		return &LimitError{limit, max}
+	}
+	return nil
+}
+
+// expansions counts the entity expansions of a document, which may be
+// spread over several Tokenizers.
+type expansions struct {
+	count int
+	bytes int64
+}
+
+// expand counts the expansion of an entity with the given replacement
+// text at the given depth of nested expansions.
+func (x *expansions) expand(l *Limits, depth int, value string) error {
+	x.count++
+	x.bytes += int64(len(value))
+	if err := exceeds("MaxEntityExpansions", int64(l.MaxEntityExpansions), int64(x.count)); err != nil {
+		return err
+	}
+	if err := exceeds("MaxEntityDepth", int64(l.MaxEntityDepth), int64(depth)); err != nil {
+		return err
+	}
+	return exceeds("MaxEntityExpansionBytes", l.MaxEntityExpansionBytes, x.bytes)
+}
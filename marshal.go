@@ -0,0 +1,898 @@
+package dom
+
+/*
+ * Binding nodes to Go values with the xml struct tags of encoding/xml:
+ * Unmarshal reads a value out of the tree itself, and Marshal builds
+ * Elements out of a value the same way.
+ *
+ * The rules for values and fields are adapted from encoding/xml/read.go
+ * and encoding/xml/marshal.go of the Go distribution.
+ * Copyright 2009, 2011 The Go Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that can be
+ * found in the LICENSE.go-authors file.
+ */
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Unmarshal stores the content of n in the value v points to, by the
+// rules of xml.Unmarshal: fields are matched by their xml struct tags,
+// with attr, chardata, cdata, innerxml, comment, any, a>b paths and
+// namespaces as encoding/xml has them. n may be an Element, a Document,
+// which stands for its document element, or an Attr. The tree is read
+// as it is, without being written out as XML; innerxml fields get the
+// children as InnerXML writes them. The content of entity references
+// is read as if it were in place of them.
+//
+// Values that implement xml.Unmarshaler are given an xml.Decoder over the
+// tokens of their element, see NewTokenReader.
+func Unmarshal(n Node, v any) error {
+	val := reflect.ValueOf(v)
+	if val.Kind() != reflect.Pointer || val.IsNil() {
+		return errors.New("non-pointer passed to Unmarshal")
+	}
+	switch n.NodeType() {
+	case DOCUMENT_NODE:
+		root := n.(Document).DocumentElement()
+		if root == nil {
+			return errors.New("document has no document element to unmarshal")
+		}
+		return unmarshalElem(root.(*_elem), val.Elem())
+	case ELEMENT_NODE:
+		return unmarshalElem(n.(*_elem), val.Elem())
+	case ATTRIBUTE_NODE:
+		return unmarshalAttr(val.Elem(), tokenAttr(n.(*_attr)))
+	}
+	return errors.New("cannot unmarshal a " + n.NodeName() + " node")
+}
+
+// Marshal returns the element xml.Marshal would write for v, as the
+// document element of a new Document. The element is built from v
+// directly, by the rules of xml.Marshal. A value that makes more than
+// one element, like a slice, gets them all as the children of a
+// DocumentFragment of the new Document; Marshal returns the first, and
+// the others are its next siblings.
+//
+// Values that implement xml.Marshaler are given an xml.Encoder, and what
+// they encode is read back; so is the text of innerxml fields.
+func Marshal(v any) (Element, error) {
+	m := &marshaler{}
+	if err := m.marshalValue(reflect.ValueOf(v), nil); err != nil {
+		return nil, err
+	}
+	if m.roots == 0 {
+		return nil, errors.New("value marshals to no element")
+	}
+
+	// the tokens are built as they come, without the limits on input
+	opts := NewParseOptions()
+	opts.SourcePositions = false
+	opts.Limits = Limits{}
+	b := newBuilder(opts)
+	s := newTokenSAXReader(m, b, opts)
+	var f *_frag
+	if m.roots > 1 {
+		f = newFrag(b.d)
+		b.e = f
+		s.fragment = true
+	}
+	if err := s.run(); err != nil {
+		return nil, err
+	}
+	if f != nil {
+		return f.FirstChild().(Element), nil
+	}
+	return b.d.DocumentElement(), nil
+}
+
+// tokenAttr returns a as xml.Decoder.Token would give it.
+func tokenAttr(a *_attr) xml.Attr {
+	name := a.n
+	if name.Space == xmlnsURL {
+		if name.Local == "xmlns" {
+			name = xml.Name{Local: "xmlns"}
+		} else {
+			name.Space = "xmlns"
+		}
+	}
+	return xml.Attr{Name: name, Value: a.value}
+}
+
+// contentOf returns the children of n, with the children of entity
+// references in place of them.
+func contentOf(n Node) []Node {
+	var nodes []Node
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if c.NodeType() == ENTITY_REFERENCE_NODE {
+			nodes = append(nodes, contentOf(c)...)
+		} else {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+// charData returns the text of the children of e.
+func charData(e *_elem) []byte {
+	var data []byte
+	for _, c := range contentOf(e) {
+		switch c.NodeType() {
+		case TEXT_NODE, CDATA_SECTION_NODE:
+			data = append(data, c.NodeValue()...)
+		}
+	}
+	return data
+}
+
+// implements returns v, or a pointer to it, as a T.
+func implements[T any](v reflect.Value) (T, bool) {
+	if v.CanInterface() {
+		if t, ok := v.Interface().(T); ok {
+			return t, true
+		}
+	}
+	if v.CanAddr() && v.Addr().CanInterface() {
+		if t, ok := v.Addr().Interface().(T); ok {
+			return t, true
+		}
+	}
+	var zero T
+	return zero, false
+}
+
+// unmarshalElem stores e in val.
+func unmarshalElem(e *_elem, val reflect.Value) error {
+	// the value held by an interface is used if it is a pointer
+	if val.Kind() == reflect.Interface && !val.IsNil() {
+		if p := val.Elem(); p.Kind() == reflect.Pointer && !p.IsNil() {
+			val = p
+		}
+	}
+	if val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+	if u, ok := implements[xml.Unmarshaler](val); ok {
+		d := xml.NewTokenDecoder(NewTokenReader(e))
+		start, err := d.Token()
+		if err != nil {
+			return err
+		}
+		return u.UnmarshalXML(d, start.(xml.StartElement))
+	}
+	if u, ok := implements[encoding.TextUnmarshaler](val); ok {
+		return u.UnmarshalText(charData(e))
+	}
+
+	var (
+		saveData, saveComment, saveXML, saveAny reflect.Value
+		sv                                      reflect.Value
+		tinfo                                   *typeInfo
+	)
+	switch val.Kind() {
+	default:
+		return errors.New("unknown type " + val.Type().String())
+	case reflect.Interface:
+		// left alone, as xml.Unmarshal does
+		return nil
+	case reflect.Slice:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			saveData = val
+			break
+		}
+		// each element is appended
+		n := val.Len()
+		val.Grow(1)
+		val.SetLen(n + 1)
+		if err := unmarshalElem(e, val.Index(n)); err != nil {
+			val.SetLen(n)
+			return err
+		}
+		return nil
+	case reflect.Bool, reflect.Float32, reflect.Float64, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32,
+		reflect.Int64, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.String:
+		saveData = val
+	case reflect.Struct:
+		if val.Type() == nameType {
+			val.Set(reflect.ValueOf(e.n))
+			break
+		}
+		var err error
+		if tinfo, err = getTypeInfo(val.Type()); err != nil {
+			return err
+		}
+		sv = val
+
+		if finfo := tinfo.xmlname; finfo != nil {
+			if finfo.name != "" && finfo.name != e.n.Local {
+				return xml.UnmarshalError("expected element type <" + finfo.name + "> but have <" + e.n.Local + ">")
+			}
+			if finfo.xmlns != "" && finfo.xmlns != e.n.Space {
+				msg := "expected element <" + finfo.name + "> in name space " + finfo.xmlns + " but have "
+				if e.n.Space == "" {
+					msg += "no name space"
+				} else {
+					msg += e.n.Space
+				}
+				return xml.UnmarshalError(msg)
+			}
+			if fv := finfo.value(sv); fv.Type() == nameType {
+				fv.Set(reflect.ValueOf(e.n))
+			}
+		}
+
+		for _, a := range e.attribs {
+			attr := tokenAttr(a)
+			handled, anyField := false, -1
+			for i := range tinfo.fields {
+				finfo := &tinfo.fields[i]
+				switch finfo.flags & fMode {
+				case fAttr:
+					if attr.Name.Local == finfo.name && (finfo.xmlns == "" || finfo.xmlns == attr.Name.Space) {
+						if err := unmarshalAttr(finfo.value(sv), attr); err != nil {
+							return err
+						}
+						handled = true
+					}
+				case fAny | fAttr:
+					if anyField == -1 {
+						anyField = i
+					}
+				}
+			}
+			if !handled && anyField >= 0 {
+				if err := unmarshalAttr(tinfo.fields[anyField].value(sv), attr); err != nil {
+					return err
+				}
+			}
+		}
+
+		for i := range tinfo.fields {
+			finfo := &tinfo.fields[i]
+			switch finfo.flags & fMode {
+			case fCDATA, fCharData:
+				if !saveData.IsValid() {
+					saveData = finfo.value(sv)
+				}
+			case fComment:
+				if !saveComment.IsValid() {
+					saveComment = finfo.value(sv)
+				}
+			case fAny, fAny | fElement:
+				if !saveAny.IsValid() {
+					saveAny = finfo.value(sv)
+				}
+			case fInnerXML:
+				if !saveXML.IsValid() {
+					saveXML = finfo.value(sv)
+				}
+			}
+		}
+	}
+
+	var data, comment []byte
+	for _, c := range contentOf(e) {
+		switch c.NodeType() {
+		case ELEMENT_NODE:
+			if !sv.IsValid() {
+				break
+			}
+			consumed, err := unmarshalPath(tinfo, sv, nil, c.(*_elem))
+			if err != nil {
+				return err
+			}
+			if !consumed && saveAny.IsValid() {
+				if err := unmarshalElem(c.(*_elem), saveAny); err != nil {
+					return err
+				}
+			}
+		case TEXT_NODE, CDATA_SECTION_NODE:
+			if saveData.IsValid() {
+				data = append(data, c.NodeValue()...)
+			}
+		case COMMENT_NODE:
+			if saveComment.IsValid() {
+				comment = append(comment, c.NodeValue()...)
+			}
+		}
+	}
+
+	if saveData.IsValid() {
+		if saveData.Kind() == reflect.Pointer {
+			if saveData.IsNil() {
+				saveData.Set(reflect.New(saveData.Type().Elem()))
+			}
+			saveData = saveData.Elem()
+		}
+		if u, ok := implements[encoding.TextUnmarshaler](saveData); ok {
+			if err := u.UnmarshalText(data); err != nil {
+				return err
+			}
+		} else if err := copyValue(saveData, data); err != nil {
+			return err
+		}
+	}
+
+	switch saveComment.Kind() {
+	case reflect.String:
+		saveComment.SetString(string(comment))
+	case reflect.Slice:
+		saveComment.Set(reflect.ValueOf(comment))
+	}
+
+	switch saveXML.Kind() {
+	case reflect.String:
+		saveXML.SetString(e.InnerXML())
+	case reflect.Slice:
+		if saveXML.Type().Elem().Kind() == reflect.Uint8 {
+			saveXML.SetBytes([]byte(e.InnerXML()))
+		}
+	}
+	return nil
+}
+
+// unmarshalPath looks for the fields of sv that e or the elements below
+// it are for, the parents of those fields being above e. It reports
+// whether any field has e on its path.
+func unmarshalPath(tinfo *typeInfo, sv reflect.Value, parents []string, e *_elem) (bool, error) {
+	recurse := false
+Fields:
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fElement == 0 || len(finfo.parents) < len(parents) || finfo.xmlns != "" && finfo.xmlns != e.n.Space {
+			continue
+		}
+		for j := range parents {
+			if parents[j] != finfo.parents[j] {
+				continue Fields
+			}
+		}
+		if len(finfo.parents) == len(parents) && finfo.name == e.n.Local {
+			return true, unmarshalElem(e, finfo.value(sv))
+		}
+		if len(finfo.parents) > len(parents) && finfo.parents[len(parents)] == e.n.Local {
+			// e is on the path of the field; a path cannot lead to
+			// another field
+			recurse = true
+			parents = finfo.parents[:len(parents)+1]
+			break
+		}
+	}
+	if !recurse {
+		return false, nil
+	}
+	for _, c := range contentOf(e) {
+		if c, ok := c.(*_elem); ok {
+			if _, err := unmarshalPath(tinfo, sv, parents, c); err != nil {
+				return true, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// unmarshalAttr stores attr in val.
+func unmarshalAttr(val reflect.Value, attr xml.Attr) error {
+	if val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			val.Set(reflect.New(val.Type().Elem()))
+		}
+		val = val.Elem()
+	}
+	if u, ok := implements[xml.UnmarshalerAttr](val); ok {
+		return u.UnmarshalXMLAttr(attr)
+	}
+	if u, ok := implements[encoding.TextUnmarshaler](val); ok {
+		return u.UnmarshalText([]byte(attr.Value))
+	}
+	if val.Kind() == reflect.Slice && val.Type().Elem().Kind() != reflect.Uint8 {
+		n := val.Len()
+		val.Grow(1)
+		val.SetLen(n + 1)
+		if err := unmarshalAttr(val.Index(n), attr); err != nil {
+			val.SetLen(n)
+			return err
+		}
+		return nil
+	}
+	if val.Type() == attrType {
+		val.Set(reflect.ValueOf(attr))
+		return nil
+	}
+	return copyValue(val, []byte(attr.Value))
+}
+
+// copyValue stores the text src in dst, parsing it as dst's type needs.
+func copyValue(dst reflect.Value, src []byte) error {
+	s := strings.TrimSpace(string(src))
+	switch dst.Kind() {
+	default:
+		return errors.New("cannot unmarshal into " + dst.Type().String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if len(src) == 0 {
+			dst.SetInt(0)
+			return nil
+		}
+		i, err := strconv.ParseInt(s, 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetInt(i)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if len(src) == 0 {
+			dst.SetUint(0)
+			return nil
+		}
+		u, err := strconv.ParseUint(s, 10, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetUint(u)
+	case reflect.Float32, reflect.Float64:
+		if len(src) == 0 {
+			dst.SetFloat(0)
+			return nil
+		}
+		f, err := strconv.ParseFloat(s, dst.Type().Bits())
+		if err != nil {
+			return err
+		}
+		dst.SetFloat(f)
+	case reflect.Bool:
+		if len(src) == 0 {
+			dst.SetBool(false)
+			return nil
+		}
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		dst.SetBool(b)
+	case reflect.String:
+		dst.SetString(string(src))
+	case reflect.Slice:
+		if src == nil {
+			// not nil, to tell that the element was there
+			src = []byte{}
+		}
+		dst.SetBytes(src)
+	}
+	return nil
+}
+
+// a marshaler turns Go values into the tokens xml.Marshal would write for
+// them, and reads them out as an xml.TokenReader. Names carry namespace
+// URIs, as those of xml.Decoder.Token do.
+type marshaler struct {
+	tokens []xml.Token
+	next   int
+	tags   []xml.Name // the elements open
+	roots  int        // the number of top-level elements
+}
+
+func (m *marshaler) Token() (xml.Token, error) {
+	if m.next == len(m.tokens) {
+		return nil, io.EOF
+	}
+	m.next++
+	return m.tokens[m.next-1], nil
+}
+
+func (m *marshaler) writeStart(start xml.StartElement) error {
+	if start.Name.Local == "" {
+		return errors.New("xml: start tag with no name")
+	}
+	if len(m.tags) == 0 {
+		m.roots++
+	}
+	m.tags = append(m.tags, start.Name)
+	if start.Name.Space != "" {
+		// declared on every element in a namespace, as xml.Marshal does
+		declared := false
+		for _, a := range start.Attr {
+			declared = declared || a.Name.Space == "" && a.Name.Local == "xmlns"
+		}
+		if !declared {
+			xmlns := xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: start.Name.Space}
+			start.Attr = append([]xml.Attr{xmlns}, start.Attr...)
+		}
+	}
+	attrs := start.Attr[:0:0]
+	for _, a := range start.Attr {
+		if a.Name.Local != "" {
+			attrs = append(attrs, a)
+		}
+	}
+	start.Attr = attrs
+	m.tokens = append(m.tokens, start)
+	return nil
+}
+
+func (m *marshaler) writeEnd(name xml.Name) {
+	m.tags = m.tags[:len(m.tags)-1]
+	m.tokens = append(m.tokens, xml.EndElement{Name: name})
+}
+
+func (m *marshaler) text(data []byte) {
+	if len(data) > 0 {
+		m.tokens = append(m.tokens, xml.CharData(data))
+	}
+}
+
+// readTokens adds the tokens of the XML text in r.
+func (m *marshaler) readTokens(r io.Reader) error {
+	d := xml.NewDecoder(r)
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		switch t := t.(type) {
+		case xml.StartElement:
+			if len(m.tags) == 0 {
+				m.roots++
+			}
+			m.tags = append(m.tags, t.Name)
+		case xml.EndElement:
+			m.tags = m.tags[:len(m.tags)-1]
+		}
+		m.tokens = append(m.tokens, xml.CopyToken(t))
+	}
+}
+
+// marshalValue adds the element for val, or those for the items of a
+// slice. finfo is the field val is in, if any.
+func (m *marshaler) marshalValue(val reflect.Value, finfo *fieldInfo) error {
+	if !val.IsValid() {
+		return nil
+	}
+	if finfo != nil && finfo.flags&fOmitEmpty != 0 && isEmptyValue(val) {
+		return nil
+	}
+	for val.Kind() == reflect.Interface || val.Kind() == reflect.Pointer {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	typ := val.Type()
+
+	if v, ok := implements[xml.Marshaler](val); ok {
+		var b bytes.Buffer
+		enc := xml.NewEncoder(&b)
+		if err := v.MarshalXML(enc, defaultStart(typ, finfo)); err != nil {
+			return err
+		}
+		if err := enc.Close(); err != nil {
+			return err
+		}
+		return m.readTokens(&b)
+	}
+	if v, ok := implements[encoding.TextMarshaler](val); ok {
+		start := defaultStart(typ, finfo)
+		text, err := v.MarshalText()
+		if err != nil {
+			return err
+		}
+		if err := m.writeStart(start); err != nil {
+			return err
+		}
+		m.text(text)
+		m.writeEnd(start.Name)
+		return nil
+	}
+
+	// the items of slices and arrays have no element around them
+	if (val.Kind() == reflect.Slice || val.Kind() == reflect.Array) && typ.Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < val.Len(); i++ {
+			if err := m.marshalValue(val.Index(i), finfo); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tinfo, err := getTypeInfo(typ)
+	if err != nil {
+		return err
+	}
+	var start xml.StartElement
+	if xmlname := tinfo.xmlname; xmlname != nil {
+		if xmlname.name != "" {
+			start.Name.Space, start.Name.Local = xmlname.xmlns, xmlname.name
+		} else if fv := fieldValue(xmlname, val); fv.IsValid() {
+			if name, ok := fv.Interface().(xml.Name); ok && name.Local != "" {
+				start.Name = name
+			}
+		}
+	}
+	if start.Name.Local == "" && finfo != nil {
+		start.Name.Space, start.Name.Local = finfo.xmlns, finfo.name
+	}
+	if start.Name.Local == "" {
+		name, _, _ := strings.Cut(typ.Name(), "[")
+		if name == "" {
+			return &xml.UnsupportedTypeError{Type: typ}
+		}
+		start.Name.Local = name
+	}
+
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fAttr == 0 {
+			continue
+		}
+		fv := fieldValue(finfo, val)
+		if !fv.IsValid() || finfo.flags&fOmitEmpty != 0 && isEmptyValue(fv) {
+			continue
+		}
+		if fv.Kind() == reflect.Interface && fv.IsNil() {
+			continue
+		}
+		name := xml.Name{Space: finfo.xmlns, Local: finfo.name}
+		if err := marshalAttr(&start, name, fv); err != nil {
+			return err
+		}
+	}
+
+	// an XMLName without a name takes the element out of the namespace
+	// of its parent
+	if tinfo.xmlname != nil && start.Name.Space == "" && tinfo.xmlname.xmlns == "" && tinfo.xmlname.name == "" &&
+		len(m.tags) > 0 && m.tags[len(m.tags)-1].Space != "" {
+		start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "xmlns"}})
+	}
+
+	if err := m.writeStart(start); err != nil {
+		return err
+	}
+	if val.Kind() == reflect.Struct {
+		if err := m.marshalStruct(tinfo, val); err != nil {
+			return err
+		}
+	} else {
+		text, err := marshalSimple(val)
+		if err != nil {
+			return err
+		}
+		m.text(text)
+	}
+	m.writeEnd(start.Name)
+	return nil
+}
+
+// marshalStruct adds the content of the struct val.
+func (m *marshaler) marshalStruct(tinfo *typeInfo, val reflect.Value) error {
+	var parents []string // the elements of a>b>c paths that are open
+	trim := func(path []string) {
+		split := 0
+		for split < len(path) && split < len(parents) && path[split] == parents[split] {
+			split++
+		}
+		for i := len(parents) - 1; i >= split; i-- {
+			m.writeEnd(xml.Name{Local: parents[i]})
+		}
+		parents = parents[:split]
+	}
+
+	for i := range tinfo.fields {
+		finfo := &tinfo.fields[i]
+		if finfo.flags&fAttr != 0 {
+			continue
+		}
+		fv := fieldValue(finfo, val)
+		if !fv.IsValid() {
+			continue
+		}
+		switch finfo.flags & fMode {
+		case fCDATA, fCharData:
+			trim(finfo.parents)
+			if v, ok := implements[encoding.TextMarshaler](fv); ok {
+				text, err := v.MarshalText()
+				if err != nil {
+					return err
+				}
+				m.text(text)
+				continue
+			}
+			if text, err := marshalSimple(indirect(fv)); err == nil {
+				m.text(text)
+			}
+			continue
+		case fComment:
+			trim(finfo.parents)
+			fv = indirect(fv)
+			if k := fv.Kind(); !(k == reflect.String || k == reflect.Slice && fv.Type().Elem().Kind() == reflect.Uint8) {
+				return fmt.Errorf("xml: bad type for comment field of %s", val.Type())
+			}
+			if fv.Len() == 0 {
+				continue
+			}
+			var comment []byte
+			if fv.Kind() == reflect.String {
+				comment = []byte(fv.String())
+			} else {
+				comment = append(comment, fv.Bytes()...)
+			}
+			if bytes.Contains(comment, []byte("--")) {
+				return errors.New(`xml: comments must not contain "--"`)
+			}
+			if comment[len(comment)-1] == '-' {
+				comment = append(comment, ' ')
+			}
+			m.tokens = append(m.tokens, xml.Comment(comment))
+			continue
+		case fInnerXML:
+			fv = indirect(fv)
+			switch raw := fv.Interface().(type) {
+			case []byte:
+				if err := m.readTokens(bytes.NewReader(raw)); err != nil {
+					return err
+				}
+				continue
+			case string:
+				if err := m.readTokens(strings.NewReader(raw)); err != nil {
+					return err
+				}
+				continue
+			}
+		case fElement, fElement | fAny:
+			trim(finfo.parents)
+			if len(finfo.parents) > len(parents) {
+				if fv.Kind() != reflect.Pointer && fv.Kind() != reflect.Interface || !fv.IsNil() {
+					for _, name := range finfo.parents[len(parents):] {
+						m.writeStart(xml.StartElement{Name: xml.Name{Local: name}})
+					}
+					parents = append(parents, finfo.parents[len(parents):]...)
+				}
+			}
+		}
+		if err := m.marshalValue(fv, finfo); err != nil {
+			return err
+		}
+	}
+	trim(nil)
+	return nil
+}
+
+// marshalAttr adds the attribute name for val to start, or one for each
+// item of a slice.
+func marshalAttr(start *xml.StartElement, name xml.Name, val reflect.Value) error {
+	if v, ok := implements[xml.MarshalerAttr](val); ok {
+		attr, err := v.MarshalXMLAttr(name)
+		if err != nil {
+			return err
+		}
+		if attr.Name.Local != "" {
+			start.Attr = append(start.Attr, attr)
+		}
+		return nil
+	}
+	if v, ok := implements[encoding.TextMarshaler](val); ok {
+		text, err := v.MarshalText()
+		if err != nil {
+			return err
+		}
+		start.Attr = append(start.Attr, xml.Attr{Name: name, Value: string(text)})
+		return nil
+	}
+	switch val.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+	if val.Kind() == reflect.Slice && val.Type().Elem().Kind() != reflect.Uint8 {
+		for i := 0; i < val.Len(); i++ {
+			if err := marshalAttr(start, name, val.Index(i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if val.Type() == attrType {
+		start.Attr = append(start.Attr, val.Interface().(xml.Attr))
+		return nil
+	}
+	text, err := marshalSimple(val)
+	if err != nil {
+		return err
+	}
+	start.Attr = append(start.Attr, xml.Attr{Name: name, Value: string(text)})
+	return nil
+}
+
+// marshalSimple returns the text of a value of a basic type.
+func marshalSimple(val reflect.Value) ([]byte, error) {
+	switch val.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.AppendInt(nil, val.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.AppendUint(nil, val.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.AppendFloat(nil, val.Float(), 'g', -1, val.Type().Bits()), nil
+	case reflect.String:
+		return []byte(val.String()), nil
+	case reflect.Bool:
+		return strconv.AppendBool(nil, val.Bool()), nil
+	case reflect.Array:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, val.Len())
+			reflect.Copy(reflect.ValueOf(b), val)
+			return b, nil
+		}
+	case reflect.Slice:
+		if val.Type().Elem().Kind() == reflect.Uint8 {
+			return val.Bytes(), nil
+		}
+	}
+	return nil, &xml.UnsupportedTypeError{Type: val.Type()}
+}
+
+// defaultStart returns the start of the element a Marshaler or a
+// TextMarshaler of type typ is written in.
+func defaultStart(typ reflect.Type, finfo *fieldInfo) xml.StartElement {
+	var start xml.StartElement
+	if finfo != nil && finfo.name != "" {
+		start.Name.Space, start.Name.Local = finfo.xmlns, finfo.name
+	} else if typ.Name() != "" {
+		start.Name.Local = typ.Name()
+	} else {
+		start.Name.Local = typ.Elem().Name()
+	}
+	return start
+}
+
+// fieldValue returns the field of v that finfo is about, or the zero
+// Value if a nil pointer to an embedded struct is on the way.
+func fieldValue(finfo *fieldInfo, v reflect.Value) reflect.Value {
+	for i, x := range finfo.idx {
+		if i > 0 && v.Kind() == reflect.Pointer && v.Type().Elem().Kind() == reflect.Struct {
+			if v.IsNil() {
+				return reflect.Value{}
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// indirect follows pointers and interfaces down to the value, or to the
+// last nil one.
+func indirect(v reflect.Value) reflect.Value {
+	for v.Kind() == reflect.Interface || v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return v
+		}
+		v = v.Elem()
+	}
+	return v
+}
+
+// isEmptyValue reports whether v is empty for omitempty.
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool, reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
@@ -0,0 +1,178 @@
+package dom
+
+import (
+  "encoding/xml"
+  "strings"
+  "testing"
+  "time"
+)
+
+type feedEntry struct {
+  XMLName xml.Name  `xml:"urn:feed entry"`
+  Id      int       `xml:"urn:x id,attr"`
+  Lang    string    `xml:"http://www.w3.org/XML/1998/namespace lang,attr"`
+  Title   string    `xml:"title"`
+  Author  string    `xml:"author>name"`
+  Tags    []string  `xml:"tags>tag"`
+  When    time.Time `xml:"when,omitempty"`
+  Body    struct {
+    Inner string `xml:",innerxml"`
+  } `xml:"body"`
+  Note    string     `xml:",comment"`
+  Other   []xml.Attr `xml:",any,attr"`
+  Rest    []xml.Name `xml:",any"`
+}
+
+func TestUnmarshal(t *testing.T) {
+  d, err := ParseNativeString(`<!DOCTYPE feed [<!ENTITY t "<tag>b</tag>">]><feed xmlns="urn:feed" xmlns:x="urn:x">` +
+    `<entry x:id="7" xml:lang="en" rel="r"><title>a &amp; <![CDATA[b]]></title><author><name>n</name></author>` +
+    `<tags><tag>a</tag>&t;</tags><when>2001-02-03T04:05:06Z</when><body><p>x<br/></p></body><!--c--><extra/></entry></feed>`)
+  if err != nil {
+    t.Fatalf("Parse failed: %v", err)
+  }
+  var e feedEntry
+  if err := Unmarshal(d.DocumentElement().FirstChild(), &e); err != nil {
+    t.Fatalf("Unmarshal failed: %v", err)
+  }
+  if (e.XMLName != xml.Name{Space: "urn:feed", Local: "entry"} || e.Id != 7 || e.Lang != "en" || e.Title != "a & b" || e.Author != "n") {
+    t.Errorf("Unmarshal gave %+v", e)
+  }
+  if (strings.Join(e.Tags, ",") != "a,b" || e.When.Year() != 2001 || e.Note != "c") {
+    t.Errorf("Unmarshal gave %+v", e)
+  }
+  if (e.Body.Inner != `<p xmlns="urn:feed">x<br></br></p>` && e.Body.Inner != `<p>x<br></br></p>`) {
+    t.Errorf("Unmarshal gave innerxml %q", e.Body.Inner)
+  }
+  if (len(e.Other) != 1 || e.Other[0].Name.Local != "rel" || len(e.Rest) != 1 || e.Rest[0].Local != "extra") {
+    t.Errorf("Unmarshal gave any %v %v", e.Other, e.Rest)
+  }
+
+  var wrong struct {
+    XMLName xml.Name `xml:"other"`
+  }
+  if err := Unmarshal(d, &wrong); (err == nil) {
+    t.Errorf("Unmarshal accepted the wrong element")
+  }
+  var id int
+  if err := Unmarshal(d.DocumentElement().FirstChild().(Element).GetAttributeNodeNS("urn:x", "id"), &id); (err != nil || id != 7) {
+    t.Errorf("Unmarshal of an attribute gave %d, %v", id, err)
+  }
+}
+
+// a point unmarshals itself from x,y text
+type point struct{ X, Y string }
+
+func (p *point) UnmarshalXML(d *xml.Decoder, start xml.StartElement) error {
+  var s string
+  if err := d.DecodeElement(&s, &start); err != nil {
+    return err
+  }
+  p.X, p.Y, _ = strings.Cut(s, ",")
+  return nil
+}
+
+func TestUnmarshalUnmarshaler(t *testing.T) {
+  d, _ := ParseString(`<shape><at>1,2</at></shape>`)
+  var s struct {
+    At point `xml:"at"`
+  }
+  if err := Unmarshal(d, &s); (err != nil || s.At.X != "1" || s.At.Y != "2") {
+    t.Errorf("Unmarshal gave %+v, %v", s, err)
+  }
+}
+
+func TestMarshal(t *testing.T) {
+  type item struct {
+    XMLName xml.Name `xml:"urn:i item"`
+    Id      string   `xml:"id,attr"`
+    Name    string   `xml:"name"`
+    Skip    string   `xml:"skip,omitempty"`
+  }
+  e, err := Marshal(item{Id: "1", Name: "a<b"})
+  if err != nil {
+    t.Fatalf("Marshal failed: %v", err)
+  }
+  if (e.NamespaceURI() != "urn:i" || e.GetAttribute("id") != "1" || e.FirstChild().FirstChild().NodeValue() != "a<b" || e.ChildNodes().Length() != 1) {
+    t.Errorf("Marshal built %s", e.OuterXML())
+  }
+  var back item
+  if err := Unmarshal(e, &back); (err != nil || back.Name != "a<b" || back.Id != "1") {
+    t.Errorf("Unmarshal of Marshal gave %+v, %v", back, err)
+  }
+  if _, err := Marshal((*item)(nil)); (err == nil) {
+    t.Errorf("Marshal of nil built an element")
+  }
+}
+
+// a celsius marshals itself as an element with a unit attribute
+type celsius float64
+
+func (c celsius) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+  start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: "unit"}, Value: "C"})
+  return e.EncodeElement(float64(c), start)
+}
+
+type nested struct {
+  Next *nested `xml:"n"`
+}
+
+func TestMarshalLikeEncodingXML(t *testing.T) {
+  type reading struct {
+    XMLName xml.Name   `xml:"urn:r reading"`
+    When    time.Time  `xml:"when,attr"`
+    Missing string     `xml:"missing,attr,omitempty"`
+    Place   string     `xml:"where>place"`
+    Temps   []celsius  `xml:"where>temp"`
+    Note    string     `xml:",comment"`
+    Raw     string     `xml:",innerxml"`
+    Other   struct {
+      XMLName xml.Name
+      Text    string `xml:",chardata"`
+    }
+    Flags   []bool     `xml:"flag"`
+    Any     any        `xml:"any"`
+  }
+  v := reading{When: time.Date(2001, 2, 3, 4, 5, 6, 0, time.UTC), Place: "a & b", Temps: []celsius{1.5, -2}, Note: "n-",
+    Raw: `<raw x="1">r</raw>`, Flags: []bool{true, false}, Any: 7}
+  v.Other.XMLName.Local, v.Other.Text = "other", "o"
+  e, err := Marshal(&v)
+  if err != nil {
+    t.Fatalf("Marshal failed: %v", err)
+  }
+  b, _ := xml.Marshal(&v)
+  d, _ := ParseString(string(b))
+  if got, want := e.OuterXML(), d.DocumentElement().OuterXML(); (got != want) {
+    t.Errorf("Marshal built\n%s\nnot\n%s", got, want)
+  }
+
+  var n nested
+  for i := 0; i < 2*DefaultLimits().MaxDepth; i++ {
+    n = nested{Next: &nested{n.Next}}
+  }
+  if _, err := Marshal(n); (err != nil) {
+    t.Errorf("Marshal of a deep value failed: %v", err)
+  }
+  if _, err := Marshal(struct{ C string `xml:",comment"` }{"a--b"}); (err == nil) {
+    t.Errorf("Marshal accepted -- in a comment")
+  }
+}
+
+func TestMarshalSlice(t *testing.T) {
+  e, err := Marshal([]string{"a", "b", "c"})
+  if err != nil {
+    t.Fatalf("Marshal failed: %v", err)
+  }
+  var got []string
+  for n := Node(e); n != nil; n = n.NextSibling() {
+    got = append(got, toXml(n))
+  }
+  if (strings.Join(got, "") != "<string>a</string><string>b</string><string>c</string>") {
+    t.Errorf("Marshal built %v", got)
+  }
+  if (e.ParentNode().NodeType() != DOCUMENT_FRAGMENT_NODE || e.OwnerDocument() == nil) {
+    t.Errorf("The elements of a slice are not in a fragment of a document")
+  }
+  if _, err := Marshal([]string{}); (err == nil) {
+    t.Errorf("Marshal of an empty slice built an element")
+  }
+}
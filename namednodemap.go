@@ -16,23 +16,15 @@ func (m *_attrnamednodemap) Length() uint {
 }
 
 func (m *_attrnamednodemap) Item(index uint) Node {
-  if index >= 0 && index < m.Length() {
-    i := uint(0)
-    for _, attr := range(m.e.attribs) {
-      if i == index {
-        return attr
-      }
-      i += 1
-    }
+  if index < m.Length() {
+    return m.e.attribs[index]
   }
   return Node(nil)
 }
 
 func (m *_attrnamednodemap) GetNamedItem(name string) Node {
-  for attrName, attr := range(m.e.attribs) {
-    if name == attrName {
-      return attr
-    }
+  if attr := m.e.attr(name); attr != nil {
+    return attr
   }
   return Node(nil)
 }
@@ -0,0 +1,94 @@
+package dom
+
+/*
+ * Namespaces in XML
+ * http://www.w3.org/TR/xml-names/
+ */
+
+import (
+	"strings"
+)
+
+const (
+	xmlURL   = "http://www.w3.org/XML/1998/namespace" // bound to the xml prefix
+	xmlnsURL = "http://www.w3.org/2000/xmlns/"        // the namespace of namespace declarations
+)
+
+// namespaceDecl reports whether the attribute called name declares a
+// namespace, and which prefix it binds. The default namespace has the
+// empty prefix.
+func namespaceDecl(name string) (prefix string, ok bool) {
+	if name == "xmlns" {
+		return "", true
+	}
+	if strings.HasPrefix(name, "xmlns:") {
+		return name[len("xmlns:"):], true
+	}
+	return "", false
+}
+
+// splitQName splits a qualified name into its prefix and local part.
+func splitQName(qname string) (prefix, local string) {
+	n := splitName(qname)
+	return n.Space, n.Local
+}
+
+// nearestElement returns the element whose namespaces are in scope at n.
+func nearestElement(n Node) Node {
+	switch n.NodeType() {
+	case DOCUMENT_NODE:
+		return n.(Document).DocumentElement()
+	case ATTRIBUTE_NODE:
+		return n.(Attr).OwnerElement()
+	}
+	for n != nil && n.NodeType() != ELEMENT_NODE {
+		n = n.ParentNode()
+	}
+	return n
+}
+
+// lookupNamespaceURI returns the namespace URI bound to prefix where n
+// is, or "" if the prefix is not bound. The empty prefix stands for the
+// default namespace.
+func lookupNamespaceURI(n Node, prefix string) string {
+	switch prefix {
+	case "xml":
+		return xmlURL
+	case "xmlns":
+		return xmlnsURL
+	}
+	for n = nearestElement(n); n != nil && n.NodeType() == ELEMENT_NODE; n = n.ParentNode() {
+		e := n.(*_elem)
+		if e.n.Space != "" && e.pfx == prefix {
+			return e.n.Space
+		}
+		for _, a := range e.attribs {
+			if p, ok := namespaceDecl(a.NodeName()); ok && p == prefix {
+				return a.value
+			}
+		}
+	}
+	return ""
+}
+
+// inScopeNamespaces returns the namespaces in scope at n, by prefix.
+func inScopeNamespaces(n Node) map[string]string {
+	ns := make(map[string]string)
+	bind := func(prefix, uri string) {
+		if _, ok := ns[prefix]; !ok {
+			ns[prefix] = uri
+		}
+	}
+	for n = nearestElement(n); n != nil && n.NodeType() == ELEMENT_NODE; n = n.ParentNode() {
+		e := n.(*_elem)
+		for _, a := range e.attribs {
+			if p, ok := namespaceDecl(a.NodeName()); ok {
+				bind(p, a.value)
+			}
+		}
+		if e.n.Space != "" {
+			bind(e.pfx, e.n.Space)
+		}
+	}
+	return ns
+}
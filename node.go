@@ -17,11 +17,15 @@ import (
 )
 
 type _node struct {
-	T    uint     // node type
-	p    Node     // parent
-	c    []Node   // children
-	n    xml.Name // name
-	self Node     // this _node as a Node
+	T    uint         // node type
+	p    Node         // parent
+	c    []Node       // children
+	n    xml.Name     // name; Space holds the namespace URI
+	pfx  string       // namespace prefix
+	self Node         // this _node as a Node
+	prev Node         // previous sibling
+	next Node         // next sibling
+	src  *SourceRange // where the node was parsed from, if known
 }
 
 // internal methods used so that our workhorses can do the real work
@@ -29,31 +33,114 @@ func (n *_node) setParent(p Node) {
 	n.p = p
 }
 
+func (n *_node) setSourceRange(r SourceRange) {
+	n.src = &r
+}
+
+func (n *_node) setPreviousSibling(s Node) {
+	n.prev = s
+}
+
+func (n *_node) setNextSibling(s Node) {
+	n.next = s
+}
+
+// insertChildAt also links c to its new neighbours so that sibling
+// navigation never has to search the children slice.
 func (n *_node) insertChildAt(c Node, i uint) {
 	n.c = append(n.c[:int(i)], append([]Node{c}, n.c[int(i):]...)...)
+	prev, next := Node(nil), Node(nil)
+	if i > 0 {
+		prev = n.c[i-1]
+		prev.setNextSibling(c)
+	}
+	if int(i)+1 < len(n.c) {
+		next = n.c[i+1]
+		next.setPreviousSibling(c)
+	}
+	c.setPreviousSibling(prev)
+	c.setNextSibling(next)
 }
 
 func (n *_node) removeChild(c Node) {
 	for i := len(n.c) - 1; i >= 0; i-- {
 		if n.c[i] == c {
 			n.c = append(n.c[:i], n.c[i+1:]...)
+			prev, next := Node(nil), Node(nil)
+			if i > 0 {
+				prev = n.c[i-1]
+			}
+			if i < len(n.c) {
+				next = n.c[i]
+			}
+			if prev != nil {
+				prev.setNextSibling(next)
+			}
+			if next != nil {
+				next.setPreviousSibling(prev)
+			}
+			c.setPreviousSibling(nil)
+			c.setNextSibling(nil)
 			break
 		}
 	}
 }
 
+// removeChildren unlinks all the children at once and returns them.
+func (n *_node) removeChildren() []Node {
+	children := n.c
+	n.c = nil
+	for _, c := range children {
+		c.setParent(nil)
+		c.setPreviousSibling(nil)
+		c.setNextSibling(nil)
+	}
+	return children
+}
+
 func (n *_node) NodeName() string {
 	switch n.T {
-	case 1:
+	case 1, 2:
+		if n.pfx != "" {
+			return n.pfx + ":" + n.n.Local
+		}
 		return n.n.Local
-	case 2:
+	case 5:
 		return n.n.Local
 	case 9:
 		return "#document"
+	case 11:
+		return "#document-fragment"
 	}
 	return "Node.NodeName() not implemented"
 }
 
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-NodeNSname
+func (n *_node) NamespaceURI() string {
+	if n.T == ELEMENT_NODE || n.T == ATTRIBUTE_NODE {
+		return n.n.Space
+	}
+	return ""
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-NodeNSPrefix
+func (n *_node) Prefix() string {
+	return n.pfx
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#ID-NodeNSLocalN
+func (n *_node) LocalName() string {
+	if n.T == ELEMENT_NODE || n.T == ATTRIBUTE_NODE {
+		return n.n.Local
+	}
+	return ""
+}
+
+// http://www.w3.org/TR/DOM-Level-3-Core/core.html#Node3-lookupNamespaceURI
+func (n *_node) LookupNamespaceURI(prefix string) string {
+	return lookupNamespaceURI(n.self, prefix)
+}
+
 func (n *_node) NodeValue() string {
 	return "Node.NodeValue() not implemented"
 }
@@ -62,16 +149,26 @@ func (n *_node) TagName() string {
 	return n.NodeName()
 }
 
+// SourceRange returns where in the input the node was found by the
+// parser. The range is not valid for nodes that were not parsed, or when
+// ParseOptions.SourcePositions was off.
+func (n *_node) SourceRange() SourceRange {
+	if n.src == nil {
+		return SourceRange{}
+	}
+	return *n.src
+}
+
 func (n *_node) NodeType() uint {
 	return n.T
 }
 
 func (n *_node) AppendChild(c Node) Node {
-	return appendChild(n, c)
+	return appendChild(n.self, c)
 }
 
 func (n *_node) RemoveChild(c Node) Node {
-	return removeChild(n, c)
+	return removeChild(n.self, c)
 }
 
 func (n *_node) ChildNodes() NodeList {
@@ -99,8 +196,22 @@ func ownerDocument(n Node) (d Document) {
 	d = nil
 
 	for n != nil {
-		if n.NodeType() == DOCUMENT_NODE {
+		switch n.NodeType() {
+		case DOCUMENT_NODE:
 			return n.(Document)
+		case DOCUMENT_FRAGMENT_NODE:
+			if owner := n.(*_frag).owner; owner != nil {
+				return owner
+			}
+			return nil
+		case ATTRIBUTE_NODE:
+			if e := n.(*_attr).ownerElement; e != nil {
+				n = e
+				continue
+			}
+		case XPATH_NAMESPACE_NODE:
+			n = n.(*xpathNamespace).owner
+			continue
 		}
 		n = n.ParentNode()
 	}
@@ -130,7 +241,7 @@ func newNode(_t uint) (n *_node) {
 func (p *_node) InsertBefore(nc Node, rc Node) Node {
 	if rc == nil {
 		// if refChild is null, insert newChild at the end of the list of children.
-		return appendChild(p, nc)
+		return appendChild(p.self, nc)
 	} else if rc == nc {
 		// inserting a node before itself is implementation dependent
 		return nc
@@ -145,8 +256,8 @@ func (p *_node) InsertBefore(nc Node, rc Node) Node {
 	i := nl.Length()
 	for cix := uint(0); cix < i; cix++ {
 		if nl.Item(cix) == rc {
-			p.insertChildAt(nc, cix)
-			nc.setParent(p)
+			insertChild(p.self, nc, cix)
+			break
 		}
 	}
 	return nc
@@ -173,22 +284,12 @@ func (p *_node) LastChild() Node {
 	return res
 }
 
+// Detached nodes, attributes and the Document have no siblings, so
+// their links are always nil.
 func (n *_node) PreviousSibling() Node {
-	children := n.p.ChildNodes()
-	for i := children.Length() - 1; i > 0; i-- {
-		if children.Item(i) == n.self {
-			return children.Item(i - 1)
-		}
-	}
-	return Node(nil)
+	return n.prev
 }
 
 func (n *_node) NextSibling() Node {
-	children := n.p.ChildNodes()
-	for i := uint(0); i < children.Length()-1; i++ {
-		if children.Item(i) == n.self {
-			return children.Item(i + 1)
-		}
-	}
-	return Node(nil)
+	return n.next
 }
@@ -40,21 +40,43 @@ func newChildNodelist(p *_node) *_childNodelist {
  * will continue until the function does not return true.
  */
 func walkTreeDepthFirst(n Node, f func(Node) bool) bool {
-	childNodes := n.ChildNodes()
-	numChildren := childNodes.Length()
-	var ix uint
-	for ix = 0; ix < numChildren; ix++ {
-		child := childNodes.Item(ix)
-		if f(child) != true {
+	// follow the sibling links rather than recursing, so that deep trees
+	// cannot exhaust the stack
+	c := n.FirstChild()
+	for c != nil {
+		if f(c) != true {
 			return false
 		}
-		if walkTreeDepthFirst(child, f) != true {
-			return false
+		if fc := c.FirstChild(); fc != nil {
+			c = fc
+			continue
+		}
+		for c != n && c.NextSibling() == nil {
+			c = c.ParentNode()
+		}
+		if c == n {
+			break
 		}
+		c = c.NextSibling()
 	}
 	return true
 }
 
+// A _staticNodeList holds nodes found once, and does not change with the
+// document.
+type _staticNodeList []Node
+
+func (nl _staticNodeList) Length() uint {
+	return uint(len(nl))
+}
+
+func (nl _staticNodeList) Item(index uint) Node {
+	if index < uint(len(nl)) {
+		return nl[index]
+	}
+	return nil
+}
+
 // A _tagNodeList only stores a reference to the element and the tagname
 // on which getElementsByTagName() was called so that the list can be
 // live.  TODO: Do we really query every time or can we cache the results
@@ -62,16 +84,28 @@ func walkTreeDepthFirst(n Node, f func(Node) bool) bool {
 type _tagNodeList struct {
 	rootNode Node
 	tag      string
+	ns       bool   // match namespaceURI and localName instead of tag
+	uri      string // for ns lists, the namespace URI to match
+}
+
+// matches reports whether n belongs in the list.
+func (nl *_tagNodeList) matches(n Node) bool {
+	if n.NodeType() != ELEMENT_NODE {
+		return false
+	}
+	e := n.(*_elem)
+	if nl.ns {
+		return (nl.uri == "*" || nl.uri == e.n.Space) && (nl.tag == "*" || nl.tag == e.n.Local)
+	}
+	return nl.tag == "*" || nl.tag == e.TagName()
 }
 
 func (nl *_tagNodeList) Length() uint {
 	parentElement := nl.rootNode
 	var count uint = 0
 	walkTreeDepthFirst(parentElement, func(n Node) bool {
-		if n.NodeType() == 1 {
-			if nl.tag == "*" || nl.tag == n.(Element).TagName() {
-				count++
-			}
+		if nl.matches(n) {
+			count++
 		}
 		return true
 	})
@@ -84,14 +118,12 @@ func (nl *_tagNodeList) Item(index uint) Node {
 	foundNode := Node(nil)
 
 	walkTreeDepthFirst(parentElement, func(n Node) bool {
-		if n.NodeType() == 1 {
-			if nl.tag == "*" || nl.tag == n.(Element).TagName() {
-				if count == index {
-					foundNode = n
-					return false
-				}
-				count++
+		if nl.matches(n) {
+			if count == index {
+				foundNode = n
+				return false
 			}
+			count++
 		}
 		return true
 	})
@@ -105,3 +137,12 @@ func newTagNodeList(p Node, t string) *_tagNodeList {
 	nl.tag = t
 	return nl
 }
+
+// newTagNodeListNS lists the elements below p with the given namespace
+// URI and local name, either of which may be "*".
+func newTagNodeListNS(p Node, uri string, local string) *_tagNodeList {
+	nl := newTagNodeList(p, local)
+	nl.ns = true
+	nl.uri = uri
+	return nl
+}
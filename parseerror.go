@@ -0,0 +1,139 @@
+package dom
+
+/*
+ * Errors returned by the parser.
+ */
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+var (
+	ErrMultipleRoots        = errors.New("document already has a root element")
+	ErrTextOutsideRoot      = errors.New("character data outside of the root element")
+	ErrReferenceOutsideRoot = errors.New("entity reference outside of the root element")
+	ErrRecursiveEntity      = errors.New("recursive entity reference")
+)
+
+// A ParseError is returned by the Parse functions. It tells where in the
+// input parsing stopped and wraps the error that made it stop, which is
+// an *xml.SyntaxError, one of the Err variables of this package or the
+// error of the underlying reader.
+type ParseError struct {
+	Line   int
+	Column int
+	Offset int64
+	// Path lists the open elements, as in /config/servers/server[3].
+	// Elements are numbered among their siblings of the same name when
+	// they are not the first one. Error shortens long paths; Path has
+	// them in full.
+	Path string
+	// Token is the raw text of the offending token, if it was read in
+	// full.
+	Token string
+	// Snippet is the line of input around the error.
+	Snippet string
+	Err     error
+}
+
+func (e *ParseError) Error() string {
+	msg := e.Err.Error()
+	if se, ok := e.Err.(*xml.SyntaxError); ok {
+		msg = se.Msg
+	}
+	s := fmt.Sprintf("dom: line %d, column %d: %s", e.Line, e.Column, msg)
+	if e.Path != "" {
+		s += " in " + shortPath(e.Path)
+	}
+	return s
+}
+
+// how many elements are kept at either end of a path in an error message
+const pathContext = 2
+
+// shortPath leaves out the middle of a long path, as in /a/b/…/y/z[3].
+func shortPath(path string) string {
+	steps := strings.Split(path[1:], "/")
+	if len(steps) <= 2*pathContext+1 {
+		return path
+	}
+	head := strings.Join(steps[:pathContext], "/")
+	tail := strings.Join(steps[len(steps)-pathContext:], "/")
+	return "/" + head + "/…/" + tail
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// how much input is kept on either side of an error for the snippet
+const snippetContext = 40
+
+// parseError wraps err, which happened at position at, in a ParseError.
+// inToken tells whether err is about the token just read.
+func (s *saxReader) parseError(err error, at Position, inToken bool) error {
+	pe := &ParseError{
+		Line:   at.Line,
+		Column: at.Column,
+		Offset: at.Offset,
+		Path:   s.path(),
+		Err:    err,
+	}
+	if s.recordingValid() {
+		if inToken {
+			pe.Token = string(s.rec.slice(s.span.Start.Offset, s.span.End.Offset))
+		}
+		pe.Snippet = s.rec.around(at.Offset)
+	}
+	return pe
+}
+
+// path describes the open elements the way ParseError.Path does.
+func (s *saxReader) path() string {
+	if len(s.open) == 0 {
+		return ""
+	}
+	steps := make([]string, len(s.open))
+	for i, e := range s.open {
+		steps[i] = e.step
+	}
+	return "/" + strings.Join(steps, "/")
+}
+
+// around returns the line of the recorded input around offset off.
+func (r *recorder) around(off int64) string {
+	if off < r.base {
+		return ""
+	}
+	if more, _ := r.r.Peek(snippetContext); len(more) > 0 {
+		// look ahead without taking the input away from its reader
+		end := r.base + int64(len(r.buf))
+		if off+snippetContext > end {
+			buf := append(r.buf[:len(r.buf):len(r.buf)], more...)
+			r = &recorder{buf: buf, base: r.base}
+		}
+	}
+	from := off - snippetContext
+	if from < r.base {
+		from = r.base
+	}
+	to := off + snippetContext
+	if max := r.base + int64(len(r.buf)); to > max {
+		to = max
+	}
+	if from > to {
+		return ""
+	}
+	before := r.slice(from, off)
+	after := r.slice(off, to)
+	if i := strings.LastIndexByte(string(before), '\n'); i >= 0 {
+		before = before[i+1:]
+	}
+	if i := strings.IndexByte(string(after), '\n'); i >= 0 {
+		after = after[:i]
+	}
+	return strings.TrimRight(string(before)+string(after), "\r")
+}
@@ -0,0 +1,698 @@
+package dom
+
+/*
+ * The parser: builds a Document out of the events of a saxReader, which
+ * reads the tokens of an xml.Decoder or a Tokenizer.
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// ParseOptions control how ParseWithOptions reads its input and which
+// nodes end up in the Document. Use NewParseOptions to get the defaults;
+// as with xml.Decoder, a zero ParseOptions is not strict.
+type ParseOptions struct {
+	// Strict, AutoClose, Entity and CharsetReader are handed to the
+	// xml.Decoder; see its documentation. Entity and CharsetReader are
+	// also used by the Tokenizer, which is always strict.
+	Strict        bool
+	AutoClose     []string
+	Entity        map[string]string
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+	// Native selects the package's own Tokenizer instead of xml.Decoder,
+	// see ParseNative.
+	Native bool
+
+	// DropWhitespace drops text nodes that hold nothing but whitespace,
+	// unless they are inside an element with xml:space="preserve".
+	DropWhitespace bool
+
+	// DropComments and DropProcessingInstructions leave out comments and
+	// processing instructions.
+	DropComments               bool
+	DropProcessingInstructions bool
+
+	// CoalesceText turns CDATA sections into text merged with the text
+	// around them.
+	CoalesceText bool
+
+	// ExpandEntities puts the replacement text of entities in place of
+	// EntityReference nodes.
+	ExpandEntities bool
+
+	// BaseURI becomes the DocumentURI of the Document.
+	BaseURI string
+
+	// SourcePositions records where in the input each node was found,
+	// see Node.SourceRange. Turn it off to save memory.
+	SourcePositions bool
+
+	// Limits bound what the input may use. Going beyond them fails the
+	// parse with a ParseError wrapping a *LimitError.
+	Limits Limits
+
+	// Filter, if it is not nil, decides which nodes go into the
+	// Document.
+	Filter ParseFilter
+}
+
+// A ParseFilter decides which nodes go into a Document while it is
+// parsed, as an LSParserFilter of DOM Level 3 Load and Save does. Its
+// methods return FILTER_ACCEPT, FILTER_REJECT, FILTER_SKIP or
+// FILTER_INTERRUPT, which stops parsing: the Document is returned as far
+// as it was built, without an error.
+type ParseFilter interface {
+	// StartElement is called for each element with its attributes, but
+	// none of its children, before it is attached. FILTER_REJECT leaves
+	// out the element and everything in it without building any of it,
+	// and FILTER_SKIP leaves out the element but not its children, which
+	// take its place. The document element cannot be skipped.
+	StartElement(el Element) int
+
+	// AcceptNode is called for each node once it is complete, with its
+	// children, where it was attached; for text, that is when the next
+	// node starts. FILTER_REJECT removes the node, and FILTER_SKIP puts
+	// its children in its place.
+	AcceptNode(n Node) int
+
+	// WhatToShow tells which types of node are handed to the filter, as
+	// a combination of the SHOW_ constants. Others are accepted. Filters
+	// never see the document, its document type, or attributes.
+	WhatToShow() uint32
+}
+
+// errInterrupted stops the parse when a filter returns FILTER_INTERRUPT.
+var errInterrupted = errors.New("parse interrupted by the filter")
+
+// NewParseOptions returns the options used by Parse.
+func NewParseOptions() *ParseOptions {
+	return &ParseOptions{Strict: true, SourcePositions: true, Limits: DefaultLimits()}
+}
+
+func ParseString(s string) (doc Document, err error) {
+	doc, err = Parse(strings.NewReader(s))
+	return
+}
+
+func Parse(r io.Reader) (doc Document, err error) {
+	return ParseWithOptions(r, nil)
+}
+
+// ParseNative parses r with the package's own Tokenizer instead of an
+// xml.Decoder. CDATA sections become CDATASection nodes and references to
+// entities other than the predefined ones become EntityReference nodes
+// holding the replacement text of the entity, if it was declared.
+func ParseNative(r io.Reader) (doc Document, err error) {
+	opts := NewParseOptions()
+	opts.Native = true
+	return ParseWithOptions(r, opts)
+}
+
+func ParseNativeString(s string) (doc Document, err error) {
+	return ParseNative(strings.NewReader(s))
+}
+
+// ParseWithOptions parses r as configured by opts. A nil opts means the
+// defaults of NewParseOptions.
+func ParseWithOptions(r io.Reader, opts *ParseOptions) (doc Document, err error) {
+	if opts == nil {
+		opts = NewParseOptions()
+	}
+	b := newBuilder(opts)
+	if err := newSAXReader(r, b, opts).run(); err != nil && !errors.Is(err, errInterrupted) {
+		return nil, err
+	}
+	// All is good, return the document
+	return b.d, nil
+}
+
+// ParseFragment parses the content of an element: any number of
+// elements, text, comments, processing instructions and references, as
+// in the body of context. The namespaces in scope at context, and the
+// entities declared by the document type of its document, are known to
+// the fragment. context may be nil.
+func ParseFragment(context Element, r io.Reader) (DocumentFragment, error) {
+	opts := NewParseOptions()
+	opts.Native = true
+	b := newBuilder(opts)
+	s := newSAXReader(r, b, opts)
+	var owner *_doc
+	if context != nil {
+		owner, _ = ownerDocument(context).(*_doc)
+		for prefix, uri := range inScopeNamespaces(context) {
+			s.ns = append(s.ns, nsBinding{prefix, uri})
+		}
+	}
+	f := newFrag(owner)
+	b.e = f
+	s.fragment = true
+	s.t.fragment = true
+	if owner != nil {
+		if dt := owner.Doctype(); dt != nil {
+			s.t.declareEntities(dt.InternalSubset())
+		}
+	}
+	if err := s.run(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// A Position is a place in the parsed input. Lines and columns are
+// 1-based and, as with xml.Decoder, columns count bytes.
+type Position struct {
+	Line   int
+	Column int
+	Offset int64 // bytes from the start of the input
+}
+
+// A SourceRange is the part of the input a node was parsed from. End is
+// the position just after the last byte of the node.
+type SourceRange struct {
+	Start Position
+	End   Position
+}
+
+// IsValid reports whether the range was recorded at all.
+func (r SourceRange) IsValid() bool {
+	return r.Start.Line > 0
+}
+
+// a tokenSource is either an *xml.Decoder or a *Tokenizer
+type tokenSource interface {
+	Token() (xml.Token, error)
+	InputPos() (line, column int)
+	InputOffset() int64
+}
+
+func inputPosition(p tokenSource) Position {
+	line, column := p.InputPos()
+	return Position{line, column, p.InputOffset()}
+}
+
+// A recorder sits between the token source and its input and keeps the
+// bytes read from the input, so that the raw text of the current token
+// can be looked at. Recorded bytes line up with the offsets of the token
+// source as long as it has not switched to a CharsetReader. A byte order
+// mark at the start of the input is dropped before either sees it.
+type recorder struct {
+	r     *bufio.Reader
+	begun bool // the input has been read from
+	buf   []byte
+	base  int64 // input offset of buf[0]
+	n     int64 // bytes read so far
+	max   int64 // the MaxInputBytes limit
+}
+
+func newRecorder(r io.Reader) *recorder {
+	return &recorder{r: bufio.NewReader(r)}
+}
+
+func (r *recorder) Read(p []byte) (int, error) {
+	r.begin()
+	n, err := r.r.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	r.n += int64(n)
+	if lerr := exceeds("MaxInputBytes", r.max, r.n); lerr != nil {
+		err = lerr
+	}
+	return n, err
+}
+
+func (r *recorder) ReadByte() (byte, error) {
+	r.begin()
+	c, err := r.r.ReadByte()
+	if err == nil {
+		r.buf = append(r.buf, c)
+		r.n++
+		err = exceeds("MaxInputBytes", r.max, r.n)
+	}
+	return c, err
+}
+
+func (r *recorder) begin() {
+	if !r.begun {
+		r.begun = true
+		skipBOM(r.r)
+	}
+}
+
+// skipBOM drops the UTF-8 byte order mark r starts with, if any.
+func skipBOM(r *bufio.Reader) {
+	if b, err := r.Peek(3); err == nil && string(b) == "\ufeff" {
+		r.Discard(3)
+	}
+}
+
+// discard forgets the input before offset off.
+func (r *recorder) discard(off int64) {
+	n := int(off - r.base)
+	if n <= 0 {
+		return
+	}
+	if n > len(r.buf) {
+		n = len(r.buf)
+	}
+	r.buf = r.buf[:copy(r.buf, r.buf[n:])]
+	r.base += int64(n)
+}
+
+// slice returns the recorded input between offsets from and to.
+func (r *recorder) slice(from, to int64) []byte {
+	if from < r.base || to > r.base+int64(len(r.buf)) || from > to {
+		return nil
+	}
+	return r.buf[from-r.base : to-r.base]
+}
+
+// scanStartTag finds the qualified names of the element and of its
+// attributes, and where the attributes are, in the raw text of a start
+// tag that begins at position start.
+func scanStartTag(raw []byte, start Position) (string, []string, []SourceRange) {
+	var names []string
+	var ranges []SourceRange
+	pos := start
+	i := 0
+	advance := func(n int) {
+		for ; n > 0 && i < len(raw); n-- {
+			if raw[i] == '\n' {
+				pos.Line++
+				pos.Column = 1
+			} else {
+				pos.Column++
+			}
+			pos.Offset++
+			i++
+		}
+	}
+	space := func() {
+		for i < len(raw) && (raw[i] == ' ' || raw[i] == '\t' || raw[i] == '\r' || raw[i] == '\n') {
+			advance(1)
+		}
+	}
+	name := func() string {
+		from := i
+		for i < len(raw) && isNameByte(raw[i]) {
+			advance(1)
+		}
+		return string(raw[from:i])
+	}
+	advance(1) // <
+	elem := name()
+	for {
+		space()
+		if i >= len(raw) || !isNameStart(raw[i]) {
+			return elem, names, ranges
+		}
+		from := pos
+		names = append(names, name())
+		space()
+		if i < len(raw) && raw[i] == '=' {
+			advance(1)
+			space()
+			if i < len(raw) && (raw[i] == '"' || raw[i] == '\'') {
+				q := raw[i]
+				advance(1)
+				for i < len(raw) && raw[i] != q {
+					advance(1)
+				}
+				advance(1)
+			} else {
+				// unquoted value, allowed by a lenient decoder
+				for i < len(raw) && raw[i] != ' ' && raw[i] != '>' && raw[i] != '/' {
+					advance(1)
+				}
+			}
+		}
+		ranges = append(ranges, SourceRange{from, pos})
+	}
+}
+
+// builder is the ContentHandler that assembles a Document.
+type builder struct {
+	d        *_doc
+	e        Node // e is the current parent
+	opts     *ParseOptions
+	loc      Locator
+	nodes    int    // the nodes created so far
+	preserve []bool // whether xml:space="preserve" is in effect, per open element
+	parents  []Node // the parents of the entities being expanded
+	cdata    *_cdata
+
+	skip     int    // how deep the parser is inside a rejected element
+	skipped  []bool // whether the filter skipped it, per open element
+	filtered *_text // the text node last handed to the filter
+}
+
+func newBuilder(opts *ParseOptions) *builder {
+	d := newDoc()
+	d.documentURI = opts.BaseURI
+	return &builder{d: d, e: d, opts: opts}
+}
+
+// appendNode adds n to the current parent, taking note of where it came
+// from.
+func (b *builder) appendNode(n Node) Node {
+	b.e.AppendChild(n)
+	if b.opts.SourcePositions {
+		n.setSourceRange(b.loc.SourceRange())
+	}
+	return n
+}
+
+// newNode counts a node about to be created against the limits, along
+// with the length of its data.
+func (b *builder) newNode(data int) error {
+	b.nodes++
+	if err := exceeds("MaxNodes", int64(b.opts.Limits.MaxNodes), int64(b.nodes)); err != nil {
+		return err
+	}
+	return exceeds("MaxTextLength", int64(b.opts.Limits.MaxTextLength), int64(data))
+}
+
+func (b *builder) SetDocumentLocator(l Locator) {
+	b.loc = l
+}
+
+func (b *builder) StartDocument() error {
+	return nil
+}
+
+func (b *builder) EndDocument() error {
+	return b.endText()
+}
+
+func (b *builder) XMLDecl(decl XMLDecl) error {
+	b.d.setXMLDecl(decl)
+	return nil
+}
+
+func (b *builder) Doctype(name, publicId, systemId, internalSubset string) error {
+	if err := b.endText(); err != nil {
+		return err
+	}
+	if err := b.newNode(len(internalSubset)); err != nil {
+		return err
+	}
+	b.appendNode(newDoctype(name, publicId, systemId, internalSubset))
+	return nil
+}
+
+func (b *builder) StartElement(name QName, attrs []Attribute) error {
+	if b.skip > 0 {
+		b.skip++
+		return nil
+	}
+	if err := b.endText(); err != nil {
+		return err
+	}
+	if err := b.newNode(0); err != nil {
+		return err
+	}
+	el := newElem(xml.StartElement{Name: xml.Name{Space: name.Space, Local: name.Local}})
+	el.pfx = name.Prefix
+	preserve := len(b.preserve) > 0 && b.preserve[len(b.preserve)-1]
+	var ranges []SourceRange
+	if b.opts.SourcePositions {
+		ranges = b.loc.AttrRanges()
+	}
+	for i, a := range attrs {
+		qname := a.Name.Qualified()
+		attr := el.attr(qname)
+		if attr == nil {
+			// a lenient xml.Decoder lets duplicates through, the last one wins
+			attr = newAttrNS(a.Name.Space, qname, a.Value, el)
+			el.addAttr(attr)
+		}
+		attr.value = a.Value
+		if a.Name.Space == xmlURL && attr.n.Local == "space" {
+			preserve = a.Value == "preserve"
+		}
+		if b.opts.SourcePositions {
+			if i < len(ranges) {
+				attr.setSourceRange(ranges[i])
+			} else {
+				attr.setSourceRange(b.loc.SourceRange())
+			}
+		}
+	}
+	if b.opts.SourcePositions {
+		el.setSourceRange(b.loc.SourceRange())
+	}
+	skipped := false
+	if b.shows(el) {
+		switch b.opts.Filter.StartElement(el) {
+		case FILTER_REJECT:
+			b.skip = 1
+			return nil
+		case FILTER_SKIP:
+			skipped = b.e != Node(b.d)
+		case FILTER_INTERRUPT:
+			return errInterrupted
+		}
+	}
+	b.preserve = append(b.preserve, preserve)
+	b.skipped = append(b.skipped, skipped)
+	if skipped {
+		// the children go where the element would have
+		return nil
+	}
+	if b.e == Node(b.d) {
+		// set doc root
+		if _, err := b.d.setRoot(el); err != nil {
+			return err
+		}
+		b.e = el
+	} else {
+		// this element is a child of e, the last element we found
+		b.e = b.e.AppendChild(el)
+	}
+	return nil
+}
+
+func (b *builder) EndElement(name QName) error {
+	if b.skip > 0 {
+		b.skip--
+		return nil
+	}
+	if err := b.endText(); err != nil {
+		return err
+	}
+	skipped := b.skipped[len(b.skipped)-1]
+	b.skipped = b.skipped[:len(b.skipped)-1]
+	b.preserve = b.preserve[:len(b.preserve)-1]
+	if skipped {
+		return nil
+	}
+	if b.opts.SourcePositions {
+		r := b.e.SourceRange()
+		r.End = b.loc.SourceRange().End
+		b.e.setSourceRange(r)
+	}
+	el := b.e
+	b.e = b.e.ParentNode()
+	return b.accept(el)
+}
+
+func (b *builder) Characters(data []byte) error {
+	if b.skip > 0 {
+		return nil
+	}
+	if b.cdata == nil {
+		return b.text(data)
+	}
+	n := len(b.cdata.content) + len(data)
+	if err := exceeds("MaxTextLength", int64(b.opts.Limits.MaxTextLength), int64(n)); err != nil {
+		return err
+	}
+	b.cdata.content = append(b.cdata.content, data...)
+	return nil
+}
+
+func (b *builder) Comment(data []byte) error {
+	if b.opts.DropComments || b.skip > 0 {
+		return nil
+	}
+	if err := b.endText(); err != nil {
+		return err
+	}
+	if err := b.newNode(len(data)); err != nil {
+		return err
+	}
+	return b.accept(b.appendNode(newComment(xml.Comment(data))))
+}
+
+func (b *builder) ProcessingInstruction(target, data string) error {
+	if b.opts.DropProcessingInstructions || b.skip > 0 {
+		return nil
+	}
+	if err := b.endText(); err != nil {
+		return err
+	}
+	if err := b.newNode(len(data)); err != nil {
+		return err
+	}
+	return b.accept(b.appendNode(newProcInst(xml.ProcInst{Target: target, Inst: []byte(data)})))
+}
+
+// StartCDATA starts a CDATASection node, unless CDATA sections are
+// merged with the text around them.
+func (b *builder) StartCDATA() error {
+	if b.opts.CoalesceText || b.skip > 0 {
+		return nil
+	}
+	if err := b.endText(); err != nil {
+		return err
+	}
+	if err := b.newNode(0); err != nil {
+		return err
+	}
+	b.cdata = newCData(nil)
+	b.appendNode(b.cdata)
+	return nil
+}
+
+func (b *builder) EndCDATA() error {
+	c := b.cdata
+	b.cdata = nil
+	if c == nil {
+		return nil
+	}
+	return b.accept(c)
+}
+
+// StartEntity starts an EntityReference node that the replacement text
+// of the entity goes into, unless entities are expanded in place.
+func (b *builder) StartEntity(name string) error {
+	if b.skip > 0 {
+		return nil
+	}
+	b.parents = append(b.parents, b.e)
+	if b.opts.ExpandEntities {
+		return nil
+	}
+	if err := b.endText(); err != nil {
+		return err
+	}
+	if err := b.newNode(0); err != nil {
+		return err
+	}
+	b.e = b.appendNode(newEntityRef(name))
+	return nil
+}
+
+func (b *builder) EndEntity(name string) error {
+	if b.skip > 0 {
+		return nil
+	}
+	if err := b.endText(); err != nil {
+		return err
+	}
+	ref := b.e
+	b.e = b.parents[len(b.parents)-1]
+	b.parents = b.parents[:len(b.parents)-1]
+	if b.opts.ExpandEntities {
+		return nil
+	}
+	return b.accept(ref)
+}
+
+// SkippedEntity leaves an empty EntityReference node for an entity that
+// was not declared.
+func (b *builder) SkippedEntity(name string) error {
+	if b.skip > 0 {
+		return nil
+	}
+	if err := b.endText(); err != nil {
+		return err
+	}
+	if err := b.newNode(0); err != nil {
+		return err
+	}
+	return b.accept(b.appendNode(newEntityRef(name)))
+}
+
+// endText is called when the text node at the end of the current parent
+// is complete. With DropWhitespace it goes away if it is only whitespace,
+// and otherwise it is handed to the filter.
+func (b *builder) endText() error {
+	last, ok := b.e.LastChild().(*_text)
+	if !ok || last == b.filtered {
+		return nil
+	}
+	preserve := len(b.preserve) > 0 && b.preserve[len(b.preserve)-1]
+	if b.opts.DropWhitespace && !preserve && len(bytes.TrimSpace(last.content)) == 0 {
+		b.e.RemoveChild(last)
+		return nil
+	}
+	if b.opts.Filter == nil {
+		return nil
+	}
+	b.filtered = last
+	return b.accept(last)
+}
+
+// shows reports whether the filter sees n.
+func (b *builder) shows(n Node) bool {
+	return b.opts.Filter != nil && shows(b.opts.Filter.WhatToShow(), n)
+}
+
+// shows reports whether whatToShow, made of the SHOW_ constants, has the
+// bit for the type of n.
+func shows(whatToShow uint32, n Node) bool {
+	return whatToShow&(1<<(n.NodeType()-1)) != 0
+}
+
+// accept hands the complete node n to the filter, and takes it out of
+// the tree if the filter says so.
+func (b *builder) accept(n Node) error {
+	if !b.shows(n) {
+		return nil
+	}
+	switch b.opts.Filter.AcceptNode(n) {
+	case FILTER_REJECT:
+		n.ParentNode().RemoveChild(n)
+	case FILTER_SKIP:
+		p := n.ParentNode()
+		if p == Node(b.d) && n.NodeType() == ELEMENT_NODE {
+			break
+		}
+		for c := n.FirstChild(); c != nil; c = n.FirstChild() {
+			p.InsertBefore(c, n)
+		}
+		p.RemoveChild(n)
+	case FILTER_INTERRUPT:
+		return errInterrupted
+	}
+	return nil
+}
+
+// text appends character data to the current parent, merging it with a
+// text node that is already there.
+func (b *builder) text(data []byte) error {
+	if last, ok := b.e.LastChild().(*_text); ok && last != b.filtered {
+		n := len(last.content) + len(data)
+		if err := exceeds("MaxTextLength", int64(b.opts.Limits.MaxTextLength), int64(n)); err != nil {
+			return err
+		}
+		last.content = append(last.content, data...)
+		if b.opts.SourcePositions {
+			r := last.SourceRange()
+			r.End = b.loc.SourceRange().End
+			last.setSourceRange(r)
+		}
+		return nil
+	}
+	if err := b.newNode(len(data)); err != nil {
+		return err
+	}
+	b.appendNode(newText(data))
+	return nil
+}
@@ -0,0 +1,450 @@
+package dom
+
+import (
+  "encoding/xml"
+  "errors"
+  "io"
+  "strings"
+  "testing"
+)
+
+func TestParseWithOptionsDropWhitespace(t *testing.T) {
+  opts := NewParseOptions()
+  opts.DropWhitespace = true
+  d, err := ParseWithOptions(strings.NewReader("<a>\n  <b> </b>\n  <c xml:space=\"preserve\"> <d> </d> </c>\n</a>\n"), opts)
+  if err != nil {
+    t.Fatalf("ParseWithOptions failed: %v", err)
+  }
+  r := d.DocumentElement()
+  if r.ChildNodes().Length() != 2 {
+    t.Errorf("Root had %d children instead of 2", r.ChildNodes().Length())
+  }
+  if r.FirstChild().HasChildNodes() {
+    t.Errorf("Whitespace-only text node was not dropped")
+  }
+  if r.LastChild().ChildNodes().Length() != 3 || r.LastChild().ChildNodes().Item(1).FirstChild() == nil {
+    t.Errorf("Whitespace was not preserved inside xml:space=\"preserve\"")
+  }
+  if d.ChildNodes().Length() != 1 {
+    t.Errorf("Whitespace outside the root element was not dropped")
+  }
+}
+
+func TestParseWithOptionsDropComments(t *testing.T) {
+  opts := NewParseOptions()
+  opts.DropComments = true
+  opts.DropProcessingInstructions = true
+  d, _ := ParseWithOptions(strings.NewReader(`<!--c--><a>x<!--c-->y<?pi?>z</a>`), opts)
+  r := d.DocumentElement()
+  if d.ChildNodes().Length() != 1 || r.ChildNodes().Length() != 1 || r.FirstChild().NodeValue() != "xyz" {
+    t.Errorf("Comments and processing instructions were not dropped")
+  }
+}
+
+func TestParseWithOptionsCoalesceText(t *testing.T) {
+  opts := NewParseOptions()
+  opts.Native = true
+  opts.CoalesceText = true
+  d, _ := ParseWithOptions(strings.NewReader(`<a>x<![CDATA[<y>]]>z</a>`), opts)
+  r := d.DocumentElement()
+  if r.ChildNodes().Length() != 1 || r.FirstChild().NodeType() != TEXT_NODE || r.FirstChild().NodeValue() != "x<y>z" {
+    t.Errorf("CDATA section was not merged into the surrounding text")
+  }
+}
+
+func TestParseWithOptionsEntities(t *testing.T) {
+  opts := NewParseOptions()
+  opts.Entity = map[string]string{"nbsp": " "}
+  d, err := ParseWithOptions(strings.NewReader(`<a>x&nbsp;y</a>`), opts)
+  if err != nil {
+    t.Fatalf("ParseWithOptions failed: %v", err)
+  }
+  if d.DocumentElement().FirstChild().NodeValue() != "x y" {
+    t.Errorf("Entity map was not handed to xml.Decoder")
+  }
+
+  opts.Native = true
+  opts.ExpandEntities = true
+  d, err = ParseWithOptions(strings.NewReader(`<a>x&nbsp;y</a>`), opts)
+  if err != nil {
+    t.Fatalf("ParseWithOptions failed: %v", err)
+  }
+  r := d.DocumentElement()
+  if r.ChildNodes().Length() != 1 || r.FirstChild().NodeValue() != "x y" {
+    t.Errorf("Entity was not expanded in place")
+  }
+}
+
+func TestParseWithOptionsLenient(t *testing.T) {
+  opts := NewParseOptions()
+  opts.Strict = false
+  opts.AutoClose = []string{"br"}
+  d, err := ParseWithOptions(strings.NewReader(`<p>a<br>b</p>`), opts)
+  if err != nil {
+    t.Fatalf("ParseWithOptions failed: %v", err)
+  }
+  if d.DocumentElement().ChildNodes().Length() != 3 {
+    t.Errorf("AutoClose was not handed to xml.Decoder")
+  }
+}
+
+func TestParseWithOptionsCharsetReader(t *testing.T) {
+  latin1 := func(charset string, input io.Reader) (io.Reader, error) {
+    b, _ := io.ReadAll(input)
+    r := make([]rune, len(b))
+    for i := range b {
+      r[i] = rune(b[i])
+    }
+    return strings.NewReader(string(r)), nil
+  }
+  for _, native := range []bool{false, true} {
+    opts := NewParseOptions()
+    opts.Native = native
+    opts.CharsetReader = latin1
+    d, err := ParseWithOptions(strings.NewReader("<?xml version=\"1.0\" encoding=\"ISO-8859-1\"?><a>caf\xe9</a>"), opts)
+    if err != nil {
+      t.Fatalf("ParseWithOptions failed: %v", err)
+    }
+    if d.DocumentElement().FirstChild().NodeValue() != "café" {
+      t.Errorf("CharsetReader was not used (native %v)", native)
+    }
+  }
+}
+
+func TestParseWithOptionsBaseURI(t *testing.T) {
+  opts := NewParseOptions()
+  opts.BaseURI = "http://example.com/doc.xml"
+  d, _ := ParseWithOptions(strings.NewReader(`<a/>`), opts)
+  if d.DocumentURI() != "http://example.com/doc.xml" {
+    t.Errorf("Document.DocumentURI() was '%s'", d.DocumentURI())
+  }
+}
+
+func TestParseSourceRanges(t *testing.T) {
+  src := "<a>\n  <b x=\"1\"  y='2'>text</b><!--c-->\n</a>"
+  for _, native := range []bool{false, true} {
+    opts := NewParseOptions()
+    opts.Native = native
+    d, err := ParseWithOptions(strings.NewReader(src), opts)
+    if err != nil {
+      t.Fatalf("ParseWithOptions failed: %v", err)
+    }
+    text := func(n Node) string {
+      r := n.SourceRange()
+      return src[r.Start.Offset:r.End.Offset]
+    }
+    a := d.DocumentElement()
+    b := a.ChildNodes().Item(1).(Element)
+    if text(a) != src {
+      t.Errorf("Root element range covers '%s' (native %v)", text(a), native)
+    }
+    if text(b) != `<b x="1"  y='2'>text</b>` {
+      t.Errorf("Element range covers '%s' (native %v)", text(b), native)
+    }
+    if r := b.SourceRange(); r.Start.Line != 2 || r.Start.Column != 3 || r.End.Line != 2 {
+      t.Errorf("Element range was %v (native %v)", r, native)
+    }
+    if text(b.GetAttributeNode("x")) != `x="1"` || text(b.GetAttributeNode("y")) != `y='2'` {
+      t.Errorf("Attribute ranges cover '%s' and '%s' (native %v)", text(b.GetAttributeNode("x")), text(b.GetAttributeNode("y")), native)
+    }
+    if text(b.FirstChild()) != "text" || text(a.ChildNodes().Item(2)) != "<!--c-->" {
+      t.Errorf("Text and comment ranges cover '%s' and '%s' (native %v)", text(b.FirstChild()), text(a.ChildNodes().Item(2)), native)
+    }
+  }
+}
+
+func TestParseSourceRangesOff(t *testing.T) {
+  opts := NewParseOptions()
+  opts.SourcePositions = false
+  d, _ := ParseWithOptions(strings.NewReader(`<a x="1">t</a>`), opts)
+  r := d.DocumentElement()
+  if r.SourceRange().IsValid() || r.FirstChild().SourceRange().IsValid() || r.GetAttributeNode("x").SourceRange().IsValid() {
+    t.Errorf("Source ranges were recorded although SourcePositions was off")
+  }
+  if d.CreateElement("new").SourceRange().IsValid() {
+    t.Errorf("Created element has a source range")
+  }
+}
+
+func TestParseErrorLocation(t *testing.T) {
+  src := "<config>\n <servers>\n  <server/>\n  <server/>\n  <server>\n   <port>80</prot>\n  </server>\n </servers>\n</config>"
+  for _, native := range []bool{false, true} {
+    opts := NewParseOptions()
+    opts.Native = native
+    _, err := ParseWithOptions(strings.NewReader(src), opts)
+    var pe *ParseError
+    if !errors.As(err, &pe) {
+      t.Fatalf("Parse did not return a *ParseError: %v", err)
+    }
+    if pe.Line != 6 || pe.Path != "/config/servers/server[3]/port" {
+      t.Errorf("ParseError at line %d in %s (native %v)", pe.Line, pe.Path, native)
+    }
+    if pe.Snippet != "   <port>80</prot>" {
+      t.Errorf("ParseError snippet was '%s' (native %v)", pe.Snippet, native)
+    }
+    var se *xml.SyntaxError
+    if !errors.As(err, &se) {
+      t.Errorf("ParseError does not wrap an *xml.SyntaxError (native %v)", native)
+    }
+  }
+}
+
+func TestParseErrorWrapsCause(t *testing.T) {
+  _, err := ParseString("<a/>\n<b/>")
+  if !errors.Is(err, ErrMultipleRoots) {
+    t.Fatalf("Parse error does not wrap ErrMultipleRoots: %v", err)
+  }
+  pe := err.(*ParseError)
+  if pe.Line != 2 || pe.Column != 1 || pe.Offset != 5 || pe.Token != "<b/>" {
+    t.Errorf("ParseError was at %d:%d (offset %d) for token '%s'", pe.Line, pe.Column, pe.Offset, pe.Token)
+  }
+  if !strings.Contains(pe.Error(), "line 2, column 1") {
+    t.Errorf("ParseError message was '%s'", pe.Error())
+  }
+}
+
+func TestParseErrorShortensPath(t *testing.T) {
+  _, err := ParseString("<r>" + strings.Repeat("<a>", 900) + "<b/><b></x>")
+  var pe *ParseError
+  if !errors.As(err, &pe) {
+    t.Fatalf("Parse did not return a *ParseError: %v", err)
+  }
+  if (pe.Path != "/r" + strings.Repeat("/a", 900) + "/b[2]") {
+    t.Errorf("ParseError path was %.40s", pe.Path)
+  }
+  if msg := pe.Error(); (!strings.HasSuffix(msg, " in /r/a/…/a/b[2]") || len(msg) > 200) {
+    t.Errorf("ParseError message was %.300s", msg)
+  }
+}
+
+// limitErrors parses src with the given limits, with both token sources,
+// and returns the name of the limit each of them went beyond.
+func limitErrors(t *testing.T, src string, limits Limits) []string {
+  var names []string
+  for _, native := range []bool{false, true} {
+    opts := NewParseOptions()
+    opts.Native = native
+    opts.Limits = limits
+    _, err := ParseWithOptions(strings.NewReader(src), opts)
+    var le *LimitError
+    if !errors.As(err, &le) {
+      t.Errorf("Parse did not return a *LimitError (native %v): %v", native, err)
+      names = append(names, "")
+      continue
+    }
+    if _, ok := err.(*ParseError); !ok {
+      t.Errorf("LimitError is not wrapped in a *ParseError (native %v)", native)
+    }
+    names = append(names, le.Limit)
+  }
+  return names
+}
+
+func TestParseLimits(t *testing.T) {
+  tests := []struct {
+    src    string
+    limits Limits
+    limit  string
+  }{
+    {strings.Repeat("<a>", 5) + strings.Repeat("</a>", 5), Limits{MaxDepth: 4}, "MaxDepth"},
+    {"<a><b/><b/><b/></a>", Limits{MaxNodes: 3}, "MaxNodes"},
+    {"<a x='1' y='2' z='3'/>", Limits{MaxAttributesPerElement: 2}, "MaxAttributesPerElement"},
+    {"<a>" + strings.Repeat("x", 100) + "</a>", Limits{MaxTextLength: 50}, "MaxTextLength"},
+    {"<a>" + strings.Repeat("x", 30) + "&amp;" + strings.Repeat("x", 30) + "</a>", Limits{MaxTextLength: 50}, "MaxTextLength"},
+    {"<a><!--" + strings.Repeat("x", 100) + "--></a>", Limits{MaxTextLength: 50}, "MaxTextLength"},
+    {"<a>" + strings.Repeat("<b/>", 100) + "</a>", Limits{MaxInputBytes: 64}, "MaxInputBytes"},
+  }
+  for _, test := range tests {
+    for _, name := range limitErrors(t, test.src, test.limits) {
+      if (name != test.limit) {
+        t.Errorf("Parse of '%.20s' went beyond %s instead of %s", test.src, name, test.limit)
+      }
+    }
+  }
+}
+
+func TestParseLimitsDefaults(t *testing.T) {
+  if l := DefaultLimits(); (l.MaxNodes <= 0 || l.MaxInputBytes <= 0 || l.MaxTextLength <= 0 || l.MaxDepth <= 0) {
+    t.Errorf("DefaultLimits leaves out a limit: %+v", l)
+  }
+  src := strings.Repeat("<a>", 2000) + strings.Repeat("</a>", 2000)
+  for _, name := range limitErrors(t, src, DefaultLimits()) {
+    if (name != "MaxDepth") {
+      t.Errorf("Deep document went beyond %s instead of MaxDepth", name)
+    }
+  }
+  d, err := ParseString(strings.Repeat("<a>", 500) + strings.Repeat("</a>", 500))
+  if (err != nil) {
+    t.Fatalf("Parse of a moderately deep document failed: %v", err)
+  }
+  if (d.GetElementsByTagName("a").Length() != 500) {
+    t.Errorf("Moderately deep document lost elements")
+  }
+}
+
+func TestParseLimitsEntityExpansion(t *testing.T) {
+  // the billion laughs
+  src := `<!DOCTYPE lolz [
+ <!ENTITY lol "lol">
+ <!ENTITY lol1 "&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;&lol;">
+ <!ENTITY lol2 "&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;&lol1;">
+ <!ENTITY lol3 "&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;&lol2;">
+ <!ENTITY lol4 "&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;&lol3;">
+ <!ENTITY lol5 "&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;&lol4;">
+ <!ENTITY lol6 "&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;&lol5;">
+ <!ENTITY lol7 "&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;&lol6;">
+ <!ENTITY lol8 "&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;&lol7;">
+ <!ENTITY lol9 "&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;&lol8;">
+]>
+`
+  for _, body := range []string{"<lolz>&lol9;</lolz>", "<lolz a='&lol9;'/>"} {
+    opts := NewParseOptions()
+    opts.Native = true
+    opts.ExpandEntities = true
+    _, err := ParseWithOptions(strings.NewReader(src+body), opts)
+    var le *LimitError
+    if !errors.As(err, &le) || le.Limit != "MaxEntityExpansions" {
+      t.Errorf("Billion laughs in %s gave %v", body, err)
+    }
+  }
+}
+
+func TestParseFragment(t *testing.T) {
+  d, _ := ParseNativeString(`<!DOCTYPE a [<!ENTITY e "entity">]><a xmlns:p="urn:p"><b/></a>`)
+  ctx := d.DocumentElement().FirstChild().(Element)
+  f, err := ParseFragment(ctx, strings.NewReader(`one <p:x/> two <y>&e;</y><!--c-->`))
+  if err != nil {
+    t.Fatalf("ParseFragment failed: %v", err)
+  }
+  if f.ChildNodes().Length() != 5 {
+    t.Fatalf("Fragment had %d children instead of 5", f.ChildNodes().Length())
+  }
+  x := f.ChildNodes().Item(1)
+  if x.NamespaceURI() != "urn:p" || x.NodeName() != "p:x" {
+    t.Errorf("Fragment element was {%s}%s", x.NamespaceURI(), x.NodeName())
+  }
+  ref := f.ChildNodes().Item(3).FirstChild()
+  if ref.NodeType() != ENTITY_REFERENCE_NODE || ref.FirstChild().NodeValue() != "entity" {
+    t.Errorf("Fragment did not know the entities of the document")
+  }
+  if f.OwnerDocument() != d {
+    t.Errorf("Fragment was not owned by the document of its context")
+  }
+  if _, err := ParseFragment(nil, strings.NewReader(`<?xml version="1.0"?><a/>`)); err == nil {
+    t.Errorf("ParseFragment accepted an XML declaration")
+  }
+}
+
+func TestParseRecoversPrefixes(t *testing.T) {
+  // without the raw input the prefixes come from the declarations in scope
+  opts := NewParseOptions()
+  opts.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+    return input, nil
+  }
+  d, err := ParseWithOptions(strings.NewReader(`<?xml version="1.0" encoding="latin1"?><a xmlns:p="urn:p"><p:b p:c="1"/></a>`), opts)
+  if err != nil {
+    t.Fatalf("Parse failed: %v", err)
+  }
+  b := d.DocumentElement().FirstChild().(Element)
+  if b.NodeName() != "p:b" || b.Attributes().Item(0).NodeName() != "p:c" {
+    t.Errorf("Prefixes were not recovered: %s, %s", b.NodeName(), b.Attributes().Item(0).NodeName())
+  }
+}
+
+// a testFilter answers for elements by name, and for other nodes by
+// their value
+type testFilter struct {
+  start  map[string]int
+  accept map[string]int
+  show   uint32
+  seen   []string
+}
+
+func (f *testFilter) StartElement(el Element) int {
+  if (el.FirstChild() != nil || el.ParentNode() != nil) {
+    f.seen = append(f.seen, "attached "+el.NodeName())
+  }
+  return f.answer(f.start, el.NodeName())
+}
+
+func (f *testFilter) AcceptNode(n Node) int {
+  key := n.NodeName()
+  if (n.NodeType() != ELEMENT_NODE) {
+    key = n.NodeValue()
+  }
+  f.seen = append(f.seen, key)
+  return f.answer(f.accept, key)
+}
+
+func (f *testFilter) answer(m map[string]int, key string) int {
+  if a, ok := m[key]; ok {
+    return a
+  }
+  return FILTER_ACCEPT
+}
+
+func (f *testFilter) WhatToShow() uint32 {
+  return f.show
+}
+
+func TestParseFilter(t *testing.T) {
+  in := `<doc><history><old>x</old><old>y</old></history><p>a<!--drop-->b<wrap><i>c</i></wrap></p><blob>QUJD</blob><?pi keep?></doc>`
+  for _, tc := range []struct {
+    filter testFilter
+    want   string
+    seen   string
+  }{
+    {testFilter{show: SHOW_ALL}, in,
+      "x,old,y,old,history,a,drop,b,c,i,wrap,p,QUJD,blob,keep,doc"},
+    {testFilter{show: SHOW_ALL, start: map[string]int{"history": FILTER_REJECT, "wrap": FILTER_SKIP}, accept: map[string]int{"drop": FILTER_REJECT, "blob": FILTER_SKIP}},
+      `<doc><p>ab<i>c</i></p>QUJD<?pi keep?></doc>`,
+      "a,drop,b,c,i,p,QUJD,blob,keep,doc"},
+    // only comments are shown, so elements are not rejected
+    {testFilter{show: SHOW_COMMENT, start: map[string]int{"history": FILTER_REJECT}, accept: map[string]int{"drop": FILTER_REJECT}},
+      `<doc><history><old>x</old><old>y</old></history><p>ab<wrap><i>c</i></wrap></p><blob>QUJD</blob><?pi keep?></doc>`,
+      "drop"},
+    {testFilter{show: SHOW_ELEMENT, start: map[string]int{"blob": FILTER_INTERRUPT}},
+      `<doc><history><old>x</old><old>y</old></history><p>a<!--drop-->b<wrap><i>c</i></wrap></p></doc>`,
+      "old,old,history,i,wrap,p"},
+    {testFilter{show: SHOW_TEXT, accept: map[string]int{"b": FILTER_INTERRUPT}},
+      `<doc><history><old>x</old><old>y</old></history><p>a<!--drop-->b</p></doc>`,
+      "x,y,a,b"},
+    // the document element cannot be skipped
+    {testFilter{show: SHOW_ELEMENT, start: map[string]int{"doc": FILTER_SKIP}, accept: map[string]int{"doc": FILTER_SKIP}}, in,
+      "old,old,history,i,wrap,p,blob,doc"},
+  } {
+    opts := NewParseOptions()
+    opts.Native = true
+    opts.Filter = &tc.filter
+    d, err := ParseWithOptions(strings.NewReader(in), opts)
+    if err != nil {
+      t.Errorf("Parse failed: %v", err)
+      continue
+    }
+    if got := toXml(d.DocumentElement()); got != tc.want {
+      t.Errorf("filter %v gave\n%s\ninstead of\n%s", tc.filter.start, got, tc.want)
+    }
+    if seen := strings.Join(tc.filter.seen, ","); seen != tc.seen {
+      t.Errorf("filter %v saw %s instead of %s", tc.filter.start, seen, tc.seen)
+    }
+  }
+}
+
+func TestParseFilterRejectsBeforeBuilding(t *testing.T) {
+  opts := NewParseOptions()
+  opts.Limits.MaxNodes = 5
+  opts.Filter = &testFilter{show: SHOW_ELEMENT, start: map[string]int{"history": FILTER_REJECT}}
+  in := `<doc><history>` + strings.Repeat(`<old>x</old>`, 100) + `</history><p>a</p></doc>`
+  d, err := ParseWithOptions(strings.NewReader(in), opts)
+  if err != nil {
+    t.Fatalf("Parse failed: %v", err)
+  }
+  if got := toXml(d.DocumentElement()); got != `<doc><p>a</p></doc>` {
+    t.Errorf("got %s", got)
+  }
+  opts.Filter = &testFilter{show: SHOW_ELEMENT, start: map[string]int{"doc": FILTER_REJECT}}
+  d, err = ParseWithOptions(strings.NewReader(in), opts)
+  if err != nil || d.DocumentElement() != nil {
+    t.Errorf("rejecting the document element gave %v, %v", d, err)
+  }
+}
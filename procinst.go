@@ -0,0 +1,46 @@
+package dom
+
+/*
+ * ProcessingInstruction node implementation
+ */
+
+import (
+	"encoding/xml"
+)
+
+type _procinst struct {
+	*_node
+	target string
+	data   string
+}
+
+func (pi *_procinst) NodeName() string {
+	return pi.target
+}
+
+func (pi *_procinst) NodeValue() string {
+	return pi.data
+}
+
+func (pi *_procinst) OwnerDocument() Document {
+	return ownerDocument(pi)
+}
+
+func (pi *_procinst) Target() string {
+	return pi.target
+}
+
+func (pi *_procinst) GetData() string {
+	return pi.data
+}
+
+func (pi *_procinst) SetData(newData string) {
+	pi.data = newData
+}
+
+func newProcInst(token xml.ProcInst) *_procinst {
+	n := newNode(PROCESSING_INSTRUCTION_NODE)
+	pi := &_procinst{n, token.Target, string(token.Inst)}
+	n.self = Node(pi)
+	return pi
+}
@@ -0,0 +1,494 @@
+package dom
+
+/*
+ * An event interface to the parser, after SAX: a ContentHandler is told
+ * about the content of a document as it is read, without a tree being
+ * built. Parse is the ContentHandler that builds one.
+ */
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// A QName is the name of an element or attribute as a ContentHandler
+// sees it. Space is the namespace URI and Prefix the prefix used in the
+// input.
+type QName struct {
+	Space  string
+	Prefix string
+	Local  string
+}
+
+// Qualified returns the name as it was written, prefix:local.
+func (n QName) Qualified() string {
+	if n.Prefix == "" {
+		return n.Local
+	}
+	return n.Prefix + ":" + n.Local
+}
+
+// An Attribute of a start tag. Namespace declarations are attributes in
+// the xmlns namespace.
+type Attribute struct {
+	Name  QName
+	Value string
+}
+
+// A ContentHandler receives the content of a document from ParseSAX.
+// An error returned by any of its methods stops the parse and comes back
+// from ParseSAX wrapped in a *ParseError. Byte slices are only valid
+// during the call.
+type ContentHandler interface {
+	StartDocument() error
+	EndDocument() error
+	StartElement(name QName, attrs []Attribute) error
+	EndElement(name QName) error
+	Characters(data []byte) error
+	Comment(data []byte) error
+	ProcessingInstruction(target, data string) error
+}
+
+// A LexicalHandler is told about the parts of the input a ContentHandler
+// does not see. A ContentHandler passed to ParseSAX may implement it.
+type LexicalHandler interface {
+	XMLDecl(decl XMLDecl) error
+	Doctype(name, publicId, systemId, internalSubset string) error
+	// The Characters of a CDATA section come between StartCDATA and
+	// EndCDATA.
+	StartCDATA() error
+	EndCDATA() error
+	// The events for the replacement text of an entity come between
+	// StartEntity and EndEntity. SkippedEntity is called instead for a
+	// reference to an entity that was not declared.
+	StartEntity(name string) error
+	EndEntity(name string) error
+	SkippedEntity(name string) error
+}
+
+// A Locator tells where in the input the current event comes from.
+type Locator interface {
+	SourceRange() SourceRange
+	// AttrRanges returns where the attributes of the current start tag
+	// are, or nil if that is not known.
+	AttrRanges() []SourceRange
+}
+
+// A LocatorHandler is given a Locator before StartDocument. A
+// ContentHandler passed to ParseSAX may implement it.
+type LocatorHandler interface {
+	SetDocumentLocator(l Locator)
+}
+
+// ParseSAX reads r and tells h about its content. It checks what Parse
+// checks, but keeps no more of the document than the open elements, so
+// the input may be of any size: the default MaxInputBytes limit is not
+// applied.
+func ParseSAX(r io.Reader, h ContentHandler) error {
+	return ParseSAXWithOptions(r, h, nil)
+}
+
+// ParseSAXWithOptions is ParseSAX configured by opts. Only the options
+// about the input are used: Strict, AutoClose, Entity, CharsetReader,
+// Native and Limits. A nil opts means the defaults of ParseSAX. The
+// Limits of NewParseOptions bound the size of the input, as they do for
+// Parse; set opts.Limits.MaxInputBytes to 0 to lift that bound.
+func ParseSAXWithOptions(r io.Reader, h ContentHandler, opts *ParseOptions) error {
+	if opts == nil {
+		opts = newStreamOptions()
+	}
+	return newSAXReader(r, h, opts).run()
+}
+
+// newStreamOptions returns the options for reading input that is not
+// kept as a whole: the defaults, without a bound on the size of the
+// input.
+func newStreamOptions() *ParseOptions {
+	opts := NewParseOptions()
+	opts.Limits.MaxInputBytes = 0
+	return opts
+}
+
+// a saxReader turns the tokens of an xml.Decoder or a Tokenizer into
+// calls of a ContentHandler. It resolves namespaces, expands entities and
+// checks the Limits that are not about nodes.
+type saxReader struct {
+	h         ContentHandler
+	lex       LexicalHandler // h, if it is one
+	opts      *ParseOptions
+	src       tokenSource // where the tokens come from
+	t         *Tokenizer  // the source of entity replacement text, if any
+	rec       *recorder   // the input of src, if it is known
+	span      SourceRange // where the current token is in the input
+	ranges    []SourceRange
+	fragment  bool // whether content may appear outside of any element
+	rooted    bool // whether the root element was seen
+	open      []saxElement
+	top       map[string]int // the elements seen outside of any element, by name
+	ns        []nsBinding    // the namespace declarations in scope, innermost last
+	nsMarks   []int          // len(ns) when each open element started
+	x         expansions     // the entities expanded so far
+	expanding []string
+	encoding  string // from the XML declaration
+}
+
+// an element that has been started but not ended
+type saxElement struct {
+	name     QName
+	step     string         // the element in a ParseError.Path
+	children map[string]int // the child elements seen so far, by name
+}
+
+func newSAXReader(r io.Reader, h ContentHandler, opts *ParseOptions) *saxReader {
+	rec := newRecorder(r)
+	rec.max = opts.Limits.MaxInputBytes
+	var src xml.TokenReader
+	if opts.Native {
+		t := NewTokenizer(rec)
+		t.Entity = opts.Entity
+		t.CharsetReader = opts.CharsetReader
+		t.Limits = opts.Limits
+		src = t
+	} else {
+		p := xml.NewDecoder(rec)
+		p.Strict = opts.Strict
+		p.AutoClose = opts.AutoClose
+		p.Entity = opts.Entity
+		p.CharsetReader = opts.CharsetReader
+		src = p
+	}
+	s := newTokenSAXReader(src, h, opts)
+	s.rec = rec
+	return s
+}
+
+// newTokenSAXReader returns a saxReader of the tokens of tr, without the
+// input they were read from.
+func newTokenSAXReader(tr xml.TokenReader, h ContentHandler, opts *ParseOptions) *saxReader {
+	s := &saxReader{h: h, opts: opts, top: make(map[string]int)}
+	s.lex, _ = h.(LexicalHandler)
+	switch src := tr.(type) {
+	case *Tokenizer:
+		src.x = &s.x
+		s.t = src
+		s.src = src
+	case *xml.Decoder:
+		s.src = src
+	default:
+		s.src = &tokenReaderSource{tr: tr}
+	}
+	return s
+}
+
+func (s *saxReader) SourceRange() SourceRange {
+	return s.span
+}
+
+func (s *saxReader) AttrRanges() []SourceRange {
+	return s.ranges
+}
+
+func (s *saxReader) run() error {
+	if l, ok := s.h.(LocatorHandler); ok {
+		l.SetDocumentLocator(s)
+	}
+	if err := s.h.StartDocument(); err != nil {
+		return s.parseError(err, inputPosition(s.src), false)
+	}
+	for first := true; ; first = false {
+		start := inputPosition(s.src)
+		t, err := s.src.Token()
+		if err == io.EOF && !first {
+			break
+		}
+		if err != nil {
+			return s.parseError(err, inputPosition(s.src), false)
+		}
+		s.span = SourceRange{start, inputPosition(s.src)}
+		if err := s.token(t); err != nil {
+			return s.parseError(err, s.span.Start, true)
+		}
+		// keep some input for the snippet of a ParseError
+		if s.rec != nil {
+			s.rec.discard(s.span.End.Offset - snippetContext)
+		}
+	}
+	if err := s.h.EndDocument(); err != nil {
+		return s.parseError(err, inputPosition(s.src), false)
+	}
+	return nil
+}
+
+// outside reports whether the reader is outside of the root element.
+func (s *saxReader) outside() bool {
+	return len(s.open) == 0 && !s.fragment
+}
+
+func (s *saxReader) token(t xml.Token) error {
+	switch token := t.(type) {
+	case xml.StartElement:
+		return s.startElement(token)
+	case xml.EndElement:
+		// a lenient xml.Decoder may close more than was opened
+		if len(s.open) == 0 {
+			return nil
+		}
+		e := s.open[len(s.open)-1]
+		s.open = s.open[:len(s.open)-1]
+		s.ns = s.ns[:s.nsMarks[len(s.nsMarks)-1]]
+		s.nsMarks = s.nsMarks[:len(s.nsMarks)-1]
+		return s.h.EndElement(e.name)
+	case xml.CharData:
+		return s.characters(token)
+	case CharRef:
+		return s.characters([]byte(string(rune(token))))
+	case EntityRef:
+		return s.entityRef(string(token))
+	case CDATA:
+		if s.lex == nil {
+			return s.h.Characters(token)
+		}
+		if err := s.lex.StartCDATA(); err != nil {
+			return err
+		}
+		if err := s.h.Characters(token); err != nil {
+			return err
+		}
+		return s.lex.EndCDATA()
+	case xml.Comment:
+		return s.h.Comment(token)
+	case xml.ProcInst:
+		// the XML declaration is not a processing instruction
+		if token.Target == "xml" {
+			return s.xmlDecl(parseXMLDecl(token.Inst))
+		}
+		return s.h.ProcessingInstruction(token.Target, string(token.Inst))
+	case XMLDecl:
+		return s.xmlDecl(token)
+	case xml.Directive:
+		if dt := newDoctypeFromDirective(token); dt != nil && s.lex != nil && s.outside() {
+			return s.lex.Doctype(dt.name, dt.publicId, dt.systemId, dt.internalSubset)
+		}
+	}
+	return nil
+}
+
+func (s *saxReader) xmlDecl(decl XMLDecl) error {
+	s.encoding = decl.Encoding
+	if s.lex != nil {
+		return s.lex.XMLDecl(decl)
+	}
+	return nil
+}
+
+func (s *saxReader) startElement(token xml.StartElement) error {
+	if s.outside() && s.rooted {
+		return ErrMultipleRoots
+	}
+	l := &s.opts.Limits
+	if err := exceeds("MaxDepth", int64(l.MaxDepth), int64(len(s.open)+1)); err != nil {
+		return err
+	}
+	if err := exceeds("MaxAttributesPerElement", int64(l.MaxAttributesPerElement), int64(len(token.Attr))); err != nil {
+		return err
+	}
+	names, ranges := s.qualifiedNames(token)
+	s.ranges = ranges
+	s.nsMarks = append(s.nsMarks, len(s.ns))
+	for i, a := range token.Attr {
+		if prefix, ok := namespaceDecl(qualifiedName(names[i+1])); ok {
+			s.ns = append(s.ns, nsBinding{prefix, a.Value})
+		}
+	}
+	name := QName{s.lookupNS(names[0].Space), names[0].Space, names[0].Local}
+	attrs := make([]Attribute, len(token.Attr))
+	for i, a := range token.Attr {
+		n := names[i+1]
+		uri := ""
+		if _, ok := namespaceDecl(qualifiedName(n)); ok {
+			uri = xmlnsURL
+		} else if n.Space != "" {
+			uri = s.lookupNS(n.Space)
+		}
+		attrs[i] = Attribute{QName{uri, n.Space, n.Local}, a.Value}
+	}
+
+	// number the element among its siblings of the same name
+	siblings := s.top
+	if len(s.open) > 0 {
+		siblings = s.open[len(s.open)-1].children
+	}
+	qname := name.Qualified()
+	siblings[qname]++
+	step := qname
+	if k := siblings[qname]; k > 1 {
+		step += fmt.Sprintf("[%d]", k)
+	}
+	s.open = append(s.open, saxElement{name, step, make(map[string]int)})
+	s.rooted = true
+	return s.h.StartElement(name, attrs)
+}
+
+func (s *saxReader) characters(data []byte) error {
+	// only whitespace may appear outside of the root element
+	if s.outside() && len(bytes.TrimSpace(data)) > 0 {
+		return ErrTextOutsideRoot
+	}
+	return s.h.Characters(data)
+}
+
+// entityRef expands the predefined entities in place. The replacement
+// text of any other entity is read as if it were at the reference.
+func (s *saxReader) entityRef(name string) error {
+	if v, ok := predefinedEntities[name]; ok {
+		return s.characters([]byte(v))
+	}
+	if s.outside() {
+		return ErrReferenceOutsideRoot
+	}
+	value, ok := "", false
+	if s.t != nil {
+		value, ok = s.t.EntityValue(name)
+	}
+	if !ok {
+		if s.lex != nil {
+			return s.lex.SkippedEntity(name)
+		}
+		return nil
+	}
+	for _, open := range s.expanding {
+		if open == name {
+			return fmt.Errorf("%w &%s;", ErrRecursiveEntity, name)
+		}
+	}
+	if err := s.x.expand(&s.opts.Limits, len(s.expanding)+1, value); err != nil {
+		return err
+	}
+	if s.lex != nil {
+		if err := s.lex.StartEntity(name); err != nil {
+			return err
+		}
+	}
+
+	t := s.t
+	s.t = t.newFragmentTokenizer(value)
+	s.expanding = append(s.expanding, name)
+	defer func() {
+		s.t = t
+		s.expanding = s.expanding[:len(s.expanding)-1]
+	}()
+	for {
+		tok, err := s.t.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := s.token(tok); err != nil {
+			return err
+		}
+	}
+	if s.lex != nil {
+		return s.lex.EndEntity(name)
+	}
+	return nil
+}
+
+// qualifiedNames returns the name of the element of token followed by
+// the names of its attributes, with the prefixes used in the input, and
+// where the attributes are in the input if that is known. An xml.Decoder
+// has replaced the prefixes with namespace URIs; they are recovered from
+// the raw input or, failing that, from the declarations in scope.
+func (s *saxReader) qualifiedNames(token xml.StartElement) ([]xml.Name, []SourceRange) {
+	names := make([]xml.Name, 1+len(token.Attr))
+	names[0] = token.Name
+	for i, a := range token.Attr {
+		names[i+1] = a.Name
+	}
+	if len(s.expanding) > 0 {
+		// replacement text is tokenized by a Tokenizer
+		return names, nil
+	}
+	switch src := s.src.(type) {
+	case *Tokenizer:
+		return names, src.AttrRanges()
+	case *xml.Decoder:
+		if s.recordingValid() {
+			raw := s.rec.slice(s.span.Start.Offset, s.span.End.Offset)
+			elem, attrs, ranges := scanStartTag(raw, s.span.Start)
+			if elem != "" && len(attrs) == len(token.Attr) {
+				names[0] = splitName(elem)
+				for i, a := range attrs {
+					names[i+1] = splitName(a)
+				}
+				return names, ranges
+			}
+		}
+		names[0].Space = s.prefixFor(names[0].Space, false, token)
+		for i := range token.Attr {
+			n := &names[i+1]
+			if n.Space != "" && n.Space != "xmlns" {
+				n.Space = s.prefixFor(n.Space, true, token)
+			}
+		}
+	}
+	return names, nil
+}
+
+// prefixFor returns a prefix that the element of token, or one of its
+// attributes, may have used for the namespace uri.
+func (s *saxReader) prefixFor(uri string, attr bool, token xml.StartElement) string {
+	if uri == "" {
+		return ""
+	}
+	if uri == xmlURL {
+		return "xml"
+	}
+	var decls []nsBinding
+	for _, a := range token.Attr {
+		if a.Name.Space == "xmlns" {
+			decls = append(decls, nsBinding{a.Name.Local, a.Value})
+		} else if a.Name.Space == "" && a.Name.Local == "xmlns" && !attr {
+			decls = append(decls, nsBinding{"", a.Value})
+		}
+	}
+	decls = append(s.ns[:len(s.ns):len(s.ns)], decls...)
+	bound := make(map[string]bool)
+	for i := len(decls) - 1; i >= 0; i-- {
+		d := decls[i]
+		if d.uri == uri && !bound[d.prefix] && (d.prefix != "" || !attr) {
+			return d.prefix
+		}
+		bound[d.prefix] = true
+	}
+	// the decoder leaves prefixes that are not bound alone
+	return uri
+}
+
+// lookupNS returns the namespace bound to prefix, or "" if it is not
+// bound.
+func (s *saxReader) lookupNS(prefix string) string {
+	switch prefix {
+	case "xml":
+		return xmlURL
+	case "xmlns":
+		return xmlnsURL
+	}
+	for i := len(s.ns) - 1; i >= 0; i-- {
+		if s.ns[i].prefix == prefix {
+			return s.ns[i].uri
+		}
+	}
+	return ""
+}
+
+// recordingValid reports whether the input is recorded and matches the
+// offsets of the token source, which it no longer does once the source
+// has switched to a CharsetReader.
+func (s *saxReader) recordingValid() bool {
+	return s.rec != nil && (s.encoding == "" || strings.EqualFold(s.encoding, "utf-8"))
+}
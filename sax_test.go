@@ -0,0 +1,167 @@
+package dom
+
+import (
+  "errors"
+  "io"
+  "strings"
+  "testing"
+)
+
+// an eventRecorder writes down the events it is told about
+type eventRecorder struct {
+  events []string
+  stopAt string
+}
+
+func (r *eventRecorder) add(s string) error {
+  r.events = append(r.events, s)
+  if (s == r.stopAt) {
+    return errors.New("stop")
+  }
+  return nil
+}
+
+func (r *eventRecorder) StartDocument() error { return r.add("startdoc") }
+func (r *eventRecorder) EndDocument() error   { return r.add("enddoc") }
+
+func (r *eventRecorder) StartElement(name QName, attrs []Attribute) error {
+  s := "start:{" + name.Space + "}" + name.Qualified()
+  for _, a := range attrs {
+    s += " {" + a.Name.Space + "}" + a.Name.Qualified() + "=" + a.Value
+  }
+  return r.add(s)
+}
+
+func (r *eventRecorder) EndElement(name QName) error { return r.add("end:" + name.Qualified()) }
+func (r *eventRecorder) Characters(data []byte) error { return r.add("text:" + string(data)) }
+func (r *eventRecorder) Comment(data []byte) error    { return r.add("comment:" + string(data)) }
+
+func (r *eventRecorder) ProcessingInstruction(target, data string) error {
+  return r.add("pi:" + target + " " + data)
+}
+
+func TestParseSAX(t *testing.T) {
+  r := &eventRecorder{}
+  err := ParseSAX(strings.NewReader(`<?xml version="1.0"?><!--c--><p:a xmlns:p="urn:p" p:x="1"><b>t&amp;</b><?pi d?></p:a>`), r)
+  if err != nil {
+    t.Fatalf("ParseSAX failed: %v", err)
+  }
+  want := []string{"startdoc", "comment:c", "start:{urn:p}p:a {http://www.w3.org/2000/xmlns/}xmlns:p=urn:p {urn:p}p:x=1",
+    "start:{}b", "text:t&", "end:b", "pi:pi d", "end:p:a", "enddoc"}
+  if (strings.Join(r.events, "|") != strings.Join(want, "|")) {
+    t.Errorf("ParseSAX produced\n%v\ninstead of\n%v", r.events, want)
+  }
+}
+
+// a recordReader makes up a feed of n copies of a record, without
+// keeping it in memory
+type recordReader struct {
+  record string
+  n      int
+  buf    string
+}
+
+func newRecordReader(record string, n int) *recordReader {
+  return &recordReader{record: record, n: n, buf: "<feed>"}
+}
+
+func (r *recordReader) Read(p []byte) (int, error) {
+  if (r.buf == "") {
+    switch {
+    case r.n > 0:
+      r.buf, r.n = r.record, r.n-1
+    case r.n == 0:
+      r.buf, r.n = "</feed>", -1
+    default:
+      return 0, io.EOF
+    }
+  }
+  n := copy(p, r.buf)
+  r.buf = r.buf[n:]
+  return n, nil
+}
+
+// a textCounter counts the elements and text it is told about
+type textCounter struct{ elements, text int64 }
+
+func (c *textCounter) StartDocument() error                           { return nil }
+func (c *textCounter) EndDocument() error                             { return nil }
+func (c *textCounter) StartElement(name QName, attrs []Attribute) error { c.elements++; return nil }
+func (c *textCounter) EndElement(name QName) error                    { return nil }
+func (c *textCounter) Characters(data []byte) error                   { c.text += int64(len(data)); return nil }
+func (c *textCounter) Comment(data []byte) error                      { return nil }
+func (c *textCounter) ProcessingInstruction(target, data string) error { return nil }
+
+func TestParseSAXLargeInput(t *testing.T) {
+  if (testing.Short()) {
+    t.Skip("reads more than the default MaxInputBytes")
+  }
+  record := "<r>" + strings.Repeat("x", 1<<20) + "</r>"
+  n := int(DefaultLimits().MaxInputBytes>>20) + 8
+  c := &textCounter{}
+  if err := ParseSAX(newRecordReader(record, n), c); (err != nil || c.elements != int64(n+1) || c.text != int64(n)<<20) {
+    t.Errorf("ParseSAX of a large input gave %v after %d elements", err, c.elements)
+  }
+}
+
+func TestParseSAXInputLimit(t *testing.T) {
+  opts := NewParseOptions()
+  opts.Limits.MaxInputBytes = 1 << 20
+  var le *LimitError
+  err := ParseSAXWithOptions(newRecordReader("<r>record</r>", 1<<17), &textCounter{}, opts)
+  if (!errors.As(err, &le) || le.Limit != "MaxInputBytes") {
+    t.Errorf("ParseSAXWithOptions went past MaxInputBytes: %v", err)
+  }
+}
+
+func TestParseSAXHandlerError(t *testing.T) {
+  r := &eventRecorder{stopAt: "start:{}c"}
+  err := ParseSAX(strings.NewReader("<a>\n<b/><c/><d/></a>"), r)
+  var pe *ParseError
+  if (!errors.As(err, &pe) || pe.Err.Error() != "stop" || pe.Line != 2 || pe.Path != "/a/c") {
+    t.Errorf("Handler error came back as %v", err)
+  }
+  if (r.events[len(r.events)-1] != "start:{}c") {
+    t.Errorf("Parsing went on after the handler failed")
+  }
+}
+
+func TestParseSAXWellFormedness(t *testing.T) {
+  for _, in := range []string{"<a/><b/>", "<a/>x"} {
+    if err := ParseSAX(strings.NewReader(in), &eventRecorder{}); (err == nil) {
+      t.Errorf("ParseSAX accepted %q", in)
+    }
+  }
+}
+
+// a lexicalRecorder also records the events of a LexicalHandler
+type lexicalRecorder struct {
+  eventRecorder
+}
+
+func (r *lexicalRecorder) XMLDecl(decl XMLDecl) error { return r.add("decl:" + decl.Version) }
+
+func (r *lexicalRecorder) Doctype(name, publicId, systemId, internalSubset string) error {
+  return r.add("doctype:" + name)
+}
+
+func (r *lexicalRecorder) StartCDATA() error                { return r.add("startcdata") }
+func (r *lexicalRecorder) EndCDATA() error                  { return r.add("endcdata") }
+func (r *lexicalRecorder) StartEntity(name string) error   { return r.add("startentity:" + name) }
+func (r *lexicalRecorder) EndEntity(name string) error     { return r.add("endentity:" + name) }
+func (r *lexicalRecorder) SkippedEntity(name string) error { return r.add("skipped:" + name) }
+
+func TestParseSAXLexicalHandler(t *testing.T) {
+  r := &lexicalRecorder{}
+  opts := NewParseOptions()
+  opts.Native = true
+  in := `<?xml version="1.0"?><!DOCTYPE a [<!ENTITY e "<b>x</b>"><!ENTITY f SYSTEM "f.xml">]><a><![CDATA[<c>]]>&e;&f;</a>`
+  if err := ParseSAXWithOptions(strings.NewReader(in), r, opts); err != nil {
+    t.Fatalf("ParseSAXWithOptions failed: %v", err)
+  }
+  want := []string{"startdoc", "decl:1.0", "doctype:a", "start:{}a", "startcdata", "text:<c>", "endcdata",
+    "startentity:e", "start:{}b", "text:x", "end:b", "endentity:e", "skipped:f", "end:a", "enddoc"}
+  if (strings.Join(r.events, "|") != strings.Join(want, "|")) {
+    t.Errorf("ParseSAXWithOptions produced\n%v\ninstead of\n%v", r.events, want)
+  }
+}
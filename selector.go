@@ -0,0 +1,557 @@
+package dom
+
+/*
+ * CSS selectors, and the QuerySelector, QuerySelectorAll, Matches and
+ * Closest methods of the DOM Standard that use them.
+ * https://dom.spec.whatwg.org/#parentnode
+ * https://www.w3.org/TR/selectors-4/
+ *
+ * The document is static, so the user action pseudo-classes such as
+ * :hover never match, and the form states such as :checked come from the
+ * attributes of HTML elements.
+ */
+
+import "strings"
+
+// A Selector is a compiled selector list.
+type Selector struct {
+	src  string
+	list []*cssSelector
+}
+
+// CompileSelector compiles a comma-separated list of selectors. resolver
+// gives the namespace URIs of the prefixes in ns|name; it may be nil if
+// there are none. Names without a prefix match elements in any
+// namespace. In the XHTML namespace, element and attribute names match
+// without regard to ASCII case.
+func CompileSelector(selectors string, resolver XPathNSResolver) (*Selector, error) {
+	p := &cssParser{src: selectors, resolver: resolver}
+	list, err := p.list(false)
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	if p.i < len(p.src) {
+		return nil, p.errorf("unexpected %s", p.found())
+	}
+	return &Selector{selectors, list}, nil
+}
+
+// String returns the source of the selector.
+func (s *Selector) String() string {
+	return s.src
+}
+
+// Match says whether e matches the selector. :scope is the same as :root.
+func (s *Selector) Match(e Element) bool {
+	el, ok := e.(*_elem)
+	return ok && newCSSMatcher(nil).matchList(s.list, el)
+}
+
+// Query returns the first element below root in document order that
+// matches the selector, or nil. :scope is root.
+func (s *Selector) Query(root Node) Element {
+	var found Element
+	m := newCSSMatcher(root)
+	walkTreeDepthFirst(root, func(n Node) bool {
+		if el, ok := n.(*_elem); ok && m.matchList(s.list, el) {
+			found = el
+			return false
+		}
+		return true
+	})
+	return found
+}
+
+// QueryAll returns the elements below root that match the selector, in
+// document order. The list does not change with the document. :scope is
+// root.
+func (s *Selector) QueryAll(root Node) NodeList {
+	found := _staticNodeList{}
+	m := newCSSMatcher(root)
+	walkTreeDepthFirst(root, func(n Node) bool {
+		if el, ok := n.(*_elem); ok && m.matchList(s.list, el) {
+			found = append(found, el)
+		}
+		return true
+	})
+	return found
+}
+
+// The methods of the DOM resolve prefixes as n.LookupNamespaceURI does.
+
+func querySelector(n Node, selectors string) (Element, error) {
+	s, err := CompileSelector(selectors, n)
+	if err != nil {
+		return nil, err
+	}
+	return s.Query(n), nil
+}
+
+func querySelectorAll(n Node, selectors string) (NodeList, error) {
+	s, err := CompileSelector(selectors, n)
+	if err != nil {
+		return nil, err
+	}
+	return s.QueryAll(n), nil
+}
+
+// https://dom.spec.whatwg.org/#dom-parentnode-queryselector
+func (e *_elem) QuerySelector(selectors string) (Element, error) {
+	return querySelector(e, selectors)
+}
+
+// https://dom.spec.whatwg.org/#dom-parentnode-queryselectorall
+func (e *_elem) QuerySelectorAll(selectors string) (NodeList, error) {
+	return querySelectorAll(e, selectors)
+}
+
+// Matches says whether e matches selectors, with e as :scope.
+// https://dom.spec.whatwg.org/#dom-element-matches
+func (e *_elem) Matches(selectors string) (bool, error) {
+	s, err := CompileSelector(selectors, e)
+	if err != nil {
+		return false, err
+	}
+	return newCSSMatcher(e).matchList(s.list, e), nil
+}
+
+// Closest returns the nearest of e and its ancestors that matches
+// selectors, with e as :scope, or nil.
+// https://dom.spec.whatwg.org/#dom-element-closest
+func (e *_elem) Closest(selectors string) (Element, error) {
+	s, err := CompileSelector(selectors, e)
+	if err != nil {
+		return nil, err
+	}
+	m := newCSSMatcher(e)
+	for el := e; el != nil; el = parentElement(el) {
+		if m.matchList(s.list, el) {
+			return el, nil
+		}
+	}
+	return nil, nil
+}
+
+// https://dom.spec.whatwg.org/#dom-parentnode-queryselector
+func (d *_doc) QuerySelector(selectors string) (Element, error) {
+	return querySelector(d, selectors)
+}
+
+// https://dom.spec.whatwg.org/#dom-parentnode-queryselectorall
+func (d *_doc) QuerySelectorAll(selectors string) (NodeList, error) {
+	return querySelectorAll(d, selectors)
+}
+
+// https://dom.spec.whatwg.org/#dom-parentnode-queryselector
+func (f *_frag) QuerySelector(selectors string) (Element, error) {
+	return querySelector(f, selectors)
+}
+
+// https://dom.spec.whatwg.org/#dom-parentnode-queryselectorall
+func (f *_frag) QuerySelectorAll(selectors string) (NodeList, error) {
+	return querySelectorAll(f, selectors)
+}
+
+// a cssSelector is a complex selector: compound selectors joined by
+// combinators
+type cssSelector struct {
+	compounds []*cssCompound
+	combs     []byte // ' ', '>', '+' or '~' between each two compounds
+}
+
+type cssCompound struct {
+	// the first compound of a relative selector stands for the element
+	// of :has
+	anchor bool
+	simple []cssSimple
+}
+
+type cssSimple interface {
+	match(m *cssMatcher, e *_elem) bool
+}
+
+// a cssMatcher matches elements against selectors during one query
+type cssMatcher struct {
+	scope    Node
+	anchor   *_elem            // the element of the innermost :has
+	siblings map[Node][]*_elem // element children, by parent
+}
+
+func newCSSMatcher(scope Node) *cssMatcher {
+	return &cssMatcher{scope: scope, siblings: make(map[Node][]*_elem)}
+}
+
+func (m *cssMatcher) matchList(list []*cssSelector, e *_elem) bool {
+	for _, s := range list {
+		if m.match(s, len(s.compounds)-1, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// match says whether compound i of s matches e, with the part of s
+// before it matching the elements its combinator leads to.
+func (m *cssMatcher) match(s *cssSelector, i int, e *_elem) bool {
+	c := s.compounds[i]
+	if c.anchor {
+		return e == m.anchor
+	}
+	for _, simple := range c.simple {
+		if !simple.match(m, e) {
+			return false
+		}
+	}
+	if i == 0 {
+		return true
+	}
+	switch s.combs[i-1] {
+	case '>':
+		p := parentElement(e)
+		return p != nil && m.match(s, i-1, p)
+	case ' ':
+		for p := parentElement(e); p != nil; p = parentElement(p) {
+			if m.match(s, i-1, p) {
+				return true
+			}
+		}
+	case '+':
+		sibs, at := m.elementSiblings(e)
+		return at > 0 && m.match(s, i-1, sibs[at-1])
+	case '~':
+		sibs, at := m.elementSiblings(e)
+		for at--; at >= 0; at-- {
+			if m.match(s, i-1, sibs[at]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// elementSiblings returns the elements among the children of the parent
+// of e, and where e is among them.
+func (m *cssMatcher) elementSiblings(e *_elem) ([]*_elem, int) {
+	p := xpathParent(e)
+	if p == nil {
+		return []*_elem{e}, 0
+	}
+	sibs, ok := m.siblings[p]
+	if !ok {
+		for _, c := range xpathChildren(p) {
+			if c, ok := c.(*_elem); ok {
+				sibs = append(sibs, c)
+			}
+		}
+		m.siblings[p] = sibs
+	}
+	for i, s := range sibs {
+		if s == e {
+			return sibs, i
+		}
+	}
+	return []*_elem{e}, 0
+}
+
+// parentElement returns the parent of e if it is an element.
+func parentElement(e *_elem) *_elem {
+	p, _ := xpathParent(e).(*_elem)
+	return p
+}
+
+// a cssName is the name of a type or attribute selector; local is * for
+// any element name
+type cssName struct {
+	anyNS bool
+	uri   string
+	local string
+}
+
+type cssType struct {
+	name cssName
+}
+
+func (t *cssType) match(m *cssMatcher, e *_elem) bool {
+	if !t.name.anyNS && t.name.uri != e.n.Space {
+		return false
+	}
+	if e.n.Space == htmlURL {
+		return t.name.local == "*" || strings.EqualFold(t.name.local, e.n.Local)
+	}
+	return t.name.local == "*" || t.name.local == e.n.Local
+}
+
+type cssID string
+
+func (id cssID) match(m *cssMatcher, e *_elem) bool {
+	return e.HasAttribute("id") && e.GetAttribute("id") == string(id)
+}
+
+type cssClass string
+
+func (c cssClass) match(m *cssMatcher, e *_elem) bool {
+	for _, class := range strings.Fields(e.GetAttribute("class")) {
+		if class == string(c) {
+			return true
+		}
+	}
+	return false
+}
+
+type cssAttr struct {
+	name  cssName
+	op    string // "" for presence, or one of = ~= |= ^= $= *=
+	value string
+	fold  bool // compare the value without regard to ASCII case
+}
+
+func (a *cssAttr) match(m *cssMatcher, e *_elem) bool {
+	for _, attr := range e.attribs {
+		if !a.name.anyNS && a.name.uri != attr.n.Space {
+			continue
+		}
+		if attr.n.Local == a.name.local || e.n.Space == htmlURL && strings.EqualFold(attr.n.Local, a.name.local) {
+			if a.matchValue(attr.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (a *cssAttr) matchValue(v string) bool {
+	want := a.value
+	if a.fold {
+		v, want = strings.ToLower(v), strings.ToLower(want)
+	}
+	switch a.op {
+	case "":
+		return true
+	case "=":
+		return v == want
+	case "~=":
+		for _, word := range strings.Fields(v) {
+			if word == want {
+				return true
+			}
+		}
+		return false
+	case "|=":
+		return v == want || strings.HasPrefix(v, want+"-")
+	}
+	// the rest never match an empty value
+	if want == "" {
+		return false
+	}
+	switch a.op {
+	case "^=":
+		return strings.HasPrefix(v, want)
+	case "$=":
+		return strings.HasSuffix(v, want)
+	}
+	return strings.Contains(v, want)
+}
+
+// cssLogical is :is, :where or :not
+type cssLogical struct {
+	list []*cssSelector
+	not  bool
+}
+
+func (l *cssLogical) match(m *cssMatcher, e *_elem) bool {
+	return m.matchList(l.list, e) != l.not
+}
+
+type cssHas struct {
+	list []*cssSelector // relative
+}
+
+func (h *cssHas) match(m *cssMatcher, e *_elem) bool {
+	inner := *m
+	inner.anchor = e
+	found := false
+	check := func(n Node) bool {
+		if el, ok := n.(*_elem); ok && inner.matchList(h.list, el) {
+			found = true
+		}
+		return !found
+	}
+	walkTreeDepthFirst(e, check)
+	for _, s := range h.list {
+		if c := s.combs[0]; c == '+' || c == '~' {
+			// the elements that may match are after e
+			sibs, at := m.elementSiblings(e)
+			for _, sib := range sibs[at+1:] {
+				if check(sib) {
+					walkTreeDepthFirst(sib, check)
+				}
+				if found {
+					return true
+				}
+			}
+			break
+		}
+	}
+	return found
+}
+
+// cssNth is the :nth-* and :*-child and :*-of-type pseudo-classes:
+// whether e is at an+b among its siblings, for some n >= 0
+type cssNth struct {
+	a, b   int
+	last   bool           // counting from the last sibling
+	ofType bool           // counting siblings of the same type
+	of     []*cssSelector // counting siblings that match these
+}
+
+func (nth *cssNth) match(m *cssMatcher, e *_elem) bool {
+	if nth.of != nil && !m.matchList(nth.of, e) {
+		return false
+	}
+	sibs, at := m.elementSiblings(e)
+	pos := 0
+	count := func(s *_elem) {
+		if nth.ofType && (s.n.Space != e.n.Space || s.n.Local != e.n.Local) {
+			return
+		}
+		if nth.of != nil && !m.matchList(nth.of, s) {
+			return
+		}
+		pos++
+	}
+	if nth.last {
+		for i := len(sibs) - 1; i >= at; i-- {
+			count(sibs[i])
+		}
+	} else {
+		for i := 0; i <= at; i++ {
+			count(sibs[i])
+		}
+	}
+	if nth.a == 0 {
+		return pos == nth.b
+	}
+	n := pos - nth.b
+	return n%nth.a == 0 && n/nth.a >= 0
+}
+
+// https://www.w3.org/TR/selectors-4/#the-lang-pseudo
+type cssLang string
+
+func (lang cssLang) match(m *cssMatcher, e *_elem) bool {
+	for ; e != nil; e = parentElement(e) {
+		var l string
+		if attr := e.attrNS(xmlURL, "lang"); attr != nil {
+			l = attr.value
+		} else if attr := e.attrNS("", "lang"); attr != nil {
+			l = attr.value
+		} else {
+			continue
+		}
+		l = strings.ToLower(l)
+		return l == string(lang) || strings.HasPrefix(l, string(lang)+"-")
+	}
+	return false
+}
+
+// the pseudo-classes without arguments
+type cssPseudo func(m *cssMatcher, e *_elem) bool
+
+func (p cssPseudo) match(m *cssMatcher, e *_elem) bool {
+	return p(m, e)
+}
+
+func cssNever(m *cssMatcher, e *_elem) bool {
+	return false
+}
+
+// the HTML elements that can be disabled
+var htmlDisableable = map[string]bool{
+	"button": true, "input": true, "select": true, "textarea": true,
+	"optgroup": true, "option": true, "fieldset": true,
+}
+
+var cssPseudoClasses = map[string]cssSimple{
+	"root": cssPseudo(func(m *cssMatcher, e *_elem) bool {
+		p := e.ParentNode()
+		return p != nil && p.NodeType() == DOCUMENT_NODE
+	}),
+	"scope": cssPseudo(func(m *cssMatcher, e *_elem) bool {
+		if scope, ok := m.scope.(*_elem); ok {
+			return e == scope
+		}
+		p := e.ParentNode()
+		return p != nil && p.NodeType() == DOCUMENT_NODE
+	}),
+	"empty": cssPseudo(func(m *cssMatcher, e *_elem) bool {
+		for _, c := range contentOf(e) {
+			switch c.NodeType() {
+			case ELEMENT_NODE:
+				return false
+			case TEXT_NODE, CDATA_SECTION_NODE:
+				if c.NodeValue() != "" {
+					return false
+				}
+			}
+		}
+		return true
+	}),
+	"first-child":   &cssNth{b: 1},
+	"last-child":    &cssNth{b: 1, last: true},
+	"first-of-type": &cssNth{b: 1, ofType: true},
+	"last-of-type":  &cssNth{b: 1, last: true, ofType: true},
+	"only-child": cssPseudo(func(m *cssMatcher, e *_elem) bool {
+		sibs, _ := m.elementSiblings(e)
+		return len(sibs) == 1
+	}),
+	"only-of-type": cssPseudo(func(m *cssMatcher, e *_elem) bool {
+		sibs, _ := m.elementSiblings(e)
+		for _, s := range sibs {
+			if s != e && s.n.Space == e.n.Space && s.n.Local == e.n.Local {
+				return false
+			}
+		}
+		return true
+	}),
+
+	"link":     cssPseudo(htmlLink),
+	"any-link": cssPseudo(htmlLink),
+	"checked": cssPseudo(func(m *cssMatcher, e *_elem) bool {
+		if e.n.Space != htmlURL {
+			return false
+		}
+		switch e.n.Local {
+		case "input":
+			t := strings.ToLower(e.GetAttribute("type"))
+			return (t == "checkbox" || t == "radio") && e.HasAttribute("checked")
+		case "option":
+			return e.HasAttribute("selected")
+		}
+		return false
+	}),
+	"disabled": cssPseudo(func(m *cssMatcher, e *_elem) bool {
+		return e.n.Space == htmlURL && htmlDisableable[e.n.Local] && e.HasAttribute("disabled")
+	}),
+	"enabled": cssPseudo(func(m *cssMatcher, e *_elem) bool {
+		return e.n.Space == htmlURL && htmlDisableable[e.n.Local] && !e.HasAttribute("disabled")
+	}),
+
+	// states of a user agent, which a static document is never in
+	"visited":       cssPseudo(cssNever),
+	"hover":         cssPseudo(cssNever),
+	"active":        cssPseudo(cssNever),
+	"focus":         cssPseudo(cssNever),
+	"focus-within":  cssPseudo(cssNever),
+	"focus-visible": cssPseudo(cssNever),
+	"target":        cssPseudo(cssNever),
+}
+
+// htmlLink is :link and :any-link: the hyperlinks of HTML.
+func htmlLink(m *cssMatcher, e *_elem) bool {
+	switch e.n.Local {
+	case "a", "area", "link":
+		return e.n.Space == htmlURL && e.HasAttribute("href")
+	}
+	return false
+}
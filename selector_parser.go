@@ -0,0 +1,525 @@
+package dom
+
+/*
+ * The parser of CSS selectors: Selectors Level 3, with the :is, :where,
+ * :not and :has of Level 4 taking selector lists, and :nth-child(An+B of
+ * S).
+ * http://www.w3.org/TR/selectors-3/#w3cselgrammar
+ * https://www.w3.org/TR/selectors-4/
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A SelectorError is returned for a selector that does not compile.
+type SelectorError struct {
+	Selector string
+	Offset   int // in bytes
+	Msg      string
+}
+
+func (e *SelectorError) Error() string {
+	return fmt.Sprintf("selector: %s at offset %d of %q", e.Msg, e.Offset, e.Selector)
+}
+
+// a cssParser parses a selector by recursive descent, straight from the
+// text
+type cssParser struct {
+	src      string
+	i        int
+	resolver XPathNSResolver
+}
+
+func (p *cssParser) errorf(format string, args ...any) error {
+	return &SelectorError{p.src, p.i, fmt.Sprintf(format, args...)}
+}
+
+// peek returns the byte at offset ahead of the current one, or 0 at the
+// end.
+func (p *cssParser) peek(ahead int) byte {
+	if p.i+ahead < len(p.src) {
+		return p.src[p.i+ahead]
+	}
+	return 0
+}
+
+func (p *cssParser) accept(c byte) bool {
+	if p.peek(0) == c && p.i < len(p.src) {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *cssParser) expect(c byte) error {
+	if !p.accept(c) {
+		return p.errorf("expected %q, found %s", c, p.found())
+	}
+	return nil
+}
+
+// found describes what is at the current offset, for errors.
+func (p *cssParser) found() string {
+	if p.i == len(p.src) {
+		return "end of selector"
+	}
+	r, _ := utf8.DecodeRuneInString(p.src[p.i:])
+	return strconv.QuoteRune(r)
+}
+
+// space skips whitespace, and says whether there was any.
+func (p *cssParser) space() bool {
+	start := p.i
+	for p.i < len(p.src) && strings.IndexByte(" \t\r\n\f", p.src[p.i]) >= 0 {
+		p.i++
+	}
+	return p.i > start
+}
+
+func isCSSNameStart(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || c == '_' || c >= 0x80
+}
+
+func isCSSNameByte(c byte) bool {
+	return isCSSNameStart(c) || '0' <= c && c <= '9' || c == '-'
+}
+
+// startsEscape says whether a valid escape begins ahead bytes on.
+func (p *cssParser) startsEscape(ahead int) bool {
+	return p.peek(ahead) == '\\' && p.i+ahead+1 < len(p.src) && p.peek(ahead+1) != '\n'
+}
+
+// startsIdent says whether an identifier begins at the current offset.
+// https://www.w3.org/TR/css-syntax-3/#would-start-an-identifier
+func (p *cssParser) startsIdent() bool {
+	ahead := 0
+	if p.peek(0) == '-' {
+		if p.peek(1) == '-' {
+			return true
+		}
+		ahead = 1
+	}
+	return isCSSNameStart(p.peek(ahead)) || p.startsEscape(ahead)
+}
+
+// ident reads an identifier, or returns "" if there is none.
+func (p *cssParser) ident() string {
+	if !p.startsIdent() {
+		return ""
+	}
+	return p.name()
+}
+
+// name reads name characters and escapes.
+func (p *cssParser) name() string {
+	var b strings.Builder
+	for p.i < len(p.src) {
+		switch c := p.src[p.i]; {
+		case isCSSNameByte(c):
+			b.WriteByte(c)
+			p.i++
+		case p.startsEscape(0):
+			p.escape(&b)
+		default:
+			return b.String()
+		}
+	}
+	return b.String()
+}
+
+// escape reads the escape at the current offset into b.
+// https://www.w3.org/TR/css-syntax-3/#consume-escaped-code-point
+func (p *cssParser) escape(b *strings.Builder) {
+	p.i++
+	start := p.i
+	for p.i < len(p.src) && p.i-start < 6 && strings.IndexByte("0123456789abcdefABCDEF", p.src[p.i]) >= 0 {
+		p.i++
+	}
+	if p.i == start {
+		r, size := utf8.DecodeRuneInString(p.src[p.i:])
+		b.WriteRune(r)
+		p.i += size
+		return
+	}
+	r, _ := strconv.ParseUint(p.src[start:p.i], 16, 32)
+	if r == 0 || r > utf8.MaxRune || 0xD800 <= r && r <= 0xDFFF {
+		r = utf8.RuneError
+	}
+	b.WriteRune(rune(r))
+	// one whitespace character ends the escape
+	if strings.HasPrefix(p.src[p.i:], "\r\n") {
+		p.i += 2
+	} else if p.i < len(p.src) && strings.IndexByte(" \t\r\n\f", p.src[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+// str reads a quoted string.
+func (p *cssParser) str() (string, error) {
+	quote := p.src[p.i]
+	start := p.i
+	p.i++
+	var b strings.Builder
+	for p.i < len(p.src) {
+		switch c := p.src[p.i]; {
+		case c == quote:
+			p.i++
+			return b.String(), nil
+		case c == '\n':
+			return "", p.errorf("newline in string")
+		case c == '\\' && p.peek(1) == '\n':
+			p.i += 2
+		case c == '\\' && p.i+1 < len(p.src):
+			p.escape(&b)
+		default:
+			b.WriteByte(c)
+			p.i++
+		}
+	}
+	p.i = start
+	return "", p.errorf("unterminated string")
+}
+
+// list parses a selector list. Each selector of a relative list starts
+// from the element of :has.
+func (p *cssParser) list(relative bool) ([]*cssSelector, error) {
+	var list []*cssSelector
+	for {
+		p.space()
+		s, err := p.complex(relative)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, s)
+		p.space()
+		if !p.accept(',') {
+			return list, nil
+		}
+	}
+}
+
+// complex parses compound selectors and the combinators between them.
+func (p *cssParser) complex(relative bool) (*cssSelector, error) {
+	s := &cssSelector{}
+	if relative {
+		comb := byte(' ')
+		if c := p.peek(0); c == '>' || c == '+' || c == '~' {
+			comb = c
+			p.i++
+			p.space()
+		}
+		s.compounds = append(s.compounds, &cssCompound{anchor: true})
+		s.combs = append(s.combs, comb)
+	}
+	for {
+		c, err := p.compound()
+		if err != nil {
+			return nil, err
+		}
+		s.compounds = append(s.compounds, c)
+
+		end := p.i
+		spaced := p.space()
+		switch c := p.peek(0); {
+		case c == '>' || c == '+' || c == '~':
+			p.i++
+			p.space()
+			s.combs = append(s.combs, c)
+		case spaced && p.startsCompound():
+			s.combs = append(s.combs, ' ')
+		default:
+			p.i = end
+			return s, nil
+		}
+	}
+}
+
+func (p *cssParser) startsCompound() bool {
+	switch p.peek(0) {
+	case '*', '|', '#', '.', '[', ':':
+		return true
+	}
+	return p.startsIdent()
+}
+
+// compound parses a type selector and the simple selectors after it.
+func (p *cssParser) compound() (*cssCompound, error) {
+	c := &cssCompound{}
+	start := p.i
+	name, ok, err := p.qualifiedName(false)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		c.simple = append(c.simple, &cssType{name})
+	}
+	for {
+		var s cssSimple
+		var err error
+		switch p.peek(0) {
+		case '#':
+			p.i++
+			if s = cssID(p.name()); s == cssID("") {
+				return nil, p.errorf("expected an id after #")
+			}
+		case '.':
+			p.i++
+			if s = cssClass(p.ident()); s == cssClass("") {
+				return nil, p.errorf("expected a class name after .")
+			}
+		case '[':
+			s, err = p.attribute()
+		case ':':
+			s, err = p.pseudo()
+		default:
+			if p.i == start {
+				return nil, p.errorf("expected a selector, found %s", p.found())
+			}
+			return c, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		c.simple = append(c.simple, s)
+	}
+}
+
+// qualifiedName parses the name of a type selector, or of an attribute,
+// with its namespace prefix, if there is one. Unprefixed names are in any
+// namespace for types, and in none for attributes.
+func (p *cssParser) qualifiedName(attr bool) (cssName, bool, error) {
+	name := cssName{anyNS: !attr}
+	start := p.i
+	var prefix string
+	switch {
+	case p.peek(0) == '*':
+		p.i++
+		prefix = "*"
+	case p.startsIdent():
+		prefix = p.ident()
+	case p.peek(0) == '|' && p.peek(1) != '=':
+	default:
+		return name, false, nil
+	}
+	// in an attribute, |= is an operator
+	if p.peek(0) != '|' || p.peek(1) == '=' {
+		if prefix == "*" && attr {
+			p.i = start
+			return name, false, p.errorf("expected an attribute name")
+		}
+		name.local = prefix
+		return name, true, nil
+	}
+	p.i++
+	switch prefix {
+	case "*":
+		name.anyNS = true
+	case "":
+		name.anyNS = false
+	default:
+		name.anyNS = false
+		if p.resolver != nil {
+			name.uri = p.resolver.LookupNamespaceURI(prefix)
+		}
+		if name.uri == "" {
+			p.i = start
+			return name, false, p.errorf("namespace prefix %s is not bound", prefix)
+		}
+	}
+	if !attr && p.accept('*') {
+		name.local = "*"
+	} else if name.local = p.ident(); name.local == "" {
+		return name, false, p.errorf("expected a name after |")
+	}
+	return name, true, nil
+}
+
+// attribute parses an attribute selector.
+func (p *cssParser) attribute() (cssSimple, error) {
+	p.i++
+	p.space()
+	name, ok, err := p.qualifiedName(true)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, p.errorf("expected an attribute name, found %s", p.found())
+	}
+	a := &cssAttr{name: name}
+	p.space()
+	if p.accept(']') {
+		return a, nil
+	}
+	switch c := p.peek(0); {
+	case c == '=':
+		a.op = "="
+	case strings.IndexByte("~|^$*", c) >= 0 && p.peek(1) == '=':
+		a.op = p.src[p.i : p.i+2]
+	default:
+		return nil, p.errorf("expected an attribute operator or ], found %s", p.found())
+	}
+	p.i += len(a.op)
+	p.space()
+	switch c := p.peek(0); {
+	case c == '"' || c == '\'':
+		if a.value, err = p.str(); err != nil {
+			return nil, err
+		}
+	case p.startsIdent():
+		a.value = p.ident()
+	default:
+		return nil, p.errorf("expected an attribute value, found %s", p.found())
+	}
+	p.space()
+	if p.startsIdent() {
+		start := p.i
+		switch flag := p.ident(); strings.ToLower(flag) {
+		case "i":
+			a.fold = true
+		case "s":
+		default:
+			p.i = start
+			return nil, p.errorf("unknown attribute flag %s", flag)
+		}
+		p.space()
+	}
+	return a, p.expect(']')
+}
+
+// pseudo parses a pseudo-class.
+func (p *cssParser) pseudo() (cssSimple, error) {
+	start := p.i
+	p.i++
+	if p.peek(0) == ':' {
+		p.i = start
+		return nil, p.errorf("pseudo-elements are not supported")
+	}
+	name := strings.ToLower(p.ident())
+	if name == "" {
+		return nil, p.errorf("expected a pseudo-class after :")
+	}
+	if !p.accept('(') {
+		switch name {
+		case "before", "after", "first-line", "first-letter":
+			p.i = start
+			return nil, p.errorf("pseudo-elements are not supported")
+		}
+		s, ok := cssPseudoClasses[name]
+		if !ok {
+			p.i = start
+			return nil, p.errorf("unknown pseudo-class :%s", name)
+		}
+		return s, nil
+	}
+
+	var s cssSimple
+	var err error
+	p.space()
+	switch name {
+	case "is", "matches", "any", "where":
+		var list []*cssSelector
+		list, err = p.list(false)
+		s = &cssLogical{list: list}
+	case "not":
+		var list []*cssSelector
+		list, err = p.list(false)
+		s = &cssLogical{list: list, not: true}
+	case "has":
+		var list []*cssSelector
+		list, err = p.list(true)
+		s = &cssHas{list}
+	case "nth-child", "nth-last-child", "nth-of-type", "nth-last-of-type":
+		nth := &cssNth{last: strings.Contains(name, "last"), ofType: strings.HasSuffix(name, "of-type")}
+		if nth.a, nth.b, err = p.nth(); err != nil {
+			return nil, err
+		}
+		p.space()
+		if !nth.ofType && p.startsIdent() {
+			save := p.i
+			if strings.ToLower(p.ident()) != "of" {
+				p.i = save
+				return nil, p.errorf("expected of or ), found %s", p.found())
+			}
+			nth.of, err = p.list(false)
+		}
+		s = nth
+	case "lang":
+		var lang string
+		if c := p.peek(0); c == '"' || c == '\'' {
+			lang, err = p.str()
+		} else if lang = p.ident(); lang == "" {
+			err = p.errorf("expected a language, found %s", p.found())
+		}
+		s = cssLang(strings.ToLower(lang))
+	default:
+		p.i = start
+		return nil, p.errorf("unknown pseudo-class :%s()", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.space()
+	return s, p.expect(')')
+}
+
+// nth parses the An+B of the :nth-* pseudo-classes.
+// https://www.w3.org/TR/css-syntax-3/#anb-microsyntax
+func (p *cssParser) nth() (a, b int, err error) {
+	start := p.i
+	if p.startsIdent() {
+		switch strings.ToLower(p.ident()) {
+		case "odd":
+			return 2, 1, nil
+		case "even":
+			return 2, 0, nil
+		}
+		p.i = start
+	}
+	sign := 1
+	if c := p.peek(0); c == '+' || c == '-' {
+		if c == '-' {
+			sign = -1
+		}
+		p.i++
+	}
+	digits := func() (int, bool) {
+		from := p.i
+		for p.i < len(p.src) && '0' <= p.src[p.i] && p.src[p.i] <= '9' {
+			p.i++
+		}
+		n, err := strconv.Atoi(p.src[from:p.i])
+		return n, err == nil
+	}
+	n, ok := digits()
+	if c := p.peek(0); c != 'n' && c != 'N' {
+		if !ok {
+			p.i = start
+			return 0, 0, p.errorf("expected An+B, found %s", p.found())
+		}
+		return 0, sign * n, nil
+	}
+	p.i++
+	a = sign
+	if ok {
+		a = sign * n
+	}
+	save := p.i
+	p.space()
+	if c := p.peek(0); c == '+' || c == '-' {
+		p.i++
+		p.space()
+		if b, ok = digits(); !ok {
+			return 0, 0, p.errorf("expected a number after %c", c)
+		}
+		if c == '-' {
+			b = -b
+		}
+		return a, b, nil
+	}
+	p.i = save
+	return a, 0, nil
+}
@@ -0,0 +1,251 @@
+package dom
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+const selectorTestHTML = `<!DOCTYPE html>
+<html id="root" lang="en-US"><body id="b">
+<ul id="list" class="menu main">
+  <li id="l1" class="item first">One</li>
+  <li id="l2" class="item"><a id="a2" href="/two" title="Second Item">Two</a></li>
+  <li id="l3" class="item" data-x="a-b c"></li>
+  <li id="l4" class="item last"><!-- empty --></li>
+</ul>
+<p id="p1" lang="fr">Un <em id="e1">mot</em></p>
+<form id="f"><input id="c1" type="checkbox" checked><input id="t1" disabled><select id="s1"><option id="o1" selected>x</option></select></form>
+</body></html>`
+
+func selectorTestDocument(t *testing.T) Document {
+  d, err := ParseHTML(strings.NewReader(selectorTestHTML))
+  if err != nil {
+    t.Fatalf("ParseHTML failed: %v", err)
+  }
+  return d
+}
+
+// selectorIds returns the ids of the elements that selectors select below
+// n, or the error.
+func selectorIds(n Node, selectors string) string {
+  var list NodeList
+  var err error
+  switch n := n.(type) {
+  case Document:
+    list, err = n.QuerySelectorAll(selectors)
+  case Element:
+    list, err = n.QuerySelectorAll(selectors)
+  }
+  if err != nil {
+    return err.Error()
+  }
+  var ids []string
+  for i := uint(0); i < list.Length(); i++ {
+    ids = append(ids, list.Item(i).(Element).GetAttribute("id"))
+  }
+  return strings.Join(ids, " ")
+}
+
+func TestQuerySelectorAll(t *testing.T) {
+  d := selectorTestDocument(t)
+  for _, tc := range []struct{ selectors, want string }{
+    {"#list > li", "l1 l2 l3 l4"},
+    {"LI.item.first", "l1"},
+    {".menu.main li:nth-child(2n)", "l2 l4"},
+    {"li:nth-child(odd)", "l1 l3"},
+    {"li:nth-child(EVEN)", "l2 l4"},
+    {"li:nth-child(-n+2)", "l1 l2"},
+    {"li:nth-child(n+3)", "l3 l4"},
+    {"li:nth-child(0n+3)", "l3"},
+    {"li:nth-child( 2n - 1 )", "l1 l3"},
+    {"li:nth-last-child(1)", "l4"},
+    {"li:nth-last-child(-n+3):nth-child(-n+3)", "l2 l3"},
+    {"li:nth-child(2 of .item:not(.first))", "l3"},
+    {"li:nth-child(1 of [data-x])", "l3"},
+    {"input:first-of-type, input:last-of-type", "c1 t1"},
+    {"form > :nth-of-type(1)", "c1 s1"},
+    {"form > :nth-last-of-type(2)", "c1"},
+    {"p :only-child", "e1"},
+    {"em:only-of-type", "e1"},
+    {"li:first-child + li", "l2"},
+    {"#l1 ~ li", "l2 l3 l4"},
+    {"#l1 ~ li ~ li", "l3 l4"},
+    {"ul a", "a2"},
+    {"ul  >  li  >  a", "a2"},
+    {"ul>li>a,em", "a2 e1"},
+    {"[href]", "a2"},
+    {"a[href^='/t']", "a2"},
+    {"a[title='Second Item']", "a2"},
+    {"li:empty", "l3 l4"},
+    {"li:has(> a)", "l2"},
+    {"li:has(+ li:empty)", "l2 l3"},
+    {"li:has(~ .last)", "l1 l2 l3"},
+    {"body :has(em, a)", "list l2 p1"},
+    {"li:not(.first, :has(a))", "l3 l4"},
+    {":is(ul, p) > :is(li, em):not(:last-child)", "l1 l2 l3"},
+    {":where(#list) li:last-child", "l4"},
+    {"[data-x~=c]", "l3"},
+    {"[data-x|=a]", "l3"},
+    {"[data-x$=' c']", "l3"},
+    {"[data-x*='-']", "l3"},
+    {"[data-x^='']", ""},
+    {"[class='ITEM' i]", "l2 l3"},
+    {"[class='ITEM' s]", ""},
+    {"[CLASS=item]", "l2 l3"},
+    {":root", "root"},
+    {":lang(fr)", "p1 e1"},
+    {"li:lang(en)", "l1 l2 l3 l4"},
+    {"li:lang(en-GB)", ""},
+    {":checked", "c1 o1"},
+    {":disabled", "t1"},
+    {"input:enabled", "c1"},
+    {":link", "a2"},
+    {":hover, :focus, :visited", ""},
+    {"#\\6c 1, #l\\32", "l1 l2"},
+    {"*|li:first-child", "l1"},
+    {"|li", ""},
+  } {
+    if got := selectorIds(d, tc.selectors); got != tc.want {
+      t.Errorf("%s gave %q, want %q", tc.selectors, got, tc.want)
+    }
+  }
+}
+
+func TestQuerySelectorScope(t *testing.T) {
+  d := selectorTestDocument(t)
+  list := d.GetElementById("list")
+  for _, tc := range []struct{ selectors, want string }{
+    {":scope > li:first-child", "l1"},
+    {"body li:last-child", "l4"},
+    {"ul", ""},
+    {":scope", ""},
+  } {
+    if got := selectorIds(list, tc.selectors); got != tc.want {
+      t.Errorf("%s gave %q, want %q", tc.selectors, got, tc.want)
+    }
+  }
+
+  if e, err := d.QuerySelector("li:nth-child(n+2)"); (err != nil || e.GetAttribute("id") != "l2") {
+    t.Errorf("QuerySelector gave %v, %v", e, err)
+  }
+  if e, err := d.QuerySelector("table"); (err != nil || e != nil) {
+    t.Errorf("QuerySelector of nothing gave %v, %v", e, err)
+  }
+
+  em := d.GetElementById("e1")
+  for _, tc := range []struct{ selectors, want string }{
+    {"p", "p1"},
+    {":scope", "e1"},
+    {"[lang] > *", "e1"},
+    {"body > [lang]", "p1"},
+    {"ul", ""},
+  } {
+    got := ""
+    if e, err := em.Closest(tc.selectors); err != nil {
+      got = err.Error()
+    } else if e != nil {
+      got = e.GetAttribute("id")
+    }
+    if (got != tc.want) {
+      t.Errorf("Closest(%s) gave %q, want %q", tc.selectors, got, tc.want)
+    }
+  }
+
+  a := d.GetElementById("a2")
+  if ok, err := a.Matches("li > a:scope"); (!ok || err != nil) {
+    t.Errorf("Matches gave %v, %v", ok, err)
+  }
+  if ok, _ := a.Matches("li > a:not([href])"); (ok) {
+    t.Errorf("Matches gave true for a :not")
+  }
+
+  // the list does not change with the document
+  all, _ := d.QuerySelectorAll("li")
+  list.RemoveChild(d.GetElementById("l1"))
+  if (all.Length() != 4 || all.Item(0).(Element).GetAttribute("id") != "l1") {
+    t.Errorf("The NodeList changed with the document")
+  }
+  f := d.CreateDocumentFragment()
+  f.AppendChild(list)
+  if e, _ := f.QuerySelector(":first-child"); (e == nil || e.GetAttribute("id") != "list") {
+    t.Errorf("QuerySelector on a fragment gave %v", e)
+  }
+}
+
+func TestSelectorNamespaces(t *testing.T) {
+  d, _ := ParseString(`<r xmlns="urn:d" xmlns:p="urn:p"><p:a id="1"/><a id="2" p:k="v"/><b xmlns="" id="3" k="w"/><A id="4"/></r>`)
+  for _, tc := range []struct{ selectors, want string }{
+    {"p|a", "1"},
+    {"a", "1 2"},
+    {"A", "4"},
+    {"|b", "3"},
+    {"p|*", "1"},
+    {"*|*[id]", "1 2 3 4"},
+    {"[p|k]", "2"},
+    {"[*|k]", "2 3"},
+    {"[k]", "3"},
+    {"[|k=w]", "3"},
+    {"r > p|a + a", "2"},
+  } {
+    if got := selectorIds(d, tc.selectors); got != tc.want {
+      t.Errorf("%s gave %q, want %q", tc.selectors, got, tc.want)
+    }
+  }
+
+  s, err := CompileSelector("q|*, [q|k]", testResolver{"q": "urn:p"})
+  if err != nil {
+    t.Fatalf("CompileSelector failed: %v", err)
+  }
+  if (s.String() != "q|*, [q|k]") {
+    t.Errorf("String gave %q", s.String())
+  }
+  var ids []string
+  for list, i := s.QueryAll(d), uint(0); i < list.Length(); i++ {
+    ids = append(ids, list.Item(i).(Element).GetAttribute("id"))
+  }
+  if (strings.Join(ids, " ") != "1 2") {
+    t.Errorf("QueryAll gave %v", ids)
+  }
+  if (!s.Match(d.DocumentElement().FirstChild().(Element)) || s.Match(d.DocumentElement())) {
+    t.Errorf("Match gave the wrong answer")
+  }
+  if _, err := CompileSelector("q|a", nil); (err == nil) {
+    t.Errorf("A prefix compiled without a resolver")
+  }
+}
+
+func TestSelectorErrors(t *testing.T) {
+  d := selectorTestDocument(t)
+  for _, tc := range []struct{ selectors string; offset int }{
+    {"", 0},
+    {"li >", 4},
+    {"li,", 3},
+    {"[a", 2},
+    {"[a=]", 3},
+    {"[a=b x]", 5},
+    {":nope", 0},
+    {"::before", 0},
+    {"li:before", 2},
+    {":nth-child(x)", 11},
+    {":nth-child(1n+)", 14},
+    {":nth-child(2 of)", 15},
+    {":not(a", 6},
+    {"p|li", 0},
+    {"#", 1},
+    {"a!", 1},
+    {"'x'", 0},
+    {"[a='b]", 3},
+  } {
+    _, err := d.QuerySelectorAll(tc.selectors)
+    var se *SelectorError
+    if (!errors.As(err, &se)) {
+      t.Errorf("%q gave %v", tc.selectors, err)
+    } else if (se.Offset != tc.offset) {
+      t.Errorf("%q failed at %d, not %d: %v", tc.selectors, se.Offset, tc.offset, err)
+    }
+  }
+  if _, err := d.GetElementById("e1").Matches("p >"); (err == nil) {
+    t.Errorf("Matches accepted a bad selector")
+  }
+}
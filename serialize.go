@@ -0,0 +1,733 @@
+package dom
+
+/*
+ * The XML serializer: writes nodes of every type to an io.Writer,
+ * escaping text and attribute values and declaring the namespaces the
+ * nodes need.
+ */
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// Serialize writes n and everything below it to w as XML. A Document is
+// written with its XML declaration, doctype, and every node around the
+// document element. Text and attribute values are escaped, "]]>" in a
+// CDATA section is split across two sections, and namespace declarations
+// are added wherever the namespaces of the nodes are not declared within
+// the output, so that it can be parsed on its own. The output is UTF-8;
+// SerializeWithOptions can write other encodings.
+//
+// The DOM lets comments hold "--" and processing instructions "?>",
+// which would end them early in the output; Serialize returns an error
+// for such nodes rather than write them.
+func Serialize(w io.Writer, n Node) error {
+	_, err := serialize(w, n, nil)
+	return err
+}
+
+// An EmptyElementStyle is a way of writing elements without children.
+type EmptyElementStyle int
+
+const (
+	ExpandEmpty          EmptyElementStyle = iota // <br></br>
+	SelfCloseEmpty                                // <br/>
+	SpacedSelfCloseEmpty                          // <br />
+)
+
+// SerializeOptions control the layout and the bytes of the output of
+// SerializeWithOptions. Use NewSerializeOptions to get the defaults, which
+// write nodes as Serialize does.
+type SerializeOptions struct {
+	// Indent, unless it is empty, puts each child of an element that
+	// holds elements on a line of its own, indented by Indent once per
+	// level, and drops the whitespace between them.
+	Indent string
+
+	// MaxLineWidth, if it is above 0, wraps the attributes of indented
+	// start tags longer than that, one attribute per line.
+	MaxLineWidth int
+
+	// ReformatMixed indents elements that hold both text and elements
+	// too, trimming the whitespace around their text. Otherwise such
+	// elements are written as they are, since the whitespace in mixed
+	// content usually means something.
+	ReformatMixed bool
+
+	// PreserveSpace names elements whose content is written as it is,
+	// like pre or script, just as for elements with xml:space="preserve".
+	PreserveSpace []string
+
+	// EmptyElements is how elements without children are written.
+	EmptyElements EmptyElementStyle
+
+	// SingleQuotes puts attribute values, and those of the XML
+	// declaration, in single quotes instead of double ones.
+	SingleQuotes bool
+
+	// Newline, unless it is empty, is written for every line break in
+	// the output, like "\r\n". Parsers read any of them back as "\n".
+	Newline string
+
+	// Encoding is the encoding of the output: UTF-8, US-ASCII,
+	// ISO-8859-1 or windows-1252. Unless it is empty, the XML
+	// declaration of a document names it, and a document gets one if it
+	// has none and the encoding is not UTF-8. Characters the encoding
+	// lacks are written as character references in text and attribute
+	// values, and outside CDATA sections; anywhere else, in names,
+	// comments or processing instructions, they are an error.
+	Encoding string
+
+	// EscapeNonASCII writes every character beyond ASCII as a
+	// character reference where it can, whatever the encoding.
+	EscapeNonASCII bool
+
+	// Filter, if it is not nil, decides which nodes are written.
+	Filter SerializeFilter
+}
+
+// A SerializeFilter decides which nodes are written, as an
+// LSSerializerFilter of DOM Level 3 Load and Save does, without changing
+// the tree.
+type SerializeFilter interface {
+	// AcceptNode returns FILTER_ACCEPT to write n, FILTER_REJECT to
+	// leave out n and everything in it, or FILTER_SKIP to leave out n but
+	// write its children in its place. Attributes are left out by either.
+	AcceptNode(n Node) int
+
+	// WhatToShow tells which types of node are handed to AcceptNode, as
+	// a combination of the SHOW_ constants. Others are written.
+	WhatToShow() uint32
+}
+
+// An AttributeRewriter is a SerializeFilter that also chooses the values
+// attributes are written with. Namespace declarations keep theirs.
+type AttributeRewriter interface {
+	RewriteAttribute(a Attr) string
+}
+
+// NewSerializeOptions returns the default SerializeOptions.
+func NewSerializeOptions() *SerializeOptions {
+	return &SerializeOptions{}
+}
+
+// SerializeWithOptions writes n to w as Serialize does, laid out as opts
+// say. A nil opts stands for the defaults.
+func SerializeWithOptions(w io.Writer, n Node, opts *SerializeOptions) error {
+	_, err := serialize(w, n, opts)
+	return err
+}
+
+// WriteTo writes the node to w as Serialize does. It returns the number
+// of bytes written.
+func (n *_node) WriteTo(w io.Writer) (int64, error) {
+	return serialize(w, n.self, nil)
+}
+
+func serialize(w io.Writer, n Node, opts *SerializeOptions) (int64, error) {
+	cw := &countingWriter{w: w}
+	xw := newXMLWriter(cw, opts)
+	if xw.err != nil {
+		return 0, xw.err
+	}
+	for _, n := range xw.expand(n) {
+		xw.node(n)
+	}
+	err := xw.Flush()
+	if xw.err != nil {
+		err = xw.err
+	}
+	return cw.n, err
+}
+
+// a countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// toXml serializes n and everything below it.
+func toXml(n Node) string {
+	var b strings.Builder
+	Serialize(&b, n)
+	return b.String()
+}
+
+// ToXml serializes the document element of doc, see Serialize.
+func ToXml(doc Document) string {
+	root := doc.DocumentElement()
+	if root == nil {
+		return ""
+	}
+	return toXml(root)
+}
+
+// an xmlWriter writes nodes as XML, keeping track of the namespace
+// declarations it has written. Errors writing stick in the bufio.Writer
+// and come back from Flush; characters that cannot be encoded stop the
+// writer, with the error in err.
+type xmlWriter struct {
+	*bufio.Writer
+	nsScope
+	opts   *SerializeOptions
+	depth  int  // the number of open elements
+	indent bool // whether whitespace may be added where the writer is
+	decl   bool // whether an XML declaration has been written
+
+	quote       string
+	attrEscaper *strings.Replacer
+	enc         *charset // the encoding of the output, nil for UTF-8
+	encoding    string   // the name of the encoding, if one was asked for
+	err         error
+}
+
+func newXMLWriter(w io.Writer, opts *SerializeOptions) *xmlWriter {
+	if opts == nil {
+		opts = NewSerializeOptions()
+	}
+	xw := &xmlWriter{Writer: bufio.NewWriter(w), opts: opts, indent: opts.Indent != "",
+		quote: "\"", attrEscaper: attrEscaper}
+	if opts.SingleQuotes {
+		xw.quote, xw.attrEscaper = "'", aposAttrEscaper
+	}
+	if opts.Encoding != "" {
+		xw.encoding, xw.enc = lookupCharset(opts.Encoding)
+		if xw.encoding == "" {
+			xw.err = fmt.Errorf("unsupported encoding %q", opts.Encoding)
+		}
+	}
+	return xw
+}
+
+// WriteString writes s, which is markup or escaped text, with the line
+// breaks and in the encoding of the output.
+func (w *xmlWriter) WriteString(s string) (int, error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.opts.Newline != "" {
+		s = strings.Replace(s, "\n", w.opts.Newline, -1)
+	}
+	if w.enc == nil {
+		return w.Writer.WriteString(s)
+	}
+	for _, r := range s {
+		b, ok := w.enc.encode(r)
+		if !ok {
+			w.err = fmt.Errorf("character %U cannot be written in %s", r, w.encoding)
+			return 0, w.err
+		}
+		w.WriteByte(b)
+	}
+	return len(s), nil
+}
+
+// fail stops the writer with err, unless it has stopped already.
+func (w *xmlWriter) fail(err error) {
+	if w.err == nil {
+		w.err = err
+	}
+}
+
+// encodable reports whether r may be written as it is in text.
+func (w *xmlWriter) encodable(r rune) bool {
+	if r < utf8.RuneSelf {
+		return true
+	}
+	if w.opts.EscapeNonASCII {
+		return false
+	}
+	if w.enc == nil {
+		return true
+	}
+	_, ok := w.enc.encode(r)
+	return ok
+}
+
+// charRefs replaces the characters of s that cannot be written as they
+// are with character references.
+func (w *xmlWriter) charRefs(s string) string {
+	i := strings.IndexFunc(s, func(r rune) bool { return !w.encodable(r) })
+	if i < 0 {
+		return s
+	}
+	var b strings.Builder
+	b.WriteString(s[:i])
+	for _, r := range s[i:] {
+		if w.encodable(r) {
+			b.WriteRune(r)
+		} else {
+			fmt.Fprintf(&b, "&#x%X;", r)
+		}
+	}
+	return b.String()
+}
+
+// text writes s as character data.
+func (w *xmlWriter) text(s string) {
+	w.WriteString(w.charRefs(escapeText(s)))
+}
+
+// attr returns name="value", escaped and quoted.
+func (w *xmlWriter) attr(name, value string) string {
+	return name + "=" + w.quote + w.charRefs(w.attrEscaper.Replace(value)) + w.quote
+}
+
+// cdata writes s as CDATA sections, split where they cannot hold what
+// is in s.
+func (w *xmlWriter) cdata(s string) {
+	w.WriteString("<![CDATA[")
+	for _, r := range strings.Replace(s, "]]>", "]]]]><![CDATA[>", -1) {
+		if w.encodable(r) {
+			w.WriteString(string(r))
+		} else {
+			w.WriteString(fmt.Sprintf("]]>&#x%X;<![CDATA[", r))
+		}
+	}
+	w.WriteString("]]>")
+}
+
+// newline starts a line indented for the current depth.
+func (w *xmlWriter) newline() {
+	w.WriteString("\n" + strings.Repeat(w.opts.Indent, w.depth))
+}
+
+// an nsScope keeps track of the namespace declarations in effect where
+// nodes are written out
+type nsScope struct {
+	scope []nsBinding // the declarations in effect, innermost last
+	gen   int         // the number of prefixes made up so far
+}
+
+type nsBinding struct {
+	prefix, uri string
+}
+
+// lookup returns the namespace bound to prefix by the output so far.
+func (s *nsScope) lookup(prefix string) (string, bool) {
+	for i := len(s.scope) - 1; i >= 0; i-- {
+		if s.scope[i].prefix == prefix {
+			return s.scope[i].uri, true
+		}
+	}
+	return "", false
+}
+
+// prefixFor returns a prefix bound to uri that may be used for an
+// attribute, declaring one if there is none.
+func (s *nsScope) prefixFor(uri string, decls *[]nsBinding) string {
+	for i := len(s.scope) - 1; i >= 0; i-- {
+		b := s.scope[i]
+		if b.uri == uri && b.prefix != "" {
+			if bound, _ := s.lookup(b.prefix); bound == uri {
+				return b.prefix
+			}
+		}
+	}
+	for {
+		s.gen++
+		prefix := fmt.Sprintf("ns%d", s.gen)
+		if _, ok := s.lookup(prefix); !ok {
+			s.declare(prefix, uri, decls)
+			return prefix
+		}
+	}
+}
+
+// declare binds prefix to uri and notes that the declaration has to be
+// written.
+func (s *nsScope) declare(prefix, uri string, decls *[]nsBinding) {
+	s.scope = append(s.scope, nsBinding{prefix, uri})
+	*decls = append(*decls, nsBinding{prefix, uri})
+}
+
+// startTag takes the declarations among the attributes of e that are
+// written into scope. It returns the declarations that have to be added
+// to the start tag of e, and the qualified names the attributes are
+// written with.
+func (s *nsScope) startTag(e *_elem, attribs []*_attr) (decls []nsBinding, names []string) {
+	// the declarations of the element itself come first
+	for _, a := range attribs {
+		if prefix, ok := namespaceDecl(a.NodeName()); ok {
+			s.scope = append(s.scope, nsBinding{prefix, a.value})
+		}
+	}
+	if uri, _ := s.lookup(e.pfx); uri != e.n.Space {
+		s.declare(e.pfx, e.n.Space, &decls)
+	}
+	names = make([]string, len(attribs))
+	for i, a := range attribs {
+		names[i] = a.NodeName()
+		if _, ok := namespaceDecl(names[i]); ok || a.n.Space == "" || a.n.Space == xmlnsURL {
+			continue
+		}
+		prefix := a.pfx
+		if a.n.Space == xmlURL {
+			prefix = "xml"
+		} else if uri, _ := s.lookup(prefix); prefix == "" || uri != a.n.Space {
+			if prefix == "" || uri != "" {
+				prefix = s.prefixFor(a.n.Space, &decls)
+			} else {
+				s.declare(prefix, a.n.Space, &decls)
+			}
+		}
+		names[i] = prefix + ":" + a.n.Local
+	}
+	return decls, names
+}
+
+func (w *xmlWriter) node(n Node) {
+	switch n.NodeType() {
+	case DOCUMENT_NODE:
+		w.document(n.(*_doc))
+	case ELEMENT_NODE:
+		w.element(n.(*_elem))
+	case ATTRIBUTE_NODE:
+		w.WriteString(w.attr(n.NodeName(), w.attrValue(n.(*_attr))))
+	case TEXT_NODE:
+		w.text(n.NodeValue())
+	case CDATA_SECTION_NODE:
+		w.cdata(n.NodeValue())
+	case ENTITY_REFERENCE_NODE:
+		w.WriteString("&" + n.NodeName() + ";")
+	case COMMENT_NODE:
+		data := n.NodeValue()
+		if strings.Contains(data, "--") || strings.HasSuffix(data, "-") {
+			w.fail(fmt.Errorf("comment %q cannot be written in XML", data))
+			return
+		}
+		w.WriteString("<!--" + data + "-->")
+	case PROCESSING_INSTRUCTION_NODE:
+		pi := n.(ProcessingInstruction)
+		data := pi.GetData()
+		if strings.Contains(data, "?>") {
+			w.fail(fmt.Errorf("processing instruction data %q cannot be written in XML", data))
+			return
+		}
+		w.WriteString("<?" + pi.Target())
+		if data != "" {
+			w.WriteString(" " + data)
+		}
+		w.WriteString("?>")
+	case DOCUMENT_TYPE_NODE:
+		w.WriteString("<!" + string(doctypeDirective(n.(DocumentType))) + ">")
+	case DOCUMENT_FRAGMENT_NODE:
+		w.topLevel(n)
+	}
+}
+
+// expand returns the nodes written for n: n, unless the filter leaves it
+// out or puts its children in its place.
+func (w *xmlWriter) expand(n Node) []Node {
+	if w.opts.Filter == nil || !shows(w.opts.Filter.WhatToShow(), n) {
+		return []Node{n}
+	}
+	switch w.opts.Filter.AcceptNode(n) {
+	case FILTER_REJECT:
+		return nil
+	case FILTER_SKIP:
+		if n.NodeType() == ATTRIBUTE_NODE {
+			return nil
+		}
+		return w.children(n)
+	}
+	return []Node{n}
+}
+
+// children returns the nodes written for the children of n.
+func (w *xmlWriter) children(n Node) []Node {
+	var nodes []Node
+	for c := n.FirstChild(); c != nil; c = c.NextSibling() {
+		if w.opts.Filter == nil {
+			nodes = append(nodes, c)
+		} else {
+			nodes = append(nodes, w.expand(c)...)
+		}
+	}
+	return nodes
+}
+
+// attrValue returns the value a is written with.
+func (w *xmlWriter) attrValue(a *_attr) string {
+	if r, ok := w.opts.Filter.(AttributeRewriter); ok {
+		if _, decl := namespaceDecl(a.NodeName()); !decl {
+			return r.RewriteAttribute(a)
+		}
+	}
+	return a.value
+}
+
+// topLevel writes the children of a document or fragment n. When
+// indenting, each goes on a line of its own.
+func (w *xmlWriter) topLevel(n Node) {
+	wrote := n.NodeType() == DOCUMENT_NODE && w.decl
+	for _, c := range w.children(n) {
+		if !w.indent {
+			w.node(c)
+			continue
+		}
+		if isWhitespace(c) {
+			continue
+		}
+		if wrote {
+			w.newline()
+		}
+		w.node(c)
+		wrote = true
+	}
+	if w.indent && n.NodeType() == DOCUMENT_NODE {
+		w.WriteString("\n")
+	}
+}
+
+// isWhitespace reports whether n is a text node of nothing but whitespace.
+func isWhitespace(n Node) bool {
+	return n.NodeType() == TEXT_NODE && strings.TrimSpace(n.NodeValue()) == ""
+}
+
+// document writes the XML declaration of d and then its children, among
+// which is the whitespace the declaration was followed by, if any.
+// Documents parsed as HTML have no declaration, unless it is needed for
+// the encoding.
+func (w *xmlWriter) document(d *_doc) {
+	version, encoding := d.xmlVersion, w.encoding
+	if encoding == "" && strings.EqualFold(d.xmlEncoding, "utf-8") {
+		// the output is UTF-8, whatever the input was
+		encoding = d.xmlEncoding
+	}
+	if version == "" && w.enc != nil {
+		version = "1.0"
+	}
+	if version != "" {
+		w.WriteString("<?xml " + w.attr("version", version))
+		if encoding != "" {
+			w.WriteString(" " + w.attr("encoding", encoding))
+		}
+		if d.xmlStandalone {
+			w.WriteString(" " + w.attr("standalone", "yes"))
+		}
+		w.WriteString("?>")
+		w.decl = true
+	}
+	w.topLevel(d)
+}
+
+func (w *xmlWriter) element(e *_elem) {
+	mark, indent := len(w.scope), w.indent
+	defer func() { w.scope, w.indent = w.scope[:mark], indent }()
+
+	attribs := e.attribs
+	if w.opts.Filter != nil {
+		attribs = nil
+		for _, a := range e.attribs {
+			if len(w.expand(a)) > 0 {
+				attribs = append(attribs, a)
+			}
+		}
+	}
+	decls, names := w.startTag(e, attribs)
+	attrs := make([]string, 0, len(decls)+len(attribs))
+	for _, d := range decls {
+		if d.prefix == "" {
+			attrs = append(attrs, w.attr("xmlns", d.uri))
+		} else {
+			attrs = append(attrs, w.attr("xmlns:"+d.prefix, d.uri))
+		}
+	}
+	for i, a := range attribs {
+		attrs = append(attrs, w.attr(names[i], w.attrValue(a)))
+	}
+	children := w.children(e)
+	empty := len(children) == 0 && w.opts.EmptyElements != ExpandEmpty
+	w.startElement(e.NodeName(), attrs, empty)
+	if empty {
+		return
+	}
+
+	w.indent = w.indents(e)
+	w.depth++
+	if w.indent && w.layout(children) {
+		for _, c := range children {
+			if isWhitespace(c) {
+				continue
+			}
+			w.newline()
+			if c.NodeType() == TEXT_NODE {
+				w.text(strings.TrimSpace(c.NodeValue()))
+			} else {
+				w.node(c)
+			}
+		}
+		w.depth--
+		w.newline()
+	} else {
+		for _, c := range children {
+			w.node(c)
+		}
+		w.depth--
+	}
+	w.WriteString("</" + e.NodeName() + ">")
+}
+
+// startElement writes a start tag, or an empty-element tag if empty,
+// wrapping the attributes if it is too long.
+func (w *xmlWriter) startElement(name string, attrs []string, empty bool) {
+	w.WriteString("<" + name)
+	wrap := false
+	if w.indent && w.opts.MaxLineWidth > 0 && len(attrs) > 1 {
+		width := w.depth*len(w.opts.Indent) + len(name) + 2
+		for _, a := range attrs {
+			width += 1 + len(a)
+		}
+		wrap = width > w.opts.MaxLineWidth
+	}
+	for i, a := range attrs {
+		if wrap && i > 0 {
+			// line the attributes up under the first one
+			w.newline()
+			w.WriteString(strings.Repeat(" ", len(name)+2) + a)
+		} else {
+			w.WriteString(" " + a)
+		}
+	}
+	switch {
+	case !empty:
+		w.WriteString(">")
+	case w.opts.EmptyElements == SpacedSelfCloseEmpty:
+		w.WriteString(" />")
+	default:
+		w.WriteString("/>")
+	}
+}
+
+// indents reports whether whitespace may be added inside e.
+func (w *xmlWriter) indents(e *_elem) bool {
+	if w.opts.Indent == "" {
+		return false
+	}
+	for _, name := range w.opts.PreserveSpace {
+		if e.NodeName() == name {
+			return false
+		}
+	}
+	switch e.GetAttributeNS(xmlURL, "space") {
+	case "preserve":
+		return false
+	case "default":
+		return true
+	}
+	return w.indent
+}
+
+// layout reports whether the children of an element are put on lines of
+// their own, which is so if there are any but text among them. Mixed
+// content is only laid out with ReformatMixed, and is otherwise written
+// as it is all the way down.
+func (w *xmlWriter) layout(children []Node) bool {
+	markup, text := false, false
+	for _, c := range children {
+		switch c.NodeType() {
+		case ELEMENT_NODE, COMMENT_NODE, PROCESSING_INSTRUCTION_NODE:
+			markup = true
+		case TEXT_NODE:
+			text = text || !isWhitespace(c)
+		default:
+			text = true
+		}
+	}
+	if markup && text && !w.opts.ReformatMixed {
+		w.indent = false
+		return false
+	}
+	return markup
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "\"", "&quot;",
+		"\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+	aposAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", "'", "&apos;",
+		"\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+// escapeText escapes s for use as character data.
+func escapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// escapeAttr escapes s for use as an attribute value in double quotes.
+func escapeAttr(s string) string {
+	return attrEscaper.Replace(s)
+}
+
+// a charset is an encoding the serializer can write. Characters below
+// max are written as the byte of the same value, except that UTF-8 is
+// written as it is.
+type charset struct {
+	max  rune
+	high map[rune]byte // other characters it has
+}
+
+var (
+	utf8Charset    = &charset{max: utf8.MaxRune + 1}
+	asciiCharset   = &charset{max: 0x80}
+	latin1Charset  = &charset{max: 0x100}
+	cp1252Charset  = &charset{max: 0x80, high: cp1252High()}
+	charsetAliases = map[string]string{
+		"utf-8": "UTF-8", "utf8": "UTF-8",
+		"us-ascii": "US-ASCII", "ascii": "US-ASCII",
+		"iso-8859-1": "ISO-8859-1", "iso_8859-1": "ISO-8859-1", "latin1": "ISO-8859-1",
+		"windows-1252": "windows-1252", "cp1252": "windows-1252",
+	}
+	charsets = map[string]*charset{
+		"UTF-8":        utf8Charset,
+		"US-ASCII":     asciiCharset,
+		"ISO-8859-1":   latin1Charset,
+		"windows-1252": cp1252Charset,
+	}
+)
+
+// cp1252High maps the characters of windows-1252 beyond ASCII to their
+// bytes.
+func cp1252High() map[rune]byte {
+	high := make(map[rune]byte)
+	for b, r := range htmlC1Replacements {
+		high[r] = byte(b)
+	}
+	for r := rune(0xA0); r < 0x100; r++ {
+		high[r] = byte(r)
+	}
+	return high
+}
+
+// lookupCharset returns the name that goes in the XML declaration for
+// the encoding called name, and the encoding, which is nil for UTF-8. The
+// name is empty if the encoding is not supported.
+func lookupCharset(name string) (string, *charset) {
+	canonical := charsetAliases[strings.ToLower(name)]
+	cs := charsets[canonical]
+	if cs == utf8Charset {
+		cs = nil
+	}
+	return canonical, cs
+}
+
+// encode returns the byte r is written as in a single-byte encoding. It
+// is only called for UTF-8 to see whether r can be written.
+func (c *charset) encode(r rune) (byte, bool) {
+	if r < c.max {
+		return byte(r), true
+	}
+	b, ok := c.high[r]
+	return b, ok
+}
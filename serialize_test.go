@@ -0,0 +1,270 @@
+package dom
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+func TestSerialize(t *testing.T) {
+  in := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+    `<!DOCTYPE a SYSTEM 'say "a".dtd'><!--c--><?pi data?><a x="&quot;1&quot; &amp; &lt;2&gt;">t &lt; u &amp; v &gt;<![CDATA[x]]]]><![CDATA[>y]]></a><!--end-->`
+  d, err := ParseNativeString(in)
+  if err != nil {
+    t.Fatalf("Parse failed: %v", err)
+  }
+  var b strings.Builder
+  if err := Serialize(&b, d); err != nil {
+    t.Fatalf("Serialize failed: %v", err)
+  }
+  want := `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+    `<!DOCTYPE a SYSTEM 'say "a".dtd'><!--c--><?pi data?><a x="&quot;1&quot; &amp; &lt;2>">t &lt; u &amp; v &gt;<![CDATA[x]]]]><![CDATA[>y]]></a><!--end-->`
+  if (b.String() != want) {
+    t.Errorf("Serialize wrote\n%s\ninstead of\n%s", b.String(), want)
+  }
+}
+
+func TestSerializeEscapes(t *testing.T) {
+  d, _ := ParseString(`<a/>`)
+  r := d.DocumentElement()
+  r.SetAttribute("q", `"><b x="`)
+  r.AppendChild(d.CreateTextNode("</a><b>&"))
+  r.AppendChild(d.CreateCDATASection("]]><c/>"))
+  s := ToXml(d)
+  d2, err := ParseNativeString(s)
+  if err != nil {
+    t.Fatalf("%s does not parse: %v", s, err)
+  }
+  r2 := d2.DocumentElement()
+  if (r2.GetAttribute("q") != `"><b x="` || r2.ChildNodes().Length() != 3 || r2.FirstChild().NodeValue() != "</a><b>&") {
+    t.Errorf("Serialize wrote %s", s)
+  }
+  if (r2.LastChild().NodeValue() != "><c/>" || r2.FirstChild().NextSibling().NodeValue() != "]]") {
+    t.Errorf("CDATA came back as %s", s)
+  }
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write(p []byte) (int, error) { return 0, errors.New("full") }
+
+func TestWriteTo(t *testing.T) {
+  d, _ := ParseString(`<a><b>x</b></a>`)
+  var b strings.Builder
+  n, err := d.DocumentElement().FirstChild().WriteTo(&b)
+  if (err != nil || b.String() != "<b>x</b>" || n != int64(b.Len())) {
+    t.Errorf("WriteTo wrote %q, %d, %v", b.String(), n, err)
+  }
+  if _, err := d.WriteTo(failingWriter{}); (err == nil || err.Error() != "full") {
+    t.Errorf("WriteTo gave %v for a failing writer", err)
+  }
+}
+
+func TestSerializeBadComments(t *testing.T) {
+  for _, tc := range []struct{ comment, pi string }{
+    {"--><evil/><!--", ""},
+    {"a -", ""},
+    {"", "x ?><evil/><?y"},
+  } {
+    d, _ := ParseString(`<a>x</a>`)
+    r := d.DocumentElement()
+    if (tc.pi == "") {
+      r.AppendChild(d.CreateComment(tc.comment))
+    } else {
+      r.AppendChild(d.CreateProcessingInstruction("p", tc.pi))
+    }
+    var b strings.Builder
+    if err := Serialize(&b, d); (err == nil) {
+      t.Errorf("Serialize wrote %q", b.String())
+    }
+    if _, err := r.WriteTo(&b); (err == nil) {
+      t.Errorf("WriteTo wrote %q", b.String())
+    }
+  }
+  d, _ := ParseString(`<a>x</a>`)
+  d.DocumentElement().AppendChild(d.CreateComment(" a - b "))
+  d.DocumentElement().AppendChild(d.CreateProcessingInstruction("p", "a ? > b"))
+  var b strings.Builder
+  if err := Serialize(&b, d.DocumentElement()); (err != nil || b.String() != "<a>x<!-- a - b --><?p a ? > b?></a>") {
+    t.Errorf("Serialize wrote %q, %v", b.String(), err)
+  }
+}
+
+func TestSerializeIndent(t *testing.T) {
+  in := `<?xml version="1.0"?><!--top--><config id="1">  <server host="a" port="80"/>
+<p>some <b>bold</b> text</p><pre>  keep
+  this <i>as</i> is</pre><code xml:space="preserve"> <x/> </code><name>  n  </name></config>`
+  d, _ := ParseString(in)
+  opts := NewSerializeOptions()
+  opts.Indent = "  "
+  opts.PreserveSpace = []string{"pre"}
+  var b strings.Builder
+  if err := SerializeWithOptions(&b, d, opts); err != nil {
+    t.Fatalf("SerializeWithOptions failed: %v", err)
+  }
+  want := `<?xml version="1.0"?>
+<!--top-->
+<config id="1">
+  <server host="a" port="80"></server>
+  <p>some <b>bold</b> text</p>
+  <pre>  keep
+  this <i>as</i> is</pre>
+  <code xml:space="preserve"> <x></x> </code>
+  <name>  n  </name>
+</config>
+`
+  if (b.String() != want) {
+    t.Errorf("SerializeWithOptions wrote\n%s\ninstead of\n%s", b.String(), want)
+  }
+
+  opts.ReformatMixed = true
+  opts.MaxLineWidth = 25
+  b.Reset()
+  SerializeWithOptions(&b, d.DocumentElement(), opts)
+  want = `<config id="1">
+  <server host="a"
+          port="80"></server>
+  <p>
+    some
+    <b>bold</b>
+    text
+  </p>
+  <pre>  keep
+  this <i>as</i> is</pre>
+  <code xml:space="preserve"> <x></x> </code>
+  <name>  n  </name>
+</config>`
+  if (b.String() != want) {
+    t.Errorf("SerializeWithOptions wrote\n%s\ninstead of\n%s", b.String(), want)
+  }
+}
+
+func TestSerializeIndentLeadingWhitespace(t *testing.T) {
+  opts := NewSerializeOptions()
+  opts.Indent = "  "
+  f, _ := ParseFragment(nil, strings.NewReader("\n  <q/>\n  <r><s/></r>"))
+  var b strings.Builder
+  if err := SerializeWithOptions(&b, f, opts); (err != nil || b.String() != "<q></q>\n<r>\n  <s></s>\n</r>") {
+    t.Errorf("SerializeWithOptions of a fragment wrote %q, %v", b.String(), err)
+  }
+}
+
+func TestSerializeBytes(t *testing.T) {
+  in := `<?xml version="1.0"?>` + "\n" +
+    `<a t="it's &quot;é&quot;"><b/><c>x</c>` + "\n" + `<d>café – €</d><e><![CDATA[né]]></e></a>`
+  d, err := ParseNativeString(in)
+  if err != nil {
+    t.Fatalf("Parse failed: %v", err)
+  }
+  for _, tc := range []struct {
+    opts SerializeOptions
+    want string
+  }{
+    {SerializeOptions{EmptyElements: SelfCloseEmpty, SingleQuotes: true},
+      `<?xml version='1.0'?>` + "\n" + `<a t='it&apos;s "é"'><b/><c>x</c>` + "\n" + `<d>café – €</d><e><![CDATA[né]]></e></a>`},
+    {SerializeOptions{EmptyElements: SpacedSelfCloseEmpty, Newline: "\r\n"},
+      `<?xml version="1.0"?>` + "\r\n" + `<a t="it's &quot;é&quot;"><b /><c>x</c>` + "\r\n" + `<d>café – €</d><e><![CDATA[né]]></e></a>`},
+    {SerializeOptions{EscapeNonASCII: true},
+      `<?xml version="1.0"?>` + "\n" + `<a t="it's &quot;&#xE9;&quot;"><b></b><c>x</c>` + "\n" + `<d>caf&#xE9; &#x2013; &#x20AC;</d><e><![CDATA[n]]>&#xE9;<![CDATA[]]></e></a>`},
+    {SerializeOptions{Encoding: "latin1"},
+      `<?xml version="1.0" encoding="ISO-8859-1"?>` + "\n" + "<a t=\"it's &quot;\xE9&quot;\"><b></b><c>x</c>\n<d>caf\xE9 &#x2013; &#x20AC;</d><e><![CDATA[n\xE9]]></e></a>"},
+    {SerializeOptions{Encoding: "Windows-1252"},
+      `<?xml version="1.0" encoding="windows-1252"?>` + "\n" + "<a t=\"it's &quot;\xE9&quot;\"><b></b><c>x</c>\n<d>caf\xE9 \x96 \x80</d><e><![CDATA[n\xE9]]></e></a>"},
+  } {
+    var b strings.Builder
+    if err := SerializeWithOptions(&b, d, &tc.opts); err != nil {
+      t.Errorf("%+v: %v", tc.opts, err)
+    } else if (b.String() != tc.want) {
+      t.Errorf("%+v: wrote\n%q\ninstead of\n%q", tc.opts, b.String(), tc.want)
+    }
+  }
+
+  // documents without a declaration get one for encodings other than UTF-8
+  d, _ = ParseString(`<a/>`)
+  d.(*_doc).xmlVersion = ""
+  var b strings.Builder
+  SerializeWithOptions(&b, d, &SerializeOptions{Encoding: "US-ASCII"})
+  if (b.String() != `<?xml version="1.0" encoding="US-ASCII"?><a></a>`) {
+    t.Errorf("wrote %s", b.String())
+  }
+
+  // names cannot be escaped
+  d, _ = ParseString(`<é/>`)
+  if err := SerializeWithOptions(&b, d, &SerializeOptions{Encoding: "US-ASCII"}); err == nil {
+    t.Errorf("wrote a name that US-ASCII lacks")
+  }
+  if err := SerializeWithOptions(&b, d, &SerializeOptions{Encoding: "EBCDIC"}); err == nil {
+    t.Errorf("wrote an unsupported encoding")
+  }
+}
+
+// publishFilter drops what is internal, unwraps draft elements and
+// rewrites links
+type publishFilter struct{}
+
+func (publishFilter) AcceptNode(n Node) int {
+  switch n.NodeType() {
+  case COMMENT_NODE:
+    return FILTER_REJECT
+  case ATTRIBUTE_NODE:
+    if (n.NodeName() == "internal") {
+      return FILTER_REJECT
+    }
+  case ELEMENT_NODE:
+    e := n.(Element)
+    if (e.GetAttribute("internal") == "true") {
+      return FILTER_REJECT
+    }
+    if (e.NodeName() == "draft") {
+      return FILTER_SKIP
+    }
+  }
+  return FILTER_ACCEPT
+}
+
+func (publishFilter) WhatToShow() uint32 {
+  return SHOW_ELEMENT | SHOW_ATTRIBUTE | SHOW_COMMENT
+}
+
+func (publishFilter) RewriteAttribute(a Attr) string {
+  if (a.NodeName() == "href") {
+    return strings.Replace(a.NodeValue(), "http://intranet/", "https://example.com/", 1)
+  }
+  return a.NodeValue()
+}
+
+func TestSerializeFilter(t *testing.T) {
+  in := `<doc xmlns:x="urn:x"><!--todo--><p internal="false">a <a href="http://intranet/b">b</a></p>` +
+    `<notes internal="true"><p>secret</p></notes><draft><x:p>c</x:p><empty internal="true"/></draft></doc>`
+  d, err := ParseString(in)
+  if err != nil {
+    t.Fatalf("Parse failed: %v", err)
+  }
+  var b strings.Builder
+  if err := SerializeWithOptions(&b, d.DocumentElement(), &SerializeOptions{Filter: publishFilter{}}); err != nil {
+    t.Fatalf("SerializeWithOptions failed: %v", err)
+  }
+  want := `<doc xmlns:x="urn:x"><p>a <a href="https://example.com/b">b</a></p><x:p>c</x:p></doc>`
+  if (b.String() != want) {
+    t.Errorf("SerializeWithOptions wrote\n%s\ninstead of\n%s", b.String(), want)
+  }
+  if got := toXml(d.DocumentElement()); got != strings.Replace(in, "/>", "></empty>", 1) {
+    t.Errorf("the filter changed the document: %s", got)
+  }
+
+  // the layout follows what is written
+  b.Reset()
+  SerializeWithOptions(&b, d.DocumentElement(), &SerializeOptions{Filter: publishFilter{}, Indent: "  ", EmptyElements: SelfCloseEmpty})
+  want = "<doc xmlns:x=\"urn:x\">\n  <p>a <a href=\"https://example.com/b\">b</a></p>\n  <x:p>c</x:p>\n</doc>"
+  if (b.String() != want) {
+    t.Errorf("SerializeWithOptions wrote\n%s\ninstead of\n%s", b.String(), want)
+  }
+
+  // a namespace declared on a skipped element is declared where it is used
+  d, _ = ParseString(`<doc><draft xmlns:x="urn:x"><x:p/></draft></doc>`)
+  b.Reset()
+  SerializeWithOptions(&b, d, &SerializeOptions{Filter: publishFilter{}, EmptyElements: SelfCloseEmpty})
+  if want := `<?xml version="1.0"?><doc><x:p xmlns:x="urn:x"/></doc>`; b.String() != want {
+    t.Errorf("SerializeWithOptions wrote\n%s\ninstead of\n%s", b.String(), want)
+  }
+}
@@ -0,0 +1,190 @@
+package dom
+
+/*
+ * Streaming: a tree is built for each matching element of the input in
+ * turn, so that inputs of any size can be worked through element by
+ * element.
+ */
+
+import (
+	"io"
+	"strings"
+)
+
+// A Matcher decides whether an element is to be built. path holds the
+// names of the open elements from the root down to the element itself;
+// it is only valid during the call.
+type Matcher func(path []QName) bool
+
+// MatchPath returns a Matcher for a path of qualified names separated by
+// slashes, like /feed/entry for the entry children of the root element
+// feed, or entry for entry elements anywhere. A * step matches any
+// element.
+func MatchPath(path string) Matcher {
+	absolute := strings.HasPrefix(path, "/")
+	steps := strings.Split(strings.Trim(path, "/"), "/")
+	return func(open []QName) bool {
+		if len(open) < len(steps) || absolute && len(open) != len(steps) {
+			return false
+		}
+		open = open[len(open)-len(steps):]
+		for i, step := range steps {
+			if step != "*" && step != open[i].Qualified() {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// ParseEach reads r and calls fn with each element that match accepts,
+// built with all its content as the document element of a Document of
+// its own. The rest of the input is read but not kept, and each element
+// can be let go of once fn returns. Elements inside a matching element
+// are not matched on their own. An error from fn stops the parse and is
+// returned as it is.
+//
+// The input may be of any size: the default MaxInputBytes limit is not
+// applied, and MaxNodes bounds each element built rather than the input.
+func ParseEach(r io.Reader, match Matcher, fn func(Element) error) error {
+	return ParseEachWithOptions(r, nil, match, fn)
+}
+
+// ParseEachWithOptions is ParseEach configured by opts. A nil opts means
+// the defaults of ParseEach. The Limits of NewParseOptions bound the size
+// of the input, as they do for Parse; set opts.Limits.MaxInputBytes to 0
+// to lift that bound.
+func ParseEachWithOptions(r io.Reader, opts *ParseOptions, match Matcher, fn func(Element) error) error {
+	if opts == nil {
+		opts = newStreamOptions()
+	}
+	h := &eachHandler{match: match, fn: fn, opts: opts}
+	err := newSAXReader(r, h, opts).run()
+	if h.err != nil {
+		return h.err
+	}
+	return err
+}
+
+// an eachHandler hands the events inside matching elements to a builder
+type eachHandler struct {
+	match Matcher
+	fn    func(Element) error
+	opts  *ParseOptions
+	loc   Locator
+	decl  *XMLDecl
+	path  []QName  // the open elements
+	b     *builder // builds the current match, if there is one
+	depth int      // the open elements of the current match
+	err   error    // from fn
+}
+
+func (h *eachHandler) SetDocumentLocator(l Locator) {
+	h.loc = l
+}
+
+func (h *eachHandler) StartDocument() error {
+	return nil
+}
+
+func (h *eachHandler) EndDocument() error {
+	return nil
+}
+
+func (h *eachHandler) StartElement(name QName, attrs []Attribute) error {
+	h.path = append(h.path, name)
+	if h.b == nil {
+		if !h.match(h.path) {
+			return nil
+		}
+		h.b = newBuilder(h.opts)
+		h.b.loc = h.loc
+		if h.decl != nil {
+			h.b.d.setXMLDecl(*h.decl)
+		}
+	}
+	h.depth++
+	return h.b.StartElement(name, attrs)
+}
+
+func (h *eachHandler) EndElement(name QName) error {
+	h.path = h.path[:len(h.path)-1]
+	if h.b == nil {
+		return nil
+	}
+	if err := h.b.EndElement(name); err != nil {
+		return err
+	}
+	if h.depth--; h.depth > 0 {
+		return nil
+	}
+	root := h.b.d.DocumentElement()
+	h.b = nil
+	h.err = h.fn(root)
+	return h.err
+}
+
+func (h *eachHandler) Characters(data []byte) error {
+	if h.b == nil {
+		return nil
+	}
+	return h.b.Characters(data)
+}
+
+func (h *eachHandler) Comment(data []byte) error {
+	if h.b == nil {
+		return nil
+	}
+	return h.b.Comment(data)
+}
+
+func (h *eachHandler) ProcessingInstruction(target, data string) error {
+	if h.b == nil {
+		return nil
+	}
+	return h.b.ProcessingInstruction(target, data)
+}
+
+func (h *eachHandler) XMLDecl(decl XMLDecl) error {
+	h.decl = &decl
+	return nil
+}
+
+func (h *eachHandler) Doctype(name, publicId, systemId, internalSubset string) error {
+	return nil
+}
+
+func (h *eachHandler) StartCDATA() error {
+	if h.b == nil {
+		return nil
+	}
+	return h.b.StartCDATA()
+}
+
+func (h *eachHandler) EndCDATA() error {
+	if h.b == nil {
+		return nil
+	}
+	return h.b.EndCDATA()
+}
+
+func (h *eachHandler) StartEntity(name string) error {
+	if h.b == nil {
+		return nil
+	}
+	return h.b.StartEntity(name)
+}
+
+func (h *eachHandler) EndEntity(name string) error {
+	if h.b == nil {
+		return nil
+	}
+	return h.b.EndEntity(name)
+}
+
+func (h *eachHandler) SkippedEntity(name string) error {
+	if h.b == nil {
+		return nil
+	}
+	return h.b.SkippedEntity(name)
+}
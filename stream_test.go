@@ -0,0 +1,129 @@
+package dom
+
+import (
+  "errors"
+  "strings"
+  "testing"
+)
+
+const records = `<?xml version="1.0"?>
+<dump xmlns:x="urn:x">
+  <meta><record id="m"/></meta>
+  <record id="1"><name>a</name><x:tag/></record>
+  <record id="2"><name>b</name><record id="inner"/></record>
+  <record id="3"><name>c</name></record>
+</dump>`
+
+func TestParseEach(t *testing.T) {
+  var ids, names []string
+  var docs []Document
+  err := ParseEach(strings.NewReader(records), MatchPath("/dump/record"), func(e Element) error {
+    ids = append(ids, e.GetAttribute("id"))
+    names = append(names, e.GetElementsByTagName("name").Item(0).FirstChild().NodeValue())
+    docs = append(docs, e.OwnerDocument())
+    if (e.OwnerDocument().DocumentElement() != e) {
+      t.Errorf("Record %s was not the document element of its document", e.GetAttribute("id"))
+    }
+    return nil
+  })
+  if err != nil {
+    t.Fatalf("ParseEach failed: %v", err)
+  }
+  if (strings.Join(ids, ",") != "1,2,3" || strings.Join(names, ",") != "a,b,c") {
+    t.Errorf("ParseEach found records %v named %v", ids, names)
+  }
+  if (docs[0] == docs[1]) {
+    t.Errorf("Records shared a document")
+  }
+}
+
+func TestParseEachNamespaces(t *testing.T) {
+  n := 0
+  ParseEach(strings.NewReader(records), MatchPath("x:tag"), func(e Element) error {
+    n++
+    if (e.NamespaceURI() != "urn:x" || e.LookupNamespaceURI("x") != "urn:x") {
+      t.Errorf("Element lost its namespace: %q", e.NamespaceURI())
+    }
+    return nil
+  })
+  if (n != 1) {
+    t.Errorf("MatchPath(\"x:tag\") matched %d elements", n)
+  }
+}
+
+func TestParseEachStop(t *testing.T) {
+  stop := errors.New("stop")
+  var ids []string
+  err := ParseEach(strings.NewReader(records), MatchPath("record"), func(e Element) error {
+    ids = append(ids, e.GetAttribute("id"))
+    if (len(ids) == 2) {
+      return stop
+    }
+    return nil
+  })
+  if (err != stop || strings.Join(ids, ",") != "m,1") {
+    t.Errorf("ParseEach returned %v after %v", err, ids)
+  }
+}
+
+func TestMatchPath(t *testing.T) {
+  path := []QName{{Local: "a"}, {Prefix: "p", Local: "b"}, {Local: "c"}}
+  tests := []struct {
+    path string
+    match bool
+  }{
+    {"/a/p:b/c", true},
+    {"c", true},
+    {"p:b/c", true},
+    {"/a/*/c", true},
+    {"/c", false},
+    {"b/c", false},
+    {"x/a/p:b/c", false},
+  }
+  for _, test := range tests {
+    if (MatchPath(test.path)(path) != test.match) {
+      t.Errorf("MatchPath(%q) did not give %v", test.path, test.match)
+    }
+  }
+}
+
+func TestParseEachLargeInput(t *testing.T) {
+  if (testing.Short()) {
+    t.Skip("reads more than the default MaxInputBytes")
+  }
+  record := "<r><t>" + strings.Repeat("x", 1<<20) + "</t></r>"
+  n := int(DefaultLimits().MaxInputBytes>>20) + 8
+  matches := 0
+  err := ParseEach(newRecordReader(record, n), MatchPath("/feed/r"), func(e Element) error {
+    matches++
+    return nil
+  })
+  if (err != nil || matches != n) {
+    t.Errorf("ParseEach of a large input gave %v after %d matches", err, matches)
+  }
+}
+
+func TestParseEachWithOptions(t *testing.T) {
+  opts := NewParseOptions()
+  opts.Limits.MaxNodes = 10
+  matches := 0
+  count := func(e Element) error {
+    matches++
+    return nil
+  }
+  // the limit on nodes is for each element built
+  err := ParseEachWithOptions(newRecordReader("<r><a>1</a><b>2</b></r>", 100), opts, MatchPath("r"), count)
+  if (err != nil || matches != 100) {
+    t.Errorf("ParseEachWithOptions gave %v after %d matches", err, matches)
+  }
+  var le *LimitError
+  err = ParseEachWithOptions(newRecordReader("<r>" + strings.Repeat("<a/>", 10) + "</r>", 1), opts, MatchPath("r"), count)
+  if (!errors.As(err, &le) || le.Limit != "MaxNodes") {
+    t.Errorf("ParseEachWithOptions went past MaxNodes: %v", err)
+  }
+  opts.Limits.MaxInputBytes = 1 << 10
+  err = ParseEachWithOptions(newRecordReader("<r/>", 1<<10), opts, MatchPath("r"), count)
+  if (!errors.As(err, &le) || le.Limit != "MaxInputBytes") {
+    t.Errorf("ParseEachWithOptions went past MaxInputBytes: %v", err)
+  }
+}
@@ -0,0 +1,985 @@
+package dom
+
+/*
+ * A native XML tokenizer.
+ *
+ * xml.Decoder folds CDATA sections and references into plain character
+ * data. The Tokenizer keeps them apart so that the parser can build
+ * CDATASection and EntityReference nodes and write them back out the way
+ * they were read.
+ */
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Tokens returned by a Tokenizer on top of the ones defined by encoding/xml.
+type (
+	// A CDATA section, without the <![CDATA[ and ]]> delimiters.
+	CDATA []byte
+
+	// A character reference such as &#60; or &#x3C;.
+	CharRef rune
+
+	// A reference to a named entity such as &amp; or &nbsp;.
+	EntityRef string
+
+	// The XML declaration at the very start of a document.
+	XMLDecl struct {
+		Version    string
+		Encoding   string
+		Standalone string
+	}
+)
+
+func (c CDATA) Copy() CDATA {
+	return CDATA(append([]byte(nil), c...))
+}
+
+// the entities every XML processor knows about
+var predefinedEntities = map[string]string{
+	"lt":   "<",
+	"gt":   ">",
+	"amp":  "&",
+	"apos": "'",
+	"quot": `"`,
+}
+
+// A Tokenizer reads XML tokens from an input stream. Start and end
+// elements are checked for proper nesting; everything else is passed on
+// as it appears in the input, but for a byte order mark at the start,
+// which is skipped. Element and attribute names are returned
+// unresolved, with the prefix in Name.Space.
+type Tokenizer struct {
+	// Entity maps entity names to replacement text, in addition to the
+	// general entities declared in the internal DTD subset.
+	Entity map[string]string
+
+	// CharsetReader, if non-nil, returns a reader that converts input in
+	// the charset named by the XML declaration to UTF-8, as it does for
+	// xml.Decoder.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+	// Limits bound what the input may use. Tokens that go beyond them
+	// fail with a *LimitError. The zero value sets no limits.
+	Limits Limits
+
+	r        *bufio.Reader
+	pos      Position // position of the next byte
+	prev     Position // position of the last byte read
+	backed   bool     // a byte has been pushed back
+	back     byte
+	backPos  Position
+	start    Position // position of the current token
+	stack    []xml.Name
+	pending  *xml.EndElement // the end of an empty element tag
+	attrs    []SourceRange   // where the attributes of the last start tag are
+	entities map[string]string
+	external map[string]bool // entities declared with an external identifier
+	hasDTD   bool            // declarations may exist that we have not seen
+	fragment bool            // no prolog allowed, as for entity replacement text
+	begun    bool            // the input has been read from
+	x        *expansions     // shared with the tokenizers of replacement text
+	buf      bytes.Buffer
+	err      error
+}
+
+func NewTokenizer(r io.Reader) *Tokenizer {
+	t := &Tokenizer{
+		pos:      Position{1, 1, 0},
+		entities: make(map[string]string),
+		external: make(map[string]bool),
+		x:        new(expansions),
+	}
+	if br, ok := r.(*bufio.Reader); ok {
+		t.r = br
+	} else {
+		t.r = bufio.NewReader(r)
+	}
+	return t
+}
+
+// newFragmentTokenizer tokenizes replacement text s with the entity
+// declarations of t.
+func (t *Tokenizer) newFragmentTokenizer(s string) *Tokenizer {
+	f := NewTokenizer(strings.NewReader(s))
+	f.Entity = t.Entity
+	f.entities = t.entities
+	f.external = t.external
+	f.hasDTD = t.hasDTD
+	f.Limits = t.Limits
+	f.x = t.x
+	f.fragment = true
+	return f
+}
+
+// InputOffset returns the byte offset of the current position in the input.
+func (t *Tokenizer) InputOffset() int64 {
+	return t.pos.Offset
+}
+
+// InputPos returns the line and column, both 1-based, of the current
+// position in the input. As with xml.Decoder, columns count bytes.
+func (t *Tokenizer) InputPos() (line, column int) {
+	return t.pos.Line, t.pos.Column
+}
+
+// AttrRanges returns where each attribute of the last StartElement
+// returned by Token is in the input, in the order of its Attr slice.
+func (t *Tokenizer) AttrRanges() []SourceRange {
+	return t.attrs
+}
+
+// EntityValue returns the replacement text of the named general entity.
+// The predefined entities are not included.
+func (t *Tokenizer) EntityValue(name string) (string, bool) {
+	if v, ok := t.Entity[name]; ok {
+		return v, true
+	}
+	v, ok := t.entities[name]
+	return v, ok
+}
+
+// Token returns the next token of the input, or io.EOF at the end of it.
+// The byte slices of the tokens are only valid until the next call.
+func (t *Tokenizer) Token() (xml.Token, error) {
+	if t.err != nil {
+		return nil, t.err
+	}
+	if t.pending != nil {
+		end := *t.pending
+		t.pending = nil
+		return end, nil
+	}
+	t.start = t.pos
+	b, ok := t.getc()
+	if !ok {
+		if t.err != nil {
+			return nil, t.err
+		}
+		if len(t.stack) > 0 {
+			return nil, t.syntaxError("unexpected EOF")
+		}
+		t.err = io.EOF
+		return nil, io.EOF
+	}
+	var tok xml.Token
+	switch b {
+	case '<':
+		tok = t.markup()
+	case '&':
+		tok = t.reference()
+	default:
+		t.ungetc(b)
+		tok = t.text()
+	}
+	if t.err != nil {
+		return nil, t.err
+	}
+	return tok, nil
+}
+
+func (t *Tokenizer) syntaxError(msg string) error {
+	t.err = &xml.SyntaxError{Msg: msg, Line: t.pos.Line}
+	return t.err
+}
+
+// within fails with a LimitError if n is over the limit max.
+func (t *Tokenizer) within(limit string, max int64, n int64) bool {
+	if err := exceeds(limit, max, n); err != nil {
+		t.err = err
+		return false
+	}
+	return true
+}
+
+// textWithin checks the length of the text in t.buf.
+func (t *Tokenizer) textWithin() bool {
+	return t.within("MaxTextLength", int64(t.Limits.MaxTextLength), int64(t.buf.Len()))
+}
+
+// getc reads the next byte, turning \r\n and lone \r into \n.
+func (t *Tokenizer) getc() (byte, bool) {
+	if t.err != nil {
+		return 0, false
+	}
+	t.prev = t.pos
+	if t.backed {
+		t.backed = false
+		t.pos = t.backPos
+		return t.back, true
+	}
+	if !t.begun {
+		t.begun = true
+		if !t.fragment {
+			skipBOM(t.r)
+		}
+	}
+	b, err := t.r.ReadByte()
+	if err != nil {
+		if err != io.EOF {
+			t.err = err
+		}
+		return 0, false
+	}
+	t.pos.Offset++
+	if !t.within("MaxInputBytes", t.Limits.MaxInputBytes, t.pos.Offset) {
+		return 0, false
+	}
+	if b == '\r' {
+		if next, err := t.r.Peek(1); err == nil && next[0] == '\n' {
+			t.r.ReadByte()
+			t.pos.Offset++
+		}
+		b = '\n'
+	}
+	if b == '\n' {
+		t.pos.Line++
+		t.pos.Column = 1
+	} else {
+		t.pos.Column++
+	}
+	return b, true
+}
+
+// mustgetc is getc for places where the input may not end.
+func (t *Tokenizer) mustgetc() (byte, bool) {
+	b, ok := t.getc()
+	if !ok && t.err == nil {
+		t.syntaxError("unexpected EOF")
+	}
+	return b, ok
+}
+
+// ungetc pushes back the byte that was just read.
+func (t *Tokenizer) ungetc(b byte) {
+	t.backed = true
+	t.back = b
+	t.backPos = t.pos
+	t.pos = t.prev
+}
+
+// expect reads the bytes of s, failing with msg if the input differs.
+func (t *Tokenizer) expect(s string, msg string) bool {
+	for i := 0; i < len(s); i++ {
+		b, ok := t.mustgetc()
+		if !ok {
+			return false
+		}
+		if b != s[i] {
+			t.syntaxError(msg)
+			return false
+		}
+	}
+	return true
+}
+
+// space skips whitespace and reports whether there was any.
+func (t *Tokenizer) space() bool {
+	skipped := false
+	for {
+		b, ok := t.getc()
+		if !ok {
+			return skipped
+		}
+		if b != ' ' && b != '\t' && b != '\n' {
+			t.ungetc(b)
+			return skipped
+		}
+		skipped = true
+	}
+}
+
+func isNameStart(b byte) bool {
+	return 'a' <= b && b <= 'z' || 'A' <= b && b <= 'Z' || b == '_' || b == ':' || b >= 0x80
+}
+
+func isNameByte(b byte) bool {
+	return isNameStart(b) || '0' <= b && b <= '9' || b == '-' || b == '.'
+}
+
+func (t *Tokenizer) name() (string, bool) {
+	b, ok := t.getc()
+	if !ok {
+		return "", false
+	}
+	if !isNameStart(b) {
+		t.ungetc(b)
+		return "", false
+	}
+	s := []byte{b}
+	for {
+		b, ok = t.getc()
+		if !ok {
+			break
+		}
+		if !isNameByte(b) {
+			t.ungetc(b)
+			break
+		}
+		s = append(s, b)
+	}
+	return string(s), true
+}
+
+// splitName splits a qualified name into prefix and local part the way
+// xml.Decoder.RawToken does.
+func splitName(s string) xml.Name {
+	if i := strings.Index(s, ":"); i > 0 && i < len(s)-1 {
+		return xml.Name{Space: s[:i], Local: s[i+1:]}
+	}
+	return xml.Name{Local: s}
+}
+
+func qualifiedName(n xml.Name) string {
+	if n.Space == "" {
+		return n.Local
+	}
+	return n.Space + ":" + n.Local
+}
+
+// markup reads whatever follows a '<'.
+func (t *Tokenizer) markup() xml.Token {
+	b, ok := t.mustgetc()
+	if !ok {
+		return nil
+	}
+	switch b {
+	case '/':
+		return t.endTag()
+	case '?':
+		return t.procInst()
+	case '!':
+		return t.bang()
+	}
+	t.ungetc(b)
+	return t.startTag()
+}
+
+func (t *Tokenizer) startTag() xml.Token {
+	name, ok := t.name()
+	if !ok {
+		if t.err == nil {
+			t.syntaxError("expected element name after <")
+		}
+		return nil
+	}
+	start := xml.StartElement{Name: splitName(name)}
+	t.attrs = t.attrs[:0]
+	for {
+		sp := t.space()
+		b, ok := t.mustgetc()
+		if !ok {
+			return nil
+		}
+		if b == '/' {
+			if !t.expect(">", "expected /> in element") {
+				return nil
+			}
+			t.pending = &xml.EndElement{Name: start.Name}
+			return start
+		}
+		if b == '>' {
+			if !t.within("MaxDepth", int64(t.Limits.MaxDepth), int64(len(t.stack)+1)) {
+				return nil
+			}
+			t.stack = append(t.stack, start.Name)
+			return start
+		}
+		if !sp {
+			t.syntaxError("expected whitespace before attribute in element <" + name + ">")
+			return nil
+		}
+		t.ungetc(b)
+		astart := t.pos
+		aname, ok := t.name()
+		if !ok {
+			if t.err == nil {
+				t.syntaxError("expected attribute name in element <" + name + ">")
+			}
+			return nil
+		}
+		t.space()
+		if !t.expect("=", "attribute name without = in element <"+name+">") {
+			return nil
+		}
+		t.space()
+		q, ok := t.mustgetc()
+		if !ok {
+			return nil
+		}
+		if q != '"' && q != '\'' {
+			t.syntaxError("unquoted or missing attribute value in element <" + name + ">")
+			return nil
+		}
+		value, ok := t.attrValue(q)
+		if !ok {
+			return nil
+		}
+		attr := xml.Attr{Name: splitName(aname), Value: value}
+		for _, a := range start.Attr {
+			if a.Name == attr.Name {
+				t.syntaxError("duplicate attribute " + aname + " in element <" + name + ">")
+				return nil
+			}
+		}
+		start.Attr = append(start.Attr, attr)
+		if !t.within("MaxAttributesPerElement", int64(t.Limits.MaxAttributesPerElement), int64(len(start.Attr))) {
+			return nil
+		}
+		t.attrs = append(t.attrs, SourceRange{astart, t.pos})
+	}
+}
+
+func (t *Tokenizer) endTag() xml.Token {
+	name, ok := t.name()
+	if !ok {
+		if t.err == nil {
+			t.syntaxError("expected element name after </")
+		}
+		return nil
+	}
+	t.space()
+	if !t.expect(">", "invalid characters between </"+name+" and >") {
+		return nil
+	}
+	if len(t.stack) == 0 {
+		t.syntaxError("unexpected end element </" + name + ">")
+		return nil
+	}
+	top := t.stack[len(t.stack)-1]
+	if qualifiedName(top) != name {
+		t.syntaxError("element <" + qualifiedName(top) + "> closed by </" + name + ">")
+		return nil
+	}
+	t.stack = t.stack[:len(t.stack)-1]
+	return xml.EndElement{Name: top}
+}
+
+// attrValue reads an attribute value up to the closing quote q,
+// expanding references and normalizing whitespace.
+func (t *Tokenizer) attrValue(q byte) (string, bool) {
+	var v []byte
+	for {
+		b, ok := t.mustgetc()
+		if !ok {
+			return "", false
+		}
+		switch b {
+		case q:
+			return string(v), true
+		case '<':
+			t.syntaxError("unescaped < inside quoted string")
+			return "", false
+		case '&':
+			s, ok := t.attrReference()
+			if !ok {
+				return "", false
+			}
+			v = append(v, s...)
+		case '\n', '\t':
+			v = append(v, ' ')
+		default:
+			v = append(v, b)
+		}
+		if !t.within("MaxTextLength", int64(t.Limits.MaxTextLength), int64(len(v))) {
+			return "", false
+		}
+	}
+}
+
+// attrReference reads a reference inside an attribute value and returns
+// its expansion.
+func (t *Tokenizer) attrReference() (string, bool) {
+	b, ok := t.mustgetc()
+	if !ok {
+		return "", false
+	}
+	if b == '#' {
+		r, ok := t.charRef()
+		return string(r), ok
+	}
+	t.ungetc(b)
+	name, ok := t.name()
+	if !ok || !t.expect(";", "invalid character entity &"+name) {
+		if t.err == nil {
+			t.syntaxError("invalid character entity &")
+		}
+		return "", false
+	}
+	return t.expandInAttr(name, nil)
+}
+
+// expandInAttr returns the replacement text of entity name as it appears
+// in an attribute value. open holds the entities being expanded, to
+// catch recursion.
+func (t *Tokenizer) expandInAttr(name string, open []string) (string, bool) {
+	if v, ok := predefinedEntities[name]; ok {
+		return v, true
+	}
+	if t.external[name] {
+		t.syntaxError("external entity &" + name + "; referenced in attribute value")
+		return "", false
+	}
+	value, ok := t.EntityValue(name)
+	if !ok {
+		t.syntaxError("undeclared entity &" + name + "; in attribute value")
+		return "", false
+	}
+	for _, o := range open {
+		if o == name {
+			t.syntaxError("recursive entity reference &" + name + ";")
+			return "", false
+		}
+	}
+	open = append(open, name)
+	if err := t.x.expand(&t.Limits, len(open), value); err != nil {
+		t.err = err
+		return "", false
+	}
+	var v []byte
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; c {
+		case '<':
+			t.syntaxError("entity &" + name + "; referenced in attribute value contains <")
+			return "", false
+		case '&':
+			end := strings.IndexByte(value[i:], ';')
+			if end < 0 {
+				t.syntaxError("invalid reference in entity &" + name + ";")
+				return "", false
+			}
+			ref := value[i+1 : i+end]
+			i += end
+			if strings.HasPrefix(ref, "#") {
+				r, ok := parseCharRef(ref[1:])
+				if !ok {
+					t.syntaxError("invalid character reference &" + ref + ";")
+					return "", false
+				}
+				v = utf8.AppendRune(v, r)
+				continue
+			}
+			s, ok := t.expandInAttr(ref, open)
+			if !ok {
+				return "", false
+			}
+			v = append(v, s...)
+		case '\n', '\t', '\r':
+			v = append(v, ' ')
+		default:
+			v = append(v, c)
+		}
+	}
+	return string(v), true
+}
+
+// parseCharRef parses the part of a character reference between &# and ;.
+func parseCharRef(s string) (rune, bool) {
+	var n uint64
+	var err error
+	if strings.HasPrefix(s, "x") {
+		n, err = strconv.ParseUint(s[1:], 16, 32)
+	} else {
+		n, err = strconv.ParseUint(s, 10, 32)
+	}
+	if err != nil || !isXMLChar(rune(n)) {
+		return 0, false
+	}
+	return rune(n), true
+}
+
+// isXMLChar reports whether r is in the Char production of XML 1.0.
+func isXMLChar(r rune) bool {
+	return r == 0x09 || r == 0x0A || r == 0x0D ||
+		r >= 0x20 && r <= 0xD7FF ||
+		r >= 0xE000 && r <= 0xFFFD ||
+		r >= 0x10000 && r <= 0x10FFFF
+}
+
+// charRef reads the rest of a character reference after &#.
+func (t *Tokenizer) charRef() (rune, bool) {
+	var s []byte
+	for {
+		b, ok := t.mustgetc()
+		if !ok {
+			return 0, false
+		}
+		if b == ';' {
+			break
+		}
+		if !('0' <= b && b <= '9' || 'a' <= b && b <= 'f' || 'A' <= b && b <= 'F' || b == 'x') {
+			t.syntaxError("invalid character reference &#" + string(s))
+			return 0, false
+		}
+		s = append(s, b)
+	}
+	r, ok := parseCharRef(string(s))
+	if !ok {
+		t.syntaxError("invalid character reference &#" + string(s) + ";")
+	}
+	return r, ok
+}
+
+// reference reads a reference in content, after the '&'.
+func (t *Tokenizer) reference() xml.Token {
+	b, ok := t.mustgetc()
+	if !ok {
+		return nil
+	}
+	if b == '#' {
+		r, ok := t.charRef()
+		if !ok {
+			return nil
+		}
+		return CharRef(r)
+	}
+	t.ungetc(b)
+	name, ok := t.name()
+	if !ok || !t.expect(";", "invalid character entity &"+name+" (no semicolon)") {
+		if t.err == nil {
+			t.syntaxError("invalid character entity &")
+		}
+		return nil
+	}
+	if _, ok := predefinedEntities[name]; !ok && !t.hasDTD && !t.external[name] {
+		if _, ok := t.EntityValue(name); !ok {
+			t.syntaxError("undeclared entity &" + name + ";")
+			return nil
+		}
+	}
+	return EntityRef(name)
+}
+
+// text reads character data up to the next markup or reference.
+func (t *Tokenizer) text() xml.Token {
+	t.buf.Reset()
+	for {
+		b, ok := t.getc()
+		if !ok {
+			break
+		}
+		if b == '<' || b == '&' {
+			t.ungetc(b)
+			break
+		}
+		t.buf.WriteByte(b)
+		if !t.textWithin() {
+			return nil
+		}
+		if b == '>' && bytes.HasSuffix(t.buf.Bytes(), []byte("]]>")) {
+			t.syntaxError("unescaped ]]> not in CDATA section")
+			return nil
+		}
+	}
+	return xml.CharData(t.buf.Bytes())
+}
+
+// readUntil reads up to and including end and returns what came before it.
+func (t *Tokenizer) readUntil(end string) ([]byte, bool) {
+	t.buf.Reset()
+	for {
+		b, ok := t.mustgetc()
+		if !ok {
+			return nil, false
+		}
+		t.buf.WriteByte(b)
+		if !t.textWithin() {
+			return nil, false
+		}
+		if b == end[len(end)-1] && bytes.HasSuffix(t.buf.Bytes(), []byte(end)) {
+			return t.buf.Bytes()[:t.buf.Len()-len(end)], true
+		}
+	}
+}
+
+func (t *Tokenizer) procInst() xml.Token {
+	target, ok := t.name()
+	if !ok {
+		if t.err == nil {
+			t.syntaxError("expected target name after <?")
+		}
+		return nil
+	}
+	t.space()
+	data, ok := t.readUntil("?>")
+	if !ok {
+		return nil
+	}
+	if target == "xml" {
+		if t.fragment || t.start.Offset != 0 {
+			t.syntaxError("XML declaration not at start of document")
+			return nil
+		}
+		decl := parseXMLDecl(data)
+		if decl.Version == "" {
+			t.syntaxError("XML declaration without version")
+			return nil
+		}
+		if enc := decl.Encoding; enc != "" && !strings.EqualFold(enc, "utf-8") {
+			if t.CharsetReader == nil {
+				t.syntaxError(fmt.Sprintf("encoding %q declared but Tokenizer.CharsetReader is nil", enc))
+				return nil
+			}
+			r, err := t.CharsetReader(enc, t.r)
+			if err != nil {
+				t.err = err
+				return nil
+			}
+			t.r = bufio.NewReader(r)
+		}
+		return decl
+	}
+	if strings.EqualFold(target, "xml") {
+		t.syntaxError("processing instruction target " + target + " is reserved")
+		return nil
+	}
+	return xml.ProcInst{Target: target, Inst: data}
+}
+
+// parseXMLDecl picks the pseudo-attributes out of the XML declaration.
+func parseXMLDecl(data []byte) XMLDecl {
+	return XMLDecl{
+		Version:    declParam(data, "version"),
+		Encoding:   declParam(data, "encoding"),
+		Standalone: declParam(data, "standalone"),
+	}
+}
+
+// declParam returns the value of the pseudo-attribute param in the data
+// of the XML declaration.
+func declParam(data []byte, param string) string {
+	s := string(data)
+	for {
+		s = strings.TrimLeft(s, " \t\r\n")
+		i := strings.IndexByte(s, '=')
+		if i < 0 {
+			return ""
+		}
+		name := strings.TrimSpace(s[:i])
+		s = strings.TrimLeft(s[i+1:], " \t\r\n")
+		if s == "" || (s[0] != '"' && s[0] != '\'') {
+			return ""
+		}
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return ""
+		}
+		if name == param {
+			return s[1 : end+1]
+		}
+		s = s[end+2:]
+	}
+}
+
+// bang reads the markup that starts with <!.
+func (t *Tokenizer) bang() xml.Token {
+	b, ok := t.mustgetc()
+	if !ok {
+		return nil
+	}
+	switch b {
+	case '-':
+		if !t.expect("-", "invalid sequence <!- not part of <!--") {
+			return nil
+		}
+		t.buf.Reset()
+		for {
+			b, ok := t.mustgetc()
+			if !ok {
+				return nil
+			}
+			if b == '-' && bytes.HasSuffix(t.buf.Bytes(), []byte("-")) {
+				if !t.expect(">", `invalid sequence "--" not allowed in comments`) {
+					return nil
+				}
+				return xml.Comment(t.buf.Bytes()[:t.buf.Len()-1])
+			}
+			t.buf.WriteByte(b)
+			if !t.textWithin() {
+				return nil
+			}
+		}
+	case '[':
+		if !t.expect("CDATA[", "invalid <![ sequence") {
+			return nil
+		}
+		if len(t.stack) == 0 && !t.fragment {
+			t.syntaxError("CDATA section outside of the root element")
+			return nil
+		}
+		data, ok := t.readUntil("]]>")
+		if !ok {
+			return nil
+		}
+		return CDATA(data)
+	case 'D':
+		if !t.expect("OCTYPE", "invalid <! construct") {
+			return nil
+		}
+		if t.fragment || len(t.stack) > 0 {
+			t.syntaxError("unexpected DOCTYPE declaration")
+			return nil
+		}
+		return t.doctype()
+	}
+	t.syntaxError("invalid <! construct")
+	return nil
+}
+
+// doctype reads the rest of a document type declaration and picks up the
+// general entities declared in its internal subset.
+func (t *Tokenizer) doctype() xml.Token {
+	t.buf.Reset()
+	t.buf.WriteString("DOCTYPE")
+	depth := 0
+	var quote byte
+	inComment := false
+	for {
+		b, ok := t.mustgetc()
+		if !ok {
+			return nil
+		}
+		t.buf.WriteByte(b)
+		if !t.textWithin() {
+			return nil
+		}
+		switch {
+		case inComment:
+			inComment = !bytes.HasSuffix(t.buf.Bytes(), []byte("-->"))
+		case quote != 0:
+			if b == quote {
+				quote = 0
+			}
+		case b == '"' || b == '\'':
+			quote = b
+		case b == '[':
+			depth++
+		case b == ']':
+			depth--
+		case b == '-' && depth > 0 && bytes.HasSuffix(t.buf.Bytes(), []byte("<!--")):
+			inComment = true
+		case b == '>' && depth == 0:
+			dir := xml.Directive(t.buf.Bytes()[:t.buf.Len()-1])
+			if dt := newDoctypeFromDirective(dir); dt != nil {
+				if dt.systemId != "" {
+					// there may be declarations we cannot read
+					t.hasDTD = true
+				}
+				t.declareEntities(dt.internalSubset)
+			}
+			return dir
+		}
+	}
+}
+
+// declareEntities records the general entity declarations of the
+// internal subset s. As in XML, the first declaration of a name wins.
+func (t *Tokenizer) declareEntities(s string) {
+	for len(s) > 0 {
+		switch {
+		case strings.HasPrefix(s, "<!--"):
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				return
+			}
+			s = s[end+3:]
+		case strings.HasPrefix(s, "<!ENTITY"):
+			s = t.declareEntity(s[len("<!ENTITY"):])
+		case s[0] == '%':
+			// parameter entity references may pull in anything
+			t.hasDTD = true
+			s = s[1:]
+		case s[0] == '"' || s[0] == '\'':
+			end := strings.IndexByte(s[1:], s[0])
+			if end < 0 {
+				return
+			}
+			s = s[end+2:]
+		default:
+			s = s[1:]
+		}
+	}
+}
+
+// declareEntity reads one entity declaration from s, which starts right
+// after <!ENTITY, and returns the rest of s.
+func (t *Tokenizer) declareEntity(s string) string {
+	s = strings.TrimLeft(s, " \t\r\n")
+	parameter := strings.HasPrefix(s, "%")
+	if parameter {
+		s = strings.TrimLeft(s[1:], " \t\r\n")
+	}
+	i := 0
+	for i < len(s) && isNameByte(s[i]) {
+		i++
+	}
+	name := s[:i]
+	s = strings.TrimLeft(s[i:], " \t\r\n")
+	if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+		end := strings.IndexByte(s[1:], s[0])
+		if end < 0 {
+			return ""
+		}
+		value := s[1 : end+1]
+		s = s[end+2:]
+		if _, seen := t.entities[name]; !parameter && name != "" && !seen && !t.external[name] {
+			t.entities[name] = expandCharRefs(value)
+		}
+	} else if !parameter && name != "" {
+		if _, seen := t.entities[name]; !seen {
+			t.external[name] = true
+		}
+	}
+	// skip to the end of the declaration
+	var quote byte
+	for i = 0; i < len(s); i++ {
+		switch {
+		case quote != 0:
+			if s[i] == quote {
+				quote = 0
+			}
+		case s[i] == '"' || s[i] == '\'':
+			quote = s[i]
+		case s[i] == '>':
+			return s[i+1:]
+		}
+	}
+	return ""
+}
+
+// expandCharRefs replaces the character references in an entity value,
+// which XML does at declaration time. Entity references are left alone.
+func expandCharRefs(s string) string {
+	if !strings.Contains(s, "&#") {
+		return s
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "&#")
+		if i < 0 {
+			break
+		}
+		end := strings.IndexByte(s[i:], ';')
+		if end < 0 {
+			break
+		}
+		r, ok := parseCharRef(s[i+2 : i+end])
+		if !ok {
+			b.WriteString(s[:i+end+1])
+		} else {
+			b.WriteString(s[:i])
+			b.WriteRune(r)
+		}
+		s = s[i+end+1:]
+	}
+	b.WriteString(s)
+	return b.String()
+}
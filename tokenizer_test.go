@@ -0,0 +1,143 @@
+package dom
+
+import (
+  "encoding/xml"
+  "io"
+  "strings"
+  "testing"
+)
+
+func TestTokenizerTokens(t *testing.T) {
+  tz := NewTokenizer(strings.NewReader(`<?xml version="1.0" encoding="UTF-8"?><a x="1&amp;2">t&#65;<![CDATA[<b>]]>&amp;<!--c--><?pi d?><e/></a>`))
+  var got []string
+  for {
+    tok, err := tz.Token()
+    if err == io.EOF {
+      break
+    }
+    if err != nil {
+      t.Fatalf("Tokenizer.Token() failed: %v", err)
+    }
+    switch tok := tok.(type) {
+    case XMLDecl:
+      got = append(got, "decl:"+tok.Version+","+tok.Encoding)
+    case xml.StartElement:
+      s := "start:" + tok.Name.Local
+      for _, a := range tok.Attr {
+        s += " " + a.Name.Local + "=" + a.Value
+      }
+      got = append(got, s)
+    case xml.EndElement:
+      got = append(got, "end:"+tok.Name.Local)
+    case xml.CharData:
+      got = append(got, "text:"+string(tok))
+    case CharRef:
+      got = append(got, "charref:"+string(rune(tok)))
+    case EntityRef:
+      got = append(got, "entityref:"+string(tok))
+    case CDATA:
+      got = append(got, "cdata:"+string(tok))
+    case xml.Comment:
+      got = append(got, "comment:"+string(tok))
+    case xml.ProcInst:
+      got = append(got, "pi:"+tok.Target+","+string(tok.Inst))
+    }
+  }
+  want := []string{"decl:1.0,UTF-8", "start:a x=1&2", "text:t", "charref:A", "cdata:<b>",
+    "entityref:amp", "comment:c", "pi:pi,d", "start:e", "end:e", "end:a"}
+  if strings.Join(got, "|") != strings.Join(want, "|") {
+    t.Errorf("Tokenizer returned\n%v\ninstead of\n%v", got, want)
+  }
+}
+
+func TestTokenizerErrors(t *testing.T) {
+  bad := []string{
+    `<a></b>`,
+    `<a>`,
+    `<a x="1" x="2"/>`,
+    `<a>&undeclared;</a>`,
+    `<a>]]></a>`,
+    `<a><!-- -- --></a>`,
+    `<a/><?xml version="1.0"?>`,
+    `<a>&#0;</a>`,
+  }
+  for _, s := range bad {
+    tz := NewTokenizer(strings.NewReader(s))
+    var err error
+    for err == nil {
+      _, err = tz.Token()
+    }
+    if _, ok := err.(*xml.SyntaxError); !ok {
+      t.Errorf("Tokenizer did not return a syntax error for %s, got %v", s, err)
+    }
+  }
+}
+
+func TestTokenizerInputPos(t *testing.T) {
+  tz := NewTokenizer(strings.NewReader("<a>\r\n  <b/></a>"))
+  tz.Token()
+  tz.Token()
+  line, col := tz.InputPos()
+  if line != 2 || col != 3 {
+    t.Errorf("Tokenizer.InputPos() was %d:%d instead of 2:3", line, col)
+  }
+  if tz.InputOffset() != 7 {
+    t.Errorf("Tokenizer.InputOffset() was %d instead of 7", tz.InputOffset())
+  }
+}
+
+func TestParseNativeCDATA(t *testing.T) {
+  d, err := ParseNativeString(`<a><![CDATA[x < y]]></a>`)
+  if err != nil {
+    t.Fatalf("ParseNative failed: %v", err)
+  }
+  cd := d.DocumentElement().FirstChild()
+  if cd.NodeType() != CDATA_SECTION_NODE || cd.NodeName() != "#cdata-section" || cd.NodeValue() != "x < y" {
+    t.Errorf("CDATA section was not parsed into a CDATASection node")
+  }
+  if s := ToXml(d); s != `<a><![CDATA[x < y]]></a>` {
+    t.Errorf("CDATA section was serialized as %s", s)
+  }
+}
+
+func TestParseNativeReferences(t *testing.T) {
+  d, err := ParseNativeString(`<!DOCTYPE a [<!ENTITY who "World"><!ENTITY greeting "Hello, &who;!">]><a>&#60;&amp;&greeting;</a>`)
+  if err != nil {
+    t.Fatalf("ParseNative failed: %v", err)
+  }
+  r := d.DocumentElement()
+  if r.ChildNodes().Length() != 2 {
+    t.Fatalf("Root had %d children instead of 2", r.ChildNodes().Length())
+  }
+  if r.FirstChild().NodeValue() != "<&" {
+    t.Errorf("Character and predefined references were not merged into one text node: '%s'", r.FirstChild().NodeValue())
+  }
+  ref := r.LastChild()
+  if ref.NodeType() != ENTITY_REFERENCE_NODE || ref.NodeName() != "greeting" {
+    t.Fatalf("Entity reference was not kept as an EntityReference node")
+  }
+  if ref.ChildNodes().Length() != 3 || ref.ChildNodes().Item(1).NodeName() != "who" ||
+     ref.ChildNodes().Item(1).FirstChild().NodeValue() != "World" {
+    t.Errorf("EntityReference node does not hold the replacement text")
+  }
+  if s := ToXml(d); s != `<a>&lt;&amp;&greeting;</a>` {
+    t.Errorf("Entity reference was serialized as %s", s)
+  }
+}
+
+func TestParseNativeXMLDecl(t *testing.T) {
+  d, _ := ParseNativeString(`<?xml version="1.1" encoding="UTF-8" standalone="yes"?><a/>`)
+  if d.XmlVersion() != "1.1" || d.XmlEncoding() != "UTF-8" || !d.XmlStandalone() {
+    t.Errorf("XML declaration was not recorded: %s %s %v", d.XmlVersion(), d.XmlEncoding(), d.XmlStandalone())
+  }
+  if d.ChildNodes().Length() != 1 {
+    t.Errorf("XML declaration became a node")
+  }
+}
+
+func TestParseNativeRecursiveEntity(t *testing.T) {
+  _, err := ParseNativeString(`<!DOCTYPE a [<!ENTITY x "&y;"><!ENTITY y "&x;">]><a>&x;</a>`)
+  if err == nil {
+    t.Errorf("Recursive entity reference did not fail")
+  }
+}
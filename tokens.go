@@ -0,0 +1,232 @@
+package dom
+
+/*
+ * Interplay with encoding/xml: nodes read as a stream of xml.Tokens, and
+ * Documents built from any xml.TokenReader.
+ */
+
+import (
+	"encoding/xml"
+	"io"
+	"strings"
+)
+
+// NewTokenReader returns the tokens of n and everything below it, as
+// xml.Decoder.RawToken would read them from n written as XML, so that
+//
+//	xml.NewTokenDecoder(dom.NewTokenReader(n)).Decode(&v)
+//
+// unmarshals a node. Names have the prefixes of the nodes, and namespace
+// declarations are added where a namespace is not declared within n.
+// CDATA sections are CharData and entity references are replaced by
+// their children. The tree must not be changed while it is read.
+func NewTokenReader(n Node) xml.TokenReader {
+	return &nodeTokenReader{root: n, n: n, entering: true}
+}
+
+type nodeTokenReader struct {
+	nsScope
+	root, n  Node // n is the node to be entered or left
+	entering bool
+	marks    []int // len(scope) when each open element started
+}
+
+func (r *nodeTokenReader) Token() (xml.Token, error) {
+	for r.n != nil {
+		n := r.n
+		if r.entering {
+			t := r.enter(n)
+			if c := n.FirstChild(); c != nil && hasTokenChildren(n) {
+				r.n = c
+			} else {
+				r.entering = false
+			}
+			if t != nil {
+				return t, nil
+			}
+			continue
+		}
+		t := r.leave(n)
+		if n == r.root {
+			r.n = nil
+		} else if s := n.NextSibling(); s != nil {
+			r.n, r.entering = s, true
+		} else {
+			r.n = n.ParentNode()
+		}
+		if t != nil {
+			return t, nil
+		}
+	}
+	return nil, io.EOF
+}
+
+// hasTokenChildren reports whether the children of n are read.
+func hasTokenChildren(n Node) bool {
+	switch n.NodeType() {
+	case ELEMENT_NODE, DOCUMENT_NODE, DOCUMENT_FRAGMENT_NODE, ENTITY_REFERENCE_NODE:
+		return true
+	}
+	return false
+}
+
+// enter returns the token that n starts with, if any.
+func (r *nodeTokenReader) enter(n Node) xml.Token {
+	switch n.NodeType() {
+	case ELEMENT_NODE:
+		e := n.(*_elem)
+		r.marks = append(r.marks, len(r.scope))
+		decls, names := r.startTag(e, e.attribs)
+		t := xml.StartElement{Name: xml.Name{Space: e.pfx, Local: e.n.Local}}
+		for _, d := range decls {
+			if d.prefix == "" {
+				t.Attr = append(t.Attr, xml.Attr{Name: xml.Name{Local: "xmlns"}, Value: d.uri})
+			} else {
+				t.Attr = append(t.Attr, xml.Attr{Name: xml.Name{Space: "xmlns", Local: d.prefix}, Value: d.uri})
+			}
+		}
+		for i, a := range e.attribs {
+			t.Attr = append(t.Attr, xml.Attr{Name: splitName(names[i]), Value: a.value})
+		}
+		return t
+	case TEXT_NODE, CDATA_SECTION_NODE:
+		return xml.CharData(n.NodeValue())
+	case COMMENT_NODE:
+		return xml.Comment(n.NodeValue())
+	case PROCESSING_INSTRUCTION_NODE:
+		pi := n.(ProcessingInstruction)
+		return xml.ProcInst{Target: pi.Target(), Inst: []byte(pi.GetData())}
+	case DOCUMENT_TYPE_NODE:
+		return doctypeDirective(n.(DocumentType))
+	}
+	return nil
+}
+
+// leave returns the token that n ends with, if any.
+func (r *nodeTokenReader) leave(n Node) xml.Token {
+	e, ok := n.(*_elem)
+	if !ok {
+		return nil
+	}
+	r.scope = r.scope[:r.marks[len(r.marks)-1]]
+	r.marks = r.marks[:len(r.marks)-1]
+	return xml.EndElement{Name: xml.Name{Space: e.pfx, Local: e.n.Local}}
+}
+
+// doctypeDirective returns the <!DOCTYPE ...> directive for dt.
+func doctypeDirective(dt DocumentType) xml.Directive {
+	s := "DOCTYPE " + dt.Name()
+	switch {
+	case dt.PublicId() != "":
+		s += " PUBLIC " + quoteLiteral(dt.PublicId()) + " " + quoteLiteral(dt.SystemId())
+	case dt.SystemId() != "":
+		s += " SYSTEM " + quoteLiteral(dt.SystemId())
+	}
+	if dt.InternalSubset() != "" {
+		s += " [" + dt.InternalSubset() + "]"
+	}
+	return xml.Directive(s)
+}
+
+// quoteLiteral quotes s with double quotes, or with single quotes if it
+// holds a double quote.
+func quoteLiteral(s string) string {
+	if strings.Contains(s, "\"") {
+		return "'" + s + "'"
+	}
+	return "\"" + s + "\""
+}
+
+// BuildFromTokens builds a Document out of the tokens of tr, as Parse
+// does out of those of an xml.Decoder. The names of the tokens may have
+// prefixes, as those of NewTokenReader and xml.Decoder.RawToken do, or
+// namespace URIs, as those of xml.Decoder.Token do; URIs that are not
+// declared in scope are given a prefix and a declaration. tr may be a
+// Tokenizer.
+func BuildFromTokens(tr xml.TokenReader) (Document, error) {
+	opts := NewParseOptions()
+	b := newBuilder(opts)
+	if err := newTokenSAXReader(tr, b, opts).run(); err != nil {
+		return nil, err
+	}
+	return b.d, nil
+}
+
+// a tokenReaderSource reads the tokens of an xml.TokenReader for a
+// saxReader, turning names with namespace URIs back into names with
+// prefixes. It knows nothing of positions in the input.
+type tokenReaderSource struct {
+	nsScope
+	tr    xml.TokenReader
+	marks []int // len(scope) when each open element started
+	eof   bool
+}
+
+func (s *tokenReaderSource) InputPos() (line, column int) {
+	return 0, 0
+}
+
+func (s *tokenReaderSource) InputOffset() int64 {
+	return 0
+}
+
+func (s *tokenReaderSource) Token() (xml.Token, error) {
+	if s.eof {
+		return nil, io.EOF
+	}
+	t, err := s.tr.Token()
+	if t != nil && err == io.EOF {
+		// a TokenReader may return the last token along with io.EOF
+		s.eof, err = true, nil
+	}
+	switch token := t.(type) {
+	case xml.StartElement:
+		t = s.start(token.Copy())
+	case xml.EndElement:
+		if len(s.marks) > 0 {
+			s.scope = s.scope[:s.marks[len(s.marks)-1]]
+			s.marks = s.marks[:len(s.marks)-1]
+		}
+	}
+	return t, err
+}
+
+func (s *tokenReaderSource) start(t xml.StartElement) xml.StartElement {
+	s.marks = append(s.marks, len(s.scope))
+	for _, a := range t.Attr {
+		if a.Name.Space == "xmlns" {
+			s.scope = append(s.scope, nsBinding{a.Name.Local, a.Value})
+		} else if a.Name.Space == "" && a.Name.Local == "xmlns" {
+			s.scope = append(s.scope, nsBinding{"", a.Value})
+		}
+	}
+	var decls []nsBinding
+	t.Name.Space = s.prefix(t.Name.Space, false, &decls)
+	for i := range t.Attr {
+		if n := &t.Attr[i].Name; n.Space != "" && n.Space != "xmlns" {
+			n.Space = s.prefix(n.Space, true, &decls)
+		}
+	}
+	for _, d := range decls {
+		t.Attr = append(t.Attr, xml.Attr{Name: xml.Name{Space: "xmlns", Local: d.prefix}, Value: d.uri})
+	}
+	return t
+}
+
+// prefix returns the prefix for space, which is either a prefix in
+// scope or a namespace URI.
+func (s *tokenReaderSource) prefix(space string, attr bool, decls *[]nsBinding) string {
+	switch space {
+	case "", "xml", "xmlns":
+		return space
+	case xmlURL:
+		return "xml"
+	}
+	if _, ok := s.lookup(space); ok {
+		return space
+	}
+	if uri, _ := s.lookup(""); !attr && uri == space {
+		return ""
+	}
+	return s.prefixFor(space, decls)
+}
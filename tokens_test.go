@@ -0,0 +1,128 @@
+package dom
+
+import (
+  "encoding/xml"
+  "io"
+  "strings"
+  "testing"
+)
+
+func TestNewTokenReaderDecode(t *testing.T) {
+  d, _ := ParseString(`<feed xmlns="urn:feed" xmlns:x="urn:x"><entry x:id="1"><title>a &amp; b</title><!--c--></entry><entry x:id="2"><title><![CDATA[<c>]]></title></entry></feed>`)
+  var v struct {
+    Entries []struct {
+      Id    string `xml:"urn:x id,attr"`
+      Title string `xml:"urn:feed title"`
+    } `xml:"urn:feed entry"`
+  }
+  if err := xml.NewTokenDecoder(NewTokenReader(d)).Decode(&v); err != nil {
+    t.Fatalf("Decode failed: %v", err)
+  }
+  if (len(v.Entries) != 2 || v.Entries[0].Id != "1" || v.Entries[0].Title != "a & b" || v.Entries[1].Title != "<c>") {
+    t.Errorf("Decode gave %+v", v)
+  }
+
+  // a subtree takes the declarations it needs along
+  var e struct {
+    Id string `xml:"urn:x id,attr"`
+  }
+  entry := d.DocumentElement().FirstChild().NextSibling()
+  if err := xml.NewTokenDecoder(NewTokenReader(entry)).Decode(&e); err != nil || e.Id != "2" {
+    t.Errorf("Decode of a subtree gave %+v, %v", e, err)
+  }
+}
+
+func TestNewTokenReaderTokens(t *testing.T) {
+  d, _ := ParseString(`<a xmlns:p="urn:p"><p:b p:c="1">t</p:b><?pi x?></a>`)
+  tr := NewTokenReader(d)
+  var got []string
+  for {
+    tok, err := tr.Token()
+    if err == io.EOF {
+      break
+    }
+    switch tok := tok.(type) {
+    case xml.StartElement:
+      s := "start:" + qualifiedName(tok.Name)
+      for _, a := range tok.Attr {
+        s += " " + qualifiedName(a.Name) + "=" + a.Value
+      }
+      got = append(got, s)
+    case xml.EndElement:
+      got = append(got, "end:"+qualifiedName(tok.Name))
+    case xml.CharData:
+      got = append(got, "text:"+string(tok))
+    case xml.ProcInst:
+      got = append(got, "pi:"+tok.Target)
+    }
+  }
+  want := "start:a xmlns:p=urn:p|start:p:b p:c=1|text:t|end:p:b|pi:pi|end:a"
+  if (strings.Join(got, "|") != want) {
+    t.Errorf("NewTokenReader gave %v", got)
+  }
+}
+
+// a renamer changes the local names of the elements of a decoder
+type renamer struct {
+  d *xml.Decoder
+}
+
+func (r renamer) Token() (xml.Token, error) {
+  tok, err := r.d.Token()
+  switch t := tok.(type) {
+  case xml.StartElement:
+    t.Name.Local = strings.ToUpper(t.Name.Local)
+    return t, err
+  case xml.EndElement:
+    t.Name.Local = strings.ToUpper(t.Name.Local)
+    return t, err
+  }
+  return tok, err
+}
+
+func TestBuildFromTokens(t *testing.T) {
+  in := `<a xmlns="urn:a" xmlns:p="urn:p"><b p:c="1">t</b><p:d/></a>`
+  d, err := BuildFromTokens(renamer{xml.NewDecoder(strings.NewReader(in))})
+  if err != nil {
+    t.Fatalf("BuildFromTokens failed: %v", err)
+  }
+  if s := ToXml(d); (s != `<A xmlns="urn:a" xmlns:p="urn:p"><B p:c="1">t</B><p:D></p:D></A>`) {
+    t.Errorf("BuildFromTokens built %s", s)
+  }
+  b := d.DocumentElement().FirstChild().(Element)
+  if (b.NamespaceURI() != "urn:a" || b.GetAttributeNS("urn:p", "c") != "1") {
+    t.Errorf("BuildFromTokens lost the namespaces")
+  }
+
+  // and back again
+  again, err := BuildFromTokens(NewTokenReader(d))
+  if err != nil || ToXml(again) != ToXml(d) {
+    t.Errorf("Tokens of a document built %v, %v", again, err)
+  }
+
+  // namespace URIs that are not declared get a prefix
+  tokens := []xml.Token{
+    xml.StartElement{Name: xml.Name{Space: "urn:x", Local: "a"}, Attr: []xml.Attr{{Name: xml.Name{Space: "urn:y", Local: "b"}, Value: "1"}}},
+    xml.EndElement{Name: xml.Name{Space: "urn:x", Local: "a"}},
+  }
+  d, err = BuildFromTokens(&sliceTokens{tokens})
+  if err != nil {
+    t.Fatalf("BuildFromTokens failed: %v", err)
+  }
+  if r := d.DocumentElement(); (r.NamespaceURI() != "urn:x" || r.GetAttributeNS("urn:y", "b") != "1") {
+    t.Errorf("BuildFromTokens built %s", ToXml(d))
+  }
+}
+
+type sliceTokens struct {
+  tokens []xml.Token
+}
+
+func (s *sliceTokens) Token() (xml.Token, error) {
+  if len(s.tokens) == 0 {
+    return nil, io.EOF
+  }
+  t := s.tokens[0]
+  s.tokens = s.tokens[1:]
+  return t, nil
+}
@@ -0,0 +1,302 @@
+package dom
+
+/*
+ * The xml struct tags of Go types, read by the same rules as
+ * encoding/xml, for Unmarshal and Marshal.
+ *
+ * Adapted from encoding/xml/typeinfo.go of the Go distribution.
+ * Copyright 2011 The Go Authors. All rights reserved.
+ * Use of this source code is governed by a BSD-style license that can be
+ * found in the LICENSE.go-authors file.
+ */
+
+import (
+	"encoding/xml"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// a typeInfo holds how the fields of a struct type map to XML
+type typeInfo struct {
+	xmlname *fieldInfo
+	fields  []fieldInfo
+}
+
+// a fieldInfo holds how a single field maps to XML
+type fieldInfo struct {
+	idx     []int
+	name    string
+	xmlns   string
+	flags   fieldFlags
+	parents []string // the names on the path to name, for a>b>c
+}
+
+type fieldFlags int
+
+const (
+	fElement fieldFlags = 1 << iota
+	fAttr
+	fCDATA
+	fCharData
+	fInnerXML
+	fComment
+	fAny
+
+	fOmitEmpty
+
+	fMode = fElement | fAttr | fCDATA | fCharData | fInnerXML | fComment | fAny
+)
+
+var typeInfos sync.Map // map[reflect.Type]*typeInfo
+
+var (
+	nameType = reflect.TypeOf(xml.Name{})
+	attrType = reflect.TypeOf(xml.Attr{})
+)
+
+// getTypeInfo returns the typeInfo of typ, which is worked out once.
+func getTypeInfo(typ reflect.Type) (*typeInfo, error) {
+	if ti, ok := typeInfos.Load(typ); ok {
+		return ti.(*typeInfo), nil
+	}
+	tinfo := &typeInfo{}
+	if typ.Kind() == reflect.Struct && typ != nameType {
+		for i := 0; i < typ.NumField(); i++ {
+			f := typ.Field(i)
+			if (!f.IsExported() && !f.Anonymous) || f.Tag.Get("xml") == "-" {
+				continue
+			}
+
+			// the fields of embedded structs are promoted
+			if f.Anonymous {
+				t := f.Type
+				if t.Kind() == reflect.Pointer {
+					t = t.Elem()
+				}
+				if t.Kind() == reflect.Struct {
+					inner, err := getTypeInfo(t)
+					if err != nil {
+						return nil, err
+					}
+					if tinfo.xmlname == nil {
+						tinfo.xmlname = inner.xmlname
+					}
+					for _, finfo := range inner.fields {
+						finfo.idx = append([]int{i}, finfo.idx...)
+						if err := addFieldInfo(typ, tinfo, &finfo); err != nil {
+							return nil, err
+						}
+					}
+					continue
+				}
+			}
+
+			finfo, err := structFieldInfo(typ, &f)
+			if err != nil {
+				return nil, err
+			}
+			if f.Name == "XMLName" {
+				tinfo.xmlname = finfo
+				continue
+			}
+			if err := addFieldInfo(typ, tinfo, finfo); err != nil {
+				return nil, err
+			}
+		}
+	}
+	ti, _ := typeInfos.LoadOrStore(typ, tinfo)
+	return ti.(*typeInfo), nil
+}
+
+// structFieldInfo reads the tag of f.
+func structFieldInfo(typ reflect.Type, f *reflect.StructField) (*fieldInfo, error) {
+	finfo := &fieldInfo{idx: f.Index}
+	tag := f.Tag.Get("xml")
+	if ns, t, ok := strings.Cut(tag, " "); ok {
+		finfo.xmlns, tag = ns, t
+	}
+
+	tokens := strings.Split(tag, ",")
+	if len(tokens) == 1 {
+		finfo.flags = fElement
+	} else {
+		tag = tokens[0]
+		for _, flag := range tokens[1:] {
+			switch flag {
+			case "attr":
+				finfo.flags |= fAttr
+			case "cdata":
+				finfo.flags |= fCDATA
+			case "chardata":
+				finfo.flags |= fCharData
+			case "innerxml":
+				finfo.flags |= fInnerXML
+			case "comment":
+				finfo.flags |= fComment
+			case "any":
+				finfo.flags |= fAny
+			case "omitempty":
+				finfo.flags |= fOmitEmpty
+			}
+		}
+
+		valid := true
+		switch mode := finfo.flags & fMode; mode {
+		case 0:
+			finfo.flags |= fElement
+		case fAttr, fCDATA, fCharData, fInnerXML, fComment, fAny, fAny | fAttr:
+			if f.Name == "XMLName" || tag != "" && mode != fAttr {
+				valid = false
+			}
+		default:
+			// more than one mode
+			valid = false
+		}
+		if finfo.flags&fMode == fAny {
+			finfo.flags |= fElement
+		}
+		if finfo.flags&fOmitEmpty != 0 && finfo.flags&(fElement|fAttr) == 0 {
+			valid = false
+		}
+		if !valid {
+			return nil, fmt.Errorf("invalid tag in field %s of type %s: %q", f.Name, typ, f.Tag.Get("xml"))
+		}
+	}
+
+	if finfo.xmlns != "" && tag == "" {
+		return nil, fmt.Errorf("namespace without name in field %s of type %s: %q", f.Name, typ, f.Tag.Get("xml"))
+	}
+
+	if f.Name == "XMLName" {
+		finfo.name = tag
+		return finfo, nil
+	}
+
+	if tag == "" {
+		// the name comes from the XMLName of the field's type, or is
+		// the name of the field
+		if xmlname := lookupXMLName(f.Type); xmlname != nil {
+			finfo.xmlns, finfo.name = xmlname.xmlns, xmlname.name
+		} else {
+			finfo.name = f.Name
+		}
+		return finfo, nil
+	}
+
+	parents := strings.Split(tag, ">")
+	if parents[0] == "" {
+		parents[0] = f.Name
+	}
+	if parents[len(parents)-1] == "" {
+		return nil, fmt.Errorf("trailing '>' in field %s of type %s", f.Name, typ)
+	}
+	finfo.name = parents[len(parents)-1]
+	if len(parents) > 1 {
+		if finfo.flags&fElement == 0 {
+			return nil, fmt.Errorf("%s chain not valid with %s flag", tag, strings.Join(tokens[1:], ","))
+		}
+		finfo.parents = parents[:len(parents)-1]
+	}
+
+	// the name in the tag must agree with the XMLName of the field's type
+	if finfo.flags&fElement != 0 {
+		if xmlname := lookupXMLName(f.Type); xmlname != nil && xmlname.name != finfo.name {
+			return nil, fmt.Errorf("name %q in tag of %s.%s conflicts with name %q in %s.XMLName",
+				finfo.name, typ, f.Name, xmlname.name, f.Type)
+		}
+	}
+	return finfo, nil
+}
+
+// lookupXMLName returns the fieldInfo of the XMLName field of typ, if it
+// has one with a name.
+func lookupXMLName(typ reflect.Type) *fieldInfo {
+	for typ.Kind() == reflect.Pointer {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return nil
+	}
+	if f, ok := typ.FieldByName("XMLName"); ok && len(f.Index) == 1 {
+		// errors are left for getTypeInfo to report
+		if finfo, err := structFieldInfo(typ, &f); err == nil && finfo.name != "" {
+			return finfo
+		}
+	}
+	return nil
+}
+
+// addFieldInfo adds newf to the fields of tinfo unless its path conflicts
+// with that of a field less deeply embedded. Conflicting fields that are
+// embedded more deeply are dropped, and a conflict at the same depth is
+// an error.
+func addFieldInfo(typ reflect.Type, tinfo *typeInfo, newf *fieldInfo) error {
+	var conflicts []int
+Fields:
+	for i := range tinfo.fields {
+		oldf := &tinfo.fields[i]
+		if oldf.flags&fMode != newf.flags&fMode {
+			continue
+		}
+		if oldf.xmlns != "" && newf.xmlns != "" && oldf.xmlns != newf.xmlns {
+			continue
+		}
+		for p := 0; p < min(len(newf.parents), len(oldf.parents)); p++ {
+			if oldf.parents[p] != newf.parents[p] {
+				continue Fields
+			}
+		}
+		switch {
+		case len(oldf.parents) > len(newf.parents):
+			if oldf.parents[len(newf.parents)] == newf.name {
+				conflicts = append(conflicts, i)
+			}
+		case len(oldf.parents) < len(newf.parents):
+			if newf.parents[len(oldf.parents)] == oldf.name {
+				conflicts = append(conflicts, i)
+			}
+		default:
+			if newf.name == oldf.name && newf.xmlns == oldf.xmlns {
+				conflicts = append(conflicts, i)
+			}
+		}
+	}
+	if conflicts == nil {
+		tinfo.fields = append(tinfo.fields, *newf)
+		return nil
+	}
+	for _, i := range conflicts {
+		if len(tinfo.fields[i].idx) < len(newf.idx) {
+			return nil
+		}
+	}
+	for _, i := range conflicts {
+		if oldf := &tinfo.fields[i]; len(oldf.idx) == len(newf.idx) {
+			f1, f2 := typ.FieldByIndex(oldf.idx), typ.FieldByIndex(newf.idx)
+			return &xml.TagPathError{Struct: typ, Field1: f1.Name, Tag1: f1.Tag.Get("xml"), Field2: f2.Name, Tag2: f2.Tag.Get("xml")}
+		}
+	}
+	for c := len(conflicts) - 1; c >= 0; c-- {
+		i := conflicts[c]
+		tinfo.fields = append(tinfo.fields[:i], tinfo.fields[i+1:]...)
+	}
+	tinfo.fields = append(tinfo.fields, *newf)
+	return nil
+}
+
+// value returns the field of v that finfo is about, making pointers to
+// embedded structs on the way.
+func (finfo *fieldInfo) value(v reflect.Value) reflect.Value {
+	for i, x := range finfo.idx {
+		if i > 0 && v.Kind() == reflect.Pointer && v.Type().Elem().Kind() == reflect.Struct {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
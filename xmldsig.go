@@ -0,0 +1,546 @@
+package dom
+
+/*
+ * XML Signature
+ * http://www.w3.org/TR/xmldsig-core1/
+ *
+ * Signatures are made and checked over the tree itself. Only references
+ * within the same document are supported.
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"math/big"
+	"strings"
+)
+
+// The namespace of XML Signature and the URIs of the algorithms it can
+// use.
+const (
+	DSigNamespace = "http://www.w3.org/2000/09/xmldsig#"
+
+	C14N10URI                      = "http://www.w3.org/TR/2001/REC-xml-c14n-20010315"
+	C14N10WithCommentsURI          = C14N10URI + "#WithComments"
+	C14N11URI                      = "http://www.w3.org/2006/12/xml-c14n11"
+	C14N11WithCommentsURI          = C14N11URI + "#WithComments"
+	ExclusiveC14NURI               = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	ExclusiveC14NWithCommentsURI   = ExclusiveC14NURI + "WithComments"
+	EnvelopedSignatureTransformURI = DSigNamespace + "enveloped-signature"
+
+	SHA256URI = "http://www.w3.org/2001/04/xmlenc#sha256"
+	SHA512URI = "http://www.w3.org/2001/04/xmlenc#sha512"
+
+	RSASHA256URI   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	RSASHA512URI   = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha512"
+	ECDSASHA256URI = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha256"
+	ECDSASHA512URI = "http://www.w3.org/2001/04/xmldsig-more#ecdsa-sha512"
+	HMACSHA256URI  = "http://www.w3.org/2001/04/xmldsig-more#hmac-sha256"
+	HMACSHA512URI  = "http://www.w3.org/2001/04/xmldsig-more#hmac-sha512"
+)
+
+// ErrSignature is wrapped by the errors Verify returns for signatures
+// that do not hold.
+var ErrSignature = errors.New("signature does not verify")
+
+// C14NOptionsFor returns the options for the canonicalization method
+// identified by uri.
+func C14NOptionsFor(uri string) (*C14NOptions, error) {
+	switch uri {
+	case C14N10URI:
+		return &C14NOptions{Method: C14N10}, nil
+	case C14N10WithCommentsURI:
+		return &C14NOptions{Method: C14N10, WithComments: true}, nil
+	case C14N11URI:
+		return &C14NOptions{Method: C14N11}, nil
+	case C14N11WithCommentsURI:
+		return &C14NOptions{Method: C14N11, WithComments: true}, nil
+	case ExclusiveC14NURI:
+		return &C14NOptions{Method: ExclusiveC14N}, nil
+	case ExclusiveC14NWithCommentsURI:
+		return &C14NOptions{Method: ExclusiveC14N, WithComments: true}, nil
+	}
+	return nil, fmt.Errorf("unsupported canonicalization method %s", uri)
+}
+
+// SignOptions choose how Sign signs.
+type SignOptions struct {
+	// Canonicalization is the URI of the canonicalization method of both
+	// the SignedInfo and the reference. It is ExclusiveC14NURI if empty.
+	Canonicalization string
+
+	// SignatureMethod is the URI of the signature method. If it is empty,
+	// it is the SHA-256 method for the kind of key.
+	SignatureMethod string
+
+	// DigestMethod is the URI of the digest method of the reference. It
+	// is SHA256URI if empty.
+	DigestMethod string
+
+	// Detached leaves the Signature out of the signed element, for the
+	// caller to put in the document; it references the element by its
+	// ID. Otherwise the Signature becomes the last child of the element.
+	// With the inclusive canonicalization methods, a detached Signature
+	// must go where it inherits no namespaces or xml: attributes, as
+	// these are part of the canonical form of the SignedInfo.
+	Detached bool
+
+	// KeyName, if it is not empty, goes into the KeyInfo of the
+	// Signature.
+	KeyName string
+}
+
+// Sign signs e with key, which is an *rsa.PrivateKey, an
+// *ecdsa.PrivateKey or, for HMAC, a []byte. It returns the ds:Signature
+// element. The reference is to the whole document if e is its document
+// element and the signature is enveloped, and otherwise to the ID, Id or
+// id attribute of e. A nil opts stands for the defaults.
+func Sign(e Element, key crypto.PrivateKey, opts *SignOptions) (Element, error) {
+	if opts == nil {
+		opts = &SignOptions{}
+	}
+	doc := e.OwnerDocument()
+	if doc == nil {
+		return nil, errors.New("element to sign is not in a document")
+	}
+	c14n := opts.Canonicalization
+	if c14n == "" {
+		c14n = ExclusiveC14NURI
+	}
+	c14nOpts, err := C14NOptionsFor(c14n)
+	if err != nil {
+		return nil, err
+	}
+	method := opts.SignatureMethod
+	if method == "" {
+		switch key.(type) {
+		case *rsa.PrivateKey:
+			method = RSASHA256URI
+		case *ecdsa.PrivateKey:
+			method = ECDSASHA256URI
+		case []byte:
+			method = HMACSHA256URI
+		default:
+			return nil, fmt.Errorf("unsupported key type %T", key)
+		}
+	}
+	digestMethod := opts.DigestMethod
+	if digestMethod == "" {
+		digestMethod = SHA256URI
+	}
+	newDigest, err := digestFor(digestMethod)
+	if err != nil {
+		return nil, err
+	}
+
+	uri := ""
+	if opts.Detached || e != doc.DocumentElement() {
+		id, _ := elementID(e)
+		if id == "" {
+			return nil, errors.New("element to sign has no ID attribute")
+		}
+		uri = "#" + id
+	}
+
+	ds := func(parent Element, name string) Element {
+		c := doc.CreateElementNS(DSigNamespace, "ds:"+name)
+		if parent != nil {
+			parent.AppendChild(c)
+		}
+		return c
+	}
+	withAlgorithm := func(parent Element, name, uri string) Element {
+		c := ds(parent, name)
+		c.SetAttribute("Algorithm", uri)
+		return c
+	}
+	sig := ds(nil, "Signature")
+	sig.SetAttributeNS(xmlnsURL, "xmlns:ds", DSigNamespace)
+	signedInfo := ds(sig, "SignedInfo")
+	withAlgorithm(signedInfo, "CanonicalizationMethod", c14n)
+	withAlgorithm(signedInfo, "SignatureMethod", method)
+	ref := ds(signedInfo, "Reference")
+	ref.SetAttribute("URI", uri)
+	transforms := ds(ref, "Transforms")
+	if !opts.Detached {
+		withAlgorithm(transforms, "Transform", EnvelopedSignatureTransformURI)
+	}
+	withAlgorithm(transforms, "Transform", c14n)
+	withAlgorithm(ref, "DigestMethod", digestMethod)
+	digestValue := ds(ref, "DigestValue")
+	signatureValue := ds(sig, "SignatureValue")
+	if opts.KeyName != "" {
+		ds(ds(sig, "KeyInfo"), "KeyName").AppendChild(doc.CreateTextNode(opts.KeyName))
+	}
+
+	h := newDigest()
+	if err := canonicalizeSubset(h, e, referenceSet(e, nil), c14nOpts); err != nil {
+		return nil, err
+	}
+	digestValue.AppendChild(doc.CreateTextNode(base64.StdEncoding.EncodeToString(h.Sum(nil))))
+
+	// the SignedInfo is canonicalized where it ends up
+	if !opts.Detached {
+		e.AppendChild(sig)
+	}
+	var b bytes.Buffer
+	err = Canonicalize(&b, signedInfo, c14nOpts)
+	var value []byte
+	if err == nil {
+		value, err = signBytes(method, key, b.Bytes())
+	}
+	if err != nil {
+		if !opts.Detached {
+			e.RemoveChild(sig)
+		}
+		return nil, err
+	}
+	signatureValue.AppendChild(doc.CreateTextNode(base64.StdEncoding.EncodeToString(value)))
+	return sig, nil
+}
+
+// A KeySelector returns the key that checks a signature made with the
+// signature method identified by method: an *rsa.PublicKey, an
+// *ecdsa.PublicKey or, for HMAC, a []byte. keyInfo is the ds:KeyInfo
+// element of the signature, or nil if it has none. The KeyInfo is not
+// covered by the signature, so a KeySelector must not trust a key just
+// because it is found there.
+type KeySelector func(keyInfo Element, method string) (crypto.PublicKey, error)
+
+// FixedKey returns a KeySelector that always selects key.
+func FixedKey(key crypto.PublicKey) KeySelector {
+	return func(Element, string) (crypto.PublicKey, error) {
+		return key, nil
+	}
+}
+
+// Verify checks the ds:Signature element sig with the key keys selects
+// and the digests of all its references. It returns the elements the
+// references are to, which are all that the signature covers: the
+// document element for a reference to the whole document. Errors for
+// signatures that do not hold wrap ErrSignature.
+func Verify(sig Element, keys KeySelector) ([]Element, error) {
+	if sig.NamespaceURI() != DSigNamespace || sig.LocalName() != "Signature" {
+		return nil, errors.New("not a ds:Signature element")
+	}
+	signedInfo := dsChild(sig, "SignedInfo")
+	signatureValue := dsChild(sig, "SignatureValue")
+	if signedInfo == nil || signatureValue == nil {
+		return nil, errors.New("ds:Signature lacks SignedInfo or SignatureValue")
+	}
+	c14nMethod := dsChild(signedInfo, "CanonicalizationMethod")
+	sigMethod := dsChild(signedInfo, "SignatureMethod")
+	if c14nMethod == nil || sigMethod == nil {
+		return nil, errors.New("ds:SignedInfo lacks CanonicalizationMethod or SignatureMethod")
+	}
+	c14nOpts, err := transformOptions(c14nMethod)
+	if err != nil {
+		return nil, err
+	}
+	method := sigMethod.GetAttribute("Algorithm")
+	key, err := keys(dsChild(sig, "KeyInfo"), method)
+	if err != nil {
+		return nil, err
+	}
+	value, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(textOf(signatureValue)), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad SignatureValue: %v", ErrSignature, err)
+	}
+	var b bytes.Buffer
+	if err := Canonicalize(&b, signedInfo, c14nOpts); err != nil {
+		return nil, err
+	}
+	if err := verifyBytes(method, key, b.Bytes(), value); err != nil {
+		return nil, err
+	}
+
+	var signed []Element
+	for c := signedInfo.FirstChild(); c != nil; c = c.NextSibling() {
+		if ref, ok := c.(Element); ok && ref.NamespaceURI() == DSigNamespace && ref.LocalName() == "Reference" {
+			e, err := verifyReference(sig, ref)
+			if err != nil {
+				return nil, err
+			}
+			signed = append(signed, e)
+		}
+	}
+	if len(signed) == 0 {
+		return nil, errors.New("ds:SignedInfo has no Reference")
+	}
+	return signed, nil
+}
+
+// verifyReference checks the digest of ref, returning the element it is
+// to.
+func verifyReference(sig, ref Element) (Element, error) {
+	uri := ref.GetAttribute("URI")
+	doc := ownerDocument(sig)
+	if doc == nil {
+		return nil, errors.New("signature is not in a document")
+	}
+	var target Element
+	switch {
+	case uri == "":
+		target = doc.DocumentElement()
+	case strings.HasPrefix(uri, "#"):
+		var err error
+		if target, err = findID(doc, uri[1:]); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported reference URI %q", uri)
+	}
+
+	inSet := referenceSet(target, nil)
+	c14nOpts := &C14NOptions{}
+	if transforms := dsChild(ref, "Transforms"); transforms != nil {
+		for c := transforms.FirstChild(); c != nil; c = c.NextSibling() {
+			t, ok := c.(Element)
+			if !ok || t.NamespaceURI() != DSigNamespace || t.LocalName() != "Transform" {
+				continue
+			}
+			if t.GetAttribute("Algorithm") == EnvelopedSignatureTransformURI {
+				inSet = referenceSet(target, sig)
+				continue
+			}
+			opts, err := transformOptions(t)
+			if err != nil {
+				return nil, err
+			}
+			c14nOpts = opts
+		}
+	}
+	digestMethod, digestValue := dsChild(ref, "DigestMethod"), dsChild(ref, "DigestValue")
+	if digestMethod == nil || digestValue == nil {
+		return nil, errors.New("ds:Reference lacks DigestMethod or DigestValue")
+	}
+	newDigest, err := digestFor(digestMethod.GetAttribute("Algorithm"))
+	if err != nil {
+		return nil, err
+	}
+	h := newDigest()
+	if err := canonicalizeSubset(h, target, inSet, c14nOpts); err != nil {
+		return nil, err
+	}
+	want, err := base64.StdEncoding.DecodeString(strings.TrimSpace(textOf(digestValue)))
+	if err != nil || subtle.ConstantTimeCompare(h.Sum(nil), want) != 1 {
+		return nil, fmt.Errorf("%w: digest of reference %q differs", ErrSignature, uri)
+	}
+	return target, nil
+}
+
+// referenceSet returns the node-set of a reference to e: e and what is
+// below it but comments, and without the subtree of sig if it is not nil.
+func referenceSet(e, sig Node) func(Node) bool {
+	return func(n Node) bool {
+		if n.NodeType() == COMMENT_NODE {
+			return false
+		}
+		for ; n != nil; n = parentOrOwner(n) {
+			if n == sig {
+				return false
+			}
+			if n == e {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// parentOrOwner returns the parent of n, or its element if n is an
+// attribute.
+func parentOrOwner(n Node) Node {
+	if a, ok := n.(Attr); ok {
+		if e := a.OwnerElement(); e != nil {
+			return e
+		}
+		return nil
+	}
+	return n.ParentNode()
+}
+
+// transformOptions returns the canonicalization options of the Algorithm
+// of t, with the PrefixList of an InclusiveNamespaces child.
+func transformOptions(t Element) (*C14NOptions, error) {
+	opts, err := C14NOptionsFor(t.GetAttribute("Algorithm"))
+	if err != nil {
+		return nil, err
+	}
+	if opts.Method == ExclusiveC14N {
+		for c := t.FirstChild(); c != nil; c = c.NextSibling() {
+			if e, ok := c.(Element); ok && e.NamespaceURI() == ExclusiveC14NURI && e.LocalName() == "InclusiveNamespaces" {
+				opts.InclusiveNamespaces = strings.Fields(e.GetAttribute("PrefixList"))
+			}
+		}
+	}
+	return opts, nil
+}
+
+// elementID returns the value of the ID, Id or id attribute of e.
+func elementID(e Element) (string, bool) {
+	for _, name := range []string{"ID", "Id", "id"} {
+		if e.HasAttribute(name) {
+			return e.GetAttribute(name), true
+		}
+	}
+	return "", false
+}
+
+// findID returns the one element of d whose ID, Id or id is id. More than
+// one such element is an error, as a signature could be made to cover
+// the wrong one.
+func findID(d Document, id string) (Element, error) {
+	var found []Element
+	walkTreeDepthFirst(d, func(n Node) bool {
+		if e, ok := n.(Element); ok {
+			if v, ok := elementID(e); ok && v == id {
+				found = append(found, e)
+			}
+		}
+		return true
+	})
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no element with ID %q", id)
+	case 1:
+		return found[0], nil
+	}
+	return nil, fmt.Errorf("%w: more than one element with ID %q", ErrSignature, id)
+}
+
+// dsChild returns the first child of e that is a ds element called name.
+func dsChild(e Element, name string) Element {
+	for c := e.FirstChild(); c != nil; c = c.NextSibling() {
+		if c, ok := c.(Element); ok && c.NamespaceURI() == DSigNamespace && c.LocalName() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// textOf returns the text of the children of e.
+func textOf(e Element) string {
+	var b strings.Builder
+	for _, c := range contentOf(e) {
+		switch c.NodeType() {
+		case TEXT_NODE, CDATA_SECTION_NODE:
+			b.WriteString(c.NodeValue())
+		}
+	}
+	return b.String()
+}
+
+func digestFor(uri string) (func() hash.Hash, error) {
+	switch uri {
+	case SHA256URI:
+		return sha256.New, nil
+	case SHA512URI:
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported digest method %s", uri)
+}
+
+// signatureHash returns the hash that the signature method uri uses.
+func signatureHash(uri string) (crypto.Hash, error) {
+	switch uri {
+	case RSASHA256URI, ECDSASHA256URI, HMACSHA256URI:
+		return crypto.SHA256, nil
+	case RSASHA512URI, ECDSASHA512URI, HMACSHA512URI:
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported signature method %s", uri)
+}
+
+func signBytes(method string, key crypto.PrivateKey, data []byte) ([]byte, error) {
+	alg, err := signatureHash(method)
+	if err != nil {
+		return nil, err
+	}
+	h := alg.New()
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		if method != RSASHA256URI && method != RSASHA512URI {
+			break
+		}
+		h.Write(data)
+		return rsa.SignPKCS1v15(rand.Reader, key, alg, h.Sum(nil))
+	case *ecdsa.PrivateKey:
+		if method != ECDSASHA256URI && method != ECDSASHA512URI {
+			break
+		}
+		h.Write(data)
+		r, s, err := ecdsa.Sign(rand.Reader, key, h.Sum(nil))
+		if err != nil {
+			return nil, err
+		}
+		// r and s side by side, each as long as the order of the curve
+		size := (key.Curve.Params().N.BitLen() + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	case []byte:
+		if method != HMACSHA256URI && method != HMACSHA512URI {
+			break
+		}
+		mac := hmac.New(alg.New, key)
+		mac.Write(data)
+		return mac.Sum(nil), nil
+	}
+	return nil, fmt.Errorf("key of type %T does not go with signature method %s", key, method)
+}
+
+func verifyBytes(method string, key crypto.PublicKey, data, sig []byte) error {
+	alg, err := signatureHash(method)
+	if err != nil {
+		return err
+	}
+	h := alg.New()
+	h.Write(data)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if method != RSASHA256URI && method != RSASHA512URI {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(key, alg, h.Sum(nil), sig); err != nil {
+			return fmt.Errorf("%w: %v", ErrSignature, err)
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if method != ECDSASHA256URI && method != ECDSASHA512URI {
+			break
+		}
+		size := (key.Curve.Params().N.BitLen() + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("%w: ECDSA signature has the wrong length", ErrSignature)
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, h.Sum(nil), r, s) {
+			return ErrSignature
+		}
+		return nil
+	case []byte:
+		if method != HMACSHA256URI && method != HMACSHA512URI {
+			break
+		}
+		mac := hmac.New(alg.New, key)
+		mac.Write(data)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return ErrSignature
+		}
+		return nil
+	}
+	return fmt.Errorf("key of type %T does not go with signature method %s", key, method)
+}
@@ -0,0 +1,350 @@
+package dom
+
+/*
+ * XML Encryption
+ * http://www.w3.org/TR/xmlenc-core1/
+ *
+ * Elements, or their content, are replaced by EncryptedData elements in
+ * place, and put back by parsing the decrypted text as a fragment.
+ */
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// The namespace of XML Encryption, the types of EncryptedData and the
+// URIs of the algorithms it can use.
+const (
+	XEncNamespace = "http://www.w3.org/2001/04/xmlenc#"
+
+	EncryptedElementType = XEncNamespace + "Element"
+	EncryptedContentType = XEncNamespace + "Content"
+
+	AES128CBCURI = XEncNamespace + "aes128-cbc"
+	AES192CBCURI = XEncNamespace + "aes192-cbc"
+	AES256CBCURI = XEncNamespace + "aes256-cbc"
+	AES128GCMURI = "http://www.w3.org/2009/xmlenc11#aes128-gcm"
+	AES192GCMURI = "http://www.w3.org/2009/xmlenc11#aes192-gcm"
+	AES256GCMURI = "http://www.w3.org/2009/xmlenc11#aes256-gcm"
+
+	// RSA-OAEP with SHA-1 for both the digest and the mask
+	RSAOAEPURI = XEncNamespace + "rsa-oaep-mgf1p"
+)
+
+// EncryptOptions choose how Encrypt encrypts.
+type EncryptOptions struct {
+	// Algorithm is the URI of the block cipher. It is AES-GCM with the
+	// size of a shared key, or AES256GCMURI with key transport, if empty.
+	Algorithm string
+
+	// Content encrypts the children of the element instead of the
+	// element itself.
+	Content bool
+
+	// KeyName, if it is not empty, goes into the KeyInfo of the
+	// EncryptedData.
+	KeyName string
+}
+
+// Encrypt replaces e, or its content, with an xenc:EncryptedData element
+// holding it encrypted, and returns that element. key is either a []byte
+// shared with the recipient, which must suit the algorithm, or an
+// *rsa.PublicKey of the recipient, in which case a fresh key encrypts
+// the data and goes along in an xenc:EncryptedKey, encrypted with
+// RSA-OAEP. Namespaces declared above e and used in what is encrypted are
+// declared in the encrypted text. A nil opts stands for the defaults.
+func Encrypt(e Element, key crypto.PublicKey, opts *EncryptOptions) (Element, error) {
+	if opts == nil {
+		opts = &EncryptOptions{}
+	}
+	alg := opts.Algorithm
+	if alg == "" {
+		alg = AES256GCMURI
+		if k, ok := key.([]byte); ok {
+			switch len(k) {
+			case 16:
+				alg = AES128GCMURI
+			case 24:
+				alg = AES192GCMURI
+			}
+		}
+	}
+	keySize, _, err := blockCipher(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	var cek, wrapped []byte
+	switch key := key.(type) {
+	case []byte:
+		cek = key
+	case *rsa.PublicKey:
+		cek = make([]byte, keySize)
+		if _, err := rand.Read(cek); err != nil {
+			return nil, err
+		}
+		if wrapped, err = rsa.EncryptOAEP(sha1.New(), rand.Reader, key, cek, nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+	if len(cek) != keySize {
+		return nil, fmt.Errorf("%s needs a key of %d bytes", alg, keySize)
+	}
+
+	var plain bytes.Buffer
+	typ := EncryptedElementType
+	if opts.Content {
+		typ = EncryptedContentType
+		for c := e.FirstChild(); c != nil; c = c.NextSibling() {
+			// each child gets the declarations it needs
+			if err := Serialize(&plain, c); err != nil {
+				return nil, err
+			}
+		}
+	} else if err := Serialize(&plain, e); err != nil {
+		return nil, err
+	}
+	cipherText, err := encryptBytes(alg, cek, plain.Bytes())
+	if err != nil {
+		return nil, err
+	}
+
+	doc := ownerDocument(e)
+	if doc == nil {
+		return nil, errors.New("element to encrypt is not in a document")
+	}
+	xenc := func(parent Element, name string) Element {
+		c := doc.CreateElementNS(XEncNamespace, "xenc:"+name)
+		if parent != nil {
+			parent.AppendChild(c)
+		}
+		return c
+	}
+	cipherData := func(parent Element, data []byte) {
+		xenc(xenc(parent, "CipherData"), "CipherValue").AppendChild(doc.CreateTextNode(base64.StdEncoding.EncodeToString(data)))
+	}
+	ed := xenc(nil, "EncryptedData")
+	ed.SetAttributeNS(xmlnsURL, "xmlns:xenc", XEncNamespace)
+	ed.SetAttribute("Type", typ)
+	xenc(ed, "EncryptionMethod").SetAttribute("Algorithm", alg)
+	if opts.KeyName != "" || wrapped != nil {
+		keyInfo := doc.CreateElementNS(DSigNamespace, "ds:KeyInfo")
+		keyInfo.SetAttributeNS(xmlnsURL, "xmlns:ds", DSigNamespace)
+		ed.AppendChild(keyInfo)
+		if opts.KeyName != "" {
+			name := doc.CreateElementNS(DSigNamespace, "ds:KeyName")
+			name.AppendChild(doc.CreateTextNode(opts.KeyName))
+			keyInfo.AppendChild(name)
+		}
+		if wrapped != nil {
+			ek := xenc(keyInfo, "EncryptedKey")
+			xenc(ek, "EncryptionMethod").SetAttribute("Algorithm", RSAOAEPURI)
+			cipherData(ek, wrapped)
+		}
+	}
+	cipherData(ed, cipherText)
+
+	if opts.Content {
+		for c := e.FirstChild(); c != nil; c = e.FirstChild() {
+			e.RemoveChild(c)
+		}
+		e.AppendChild(ed)
+	} else {
+		e.ParentNode().ReplaceChild(ed, e)
+	}
+	return ed, nil
+}
+
+// Decrypt decrypts the xenc:EncryptedData element ed with key, and puts
+// the nodes parsed from the plaintext in its place, with the namespaces
+// in scope at its parent. key is the shared []byte key or the
+// *rsa.PrivateKey that decrypts the xenc:EncryptedKey in the KeyInfo of
+// ed. It returns the nodes put in place.
+func Decrypt(ed Element, key crypto.PrivateKey) ([]Node, error) {
+	if ed.NamespaceURI() != XEncNamespace || ed.LocalName() != "EncryptedData" {
+		return nil, errors.New("not an xenc:EncryptedData element")
+	}
+	parent := ed.ParentNode()
+	if parent == nil {
+		return nil, ErrNoParent
+	}
+	method := xencChild(ed, "EncryptionMethod")
+	if method == nil {
+		return nil, errors.New("xenc:EncryptedData lacks EncryptionMethod")
+	}
+	alg := method.GetAttribute("Algorithm")
+
+	var cek []byte
+	switch key := key.(type) {
+	case []byte:
+		cek = key
+	case *rsa.PrivateKey:
+		var ek Element
+		if keyInfo := childNS(ed, DSigNamespace, "KeyInfo"); keyInfo != nil {
+			ek = xencChild(keyInfo, "EncryptedKey")
+		}
+		if ek == nil {
+			return nil, errors.New("xenc:EncryptedData has no EncryptedKey")
+		}
+		if m := xencChild(ek, "EncryptionMethod"); m == nil || m.GetAttribute("Algorithm") != RSAOAEPURI {
+			return nil, errors.New("unsupported key transport algorithm")
+		}
+		wrapped, err := cipherValue(ek)
+		if err != nil {
+			return nil, err
+		}
+		if cek, err = rsa.DecryptOAEP(sha1.New(), nil, key, wrapped, nil); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", key)
+	}
+
+	data, err := cipherValue(ed)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := decryptBytes(alg, cek, data)
+	if err != nil {
+		return nil, err
+	}
+
+	context, _ := parent.(Element)
+	f, err := ParseFragment(context, bytes.NewReader(plain))
+	if err != nil {
+		return nil, err
+	}
+	var nodes []Node
+	for c := f.FirstChild(); c != nil; c = c.NextSibling() {
+		nodes = append(nodes, c)
+	}
+	parent.InsertBefore(f, ed)
+	parent.RemoveChild(ed)
+	return nodes, nil
+}
+
+// childNS returns the first child of e that is an element called local
+// in the namespace uri.
+func childNS(e Element, uri, local string) Element {
+	for c := e.FirstChild(); c != nil; c = c.NextSibling() {
+		if c, ok := c.(Element); ok && c.NamespaceURI() == uri && c.LocalName() == local {
+			return c
+		}
+	}
+	return nil
+}
+
+func xencChild(e Element, local string) Element {
+	return childNS(e, XEncNamespace, local)
+}
+
+// cipherValue returns the decoded CipherData/CipherValue of e.
+func cipherValue(e Element) ([]byte, error) {
+	var value Element
+	if cd := xencChild(e, "CipherData"); cd != nil {
+		value = xencChild(cd, "CipherValue")
+	}
+	if value == nil {
+		return nil, errors.New(e.NodeName() + " lacks CipherData/CipherValue")
+	}
+	return base64.StdEncoding.DecodeString(strings.Join(strings.Fields(textOf(value)), ""))
+}
+
+// blockCipher returns the key size of the block cipher identified by uri
+// and whether it is used in GCM mode.
+func blockCipher(uri string) (keySize int, gcm bool, err error) {
+	switch uri {
+	case AES128CBCURI:
+		return 16, false, nil
+	case AES192CBCURI:
+		return 24, false, nil
+	case AES256CBCURI:
+		return 32, false, nil
+	case AES128GCMURI:
+		return 16, true, nil
+	case AES192GCMURI:
+		return 24, true, nil
+	case AES256GCMURI:
+		return 32, true, nil
+	}
+	return 0, false, fmt.Errorf("unsupported encryption method %s", uri)
+}
+
+// encryptBytes encrypts plain with the block cipher uri. The IV comes
+// first in the output.
+func encryptBytes(uri string, key, plain []byte) ([]byte, error) {
+	_, gcm, err := blockCipher(uri)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if gcm {
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		iv := make([]byte, aead.NonceSize())
+		if _, err := rand.Read(iv); err != nil {
+			return nil, err
+		}
+		return aead.Seal(iv, iv, plain, nil), nil
+	}
+	// the last byte of the padding tells its length
+	pad := aes.BlockSize - len(plain)%aes.BlockSize
+	out := make([]byte, aes.BlockSize+len(plain)+pad)
+	iv := out[:aes.BlockSize]
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	copy(out[aes.BlockSize:], plain)
+	out[len(out)-1] = byte(pad)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(out[aes.BlockSize:], out[aes.BlockSize:])
+	return out, nil
+}
+
+// decryptBytes undoes encryptBytes.
+func decryptBytes(uri string, key, data []byte) ([]byte, error) {
+	_, gcm, err := blockCipher(uri)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	if gcm {
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < aead.NonceSize() {
+			return nil, errors.New("cipher text too short")
+		}
+		return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], nil)
+	}
+	if len(data) < 2*aes.BlockSize || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("cipher text is not a whole number of blocks")
+	}
+	plain := make([]byte, len(data)-aes.BlockSize)
+	cipher.NewCBCDecrypter(block, data[:aes.BlockSize]).CryptBlocks(plain, data[aes.BlockSize:])
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize {
+		return nil, errors.New("bad padding")
+	}
+	return plain[:len(plain)-pad], nil
+}
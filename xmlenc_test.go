@@ -0,0 +1,138 @@
+package dom
+
+import (
+  "bytes"
+  "strings"
+  "testing"
+)
+
+const encTestDoc = `<order xmlns:p="urn:pay"><item>book</item><p:card p:type="visa">4111 &amp; 1111</p:card></order>`
+
+// the decrypted card declares the namespace it was encrypted with
+const encTestDecrypted = `<?xml version="1.0"?><order xmlns:p="urn:pay"><item>book</item><p:card xmlns:p="urn:pay" p:type="visa">4111 &amp; 1111</p:card></order>`
+
+func TestEncryptElement(t *testing.T) {
+  key := []byte("0123456789abcdef0123456789abcdef")
+  for _, alg := range []string{"", AES128CBCURI, AES256CBCURI, AES256GCMURI} {
+    k := key
+    if (alg == AES128CBCURI) {
+      k = key[:16]
+    }
+    d, err := ParseString(encTestDoc)
+    if (err != nil) {
+      t.Fatalf("%v", err)
+    }
+    card := d.GetElementsByTagNameNS("urn:pay", "card").Item(0).(Element)
+    ed, err := Encrypt(card, k, &EncryptOptions{Algorithm: alg, KeyName: "shared"})
+    if (err != nil) {
+      t.Fatalf("%s: %v", alg, err)
+    }
+    out := toXml(d)
+    if (strings.Contains(out, "4111")) {
+      t.Errorf("%s: plaintext left in %s", alg, out)
+    }
+    if (ed.GetAttribute("Type") != EncryptedElementType) {
+      t.Errorf("%s: Type is %q", alg, ed.GetAttribute("Type"))
+    }
+
+    // decrypt after a round trip through text
+    d, err = ParseString(out)
+    if (err != nil) {
+      t.Fatalf("%v", err)
+    }
+    ed = d.GetElementsByTagNameNS(XEncNamespace, "EncryptedData").Item(0).(Element)
+    nodes, err := Decrypt(ed, k)
+    if (err != nil) {
+      t.Fatalf("%s: %v", alg, err)
+    }
+    if (len(nodes) != 1) {
+      t.Fatalf("%s: got %d nodes", alg, len(nodes))
+    }
+    if e, ok := nodes[0].(Element); !ok || e.NamespaceURI() != "urn:pay" || e.GetAttributeNS("urn:pay", "type") != "visa" {
+      t.Errorf("%s: got %s", alg, toXml(nodes[0]))
+    }
+    if got := toXml(d); got != encTestDecrypted {
+      t.Errorf("%s: got %s", alg, got)
+    }
+  }
+}
+
+func TestEncryptContent(t *testing.T) {
+  key := []byte("0123456789abcdef")
+  d, _ := ParseString(encTestDoc)
+  order := d.DocumentElement()
+  ed, err := Encrypt(order, key, &EncryptOptions{Content: true, Algorithm: AES128GCMURI})
+  if (err != nil) {
+    t.Fatalf("%v", err)
+  }
+  if (order.FirstChild() != ed || ed.NextSibling() != nil) {
+    t.Errorf("content not replaced: %s", toXml(d))
+  }
+  if (ed.GetAttribute("Type") != EncryptedContentType) {
+    t.Errorf("Type is %q", ed.GetAttribute("Type"))
+  }
+  nodes, err := Decrypt(ed, key)
+  if (err != nil) {
+    t.Fatalf("%v", err)
+  }
+  if (len(nodes) != 2) {
+    t.Errorf("got %d nodes", len(nodes))
+  }
+  if got := toXml(d); got != encTestDecrypted {
+    t.Errorf("got %s", got)
+  }
+}
+
+func TestEncryptKeyTransport(t *testing.T) {
+  key := testKey(t)
+  d, _ := ParseString(encTestDoc)
+  ed, err := Encrypt(d.DocumentElement(), &key.PublicKey, nil)
+  if (err != nil) {
+    t.Fatalf("%v", err)
+  }
+  if (d.DocumentElement() != ed) {
+    t.Fatalf("document element not replaced")
+  }
+  if (d.GetElementsByTagNameNS(XEncNamespace, "EncryptedKey").Length() != 1) {
+    t.Errorf("no EncryptedKey in %s", toXml(d))
+  }
+  var b bytes.Buffer
+  Serialize(&b, d)
+  d, err = ParseString(b.String())
+  if (err != nil) {
+    t.Fatalf("%v", err)
+  }
+  if _, err := Decrypt(d.DocumentElement(), []byte("0123456789abcdef0123456789abcdef")); err == nil {
+    t.Errorf("decrypted with the wrong key")
+  }
+  if _, err := Decrypt(d.DocumentElement(), key); err != nil {
+    t.Fatalf("%v", err)
+  }
+  if got := toXml(d); got != `<?xml version="1.0"?>`+encTestDoc {
+    t.Errorf("got %s", got)
+  }
+}
+
+func TestDecryptTampered(t *testing.T) {
+  key := []byte("0123456789abcdef")
+  d, _ := ParseString(encTestDoc)
+  ed, _ := Encrypt(d.DocumentElement().FirstChild().(Element), key, nil)
+  // GCM authenticates the cipher text, CBC does not
+  text := d.GetElementsByTagNameNS(XEncNamespace, "CipherValue").Item(0).FirstChild().(Text)
+  b := []byte(text.NodeValue())
+  if (b[0] == 'A') {
+    b[0] = 'B'
+  } else {
+    b[0] = 'A'
+  }
+  text.SetData(string(b))
+  if _, err := Decrypt(ed, key); err == nil {
+    t.Errorf("tampered cipher text decrypted")
+  }
+  if (ed.ParentNode() == nil) {
+    t.Errorf("EncryptedData removed after a failed decryption")
+  }
+  if _, err := Encrypt(d.DocumentElement(), key[:10], nil); err == nil {
+    t.Errorf("short key accepted")
+  }
+}
@@ -0,0 +1,1187 @@
+package dom
+
+/*
+ * XPath 1.0, and the DOM Level 3 XPath interfaces to it
+ * http://www.w3.org/TR/xpath/
+ * http://www.w3.org/TR/DOM-Level-3-XPath/
+ *
+ * Expressions compile to a tree that is never changed afterwards, so one
+ * XPathExpression may be evaluated by many goroutines at once. All the
+ * state of an evaluation lives in an xpathEval.
+ */
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// ErrXPathType is returned when a value is not of the type an
+	// operation needs, such as a number where a node-set is wanted, or a
+	// result asked for as the wrong type.
+	ErrXPathType = errors.New("xpath: wrong type")
+
+	// ErrInvalidIterator is returned by XPathResult.IterateNext once the
+	// document has changed since the result was made.
+	ErrInvalidIterator = errors.New("xpath: document changed during iteration")
+)
+
+// An XPathFunction is a function that expressions can call. context is
+// the context node of the call. The arguments are a []Node for a
+// node-set, a string, a float64 or a bool; the result may be any of
+// these, a Node, a NodeList, or any Go number.
+type XPathFunction func(context Node, args []any) (any, error)
+
+// XPathOptions tell CompileXPath how to resolve the names in an
+// expression.
+type XPathOptions struct {
+	// Resolver gives the namespace URIs of the prefixes. The xml prefix
+	// is always bound.
+	Resolver XPathNSResolver
+
+	// Functions are the functions that expressions may call besides the
+	// core library, by name for unprefixed names and by "{uri}local" for
+	// prefixed ones. The core functions cannot be replaced.
+	Functions map[string]XPathFunction
+}
+
+// An XPathExpression is a compiled XPath 1.0 expression.
+// http://www.w3.org/TR/DOM-Level-3-XPath/xpath.html#XPathExpression
+type XPathExpression struct {
+	src  string
+	root xpathExpr
+}
+
+// CompileXPath compiles expr, resolving its prefixes and function names
+// as opts say. A nil opts stands for the defaults.
+func CompileXPath(expr string, opts *XPathOptions) (*XPathExpression, error) {
+	if opts == nil {
+		opts = &XPathOptions{}
+	}
+	toks, err := xpathTokens(expr)
+	if err != nil {
+		return nil, err
+	}
+	p := &xpathParser{src: expr, toks: toks, opts: opts}
+	root, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tkEOF {
+		return nil, p.errorf("unexpected %s", t)
+	}
+	return &XPathExpression{expr, root}, nil
+}
+
+// String returns the source of the expression.
+func (x *XPathExpression) String() string {
+	return x.src
+}
+
+// Evaluate evaluates the expression with context as its context node and
+// returns the result as the given type, one of the *_TYPE constants.
+// http://www.w3.org/TR/DOM-Level-3-XPath/xpath.html#XPathExpression-evaluate
+func (x *XPathExpression) Evaluate(context Node, resultType uint) (*XPathResult, error) {
+	return x.EvaluateWithVariables(context, resultType, nil)
+}
+
+// EvaluateWithVariables is Evaluate with values for the variables of the
+// expression, keyed like XPathOptions.Functions. The values may be of
+// any type an XPathFunction may return.
+func (x *XPathExpression) EvaluateWithVariables(context Node, resultType uint, vars map[string]any) (*XPathResult, error) {
+	if context == nil {
+		return nil, errors.New("xpath: no context node")
+	}
+	switch context.NodeType() {
+	case DOCUMENT_TYPE_NODE, ENTITY_REFERENCE_NODE:
+		return nil, fmt.Errorf("xpath: a %s node cannot be the context node", context.NodeName())
+	}
+	e := &xpathEval{vars: make(map[string]any, len(vars))}
+	for name, v := range vars {
+		v, err := e.value(v)
+		if err != nil {
+			return nil, fmt.Errorf("variable %s: %w", name, err)
+		}
+		e.vars[name] = v
+	}
+	v, err := x.root.eval(e, xpathContext{xpathNode(context), 1, 1})
+	if err != nil {
+		return nil, err
+	}
+
+	r := &XPathResult{resultType: resultType}
+	switch resultType {
+	case ANY_TYPE:
+		switch v := v.(type) {
+		case []Node:
+			r.resultType = UNORDERED_NODE_ITERATOR_TYPE
+		case float64:
+			r.resultType, r.number = NUMBER_TYPE, v
+		case string:
+			r.resultType, r.str = STRING_TYPE, v
+		case bool:
+			r.resultType, r.boolean = BOOLEAN_TYPE, v
+		}
+	case NUMBER_TYPE:
+		r.number = xpathNumber(v)
+	case STRING_TYPE:
+		r.str = xpathString(v)
+	case BOOLEAN_TYPE:
+		r.boolean = xpathBoolean(v)
+	case UNORDERED_NODE_ITERATOR_TYPE, ORDERED_NODE_ITERATOR_TYPE, UNORDERED_NODE_SNAPSHOT_TYPE,
+		ORDERED_NODE_SNAPSHOT_TYPE, ANY_UNORDERED_NODE_TYPE, FIRST_ORDERED_NODE_TYPE:
+	default:
+		return nil, fmt.Errorf("xpath: unknown result type %d", resultType)
+	}
+	if r.resultType >= UNORDERED_NODE_ITERATOR_TYPE {
+		nodes, ok := v.([]Node)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not a node-set", ErrXPathType, x.src)
+		}
+		r.nodes = nodes
+		if r.resultType >= ANY_UNORDERED_NODE_TYPE && len(nodes) > 1 {
+			r.nodes = nodes[:1]
+		}
+		if d, ok := ownerDocument(context).(*_doc); ok {
+			r.doc, r.mutations = d, d.mutations
+		}
+	}
+	return r, nil
+}
+
+// An XPathResult is the result of evaluating an expression. Its methods
+// fail with ErrXPathType if they do not suit its type.
+// http://www.w3.org/TR/DOM-Level-3-XPath/xpath.html#XPathResult
+type XPathResult struct {
+	resultType uint
+	number     float64
+	str        string
+	boolean    bool
+	nodes      []Node // in document order
+	next       int    // the next node of an iterator
+	doc        *_doc  // whose changes invalidate an iterator
+	mutations  uint   // of doc when the result was made
+}
+
+func (r *XPathResult) ResultType() uint {
+	return r.resultType
+}
+
+func (r *XPathResult) is(types ...uint) error {
+	for _, t := range types {
+		if r.resultType == t {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: the result is of type %d", ErrXPathType, r.resultType)
+}
+
+func (r *XPathResult) NumberValue() (float64, error) {
+	return r.number, r.is(NUMBER_TYPE)
+}
+
+func (r *XPathResult) StringValue() (string, error) {
+	return r.str, r.is(STRING_TYPE)
+}
+
+func (r *XPathResult) BooleanValue() (bool, error) {
+	return r.boolean, r.is(BOOLEAN_TYPE)
+}
+
+// SingleNodeValue returns the node of an ANY_UNORDERED_NODE_TYPE or
+// FIRST_ORDERED_NODE_TYPE result, or nil if there is none.
+func (r *XPathResult) SingleNodeValue() (Node, error) {
+	if err := r.is(ANY_UNORDERED_NODE_TYPE, FIRST_ORDERED_NODE_TYPE); err != nil {
+		return nil, err
+	}
+	if len(r.nodes) == 0 {
+		return nil, nil
+	}
+	return r.nodes[0], nil
+}
+
+// InvalidIteratorState says whether the document has changed since an
+// iterator result was made.
+func (r *XPathResult) InvalidIteratorState() bool {
+	switch r.resultType {
+	case UNORDERED_NODE_ITERATOR_TYPE, ORDERED_NODE_ITERATOR_TYPE:
+		return r.doc != nil && r.doc.mutations != r.mutations
+	}
+	return false
+}
+
+// IterateNext returns the next node of an iterator result, or nil after
+// the last one.
+func (r *XPathResult) IterateNext() (Node, error) {
+	if err := r.is(UNORDERED_NODE_ITERATOR_TYPE, ORDERED_NODE_ITERATOR_TYPE); err != nil {
+		return nil, err
+	}
+	if r.InvalidIteratorState() {
+		return nil, ErrInvalidIterator
+	}
+	if r.next == len(r.nodes) {
+		return nil, nil
+	}
+	r.next++
+	return r.nodes[r.next-1], nil
+}
+
+func (r *XPathResult) SnapshotLength() (uint, error) {
+	return uint(len(r.nodes)), r.is(UNORDERED_NODE_SNAPSHOT_TYPE, ORDERED_NODE_SNAPSHOT_TYPE)
+}
+
+// SnapshotItem returns the node at index of a snapshot result, or nil if
+// index is out of range.
+func (r *XPathResult) SnapshotItem(index uint) (Node, error) {
+	if err := r.is(UNORDERED_NODE_SNAPSHOT_TYPE, ORDERED_NODE_SNAPSHOT_TYPE); err != nil {
+		return nil, err
+	}
+	if index >= uint(len(r.nodes)) {
+		return nil, nil
+	}
+	return r.nodes[index], nil
+}
+
+// http://www.w3.org/TR/DOM-Level-3-XPath/xpath.html#XPathEvaluator-createExpression
+func (d *_doc) CreateExpression(expression string, resolver XPathNSResolver) (*XPathExpression, error) {
+	return CompileXPath(expression, &XPathOptions{Resolver: resolver})
+}
+
+// CreateNSResolver returns a resolver that looks prefixes up as
+// nodeResolver.LookupNamespaceURI does.
+// http://www.w3.org/TR/DOM-Level-3-XPath/xpath.html#XPathEvaluator-createNSResolver
+func (d *_doc) CreateNSResolver(nodeResolver Node) XPathNSResolver {
+	return nodeResolver
+}
+
+// Evaluate compiles expression and evaluates it with contextNode, which
+// must belong to d, as the context node.
+// http://www.w3.org/TR/DOM-Level-3-XPath/xpath.html#XPathEvaluator-evaluate
+func (d *_doc) Evaluate(expression string, contextNode Node, resolver XPathNSResolver, resultType uint) (*XPathResult, error) {
+	if contextNode != nil && ownerDocument(contextNode) != Document(d) {
+		return nil, errors.New("xpath: the context node is not in the document")
+	}
+	x, err := d.CreateExpression(expression, resolver)
+	if err != nil {
+		return nil, err
+	}
+	return x.Evaluate(contextNode, resultType)
+}
+
+// An xpathNamespace is a node of the namespace axis: a namespace in scope
+// at its owner element.
+type xpathNamespace struct {
+	*_node
+	uri   string
+	owner *_elem
+}
+
+func newXPathNamespace(owner *_elem, prefix, uri string) *xpathNamespace {
+	ns := &xpathNamespace{_node: &_node{T: XPATH_NAMESPACE_NODE}, uri: uri, owner: owner}
+	ns.n.Local = prefix
+	ns.self = ns
+	return ns
+}
+
+func (ns *xpathNamespace) NodeName() string {
+	return ns.n.Local
+}
+
+func (ns *xpathNamespace) LocalName() string {
+	return ns.n.Local
+}
+
+func (ns *xpathNamespace) Prefix() string {
+	return ns.n.Local
+}
+
+func (ns *xpathNamespace) NamespaceURI() string {
+	return ns.uri
+}
+
+func (ns *xpathNamespace) NodeValue() string {
+	return ns.uri
+}
+
+func (ns *xpathNamespace) OwnerElement() Element {
+	return ns.owner
+}
+
+func (ns *xpathNamespace) OwnerDocument() Document {
+	return ownerDocument(ns.owner)
+}
+
+// the nodes of an expression tree
+type xpathExpr interface {
+	// eval returns a []Node in document order, a string, a float64 or a
+	// bool.
+	eval(e *xpathEval, ctx xpathContext) (any, error)
+}
+
+// an xpathEval holds the state of one evaluation
+type xpathEval struct {
+	vars  map[string]any
+	ns    map[*_elem][]Node // the namespace nodes made so far
+	order map[Node]int      // document order, see sortNodes
+	next  int               // the next number in order
+}
+
+// http://www.w3.org/TR/xpath/#dt-context-node
+type xpathContext struct {
+	node      Node
+	pos, size int
+}
+
+// value turns v, given to an evaluation from outside, into a value of
+// XPath.
+func (e *xpathEval) value(v any) (any, error) {
+	switch v := v.(type) {
+	case string, float64, bool:
+		return v, nil
+	case Node:
+		if v == nil || reflect.ValueOf(v).IsNil() {
+			return []Node{}, nil
+		}
+		return []Node{xpathNode(v)}, nil
+	case []Node:
+		nodes := make([]Node, len(v))
+		for i, n := range v {
+			nodes[i] = xpathNode(n)
+		}
+		return e.sortNodes(nodes), nil
+	case NodeList:
+		var nodes []Node
+		for i := uint(0); i < v.Length(); i++ {
+			nodes = append(nodes, xpathNode(v.Item(i)))
+		}
+		return e.sortNodes(nodes), nil
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(rv.Uint()), nil
+	case reflect.Float32:
+		return rv.Float(), nil
+	}
+	return nil, fmt.Errorf("%w: %T is not an XPath value", ErrXPathType, v)
+}
+
+type xpathLiteral struct {
+	v any // a string or a float64
+}
+
+func (x *xpathLiteral) eval(e *xpathEval, ctx xpathContext) (any, error) {
+	return x.v, nil
+}
+
+type xpathVariable struct {
+	name string
+}
+
+func (x *xpathVariable) eval(e *xpathEval, ctx xpathContext) (any, error) {
+	v, ok := e.vars[x.name]
+	if !ok {
+		return nil, fmt.Errorf("xpath: variable %s is not bound", x.name)
+	}
+	return v, nil
+}
+
+type xpathNegate struct {
+	x xpathExpr
+}
+
+func (x *xpathNegate) eval(e *xpathEval, ctx xpathContext) (any, error) {
+	v, err := x.x.eval(e, ctx)
+	return -xpathNumber(v), err
+}
+
+type xpathBinary struct {
+	op   string
+	l, r xpathExpr
+}
+
+func (x *xpathBinary) eval(e *xpathEval, ctx xpathContext) (any, error) {
+	l, err := x.l.eval(e, ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "and", "or":
+		if xpathBoolean(l) == (x.op == "or") {
+			return x.op == "or", nil
+		}
+		r, err := x.r.eval(e, ctx)
+		return xpathBoolean(r), err
+	}
+	r, err := x.r.eval(e, ctx)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "+":
+		return xpathNumber(l) + xpathNumber(r), nil
+	case "-":
+		return xpathNumber(l) - xpathNumber(r), nil
+	case "*":
+		return xpathNumber(l) * xpathNumber(r), nil
+	case "div":
+		return xpathNumber(l) / xpathNumber(r), nil
+	case "mod":
+		return math.Mod(xpathNumber(l), xpathNumber(r)), nil
+	}
+	return xpathCompare(x.op, l, r), nil
+}
+
+// xpathCompare compares two values as
+// http://www.w3.org/TR/xpath/#booleans says.
+func xpathCompare(op string, l, r any) bool {
+	ln, lset := l.([]Node)
+	rn, rset := r.([]Node)
+	switch {
+	case lset && rset:
+		rs := make([]string, len(rn))
+		for i, n := range rn {
+			rs[i] = xpathStringValue(n)
+		}
+		for _, n := range ln {
+			ls := xpathStringValue(n)
+			for _, s := range rs {
+				if xpathCompareAtoms(op, ls, s) {
+					return true
+				}
+			}
+		}
+		return false
+	case lset:
+		if b, ok := r.(bool); ok {
+			return xpathCompareAtoms(op, len(ln) > 0, b)
+		}
+		for _, n := range ln {
+			if xpathCompareAtoms(op, xpathStringValue(n), r) {
+				return true
+			}
+		}
+		return false
+	case rset:
+		if b, ok := l.(bool); ok {
+			return xpathCompareAtoms(op, b, len(rn) > 0)
+		}
+		for _, n := range rn {
+			if xpathCompareAtoms(op, l, xpathStringValue(n)) {
+				return true
+			}
+		}
+		return false
+	}
+	return xpathCompareAtoms(op, l, r)
+}
+
+// xpathCompareAtoms compares two values that are not node-sets.
+func xpathCompareAtoms(op string, l, r any) bool {
+	switch op {
+	case "=", "!=":
+		var eq bool
+		_, lb := l.(bool)
+		_, rb := r.(bool)
+		_, lf := l.(float64)
+		_, rf := r.(float64)
+		switch {
+		case lb || rb:
+			eq = xpathBoolean(l) == xpathBoolean(r)
+		case lf || rf:
+			eq = xpathNumber(l) == xpathNumber(r)
+		default:
+			eq = xpathString(l) == xpathString(r)
+		}
+		return eq == (op == "=")
+	case "<":
+		return xpathNumber(l) < xpathNumber(r)
+	case "<=":
+		return xpathNumber(l) <= xpathNumber(r)
+	case ">":
+		return xpathNumber(l) > xpathNumber(r)
+	}
+	return xpathNumber(l) >= xpathNumber(r)
+}
+
+type xpathUnion struct {
+	l, r xpathExpr
+}
+
+func (x *xpathUnion) eval(e *xpathEval, ctx xpathContext) (any, error) {
+	l, err := e.nodeSet(x.l, ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := e.nodeSet(x.r, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.sortNodes(append(append([]Node{}, l...), r...)), nil
+}
+
+// nodeSet evaluates x, which must give a node-set.
+func (e *xpathEval) nodeSet(x xpathExpr, ctx xpathContext) ([]Node, error) {
+	v, err := x.eval(e, ctx)
+	if err != nil {
+		return nil, err
+	}
+	nodes, ok := v.([]Node)
+	if !ok {
+		return nil, fmt.Errorf("%w: %v is not a node-set", ErrXPathType, v)
+	}
+	return nodes, nil
+}
+
+type xpathCall struct {
+	name string
+	args []xpathExpr
+	core *xpathCoreFunc // or
+	ext  XPathFunction
+}
+
+func (x *xpathCall) eval(e *xpathEval, ctx xpathContext) (any, error) {
+	args := make([]any, len(x.args))
+	for i, a := range x.args {
+		v, err := a.eval(e, ctx)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	if x.core != nil {
+		v, err := x.core.fn(e, ctx, args)
+		if err != nil {
+			return nil, fmt.Errorf("%s(): %w", x.name, err)
+		}
+		return v, nil
+	}
+	v, err := x.ext(ctx.node, args)
+	if err == nil {
+		v, err = e.value(v)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s(): %w", x.name, err)
+	}
+	return v, nil
+}
+
+// an xpathFilter is a FilterExpr with predicates
+type xpathFilter struct {
+	x     xpathExpr
+	preds []xpathExpr
+}
+
+func (x *xpathFilter) eval(e *xpathEval, ctx xpathContext) (any, error) {
+	nodes, err := e.nodeSet(x.x, ctx)
+	if err != nil {
+		return nil, err
+	}
+	return e.filter(nodes, x.preds)
+}
+
+// filter returns the nodes for which all the predicates hold, each
+// predicate seeing the nodes that passed the one before, with positions
+// in their order.
+func (e *xpathEval) filter(nodes []Node, preds []xpathExpr) ([]Node, error) {
+	for _, pred := range preds {
+		var kept []Node
+		for i, n := range nodes {
+			v, err := pred.eval(e, xpathContext{n, i + 1, len(nodes)})
+			if err != nil {
+				return nil, err
+			}
+			if f, ok := v.(float64); ok && f == float64(i+1) || !ok && xpathBoolean(v) {
+				kept = append(kept, n)
+			}
+		}
+		nodes = kept
+	}
+	return nodes, nil
+}
+
+type xpathPath struct {
+	start    xpathExpr // the FilterExpr the path starts from, or nil
+	absolute bool
+	steps    []*xpathStep
+}
+
+func (x *xpathPath) eval(e *xpathEval, ctx xpathContext) (any, error) {
+	nodes := []Node{ctx.node}
+	switch {
+	case x.start != nil:
+		var err error
+		if nodes, err = e.nodeSet(x.start, ctx); err != nil {
+			return nil, err
+		}
+	case x.absolute:
+		nodes = []Node{xpathRoot(ctx.node)}
+	}
+	for _, s := range x.steps {
+		var next []Node
+		for _, n := range nodes {
+			found, err := s.eval(e, n)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, found...)
+		}
+		switch {
+		case len(nodes) > 1:
+			next = e.sortNodes(next)
+		case xpathReverseAxes[s.axis]:
+			for i, j := 0, len(next)-1; i < j; i, j = i+1, j-1 {
+				next[i], next[j] = next[j], next[i]
+			}
+		}
+		nodes = next
+	}
+	if nodes == nil {
+		nodes = []Node{}
+	}
+	return nodes, nil
+}
+
+type xpathStep struct {
+	axis  xpathAxis
+	test  xpathNodeTest
+	preds []xpathExpr
+}
+
+// eval returns the nodes the step selects from n, in the order of its
+// axis.
+func (s *xpathStep) eval(e *xpathEval, n Node) ([]Node, error) {
+	var nodes []Node
+	e.axis(s.axis, n, func(c Node) {
+		if s.test.matches(s.axis, c) {
+			nodes = append(nodes, c)
+		}
+	})
+	return e.filter(nodes, s.preds)
+}
+
+type xpathNodeTestKind int
+
+const (
+	testName      xpathNodeTestKind = iota // uri and local
+	testAnyName                            // *
+	testNamespace                          // uri:*
+	testNode                               // node()
+	testText                               // text()
+	testComment                            // comment()
+	testPI                                 // processing-instruction(), of the target local if not empty
+)
+
+type xpathNodeTest struct {
+	kind  xpathNodeTestKind
+	uri   string
+	local string
+}
+
+func (t xpathNodeTest) matches(axis xpathAxis, n Node) bool {
+	switch t.kind {
+	case testNode:
+		return true
+	case testText:
+		return n.NodeType() == TEXT_NODE || n.NodeType() == CDATA_SECTION_NODE
+	case testComment:
+		return n.NodeType() == COMMENT_NODE
+	case testPI:
+		return n.NodeType() == PROCESSING_INSTRUCTION_NODE && (t.local == "" || n.(ProcessingInstruction).Target() == t.local)
+	}
+	// the principal node type of the axis
+	principal := uint(ELEMENT_NODE)
+	switch axis {
+	case axisAttribute:
+		principal = ATTRIBUTE_NODE
+	case axisNamespace:
+		principal = XPATH_NAMESPACE_NODE
+	}
+	if n.NodeType() != principal {
+		return false
+	}
+	uri, local := xpathName(n)
+	switch t.kind {
+	case testNamespace:
+		return uri == t.uri
+	case testName:
+		return uri == t.uri && local == t.local
+	}
+	return true
+}
+
+// xpathName returns the expanded name of n.
+// http://www.w3.org/TR/xpath/#dt-expanded-name
+func xpathName(n Node) (uri, local string) {
+	switch n.NodeType() {
+	case ELEMENT_NODE, ATTRIBUTE_NODE:
+		return n.NamespaceURI(), n.LocalName()
+	case PROCESSING_INSTRUCTION_NODE:
+		return "", n.(ProcessingInstruction).Target()
+	case XPATH_NAMESPACE_NODE:
+		return "", n.LocalName()
+	}
+	return "", ""
+}
+
+type xpathAxis int
+
+const (
+	axisAncestor xpathAxis = iota
+	axisAncestorOrSelf
+	axisAttribute
+	axisChild
+	axisDescendant
+	axisDescendantOrSelf
+	axisFollowing
+	axisFollowingSibling
+	axisNamespace
+	axisParent
+	axisPreceding
+	axisPrecedingSibling
+	axisSelf
+)
+
+var xpathAxes = map[string]xpathAxis{
+	"ancestor":           axisAncestor,
+	"ancestor-or-self":   axisAncestorOrSelf,
+	"attribute":          axisAttribute,
+	"child":              axisChild,
+	"descendant":         axisDescendant,
+	"descendant-or-self": axisDescendantOrSelf,
+	"following":          axisFollowing,
+	"following-sibling":  axisFollowingSibling,
+	"namespace":          axisNamespace,
+	"parent":             axisParent,
+	"preceding":          axisPreceding,
+	"preceding-sibling":  axisPrecedingSibling,
+	"self":               axisSelf,
+}
+
+// the axes whose nodes come in reverse document order
+var xpathReverseAxes = map[xpathAxis]bool{
+	axisAncestor:         true,
+	axisAncestorOrSelf:   true,
+	axisPreceding:        true,
+	axisPrecedingSibling: true,
+}
+
+// axis calls f with the nodes on axis from n, in the order of the axis.
+func (e *xpathEval) axis(axis xpathAxis, n Node, f func(Node)) {
+	switch axis {
+	case axisSelf:
+		f(n)
+	case axisChild:
+		for _, c := range xpathChildren(n) {
+			f(c)
+		}
+	case axisDescendantOrSelf:
+		f(n)
+		fallthrough
+	case axisDescendant:
+		xpathDescendants(n, f)
+	case axisAncestorOrSelf:
+		f(n)
+		fallthrough
+	case axisAncestor:
+		for p := xpathParent(n); p != nil; p = xpathParent(p) {
+			f(p)
+		}
+	case axisParent:
+		if p := xpathParent(n); p != nil {
+			f(p)
+		}
+	case axisFollowingSibling:
+		sibs, i := xpathSiblings(n)
+		for _, s := range sibs[i+1:] {
+			f(s)
+		}
+	case axisPrecedingSibling:
+		sibs, i := xpathSiblings(n)
+		for i--; i >= 0; i-- {
+			f(sibs[i])
+		}
+	case axisFollowing:
+		switch n.NodeType() {
+		case ATTRIBUTE_NODE, XPATH_NAMESPACE_NODE:
+			// the content of the owner follows its attributes
+			if n = xpathParent(n); n == nil {
+				return
+			}
+			xpathDescendants(n, f)
+		}
+		for ; n != nil; n = xpathParent(n) {
+			sibs, i := xpathSiblings(n)
+			for _, s := range sibs[i+1:] {
+				f(s)
+				xpathDescendants(s, f)
+			}
+		}
+	case axisPreceding:
+		switch n.NodeType() {
+		case ATTRIBUTE_NODE, XPATH_NAMESPACE_NODE:
+			if n = xpathParent(n); n == nil {
+				return
+			}
+		}
+		for ; n != nil; n = xpathParent(n) {
+			sibs, i := xpathSiblings(n)
+			for i--; i >= 0; i-- {
+				var sub []Node
+				xpathDescendants(sibs[i], func(d Node) { sub = append(sub, d) })
+				for j := len(sub) - 1; j >= 0; j-- {
+					f(sub[j])
+				}
+				f(sibs[i])
+			}
+		}
+	case axisAttribute:
+		if el, ok := n.(*_elem); ok {
+			for _, a := range el.attribs {
+				if _, ok := namespaceDecl(a.NodeName()); !ok {
+					f(a)
+				}
+			}
+		}
+	case axisNamespace:
+		if el, ok := n.(*_elem); ok {
+			for _, ns := range e.namespaces(el) {
+				f(ns)
+			}
+		}
+	}
+}
+
+// namespaces returns the namespace nodes of el, sorted by prefix. They are
+// made once for each evaluation, so that they are the same nodes each
+// time.
+func (e *xpathEval) namespaces(el *_elem) []Node {
+	if nodes, ok := e.ns[el]; ok {
+		return nodes
+	}
+	scope := inScopeNamespaces(el)
+	scope["xml"] = xmlURL
+	prefixes := make([]string, 0, len(scope))
+	for prefix, uri := range scope {
+		// an undeclaration
+		if uri != "" {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+	nodes := make([]Node, len(prefixes))
+	for i, prefix := range prefixes {
+		nodes[i] = newXPathNamespace(el, prefix, scope[prefix])
+	}
+	if e.ns == nil {
+		e.ns = make(map[*_elem][]Node)
+	}
+	e.ns[el] = nodes
+	// the order numbered so far leaves them out
+	e.order = nil
+	return nodes
+}
+
+// sortNodes sorts nodes into document order and drops duplicates. The
+// trees of different roots come in the order they are first met.
+func (e *xpathEval) sortNodes(nodes []Node) []Node {
+	if len(nodes) < 2 {
+		return nodes
+	}
+	if e.order == nil {
+		e.order = make(map[Node]int)
+	}
+	for _, n := range nodes {
+		if _, ok := e.order[n]; !ok {
+			e.number(xpathRoot(n))
+		}
+	}
+	sort.SliceStable(nodes, func(i, j int) bool {
+		return e.order[nodes[i]] < e.order[nodes[j]]
+	})
+	out := nodes[:1]
+	for _, n := range nodes[1:] {
+		if n != out[len(out)-1] {
+			out = append(out, n)
+		}
+	}
+	return out
+}
+
+// number numbers the nodes of the tree of root in document order: each
+// element, then its namespace nodes, then its attributes, then its
+// children.
+func (e *xpathEval) number(root Node) {
+	f := func(n Node) bool {
+		e.order[n] = e.next
+		e.next++
+		if el, ok := n.(*_elem); ok {
+			for _, ns := range e.ns[el] {
+				e.order[ns] = e.next
+				e.next++
+			}
+			for _, a := range el.attribs {
+				e.order[a] = e.next
+				e.next++
+			}
+		}
+		return true
+	}
+	f(root)
+	walkTreeDepthFirst(root, f)
+}
+
+// xpathNode returns the node that stands for n in XPath: the first of
+// the text and CDATA nodes that make up one text node of XPath.
+func xpathNode(n Node) Node {
+	switch n.NodeType() {
+	case TEXT_NODE, CDATA_SECTION_NODE:
+	default:
+		return n
+	}
+	p := xpathParent(n)
+	if p == nil {
+		return n
+	}
+	var first Node
+	for _, c := range contentOf(p) {
+		switch c.NodeType() {
+		case TEXT_NODE, CDATA_SECTION_NODE:
+			if first == nil {
+				first = c
+			}
+		default:
+			first = nil
+		}
+		if c == n {
+			return first
+		}
+	}
+	return n
+}
+
+// xpathParent returns the parent of n in XPath, which looks through
+// entity references, and has elements as the parents of their
+// attributes and namespace nodes.
+func xpathParent(n Node) Node {
+	switch n := n.(type) {
+	case *_attr:
+		if n.ownerElement == nil {
+			return nil
+		}
+		return n.ownerElement
+	case *xpathNamespace:
+		return n.owner
+	}
+	p := n.ParentNode()
+	for p != nil && p.NodeType() == ENTITY_REFERENCE_NODE {
+		p = p.ParentNode()
+	}
+	return p
+}
+
+func xpathRoot(n Node) Node {
+	for p := xpathParent(n); p != nil; p = xpathParent(p) {
+		n = p
+	}
+	return n
+}
+
+// xpathChildren returns the children of n in XPath: the content of
+// entity references is in place of them, there is no document type, and
+// adjacent text and CDATA nodes are one node, the first of them.
+func xpathChildren(n Node) []Node {
+	switch n.NodeType() {
+	case ELEMENT_NODE, DOCUMENT_NODE, DOCUMENT_FRAGMENT_NODE:
+	default:
+		return nil
+	}
+	var children []Node
+	text := false
+	for _, c := range contentOf(n) {
+		switch c.NodeType() {
+		case TEXT_NODE, CDATA_SECTION_NODE:
+			if !text {
+				children = append(children, c)
+			}
+			text = true
+			continue
+		case ELEMENT_NODE, COMMENT_NODE, PROCESSING_INSTRUCTION_NODE:
+			children = append(children, c)
+		}
+		text = false
+	}
+	return children
+}
+
+// xpathSiblings returns the children of the parent of n and where n is
+// among them. The index is -1 if n has no siblings, like an attribute.
+func xpathSiblings(n Node) ([]Node, int) {
+	switch n.NodeType() {
+	case ATTRIBUTE_NODE, XPATH_NAMESPACE_NODE:
+		return nil, -1
+	}
+	p := xpathParent(n)
+	if p == nil {
+		return nil, -1
+	}
+	sibs := xpathChildren(p)
+	for i, s := range sibs {
+		if s == n {
+			return sibs, i
+		}
+	}
+	return nil, -1
+}
+
+// xpathDescendants calls f with the descendants of n in document order.
+func xpathDescendants(n Node, f func(Node)) {
+	// a stack of the children still to visit at each level, so that deep
+	// trees cannot exhaust the stack
+	stack := [][]Node{xpathChildren(n)}
+	for len(stack) > 0 {
+		top := stack[len(stack)-1]
+		if len(top) == 0 {
+			stack = stack[:len(stack)-1]
+			continue
+		}
+		stack[len(stack)-1] = top[1:]
+		f(top[0])
+		if children := xpathChildren(top[0]); len(children) > 0 {
+			stack = append(stack, children)
+		}
+	}
+}
+
+// xpathStringValue returns the string-value of n.
+// http://www.w3.org/TR/xpath/#dt-string-value
+func xpathStringValue(n Node) string {
+	switch n.NodeType() {
+	case ELEMENT_NODE, DOCUMENT_NODE, DOCUMENT_FRAGMENT_NODE:
+		var b strings.Builder
+		walkTreeDepthFirst(n, func(c Node) bool {
+			switch c.NodeType() {
+			case TEXT_NODE, CDATA_SECTION_NODE:
+				b.WriteString(c.NodeValue())
+			}
+			return true
+		})
+		return b.String()
+	case TEXT_NODE, CDATA_SECTION_NODE:
+		p := xpathParent(n)
+		if p == nil {
+			return n.NodeValue()
+		}
+		// the run of text that n begins
+		var b strings.Builder
+		in := false
+		for _, c := range contentOf(p) {
+			if c == n {
+				in = true
+			}
+			if in {
+				if t := c.NodeType(); t != TEXT_NODE && t != CDATA_SECTION_NODE {
+					break
+				}
+				b.WriteString(c.NodeValue())
+			}
+		}
+		return b.String()
+	case PROCESSING_INSTRUCTION_NODE:
+		return n.(ProcessingInstruction).GetData()
+	case COMMENT_NODE:
+		return n.(Comment).GetData()
+	}
+	return n.NodeValue()
+}
+
+// http://www.w3.org/TR/xpath/#function-string
+func xpathString(v any) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case float64:
+		return xpathNumberString(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case []Node:
+		if len(v) > 0 {
+			return xpathStringValue(v[0])
+		}
+	}
+	return ""
+}
+
+func xpathNumberString(f float64) string {
+	switch {
+	case math.IsNaN(f):
+		return "NaN"
+	case math.IsInf(f, 1):
+		return "Infinity"
+	case math.IsInf(f, -1):
+		return "-Infinity"
+	case f == 0:
+		// and -0
+		return "0"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// http://www.w3.org/TR/xpath/#function-number
+func xpathNumber(v any) float64 {
+	switch v := v.(type) {
+	case float64:
+		return v
+	case bool:
+		if v {
+			return 1
+		}
+		return 0
+	}
+	return xpathParseNumber(xpathString(v))
+}
+
+// xpathParseNumber parses s as the Number of XPath, with an optional
+// minus sign and whitespace around it, or returns NaN.
+func xpathParseNumber(s string) float64 {
+	s = strings.TrimFunc(s, isXPathSpace)
+	digits := strings.TrimPrefix(s, "-")
+	whole, frac, _ := strings.Cut(digits, ".")
+	if whole == "" && frac == "" {
+		return math.NaN()
+	}
+	for _, part := range []string{whole, frac} {
+		for i := 0; i < len(part); i++ {
+			if part[i] < '0' || part[i] > '9' {
+				return math.NaN()
+			}
+		}
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return math.NaN()
+	}
+	return f
+}
+
+// http://www.w3.org/TR/xpath/#function-boolean
+func xpathBoolean(v any) bool {
+	switch v := v.(type) {
+	case bool:
+		return v
+	case float64:
+		return v != 0 && !math.IsNaN(v)
+	case string:
+		return v != ""
+	case []Node:
+		return len(v) > 0
+	}
+	return false
+}
@@ -0,0 +1,242 @@
+package dom
+
+/*
+ * The core function library of XPath 1.0
+ * http://www.w3.org/TR/xpath/#corelib
+ */
+
+import (
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+type xpathCoreFunc struct {
+	min, max int // arguments; max is -1 for any number
+	fn       func(e *xpathEval, ctx xpathContext, args []any) (any, error)
+}
+
+var xpathCoreFunctions = map[string]*xpathCoreFunc{
+	// node-set functions
+	"last":     {0, 0, func(e *xpathEval, ctx xpathContext, args []any) (any, error) { return float64(ctx.size), nil }},
+	"position": {0, 0, func(e *xpathEval, ctx xpathContext, args []any) (any, error) { return float64(ctx.pos), nil }},
+	"count": {1, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		nodes, err := xpathNodeArg(args[0])
+		return float64(len(nodes)), err
+	}},
+	"id":            {1, 1, xpathID},
+	"local-name":    {0, 1, xpathNameFunc(func(n Node) string { _, local := xpathName(n); return local })},
+	"namespace-uri": {0, 1, xpathNameFunc(func(n Node) string { uri, _ := xpathName(n); return uri })},
+	"name":          {0, 1, xpathNameFunc(xpathQName)},
+
+	// string functions
+	"string": {0, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		return xpathStringArg(ctx, args), nil
+	}},
+	"concat": {2, -1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		var b strings.Builder
+		for _, a := range args {
+			b.WriteString(xpathString(a))
+		}
+		return b.String(), nil
+	}},
+	"starts-with": {2, 2, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		return strings.HasPrefix(xpathString(args[0]), xpathString(args[1])), nil
+	}},
+	"contains": {2, 2, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		return strings.Contains(xpathString(args[0]), xpathString(args[1])), nil
+	}},
+	"substring-before": {2, 2, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		before, _, found := strings.Cut(xpathString(args[0]), xpathString(args[1]))
+		if !found {
+			return "", nil
+		}
+		return before, nil
+	}},
+	"substring-after": {2, 2, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		_, after, _ := strings.Cut(xpathString(args[0]), xpathString(args[1]))
+		return after, nil
+	}},
+	"substring": {2, 3, xpathSubstring},
+	"string-length": {0, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		return float64(utf8.RuneCountInString(xpathStringArg(ctx, args))), nil
+	}},
+	"normalize-space": {0, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		return strings.Join(strings.FieldsFunc(xpathStringArg(ctx, args), isXPathSpace), " "), nil
+	}},
+	"translate": {3, 3, xpathTranslate},
+
+	// boolean functions
+	"boolean": {1, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) { return xpathBoolean(args[0]), nil }},
+	"not":     {1, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) { return !xpathBoolean(args[0]), nil }},
+	"true":    {0, 0, func(e *xpathEval, ctx xpathContext, args []any) (any, error) { return true, nil }},
+	"false":   {0, 0, func(e *xpathEval, ctx xpathContext, args []any) (any, error) { return false, nil }},
+	"lang":    {1, 1, xpathLang},
+
+	// number functions
+	"number": {0, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		if len(args) == 0 {
+			return xpathParseNumber(xpathStringValue(ctx.node)), nil
+		}
+		return xpathNumber(args[0]), nil
+	}},
+	"sum": {1, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		nodes, err := xpathNodeArg(args[0])
+		sum := 0.0
+		for _, n := range nodes {
+			sum += xpathParseNumber(xpathStringValue(n))
+		}
+		return sum, err
+	}},
+	"floor": {1, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		return math.Floor(xpathNumber(args[0])), nil
+	}},
+	"ceiling": {1, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		return math.Ceil(xpathNumber(args[0])), nil
+	}},
+	"round": {1, 1, func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		return xpathRound(xpathNumber(args[0])), nil
+	}},
+}
+
+func xpathNodeArg(v any) ([]Node, error) {
+	nodes, ok := v.([]Node)
+	if !ok {
+		return nil, ErrXPathType
+	}
+	return nodes, nil
+}
+
+// xpathStringArg returns the string of the optional argument, which is
+// the context node if missing.
+func xpathStringArg(ctx xpathContext, args []any) string {
+	if len(args) == 0 {
+		return xpathStringValue(ctx.node)
+	}
+	return xpathString(args[0])
+}
+
+// xpathNameFunc makes a function that gives name of the first node of
+// its optional node-set argument, which is the context node if missing.
+func xpathNameFunc(name func(Node) string) func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+	return func(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+		nodes := []Node{ctx.node}
+		if len(args) > 0 {
+			var err error
+			if nodes, err = xpathNodeArg(args[0]); err != nil {
+				return nil, err
+			}
+		}
+		if len(nodes) == 0 {
+			return "", nil
+		}
+		return name(nodes[0]), nil
+	}
+}
+
+// xpathQName returns the name of n as it was written.
+func xpathQName(n Node) string {
+	switch n.NodeType() {
+	case ELEMENT_NODE, ATTRIBUTE_NODE:
+		return n.NodeName()
+	}
+	_, local := xpathName(n)
+	return local
+}
+
+// http://www.w3.org/TR/xpath/#function-id
+func xpathID(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+	var ids []string
+	if nodes, ok := args[0].([]Node); ok {
+		for _, n := range nodes {
+			ids = append(ids, strings.FieldsFunc(xpathStringValue(n), isXPathSpace)...)
+		}
+	} else {
+		ids = strings.FieldsFunc(xpathString(args[0]), isXPathSpace)
+	}
+	root := xpathRoot(ctx.node)
+	found := []Node{}
+	for _, id := range ids {
+		var el Element
+		switch root := root.(type) {
+		case *_doc:
+			el = root.GetElementById(id)
+		case Element:
+			el = getElementById(root, id)
+		default:
+			for _, c := range xpathChildren(root) {
+				if c, ok := c.(Element); ok {
+					if el = getElementById(c, id); el != nil {
+						break
+					}
+				}
+			}
+		}
+		if el != nil {
+			found = append(found, el)
+		}
+	}
+	return e.sortNodes(found), nil
+}
+
+// http://www.w3.org/TR/xpath/#function-substring
+func xpathSubstring(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+	s := []rune(xpathString(args[0]))
+	start := xpathRound(xpathNumber(args[1]))
+	end := math.Inf(1)
+	if len(args) == 3 {
+		end = start + xpathRound(xpathNumber(args[2]))
+	}
+	var b strings.Builder
+	for i, r := range s {
+		// NaN compares false, leaving nothing
+		if p := float64(i + 1); p >= start && p < end {
+			b.WriteRune(r)
+		}
+	}
+	return b.String(), nil
+}
+
+// http://www.w3.org/TR/xpath/#function-translate
+func xpathTranslate(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+	from, to := []rune(xpathString(args[1])), []rune(xpathString(args[2]))
+	var b strings.Builder
+	for _, r := range xpathString(args[0]) {
+		i := 0
+		for i < len(from) && from[i] != r {
+			i++
+		}
+		switch {
+		case i == len(from):
+			b.WriteRune(r)
+		case i < len(to):
+			b.WriteRune(to[i])
+		}
+	}
+	return b.String(), nil
+}
+
+// http://www.w3.org/TR/xpath/#function-lang
+func xpathLang(e *xpathEval, ctx xpathContext, args []any) (any, error) {
+	want := strings.ToLower(xpathString(args[0]))
+	for n := ctx.node; n != nil; n = xpathParent(n) {
+		el, ok := n.(*_elem)
+		if !ok || !el.HasAttributeNS(xmlURL, "lang") {
+			continue
+		}
+		lang := strings.ToLower(el.GetAttributeNS(xmlURL, "lang"))
+		return lang == want || strings.HasPrefix(lang, want+"-"), nil
+	}
+	return false, nil
+}
+
+// http://www.w3.org/TR/xpath/#function-round
+func xpathRound(f float64) float64 {
+	switch {
+	case math.IsNaN(f), math.IsInf(f, 0), f == 0:
+		return f
+	case f < 0 && f >= -0.5:
+		return math.Copysign(0, -1)
+	}
+	return math.Floor(f + 0.5)
+}
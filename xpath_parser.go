@@ -0,0 +1,581 @@
+package dom
+
+/*
+ * The XPath 1.0 lexer and parser: turn an expression into a tree of
+ * xpathExprs, resolving prefixes and function names on the way.
+ * http://www.w3.org/TR/xpath/#exprlex
+ */
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// An XPathError is returned for an expression that does not compile.
+type XPathError struct {
+	Expr   string
+	Offset int // in bytes
+	Msg    string
+}
+
+func (e *XPathError) Error() string {
+	return fmt.Sprintf("xpath: %s at offset %d of %q", e.Msg, e.Offset, e.Expr)
+}
+
+type xpathTokenKind int
+
+const (
+	tkEOF      xpathTokenKind = iota
+	tkNumber                  // num holds the value
+	tkLiteral                 // val holds the string, without quotes
+	tkName                    // a name test: *, prefix:* or a QName
+	tkFunction                // a function name, before (
+	tkNodeType                // comment, text, processing-instruction or node, before (
+	tkAxis                    // an axis name, before ::
+	tkVariable                // val holds the QName, without $
+	tkOperator                // and or mod div * / // | + - = != < <= > >=
+	tkPunct                   // ( ) [ ] . .. @ , ::
+)
+
+type xpathToken struct {
+	kind xpathTokenKind
+	val  string
+	num  float64
+	pos  int
+}
+
+func (t xpathToken) String() string {
+	switch t.kind {
+	case tkEOF:
+		return "end of expression"
+	case tkNumber:
+		return xpathNumberString(t.num)
+	case tkLiteral:
+		return strconv.Quote(t.val)
+	case tkVariable:
+		return "$" + t.val
+	}
+	return t.val
+}
+
+func isXPathSpace(r rune) bool {
+	return r == ' ' || r == '\t' || r == '\r' || r == '\n'
+}
+
+// xpathTokens splits expr into tokens, telling operators from names as
+// http://www.w3.org/TR/xpath/#exprlex says.
+func xpathTokens(expr string) ([]xpathToken, error) {
+	var toks []xpathToken
+	i := 0
+	fail := func(pos int, format string, args ...any) error {
+		return &XPathError{expr, pos, fmt.Sprintf(format, args...)}
+	}
+	space := func(i int) int {
+		for i < len(expr) && isXPathSpace(rune(expr[i])) {
+			i++
+		}
+		return i
+	}
+	ncname := func() string {
+		start := i
+		if i < len(expr) && isNameStart(expr[i]) && expr[i] != ':' {
+			for i++; i < len(expr) && isNameByte(expr[i]) && expr[i] != ':'; i++ {
+			}
+		}
+		return expr[start:i]
+	}
+	qname := func() string {
+		name := ncname()
+		if name != "" && i+1 < len(expr) && expr[i] == ':' && isNameStart(expr[i+1]) && expr[i+1] != ':' {
+			i++
+			name += ":" + ncname()
+		}
+		return name
+	}
+	digits := func() {
+		for i < len(expr) && '0' <= expr[i] && expr[i] <= '9' {
+			i++
+		}
+	}
+
+	for {
+		i = space(i)
+		tok := xpathToken{pos: i}
+		if i == len(expr) {
+			return append(toks, tok), nil
+		}
+		// after these, * and names are operators
+		operator := false
+		if len(toks) > 0 {
+			switch prev := toks[len(toks)-1]; prev.kind {
+			case tkOperator:
+			case tkPunct:
+				operator = prev.val == ")" || prev.val == "]" || prev.val == "." || prev.val == ".."
+			default:
+				operator = true
+			}
+		}
+
+		c := expr[i]
+		switch {
+		case c == '"' || c == '\'':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fail(i, "unterminated literal")
+			}
+			tok.kind, tok.val = tkLiteral, expr[i+1:i+1+end]
+			i += end + 2
+		case '0' <= c && c <= '9' || c == '.' && i+1 < len(expr) && '0' <= expr[i+1] && expr[i+1] <= '9':
+			digits()
+			if i < len(expr) && expr[i] == '.' {
+				i++
+				digits()
+			}
+			tok.kind = tkNumber
+			tok.num, _ = strconv.ParseFloat(expr[tok.pos:i], 64)
+		case c == '*':
+			i++
+			tok.kind, tok.val = tkName, "*"
+			if operator {
+				tok.kind = tkOperator
+			}
+		case c == '$':
+			i++
+			tok.kind, tok.val = tkVariable, qname()
+			if tok.val == "" {
+				return nil, fail(tok.pos, "expected a variable name after $")
+			}
+		case isNameStart(c) && c != ':':
+			name := ncname()
+			if operator {
+				switch name {
+				case "and", "or", "mod", "div":
+					tok.kind, tok.val = tkOperator, name
+				default:
+					return nil, fail(tok.pos, "expected an operator, found %s", name)
+				}
+				break
+			}
+			if i+1 < len(expr) && expr[i] == ':' && expr[i+1] == '*' {
+				i += 2
+				name += ":*"
+			} else if i+1 < len(expr) && expr[i] == ':' && expr[i+1] != ':' {
+				i++
+				local := ncname()
+				if local == "" {
+					return nil, fail(i, "expected a local name after %s:", name)
+				}
+				name += ":" + local
+			}
+			tok.kind, tok.val = tkName, name
+			switch next := expr[space(i):]; {
+			case strings.HasPrefix(next, "::"):
+				if _, ok := xpathAxes[name]; !ok {
+					return nil, fail(tok.pos, "unknown axis %s", name)
+				}
+				tok.kind = tkAxis
+			case strings.HasPrefix(next, "("):
+				tok.kind = tkFunction
+				switch name {
+				case "comment", "text", "processing-instruction", "node":
+					tok.kind = tkNodeType
+				}
+			}
+		default:
+			two := expr[i:min(i+2, len(expr))]
+			switch {
+			case two == "//" || two == "!=" || two == "<=" || two == ">=":
+				tok.kind, tok.val = tkOperator, two
+			case two == "::" || two == "..":
+				tok.kind, tok.val = tkPunct, two
+			case strings.IndexByte("/|+-=<>", c) >= 0:
+				tok.kind, tok.val = tkOperator, string(c)
+			case strings.IndexByte("()[].@,", c) >= 0:
+				tok.kind, tok.val = tkPunct, string(c)
+			default:
+				return nil, fail(i, "unexpected %q", c)
+			}
+			i += len(tok.val)
+		}
+		toks = append(toks, tok)
+	}
+}
+
+// an xpathParser builds the tree of an expression by recursive descent,
+// one function per production of the grammar
+type xpathParser struct {
+	src  string
+	toks []xpathToken
+	i    int
+	opts *XPathOptions
+}
+
+func (p *xpathParser) peek() xpathToken {
+	return p.toks[p.i]
+}
+
+func (p *xpathParser) next() xpathToken {
+	t := p.toks[p.i]
+	if t.kind != tkEOF {
+		p.i++
+	}
+	return t
+}
+
+// accept consumes the next token if it is val of the given kind.
+func (p *xpathParser) accept(kind xpathTokenKind, val string) bool {
+	if t := p.peek(); t.kind == kind && t.val == val {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *xpathParser) expect(kind xpathTokenKind, val string) error {
+	if !p.accept(kind, val) {
+		return p.errorf("expected %s, found %s", val, p.peek())
+	}
+	return nil
+}
+
+func (p *xpathParser) errorf(format string, args ...any) error {
+	return &XPathError{p.src, p.peek().pos, fmt.Sprintf(format, args...)}
+}
+
+// resolve returns the namespace URI bound to prefix.
+func (p *xpathParser) resolve(prefix string) (string, error) {
+	if p.opts.Resolver != nil {
+		if uri := p.opts.Resolver.LookupNamespaceURI(prefix); uri != "" {
+			return uri, nil
+		}
+	}
+	if prefix == "xml" {
+		return xmlURL, nil
+	}
+	return "", p.errorf("prefix %s is not bound", prefix)
+}
+
+// expandedName returns the key of the QName name in the Functions of
+// XPathOptions and in variable bindings.
+func (p *xpathParser) expandedName(name string) (string, error) {
+	prefix, local := splitQName(name)
+	if prefix == "" {
+		return local, nil
+	}
+	uri, err := p.resolve(prefix)
+	return "{" + uri + "}" + local, err
+}
+
+// the operators of each level of precedence, loosest first
+var xpathOperators = [][]string{
+	{"or"},
+	{"and"},
+	{"=", "!="},
+	{"<", "<=", ">", ">="},
+	{"+", "-"},
+	{"*", "div", "mod"},
+}
+
+// expr parses Expr.
+func (p *xpathParser) expr() (xpathExpr, error) {
+	return p.binary(0)
+}
+
+// binary parses the left-associative operators of level and those that
+// bind tighter.
+func (p *xpathParser) binary(level int) (xpathExpr, error) {
+	if level == len(xpathOperators) {
+		return p.unary()
+	}
+	l, err := p.binary(level + 1)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		found := false
+		for _, op := range xpathOperators[level] {
+			found = found || t.kind == tkOperator && t.val == op
+		}
+		if !found {
+			return l, nil
+		}
+		p.next()
+		r, err := p.binary(level + 1)
+		if err != nil {
+			return nil, err
+		}
+		l = &xpathBinary{op: t.val, l: l, r: r}
+	}
+}
+
+// unary parses UnaryExpr.
+func (p *xpathParser) unary() (xpathExpr, error) {
+	if p.accept(tkOperator, "-") {
+		x, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+		return &xpathNegate{x}, nil
+	}
+	return p.union()
+}
+
+// union parses UnionExpr.
+func (p *xpathParser) union() (xpathExpr, error) {
+	l, err := p.path()
+	if err != nil {
+		return nil, err
+	}
+	for p.accept(tkOperator, "|") {
+		r, err := p.path()
+		if err != nil {
+			return nil, err
+		}
+		l = &xpathUnion{l, r}
+	}
+	return l, nil
+}
+
+// path parses PathExpr.
+func (p *xpathParser) path() (xpathExpr, error) {
+	switch t := p.peek(); {
+	case t.kind == tkVariable, t.kind == tkLiteral, t.kind == tkNumber, t.kind == tkFunction,
+		t.kind == tkPunct && t.val == "(":
+		x, err := p.filter()
+		if err != nil {
+			return nil, err
+		}
+		if t := p.peek(); t.kind != tkOperator || t.val != "/" && t.val != "//" {
+			return x, nil
+		}
+		path := &xpathPath{start: x}
+		return path, p.relativePath(path, true)
+	}
+	return p.locationPath()
+}
+
+// locationPath parses LocationPath.
+func (p *xpathParser) locationPath() (xpathExpr, error) {
+	path := &xpathPath{}
+	switch {
+	case p.accept(tkOperator, "/"):
+		path.absolute = true
+		if !p.startsStep() {
+			// the root alone
+			return path, nil
+		}
+		return path, p.relativePath(path, false)
+	case p.peek().kind == tkOperator && p.peek().val == "//":
+		path.absolute = true
+		return path, p.relativePath(path, true)
+	}
+	return path, p.relativePath(path, false)
+}
+
+func (p *xpathParser) startsStep() bool {
+	switch t := p.peek(); t.kind {
+	case tkAxis, tkName, tkNodeType:
+		return true
+	case tkPunct:
+		return t.val == "@" || t.val == "." || t.val == ".."
+	}
+	return false
+}
+
+// relativePath parses the steps of RelativeLocationPath into path. sep
+// says whether a / or // comes before the first step.
+func (p *xpathParser) relativePath(path *xpathPath, sep bool) error {
+	for {
+		descendants := false
+		if sep {
+			if p.accept(tkOperator, "//") {
+				descendants = true
+			} else if !p.accept(tkOperator, "/") {
+				return nil
+			}
+		}
+		sep = true
+		s, err := p.step()
+		if err != nil {
+			return err
+		}
+		if descendants {
+			// a//b is a/descendant-or-self::node()/child::b, which is
+			// a/descendant::b when there are no predicates to count
+			// positions among the children
+			if s.axis == axisChild && len(s.preds) == 0 {
+				s.axis = axisDescendant
+			} else {
+				path.steps = append(path.steps, &xpathStep{axis: axisDescendantOrSelf, test: xpathNodeTest{kind: testNode}})
+			}
+		}
+		path.steps = append(path.steps, s)
+	}
+}
+
+// step parses Step.
+func (p *xpathParser) step() (*xpathStep, error) {
+	if p.accept(tkPunct, ".") {
+		return &xpathStep{axis: axisSelf, test: xpathNodeTest{kind: testNode}}, nil
+	}
+	if p.accept(tkPunct, "..") {
+		return &xpathStep{axis: axisParent, test: xpathNodeTest{kind: testNode}}, nil
+	}
+	s := &xpathStep{axis: axisChild}
+	if t := p.peek(); t.kind == tkAxis {
+		p.next()
+		s.axis = xpathAxes[t.val]
+		if err := p.expect(tkPunct, "::"); err != nil {
+			return nil, err
+		}
+	} else if p.accept(tkPunct, "@") {
+		s.axis = axisAttribute
+	}
+
+	t := p.peek()
+	switch t.kind {
+	case tkName:
+		p.next()
+		switch {
+		case t.val == "*":
+			s.test.kind = testAnyName
+		case strings.HasSuffix(t.val, ":*"):
+			uri, err := p.resolve(strings.TrimSuffix(t.val, ":*"))
+			if err != nil {
+				return nil, err
+			}
+			s.test = xpathNodeTest{kind: testNamespace, uri: uri}
+		default:
+			prefix, local := splitQName(t.val)
+			s.test = xpathNodeTest{kind: testName, local: local}
+			if prefix != "" {
+				uri, err := p.resolve(prefix)
+				if err != nil {
+					return nil, err
+				}
+				s.test.uri = uri
+			}
+		}
+	case tkNodeType:
+		p.next()
+		if err := p.expect(tkPunct, "("); err != nil {
+			return nil, err
+		}
+		switch t.val {
+		case "node":
+			s.test.kind = testNode
+		case "text":
+			s.test.kind = testText
+		case "comment":
+			s.test.kind = testComment
+		case "processing-instruction":
+			s.test.kind = testPI
+			if lit := p.peek(); lit.kind == tkLiteral {
+				p.next()
+				s.test.local = lit.val
+			}
+		}
+		if err := p.expect(tkPunct, ")"); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, p.errorf("expected a step, found %s", t)
+	}
+
+	preds, err := p.predicates()
+	s.preds = preds
+	return s, err
+}
+
+// predicates parses any number of Predicates.
+func (p *xpathParser) predicates() ([]xpathExpr, error) {
+	var preds []xpathExpr
+	for p.accept(tkPunct, "[") {
+		x, err := p.expr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(tkPunct, "]"); err != nil {
+			return nil, err
+		}
+		preds = append(preds, x)
+	}
+	return preds, nil
+}
+
+// filter parses FilterExpr.
+func (p *xpathParser) filter() (xpathExpr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	preds, err := p.predicates()
+	if err != nil || preds == nil {
+		return x, err
+	}
+	return &xpathFilter{x, preds}, nil
+}
+
+// primary parses PrimaryExpr.
+func (p *xpathParser) primary() (xpathExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case tkVariable:
+		name, err := p.expandedName(t.val)
+		return &xpathVariable{name}, err
+	case tkLiteral:
+		return &xpathLiteral{t.val}, nil
+	case tkNumber:
+		return &xpathLiteral{t.num}, nil
+	case tkFunction:
+		return p.call(t)
+	}
+	// (
+	x, err := p.expr()
+	if err != nil {
+		return nil, err
+	}
+	return x, p.expect(tkPunct, ")")
+}
+
+// call parses the arguments of the FunctionCall of the function named by
+// t, and finds the function.
+func (p *xpathParser) call(t xpathToken) (xpathExpr, error) {
+	if err := p.expect(tkPunct, "("); err != nil {
+		return nil, err
+	}
+	c := &xpathCall{name: t.val}
+	if !p.accept(tkPunct, ")") {
+		for {
+			x, err := p.expr()
+			if err != nil {
+				return nil, err
+			}
+			c.args = append(c.args, x)
+			if p.accept(tkPunct, ")") {
+				break
+			}
+			if err := p.expect(tkPunct, ","); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if core, ok := xpathCoreFunctions[t.val]; ok {
+		if len(c.args) < core.min || core.max >= 0 && len(c.args) > core.max {
+			return nil, &XPathError{p.src, t.pos, fmt.Sprintf("wrong number of arguments to %s()", t.val)}
+		}
+		c.core = core
+		return c, nil
+	}
+	name, err := p.expandedName(t.val)
+	if err != nil {
+		return nil, err
+	}
+	if c.ext = p.opts.Functions[name]; c.ext == nil {
+		return nil, &XPathError{p.src, t.pos, fmt.Sprintf("unknown function %s()", t.val)}
+	}
+	return c, nil
+}
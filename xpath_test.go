@@ -0,0 +1,388 @@
+package dom
+
+import (
+  "errors"
+  "math"
+  "strings"
+  "sync"
+  "testing"
+)
+
+const xpathTestDoc = `<?xml version="1.0"?>
+<!DOCTYPE r [<!ENTITY e "ent">]>
+<r xmlns:p="urn:p" xml:lang="en-GB">
+  <a id="a1" n="1">one<![CDATA[two]]>&e;three</a>
+  <!--c-->
+  <p:b id="b1" n="2"><c/><c n="3"/><?pi data?></p:b>
+  <a id="a2" n="4" p:q="x"><d xmlns="urn:d"/></a>
+</r>`
+
+func xpathTestDocument(t *testing.T) Document {
+  opts := NewParseOptions()
+  opts.Native = true
+  opts.DropWhitespace = true
+  d, err := ParseWithOptions(strings.NewReader(xpathTestDoc), opts)
+  if err != nil {
+    t.Fatalf("Parse failed: %v", err)
+  }
+  return d
+}
+
+// xpathNames returns the names of the nodes that expr selects from
+// context, in document order, or the error.
+func xpathNames(context Node, expr string, resolver XPathNSResolver) string {
+  r, err := ownerDocument(context).Evaluate(expr, context, resolver, ORDERED_NODE_SNAPSHOT_TYPE)
+  if err != nil {
+    return err.Error()
+  }
+  var names []string
+  n, _ := r.SnapshotLength()
+  for i := uint(0); i < n; i++ {
+    item, _ := r.SnapshotItem(i)
+    names = append(names, item.NodeName())
+  }
+  return strings.Join(names, " ")
+}
+
+// xpathResultString returns the string that expr gives from context, or
+// the error.
+func xpathResultString(context Node, expr string) string {
+  r, err := ownerDocument(context).Evaluate(expr, context, ownerDocument(context).DocumentElement(), STRING_TYPE)
+  if err != nil {
+    return err.Error()
+  }
+  s, _ := r.StringValue()
+  return s
+}
+
+func TestXPathAxes(t *testing.T) {
+  d := xpathTestDocument(t)
+  b := d.GetElementById("b1")
+  resolver := d.CreateNSResolver(d.DocumentElement())
+  for _, tc := range []struct{ context Node; expr, want string }{
+    {d, "/r/*", "a p:b a"},
+    {d, "//c", "c c"},
+    {d, "//c[2]", "c"},
+    {d, "//@n", "n n n n"},
+    {d, "/descendant::node()[self::comment()]", "#comment"},
+    {b, "..", "r"},
+    {b, "ancestor::*", "r"},
+    {b, "ancestor-or-self::*[1]", "p:b"},
+    {b, "following-sibling::*", "a"},
+    {b, "preceding-sibling::node()", "a #comment"},
+    {b, "preceding-sibling::node()[1]", "#comment"},
+    {b, "following::*", "a d"},
+    {b, "preceding::*", "a"},
+    {b, "preceding::text()[1]", "#text"},
+    {b, "descendant::*", "c c"},
+    {b, "descendant-or-self::*", "p:b c c"},
+    {b, "child::processing-instruction('pi')", "pi"},
+    {b, "child::processing-instruction('other')", ""},
+    {b, "self::p:b", "p:b"},
+    {b, "self::p:*", "p:b"},
+    {b, "self::b", ""},
+    {d, "//*[@p:q]", "a"},
+    {d, "/r/a[2]/@*", "id n p:q"},
+    {d, "/r/a[2]/@p:q/following::*", "d"},
+    {d, "/r/a[2]/@p:q/preceding::*", "a p:b c c"},
+    {d, "/r/a[2]/@p:q/parent::*/@id", "id"},
+    {d, "/r/a[1]/namespace::*", "p xml"},
+    {d, "//*[local-name()='d']/namespace::*", " p xml"},
+    {d, "/r/a[1]/text()", "#text"},
+    {d, "(//c | /r | //c)", "r c c"},
+    {d, "(//c)[last()]/@n", "n"},
+    {d, "//*[position() = last()]", "r c a d"},
+    {d, "/r/a[1]/text() | /r/a[1]", "a #text"},
+  } {
+    if got := xpathNames(tc.context, tc.expr, resolver); got != tc.want {
+      t.Errorf("%s from %s gave %q, want %q", tc.expr, tc.context.NodeName(), got, tc.want)
+    }
+  }
+}
+
+func TestXPathStrings(t *testing.T) {
+  d := xpathTestDocument(t)
+  for _, tc := range []struct{ expr, want string }{
+    {"/r/a[1]", "onetwoentthree"},
+    {"/r/a[1]/text()", "onetwoentthree"},
+    {"count(//a/text())", "1"},
+    {"string(//comment())", "c"},
+    {"//processing-instruction()", "data"},
+    {"name(/r/*[2])", "p:b"},
+    {"local-name(/r/*[2])", "b"},
+    {"namespace-uri(/r/*[2])", "urn:p"},
+    {"name(//processing-instruction())", "pi"},
+    {"name(/r/a[1]/namespace::p)", "p"},
+    {"string(/r/a[1]/namespace::p)", "urn:p"},
+    {"concat('a', 1, true())", "a1true"},
+    {"substring('12345', 2, 3)", "234"},
+    {"substring('12345', 1.5, 2.6)", "234"},
+    {"substring('12345', 0, 3)", "12"},
+    {"substring('12345', 0 div 0, 3)", ""},
+    {"substring('12345', 1, 0 div 0)", ""},
+    {"substring('12345', -42, 1 div 0)", "12345"},
+    {"substring('12345', -1 div 0, 1 div 0)", ""},
+    {"substring('åäö', 2)", "äö"},
+    {"substring-before('1999/04/01', '/')", "1999"},
+    {"substring-before('1999', '/')", ""},
+    {"substring-after('1999/04/01', '/')", "04/01"},
+    {"translate('bar', 'abc', 'ABC')", "BAr"},
+    {"translate('--aaa--', 'abc-', 'ABC')", "AAA"},
+    {"normalize-space('  a \t b\n ')", "a b"},
+    {"string-length('åäö')", "3"},
+    {"1 div 0", "Infinity"},
+    {"-1 div 0", "-Infinity"},
+    {"0 div 0", "NaN"},
+    {"-0", "0"},
+    {"1.50", "1.5"},
+    {"1000000 * 1000000", "1000000000000"},
+    {"7 mod -3", "1"},
+    {"-7 mod 3", "-1"},
+    {"round(2.5)", "3"},
+    {"round(-2.5)", "-2"},
+    {"1 div round(-0.4)", "-Infinity"},
+    {"floor(-1.5)", "-2"},
+    {"ceiling(1.2)", "2"},
+    {"number(' 12.5 ')", "12.5"},
+    {"number('1e3')", "NaN"},
+    {"number('-.5')", "-0.5"},
+    {"sum(//@n)", "10"},
+    {"sum(//@id)", "NaN"},
+    {"boolean(/r/a[1][lang('en')])", "true"},
+    {"boolean(/r/a[1]/text()[lang('EN-gb')])", "true"},
+    {"boolean(/r/a[1][lang('en-us')])", "false"},
+    {"lang('en')", "false"},
+    {"count(id('a2 b1 nope'))", "2"},
+    {"name(id(/r/a[1]/@id))", "a"},
+    {"1 < 2 and 2 <= 2 and not(2 > 3) and 3 >= 3", "true"},
+    {"//@n = 3", "true"},
+    {"//@n != 3", "true"},
+    {"3 = //@n", "true"},
+    {"//@n > 3", "true"},
+    {"3 > //@n", "true"},
+    {"//@n > 4", "false"},
+    {"//@n = '2'", "true"},
+    {"//a[1] = //p:b", "false"},
+    {"//a = //p:b", "true"},
+    {"//@n = //@id", "false"},
+    {"//nothing = false()", "true"},
+    {"true() = 'x'", "true"},
+    {"1 = '1.0'", "true"},
+    {"'1' = '1.0'", "false"},
+    {"boolean(0 div 0)", "false"},
+    {"1 or $undefined", "true"},
+    {"0 and $undefined", "false"},
+    {"-(1 - 2) - -3", "4"},
+    {"2 * 3 + 4 div 2 - 1", "7"},
+    {"count(div * 2)", "count(): xpath: wrong type"},
+  } {
+    if s := xpathResultString(d, tc.expr); s != tc.want {
+      t.Errorf("%s gave %q, want %q", tc.expr, s, tc.want)
+    }
+  }
+}
+
+func TestXPathCompileErrors(t *testing.T) {
+  for _, tc := range []struct{ expr string; offset int }{
+    {"", 0},
+    {"/r/", 3},
+    {"a[1", 3},
+    {"'open", 0},
+    {"foo::a", 0},
+    {"x:a", 3},
+    {"count()", 0},
+    {"concat('a')", 0},
+    {"nosuch(1)", 0},
+    {"1 2", 2},
+    {"a b", 2},
+    {"@", 1},
+    {"a/#", 2},
+  } {
+    _, err := CompileXPath(tc.expr, nil)
+    var xe *XPathError
+    if (!errors.As(err, &xe)) {
+      t.Errorf("%q gave %v", tc.expr, err)
+    } else if (xe.Offset != tc.offset) {
+      t.Errorf("%q failed at %d, not %d: %v", tc.expr, xe.Offset, tc.offset, err)
+    }
+  }
+}
+
+type testResolver map[string]string
+
+func (r testResolver) LookupNamespaceURI(prefix string) string {
+  return r[prefix]
+}
+
+func TestXPathVariablesAndFunctions(t *testing.T) {
+  d := xpathTestDocument(t)
+  opts := &XPathOptions{
+    Resolver: testResolver{"f": "urn:f", "q": "urn:p"},
+    Functions: map[string]XPathFunction{
+      "{urn:f}upper": func(context Node, args []any) (any, error) {
+        return strings.ToUpper(args[0].(string)), nil
+      },
+      "first-a": func(context Node, args []any) (any, error) {
+        return ownerDocument(context).GetElementsByTagName("a").Item(0), nil
+      },
+      "twice": func(context Node, args []any) (any, error) {
+        return 2 * args[0].(float64), nil
+      },
+      "count": func(context Node, args []any) (any, error) {
+        return 42, nil
+      },
+      "fail": func(context Node, args []any) (any, error) {
+        return nil, errors.New("failed")
+      },
+    },
+  }
+  for _, tc := range []struct{ expr, want string }{
+    {"f:upper($s)", "ABC"},
+    {"first-a()/@id", "a1"},
+    {"twice($n) + $f:n", "10"},
+    {"count(//q:b)", "1"},
+    {"$nodes[2]/@id", "a2"},
+    {"count($nodes | //a)", "2"},
+    {"$b", "true"},
+  } {
+    x, err := CompileXPath(tc.expr, opts)
+    if err != nil {
+      t.Errorf("%s failed to compile: %v", tc.expr, err)
+      continue
+    }
+    r, err := x.EvaluateWithVariables(d, STRING_TYPE, map[string]any{
+      "s": "abc", "n": 3, "{urn:f}n": uint8(4), "b": true,
+      "nodes": d.GetElementsByTagName("a"),
+    })
+    if err != nil {
+      t.Errorf("%s failed: %v", tc.expr, err)
+      continue
+    }
+    if s, _ := r.StringValue(); s != tc.want {
+      t.Errorf("%s gave %q, want %q", tc.expr, s, tc.want)
+    }
+  }
+
+  x, _ := CompileXPath("fail()", opts)
+  if _, err := x.Evaluate(d, ANY_TYPE); (err == nil || !strings.Contains(err.Error(), "failed")) {
+    t.Errorf("A failing function gave %v", err)
+  }
+  x, _ = CompileXPath("$missing", opts)
+  if _, err := x.Evaluate(d, ANY_TYPE); (err == nil) {
+    t.Errorf("An unbound variable gave no error")
+  }
+  if _, err := x.EvaluateWithVariables(d, ANY_TYPE, map[string]any{"missing": struct{}{}}); (!errors.Is(err, ErrXPathType)) {
+    t.Errorf("A variable of the wrong type gave %v", err)
+  }
+  if _, err := CompileXPath("f:upper('a')", nil); (err == nil) {
+    t.Errorf("An unbound function prefix compiled")
+  }
+}
+
+func TestXPathResultTypes(t *testing.T) {
+  d := xpathTestDocument(t)
+  r, _ := d.Evaluate("count(//a)", d, nil, ANY_TYPE)
+  if n, err := r.NumberValue(); (r.ResultType() != NUMBER_TYPE || n != 2 || err != nil) {
+    t.Errorf("count gave %d %v %v", r.ResultType(), n, err)
+  }
+  if _, err := r.StringValue(); (!errors.Is(err, ErrXPathType)) {
+    t.Errorf("StringValue of a number gave %v", err)
+  }
+  r, _ = d.Evaluate("//a", d, nil, BOOLEAN_TYPE)
+  if b, _ := r.BooleanValue(); (!b) {
+    t.Errorf("//a was false")
+  }
+  r, _ = d.Evaluate("//@n", d, nil, NUMBER_TYPE)
+  if n, _ := r.NumberValue(); (n != 1) {
+    t.Errorf("number(//@n) gave %v", n)
+  }
+  r, _ = d.Evaluate("'x' + 1", d, nil, NUMBER_TYPE)
+  if n, _ := r.NumberValue(); (!math.IsNaN(n)) {
+    t.Errorf("'x' + 1 gave %v", n)
+  }
+  if _, err := d.Evaluate("1", d, nil, ORDERED_NODE_SNAPSHOT_TYPE); (!errors.Is(err, ErrXPathType)) {
+    t.Errorf("A number as a node-set gave %v", err)
+  }
+  if _, err := d.Evaluate("1 | //a", d, nil, ANY_TYPE); (!errors.Is(err, ErrXPathType)) {
+    t.Errorf("A union with a number gave %v", err)
+  }
+
+  r, _ = d.Evaluate("//c", d, nil, FIRST_ORDERED_NODE_TYPE)
+  if n, _ := r.SingleNodeValue(); (n == nil || n.(Element).HasAttribute("n")) {
+    t.Errorf("The first c was %v", n)
+  }
+  r, _ = d.Evaluate("//none", d, nil, ANY_UNORDERED_NODE_TYPE)
+  if n, err := r.SingleNodeValue(); (n != nil || err != nil) {
+    t.Errorf("An empty node-set gave %v, %v", n, err)
+  }
+
+  r, _ = d.Evaluate("//a", d, nil, ANY_TYPE)
+  if (r.ResultType() != UNORDERED_NODE_ITERATOR_TYPE) {
+    t.Errorf("A node-set is of type %d", r.ResultType())
+  }
+  var ids []string
+  for n, err := r.IterateNext(); n != nil && err == nil; n, err = r.IterateNext() {
+    ids = append(ids, n.(Element).GetAttribute("id"))
+  }
+  if (strings.Join(ids, " ") != "a1 a2") {
+    t.Errorf("The iterator gave %v", ids)
+  }
+
+  r, _ = d.Evaluate("//a", d, nil, ORDERED_NODE_ITERATOR_TYPE)
+  first, _ := r.IterateNext()
+  first.ParentNode().RemoveChild(first)
+  if _, err := r.IterateNext(); (!r.InvalidIteratorState() || !errors.Is(err, ErrInvalidIterator)) {
+    t.Errorf("The iterator was not invalidated by a change: %v", err)
+  }
+  r, _ = d.Evaluate("//a", d, nil, ORDERED_NODE_SNAPSHOT_TYPE)
+  d.DocumentElement().AppendChild(d.CreateElement("a"))
+  if n, _ := r.SnapshotLength(); (n != 1 || r.InvalidIteratorState()) {
+    t.Errorf("The snapshot changed with the document")
+  }
+
+  other := xpathTestDocument(t)
+  if _, err := d.Evaluate("/", other, nil, ANY_TYPE); (err == nil) {
+    t.Errorf("A context node from another document was accepted")
+  }
+  if _, err := d.Evaluate("/", d.Doctype(), nil, ANY_TYPE); (err == nil) {
+    t.Errorf("A document type was accepted as the context node")
+  }
+  if _, err := d.Evaluate("/", d, nil, 42); (err == nil) {
+    t.Errorf("An unknown result type was accepted")
+  }
+}
+
+func TestXPathDetached(t *testing.T) {
+  d := xpathTestDocument(t)
+  e := d.CreateElement("x")
+  e.SetAttribute("id", "i")
+  e.AppendChild(d.CreateElement("y"))
+  x, _ := CompileXPath("/y | id('i')", nil)
+  r, err := x.Evaluate(e.FirstChild(), UNORDERED_NODE_SNAPSHOT_TYPE)
+  if n, _ := r.SnapshotLength(); (err != nil || n != 2) {
+    t.Errorf("Detached evaluation gave %d nodes, %v", n, err)
+  }
+}
+
+func TestXPathConcurrent(t *testing.T) {
+  d := xpathTestDocument(t)
+  x, err := CompileXPath("count(//*[namespace::p]) + count(//@*[. = '4']/preceding::*)", nil)
+  if err != nil {
+    t.Fatalf("Compile failed: %v", err)
+  }
+  var wg sync.WaitGroup
+  for i := 0; i < 8; i++ {
+    wg.Add(1)
+    go func() {
+      defer wg.Done()
+      for j := 0; j < 50; j++ {
+        r, err := x.Evaluate(d, NUMBER_TYPE)
+        if n, _ := r.NumberValue(); (err != nil || n != 11) {
+          t.Errorf("Concurrent evaluation gave %v, %v", n, err)
+          return
+        }
+      }
+    }()
+  }
+  wg.Wait()
+}